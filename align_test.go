@@ -0,0 +1,58 @@
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAlignWrites checks that the tail file is written in whole blocks and
+// that the padding after the last entry is not taken for entries, when the
+// log is reopened after a crash as well as after Close.
+func TestAlignWrites(t *testing.T) {
+	configs := map[string]Config{
+		"varint":     {},
+		"fixed":      {EntryFormat: EntryFormatFixed},
+		"rotated":    {SegmentSize: 10000},
+		"buffered":   {WriteBufferSize: 3000, SyncPolicy: SyncPolicy{Mode: SyncNever}},
+		"compressed": {Compression: CompressionSnappy},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.AlignWrites = true
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			if err := l.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			tail := l.segments[len(l.segments)-1].path
+			info, err := os.Stat(tail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size()%directBlockSize != 0 {
+				t.Fatalf("tail file size %d is not a multiple of %d", info.Size(), directBlockSize)
+			}
+
+			// Copy the files of the open log, as a crash leaves them
+			crashed := t.TempDir()
+			for _, seg := range l.segments {
+				data, err := os.ReadFile(seg.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(crashed, filepath.Base(seg.path)), data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l = openTest(t, crashed, cfg)
+			checkEntries(t, l, 1, 500)
+			writeEntries(t, l, 501, 600)
+			l = reopenTest(t, l, crashed, Config{})
+			checkEntries(t, l, 1, 600)
+		})
+	}
+}
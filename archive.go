@@ -0,0 +1,192 @@
+package jellywal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Archiver copies segments somewhere else before the log deletes them, for
+// example to object storage, so entries removed by TruncateFront or
+// retention are kept in a cheaper tier.
+//
+// Archive is called once for every segment about to be deleted, oldest
+// first, one call at a time. It is called without holding the log lock, so
+// writes go on while a segment is uploaded: TruncateFront archives the
+// segments before it takes the lock, and retention leaves them to the
+// background janitor, deleting them at the first seal or janitor run after
+// they are archived. The segment is only deleted once Archive returns nil.
+// An error aborts the truncation, which TruncateFront returns and retention
+// tries again later, and a segment file rewritten in the meantime, such as
+// by SegmentCompression, is archived again, so an archiver may see the
+// same segment more than once.
+type Archiver interface {
+	Archive(segment ArchivedSegment) error
+}
+
+// ArchivedSegment describes a segment file handed to an Archiver. The file
+// must not be modified by Archive.
+type ArchivedSegment struct {
+	Path        string      // Path of the segment file
+	FirstIndex  uint64      // First index held by the file
+	LastIndex   uint64      // Last index held by the file
+	Compression Compression // Algorithm the whole file is compressed with
+	SHA256      [32]byte    // SHA-256 of the file contents
+}
+
+// pendingSegment is a segment to be archived, as it is handed to the
+// archiver but for its checksum.
+type pendingSegment struct {
+	seg     *segment
+	archive ArchivedSegment
+}
+
+// archiveSegments hands the segments not archived yet to the configured
+// archiver, the segment following the last of them is next. It backs the
+// truncations made under the lock, the segments are normally archived by
+// archivePending beforehand. The caller must hold the write lock.
+func (l *Log) archiveSegments(segments []*segment, next *segment) error {
+	if l.config.Archiver == nil {
+		return nil
+	}
+
+	for _, p := range l.unarchived(segments, next) {
+		info, err := l.archiveSegment(p)
+		if err != nil {
+			return err
+		}
+		p.seg.archived = info
+	}
+
+	return nil
+}
+
+// archivePending hands the pending segments to the configured archiver
+// without holding the log lock, and records those whose files were not
+// replaced meanwhile as archived. The caller must hold archiveMu, so the
+// segments are not archived twice at once.
+func (l *Log) archivePending(pending []pendingSegment) error {
+	for _, p := range pending {
+		info, err := l.archiveSegment(p)
+		if err != nil {
+			return err
+		}
+
+		l.mu.Lock()
+		if p.seg.path == p.archive.Path {
+			p.seg.archived = info
+		}
+		l.mu.Unlock()
+	}
+
+	return nil
+}
+
+// unarchived returns the segments not archived yet of those given, the
+// segment following the last of them is next. The caller must hold the
+// read lock.
+func (l *Log) unarchived(segments []*segment, next *segment) []pendingSegment {
+	var pending []pendingSegment
+	for i, seg := range segments {
+		if l.isArchived(seg) {
+			continue
+		}
+
+		last := seg.last
+		if last == 0 {
+			if i < len(segments)-1 {
+				last = segments[i+1].index - 1
+			} else {
+				last = next.index - 1
+			}
+		}
+
+		pending = append(pending, pendingSegment{seg, ArchivedSegment{
+			Path:        seg.path,
+			FirstIndex:  seg.index,
+			LastIndex:   last,
+			Compression: segmentFileCompression(seg.path),
+		}})
+	}
+
+	return pending
+}
+
+// pendingBefore returns the segments not archived yet that a truncation of
+// the front of the log up to the given index deletes. It returns none when
+// the truncation fails, truncateFront reports why.
+func (l *Log) pendingBefore(index uint64) []pendingSegment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed || l.corrupt.Load() || l.config.ReadOnly || index <= l.firstIndex || index > l.lastIndex {
+		return nil
+	}
+
+	segIdx := l.findSegment(index)
+	return l.unarchived(l.segments[:segIdx], l.segments[segIdx])
+}
+
+// isArchived reports whether the file of the segment is the one last handed
+// to the archiver. The caller must hold the read lock.
+func (l *Log) isArchived(seg *segment) bool {
+	if seg.archived == nil {
+		return false
+	}
+
+	info, err := os.Stat(seg.path)
+	return err == nil && sameFileInfo(info, seg.archived)
+}
+
+// archiveSegment checksums the pending segment file and hands it to the
+// archiver. It returns the file as it was archived, or nil when it was
+// replaced or modified while being archived, in which case it has to be
+// archived again. It does not take the log lock.
+func (l *Log) archiveSegment(p pendingSegment) (os.FileInfo, error) {
+	before, err := os.Stat(p.archive.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat log segment for archiving: %w", err)
+	}
+
+	p.archive.SHA256, err = fileSHA256(p.archive.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum log segment for archiving: %w", err)
+	}
+
+	if err := l.config.Archiver.Archive(p.archive); err != nil {
+		return nil, fmt.Errorf("failed to archive log segment: %w", err)
+	}
+
+	after, err := os.Stat(p.archive.Path)
+	if err != nil || !sameFileInfo(before, after) {
+		return nil, nil
+	}
+
+	return before, nil
+}
+
+// sameFileInfo reports whether both describe the same file with the same
+// contents, as far as its size and modification time tell.
+func sameFileInfo(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// fileSHA256 returns the SHA-256 of the contents of the file at path.
+func fileSHA256(path string) ([32]byte, error) {
+	var sum [32]byte
+
+	file, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return sum, err
+	}
+
+	hash.Sum(sum[:0])
+	return sum, nil
+}
@@ -0,0 +1,221 @@
+package jellywal
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errArchive = errors.New("archive failed")
+
+// testArchiver keeps a copy of every segment it is handed, failing while
+// fail is set.
+type testArchiver struct {
+	mu       sync.Mutex
+	segments []ArchivedSegment
+	data     [][]byte
+	fail     bool
+}
+
+func (a *testArchiver) Archive(seg ArchivedSegment) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.fail {
+		return errArchive
+	}
+	data, err := os.ReadFile(seg.Path)
+	if err != nil {
+		return err
+	}
+	a.segments = append(a.segments, seg)
+	a.data = append(a.data, data)
+	return nil
+}
+
+// check checks that the archived segments are intact and cover the
+// indexes before first, oldest first.
+func (a *testArchiver) check(tb testing.TB, first uint64) {
+	tb.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next := uint64(1)
+	for i, seg := range a.segments {
+		if seg.FirstIndex != next || seg.LastIndex < seg.FirstIndex {
+			tb.Fatalf("archived %d-%d, want a segment from %d", seg.FirstIndex, seg.LastIndex, next)
+		}
+		if sha256.Sum256(a.data[i]) != seg.SHA256 {
+			tb.Fatalf("archived %d-%d with a wrong checksum", seg.FirstIndex, seg.LastIndex)
+		}
+		next = seg.LastIndex + 1
+	}
+	if next != first {
+		tb.Fatalf("archived up to %d, want up to %d", next-1, first-1)
+	}
+}
+
+// TestArchiver checks that TruncateFront hands the segments it deletes to
+// the archiver, whatever they are compressed with.
+func TestArchiver(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionSnappy} {
+		t.Run(compression.String(), func(t *testing.T) {
+			a := &testArchiver{}
+			cfg := Config{SegmentSize: 1024, SegmentCompression: compression, Archiver: a}
+			l := openTest(t, t.TempDir(), cfg)
+			writeEntries(t, l, 1, 500)
+
+			if err := l.TruncateFront(250); err != nil {
+				t.Fatal(err)
+			}
+			a.check(t, l.segments[0].index)
+			if len(a.segments) == 0 {
+				t.Fatal("no segments archived")
+			}
+			for _, seg := range a.segments {
+				if seg.Compression != compression {
+					t.Fatalf("archived %d-%d as %v, want %v", seg.FirstIndex, seg.LastIndex, seg.Compression, compression)
+				}
+			}
+			checkEntries(t, l, 250, 500)
+		})
+	}
+}
+
+// TestArchiverFailure checks that a failing archiver leaves the log and
+// its segments as they were, and that the truncation succeeds once it
+// recovers.
+func TestArchiverFailure(t *testing.T) {
+	dir := t.TempDir()
+	a := &testArchiver{fail: true}
+	cfg := Config{SegmentSize: 1024, Archiver: a}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	segments := len(l.segments)
+
+	if err := l.TruncateFront(250); !errors.Is(err, errArchive) {
+		t.Fatalf("got %v, want the archiver error", err)
+	}
+	if len(l.segments) != segments {
+		t.Fatalf("%d segments left of %d, want all", len(l.segments), segments)
+	}
+	for _, seg := range l.segments {
+		if _, err := os.Stat(seg.path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkEntries(t, l, 1, 500)
+
+	a.mu.Lock()
+	a.fail = false
+	a.mu.Unlock()
+	if err := l.TruncateFront(250); err != nil {
+		t.Fatal(err)
+	}
+	a.check(t, l.segments[0].index)
+	checkEntries(t, l, 250, 500)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 250, 500)
+}
+
+// waitRetention waits for retention to delete segments, and those the
+// janitor archived, and returns the first index left. Archiving is held
+// off until the test ends, so the archiver and the log stay in step.
+func waitRetention(tb testing.TB, l *Log) uint64 {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.archiveMu.Lock()
+		l.mu.RLock()
+		first, archived := l.firstIndex, l.isArchived(l.segments[0])
+		l.mu.RUnlock()
+		if first != 1 && !archived {
+			tb.Cleanup(l.archiveMu.Unlock)
+			return first
+		}
+		l.archiveMu.Unlock()
+		if time.Now().After(deadline) {
+			tb.Fatalf("first index %d, want archived segments deleted", first)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestArchiverRetention checks that retention archives the segments it
+// deletes, and keeps them while the archiver fails.
+func TestArchiverRetention(t *testing.T) {
+	a := &testArchiver{fail: true}
+	var r retained
+	cfg := Config{SegmentSize: 1024, MaxDiskBytes: 4096, OnRetention: r.record, Archiver: a}
+	l := openTest(t, t.TempDir(), cfg)
+	writeEntries(t, l, 1, 500)
+	checkEntries(t, l, 1, 500)
+
+	a.mu.Lock()
+	a.fail = false
+	a.mu.Unlock()
+	writeEntries(t, l, 501, 1000)
+	first := waitRetention(t, l)
+	r.check(t, first)
+	a.check(t, first)
+	checkEntries(t, l, first, 1000)
+}
+
+// blockingArchiver blocks every Archive call until release is closed.
+type blockingArchiver struct {
+	testArchiver
+	started chan struct{}
+	release chan struct{}
+}
+
+func (a *blockingArchiver) Archive(seg ArchivedSegment) error {
+	select {
+	case a.started <- struct{}{}:
+	default:
+	}
+	<-a.release
+	return a.testArchiver.Archive(seg)
+}
+
+// TestArchiverBlocking checks that writes go on while retention waits for
+// a slow archiver, and that the segments are only deleted once archived.
+func TestArchiverBlocking(t *testing.T) {
+	a := &blockingArchiver{started: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := Config{SegmentSize: 1024, MaxDiskBytes: 4096, Archiver: a}
+	l := openTest(t, t.TempDir(), cfg)
+	writeEntries(t, l, 1, 500)
+
+	select {
+	case <-a.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retention did not archive")
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		for i := uint64(501); i <= 1000; i++ {
+			if err := l.Write(i, testEntry(i)); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(a.release)
+		t.Fatal("writes blocked by the archiver")
+	}
+	checkEntries(t, l, 1, 1000)
+
+	close(a.release)
+	first := waitRetention(t, l)
+	a.check(t, first)
+	checkEntries(t, l, first, 1000)
+}
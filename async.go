@@ -0,0 +1,133 @@
+package jellywal
+
+// asyncQueueSize is the number of WriteAsync entries that can be waiting
+// for the async writer before WriteAsync blocks.
+const asyncQueueSize = 1024
+
+// WriteAsync queues an entry to be appended at the given index and returns
+// a channel that receives the result of the write once it is done, as Write
+// would have returned it. Entries are written by a background goroutine,
+// which commits everything queued while it was busy as one group with a
+// single file write and fsync, so the caller can go on submitting while
+// earlier entries are being synced. The data must not be modified until
+// the result is received.
+//
+// Entries queued by one goroutine are written in order. WriteAsync only
+// blocks when asyncQueueSize entries are waiting already. Barrier and Close
+// write the queued entries first.
+func (l *Log) WriteAsync(index uint64, data []byte) <-chan error {
+	req := &writeRequest{index: index, data: data, done: make(chan error, 1)}
+
+	l.amu.RLock()
+	if l.aqueue == nil && !l.astopped {
+		l.amu.RUnlock()
+		l.startAsyncWriter()
+		l.amu.RLock()
+	}
+	defer l.amu.RUnlock()
+
+	if l.astopped {
+		req.done <- ErrClosed
+		return req.done
+	}
+
+	// Sent holding the lock, so the queue is not closed under the send
+	l.aqueue <- req
+	return req.done
+}
+
+// startAsyncWriter starts the goroutine writing the entries queued by
+// WriteAsync, unless it is running already.
+func (l *Log) startAsyncWriter() {
+	l.amu.Lock()
+	defer l.amu.Unlock()
+
+	if l.aqueue != nil || l.astopped {
+		return
+	}
+
+	l.aqueue = make(chan *writeRequest, asyncQueueSize)
+	l.adone = make(chan struct{})
+	go l.runAsyncWriter(l.aqueue, l.adone)
+}
+
+// stopAsyncWriter stops queueing WriteAsync entries and waits until the
+// ones queued already are written. Later calls to WriteAsync fail with
+// ErrClosed. The caller must not hold the write lock, the writer needs it.
+func (l *Log) stopAsyncWriter() {
+	l.amu.Lock()
+	l.astopped = true
+	queue, done := l.aqueue, l.adone
+	l.aqueue = nil
+	l.amu.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-done
+	}
+}
+
+// flushAsync waits until the entries queued by WriteAsync so far are
+// written. The caller must not hold the write lock, the writer needs it.
+func (l *Log) flushAsync() {
+	l.amu.RLock()
+	if l.aqueue == nil {
+		l.amu.RUnlock()
+		return
+	}
+
+	req := &writeRequest{flush: true, done: make(chan error, 1)}
+	l.aqueue <- req
+	l.amu.RUnlock()
+
+	<-req.done
+}
+
+// runAsyncWriter writes the queued entries until the queue is closed. It
+// takes whatever was queued while it was busy and commits it as a group,
+// which ends early at a flush request to complete it once the group is
+// written.
+func (l *Log) runAsyncWriter(queue chan *writeRequest, done chan struct{}) {
+	defer close(done)
+
+	group := make([]*writeRequest, 0, asyncQueueSize)
+	for req := range queue {
+		var flush *writeRequest
+		if req.flush {
+			flush = req
+		} else {
+			group = append(group, req)
+		}
+	drain:
+		for flush == nil && len(group) < cap(group) {
+			select {
+			case req, ok := <-queue:
+				if !ok {
+					break drain
+				} else if req.flush {
+					flush = req
+				} else {
+					group = append(group, req)
+				}
+			default:
+				break drain
+			}
+		}
+
+		if len(group) > 0 {
+			l.mu.Lock()
+			results := l.commitGroup(group)
+			for i, r := range group {
+				r.done <- results[i]
+			}
+			l.mu.Unlock()
+		}
+
+		if flush != nil {
+			flush.done <- nil
+		}
+
+		clear(group)
+		group = group[:0]
+	}
+}
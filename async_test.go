@@ -0,0 +1,103 @@
+package jellywal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestWriteAsync checks that queued entries are written in order and that
+// every result is delivered, including errors.
+func TestWriteAsync(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SyncPolicy: SyncPolicy{Mode: SyncAlways}})
+
+	var results []<-chan error
+	for i := uint64(1); i <= 2*asyncQueueSize; i++ {
+		results = append(results, l.WriteAsync(i, testEntry(i)))
+	}
+	for i, result := range results {
+		if err := <-result; err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+	}
+	checkEntries(t, l, 1, 2*asyncQueueSize)
+
+	if err := <-l.WriteAsync(10, testEntry(10)); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("got %v, want ErrOutOfOrder", err)
+	}
+}
+
+// TestWriteAsyncConcurrent checks that entries queued by several
+// goroutines are all written.
+func TestWriteAsyncConcurrent(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SyncPolicy: SyncPolicy{Mode: SyncNever}})
+
+	var mu sync.Mutex
+	next := uint64(1)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// Queued in index order, waited for concurrently
+				mu.Lock()
+				index := next
+				next++
+				result := l.WriteAsync(index, testEntry(index))
+				mu.Unlock()
+				if err := <-result; err != nil {
+					t.Errorf("write %d: %v", index, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	checkEntries(t, l, 1, 800)
+}
+
+// TestWriteAsyncClose checks that Close writes the queued entries and that
+// WriteAsync fails once the log is closed.
+func TestWriteAsyncClose(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	result := l.WriteAsync(11, testEntry(11))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("write queued before close: %v", err)
+	}
+	if err := <-l.WriteAsync(12, testEntry(12)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("write after close: got %v, want ErrClosed", err)
+	}
+
+	l = openTest(t, dir, Config{})
+	checkEntries(t, l, 1, 11)
+}
+
+// TestBarrierAfterWriteAsync checks that Barrier makes the entries queued
+// by WriteAsync before it durable without waiting for their results.
+func TestBarrierAfterWriteAsync(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SyncPolicy: SyncPolicy{Mode: SyncNever}})
+
+	var results []<-chan error
+	for i := uint64(1); i <= 100; i++ {
+		results = append(results, l.WriteAsync(i, testEntry(i)))
+	}
+	if err := l.Barrier(); err != nil {
+		t.Fatal(err)
+	}
+	if durable := l.DurableIndex(); durable != 100 {
+		t.Fatalf("durable index %d after barrier, want 100", durable)
+	}
+
+	for i, result := range results {
+		if err := <-result; err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+	}
+	checkEntries(t, l, 1, 100)
+}
@@ -0,0 +1,130 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestWriteBatch checks that a batch is written in order and cleared, so
+// it can be reused for the next one.
+func TestWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+
+	var b Batch
+	for first := uint64(1); first <= 500; first += 50 {
+		for i := first; i < first+50; i++ {
+			b.Write(i, testEntry(i))
+		}
+		if err := l.WriteBatch(&b); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.entries) != 0 || len(b.datas) != 0 {
+			t.Fatal("batch not cleared after the write")
+		}
+	}
+	checkEntries(t, l, 1, 500)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+}
+
+// TestWriteBatchClear checks that Clear drops the entries added so far.
+func TestWriteBatchClear(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+
+	var b Batch
+	b.Write(5, testEntry(5))
+	b.Clear()
+	b.Write(1, testEntry(1))
+	if err := l.WriteBatch(&b); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, l, 1, 1)
+
+	if err := l.WriteBatch(&b); err != nil {
+		t.Fatalf("empty batch: %v", err)
+	}
+	checkEntries(t, l, 1, 1)
+}
+
+// TestWriteBatchOutOfOrder checks that a batch is rejected as a whole when
+// any of its indexes is out of order.
+func TestWriteBatchOutOfOrder(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 5)
+
+	batches := [][]uint64{{5, 6}, {7, 8}, {6, 7, 9}, {6, 6}}
+	for _, indexes := range batches {
+		var b Batch
+		for _, index := range indexes {
+			b.Write(index, testEntry(index))
+		}
+		if err := l.WriteBatch(&b); !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("batch %v: got %v, want ErrOutOfOrder", indexes, err)
+		}
+	}
+	checkEntries(t, l, 1, 5)
+}
+
+// TestWriteBatchTorn checks that a batch cut short by a crash is dropped
+// as a whole on Open.
+func TestWriteBatchTorn(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 5)
+	writeBatchEntries(t, l, 6, 10)
+	tail := l.segments[len(l.segments)-1].path
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the last entry of the batch in half
+	info, err := os.Stat(tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(tail, info.Size()-int64(len(testEntry(10)))/2); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, Config{})
+	checkEntries(t, l, 1, 5)
+	writeEntries(t, l, 6, 7)
+	l = reopenTest(t, l, dir, Config{})
+	checkEntries(t, l, 1, 7)
+}
+
+// TestWriteBatchTornAtEntry checks that a batch cut between two of its
+// entries is dropped as a whole, although every entry left is intact.
+func TestWriteBatchTornAtEntry(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		if cfg.SegmentCompression != CompressionNone {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 5)
+			writeBatchEntries(t, l, 6, 10)
+			tail := l.segments[len(l.segments)-1]
+			cut := tail.cpos[len(tail.cpos)-2].start
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Drop the last two entries of the batch
+			if err := os.Truncate(tail.path, int64(cut)); err != nil {
+				t.Fatal(err)
+			}
+
+			l = openTest(t, dir, cfg)
+			checkEntries(t, l, 1, 5)
+			writeEntries(t, l, 6, 7)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 7)
+		})
+	}
+}
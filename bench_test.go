@@ -0,0 +1,118 @@
+package jellywal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// benchKey is the key of benchKeys.
+var benchKey = bytes.Repeat([]byte{1}, 32)
+
+// benchKeys is a KeyProvider with a single fixed key.
+type benchKeys struct{}
+
+func (benchKeys) CurrentKey() (uint32, []byte, error) { return 1, benchKey, nil }
+func (benchKeys) Key(uint32) ([]byte, error)          { return benchKey, nil }
+
+// benchEntry is the data written by the benchmarks, compressible so the
+// compressed path stores it compressed.
+var benchEntry = bytes.Repeat([]byte("jellywal "), 16)
+
+func openBench(tb testing.TB, cfg Config) *Log {
+	l, err := Open(tb.TempDir(), &cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+	return l
+}
+
+func benchmarkWrite(b *testing.B, cfg Config) {
+	l := openBench(b, cfg)
+	b.SetBytes(int64(len(benchEntry)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := l.Write(uint64(i+1), benchEntry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	modes := []struct {
+		name string
+		mode SyncMode
+	}{{"SyncNever", SyncNever}, {"SyncAlways", SyncAlways}}
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			benchmarkWrite(b, Config{SyncPolicy: SyncPolicy{Mode: m.mode}})
+		})
+	}
+}
+
+func BenchmarkWriteCompressed(b *testing.B) {
+	for _, c := range []Compression{CompressionSnappy, CompressionLZ4, CompressionZstd} {
+		b.Run(fmt.Sprint(c), func(b *testing.B) {
+			benchmarkWrite(b, Config{SyncPolicy: SyncPolicy{Mode: SyncNever}, Compression: c})
+		})
+	}
+}
+
+func BenchmarkWriteEncrypted(b *testing.B) {
+	benchmarkWrite(b, Config{SyncPolicy: SyncPolicy{Mode: SyncNever}, Encryption: benchKeys{}})
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	l := openBench(b, Config{SyncPolicy: SyncPolicy{Mode: SyncNever}})
+	var batch Batch
+	b.SetBytes(10 * int64(len(benchEntry)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10; j++ {
+			batch.Write(uint64(i*10+j+1), benchEntry)
+		}
+		if err := l.WriteBatch(&batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestWriteAllocs checks that steady writes do not allocate once the tail
+// has grown, whether entries are compressed or encrypted.
+func TestWriteAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items with the race detector")
+	}
+
+	configs := map[string]Config{
+		"raw":       {},
+		"snappy":    {Compression: CompressionSnappy},
+		"lz4":       {Compression: CompressionLZ4},
+		"encrypted": {Encryption: benchKeys{}},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SyncPolicy = SyncPolicy{Mode: SyncNever}
+			cfg.SegmentSize = 64 << 20
+			l := openBench(t, cfg)
+
+			index := uint64(1)
+			write := func() {
+				if err := l.Write(index, benchEntry); err != nil {
+					t.Fatal(err)
+				}
+				index++
+			}
+			// Grow the tail buffers first
+			for i := 0; i < 10000; i++ {
+				write()
+			}
+			if allocs := testing.AllocsPerRun(1000, write); allocs > 0.01 {
+				t.Fatalf("got %v allocations per write, want none", allocs)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package jellywal
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// maxCacheConfigs are the configurations the MaxCacheBytes tests run with,
+// filling the sidecar cache and the decompression cache.
+var maxCacheConfigs = map[string]Config{
+	"sparse":     {SparseIndexInterval: 4},
+	"compressed": {SegmentCompression: CompressionZstd},
+}
+
+// TestMaxCacheBytes checks that random reads keep every cache within its
+// share of MaxCacheBytes, as reported by Stats.
+func TestMaxCacheBytes(t *testing.T) {
+	for name, cfg := range maxCacheConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 4096
+			cfg.MaxCacheBytes = 64 << 10
+			l := openTest(t, t.TempDir(), cfg)
+			writeEntries(t, l, 1, 5000)
+			readRandom(t, l, 5000)
+
+			stats, err := l.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decompressShare := cfg.MaxCacheBytes / 16 * decompressCacheShare
+			indexShare := cfg.MaxCacheBytes / 16 * indexCacheShare
+			segmentShare := cfg.MaxCacheBytes - decompressShare - indexShare
+			tail := l.segments[len(l.segments)-1].cacheSize()
+			if segments := stats.CacheBytes - tail; segments > segmentShare {
+				t.Errorf("segment cache holds %d bytes, over its share of %d", segments, segmentShare)
+			}
+			if stats.DecompressCacheBytes > decompressShare {
+				t.Errorf("decompression cache holds %d bytes, over its share of %d", stats.DecompressCacheBytes, decompressShare)
+			}
+			if stats.IndexCacheBytes > indexShare {
+				t.Errorf("sidecar cache holds %d bytes, over its share of %d", stats.IndexCacheBytes, indexShare)
+			}
+
+			if cfg.SparseIndexInterval > 0 && stats.IndexCacheBytes == 0 {
+				t.Error("no sidecars cached")
+			}
+			if cfg.SegmentCompression != CompressionNone && stats.DecompressCacheBytes == 0 {
+				t.Error("no decompressed segments cached")
+			}
+		})
+	}
+}
+
+// TestMaxCacheBytesTiny checks that a budget too small for any segment
+// still keeps the caches to the most recently read segment and sidecar.
+func TestMaxCacheBytesTiny(t *testing.T) {
+	for name, cfg := range maxCacheConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 4096
+			cfg.MaxCacheBytes = 3
+			l := openTest(t, t.TempDir(), cfg)
+			writeEntries(t, l, 1, 5000)
+			readRandom(t, l, 1000)
+
+			stats, err := l.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.DecompressCacheBytes != 0 {
+				t.Errorf("decompression cache holds %d bytes, want none", stats.DecompressCacheBytes)
+			}
+			if n := len(l.scache.entries); n > 1 {
+				t.Errorf("segment cache holds %d segments, want at most one", n)
+			}
+			if n := len(l.icache.entries); n > 1 {
+				t.Errorf("sidecar cache holds %d sidecars, want at most one", n)
+			}
+		})
+	}
+}
+
+// readRandom reads n random test entries of the log and checks them.
+func readRandom(tb testing.TB, l *Log, n int) {
+	tb.Helper()
+	last, err := l.LastIndex()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		index := uint64(r.Int63n(int64(last))) + 1
+		data, err := l.Read(index)
+		if err != nil {
+			tb.Fatalf("read %d: %v", index, err)
+		}
+		if string(data) != string(testEntry(index)) {
+			tb.Fatalf("read %d: got %q, want %q", index, data, testEntry(index))
+		}
+	}
+}
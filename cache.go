@@ -0,0 +1,204 @@
+package jellywal
+
+import (
+	"slices"
+	"sync"
+)
+
+// Shares of MaxCacheBytes given to the decompression cache and the sparse
+// index cache, in sixteenths. The segment cache gets the rest.
+const (
+	decompressCacheShare = 4
+	indexCacheShare      = 1
+)
+
+// decompressCache keeps the decompressed data of recently read compressed
+// segment files, up to a budget in bytes, so going back and forth between
+// segments does not decompress the same file over and over. Entries are
+// keyed by path, which is only reused for a different file once the cache
+// has been cleared.
+type decompressCache struct {
+	mu      sync.Mutex
+	budget  int                   // Max bytes of decompressed data, 0 disables the cache
+	size    int                   // Bytes of decompressed data cached
+	entries []decompressed        // In the order they were cached
+	policy  EvictionPolicy        // Picks the files to evict, nil until the first put
+	evictor func() EvictionPolicy // Creates the policy, nil for LRU
+}
+
+type decompressed struct {
+	index uint64
+	path  string
+	data  []byte
+}
+
+// get returns the cached data of the file at path, or nil.
+func (c *decompressCache) get(path string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range c.entries {
+		if entry.path == path {
+			c.policy.Access(entry.index)
+			return entry.data
+		}
+	}
+
+	return nil
+}
+
+// put caches the data of the file at path, which holds the segment
+// starting at index, evicting files as the eviction policy sees fit to stay
+// within the budget. Files larger than the budget are not cached.
+func (c *decompressCache) put(index uint64, path string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(data) > c.budget {
+		return
+	}
+
+	if c.policy == nil {
+		c.policy = newEvictionPolicy(c.evictor)
+	}
+
+	for i, entry := range c.entries {
+		if entry.index == index {
+			// A different file of the same segment, such as one
+			// compressed with another algorithm
+			c.size += len(data) - len(entry.data)
+			c.entries[i] = decompressed{index, path, data}
+			c.policy.Access(index)
+			return
+		}
+	}
+
+	for len(c.entries) > 0 && c.size+len(data) > c.budget {
+		evicted := c.policy.Evict()
+		i := slices.IndexFunc(c.entries, func(entry decompressed) bool { return entry.index == evicted })
+		if i < 0 {
+			// Not a cached one, keep the rest rather than loop forever
+			break
+		}
+		c.size -= len(c.entries[i].data)
+		copy(c.entries[i:], c.entries[i+1:])
+		c.entries[len(c.entries)-1] = decompressed{}
+		c.entries = c.entries[:len(c.entries)-1]
+	}
+
+	c.entries = append(c.entries, decompressed{index, path, data})
+	c.size += len(data)
+	c.policy.Insert(index)
+}
+
+// clear drops every cached file.
+func (c *decompressCache) clear() {
+	c.mu.Lock()
+	c.entries = nil
+	c.size = 0
+	c.policy = nil
+	c.mu.Unlock()
+}
+
+// segmentCache keeps the loaded entries of recently read sealed segments,
+// up to a budget in bytes, so reads jumping around the history of the log
+// do not load the same segments over and over. The most recently read
+// segment is kept even when it exceeds the budget on its own.
+type segmentCache struct {
+	mu        sync.Mutex
+	budget    int64                 // Max bytes of loaded entries and positions
+	size      int64                 // Bytes of loaded entries and positions cached
+	entries   []*segment            // In the order they were cached
+	policy    EvictionPolicy        // Picks the segments to evict, nil until the first put
+	evictor   func() EvictionPolicy // Creates the policy, nil for LRU
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// get returns the cached segment holding the given index, or nil.
+func (c *segmentCache) get(index uint64) *segment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, seg := range c.entries {
+		if index >= seg.index && index < seg.index+uint64(len(seg.cpos)) {
+			c.policy.Access(seg.index)
+			c.hits++
+			return seg
+		}
+	}
+
+	c.misses++
+	return nil
+}
+
+// put caches a loaded segment, evicting segments as the eviction policy
+// sees fit to stay within the budget. Concurrent readers may load the same
+// segment, only the first one is cached.
+func (c *segmentCache) put(seg *segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cached := range c.entries {
+		if cached.index == seg.index {
+			return
+		}
+	}
+
+	if c.policy == nil {
+		c.policy = newEvictionPolicy(c.evictor)
+	}
+
+	size := seg.cacheSize()
+	for len(c.entries) > 0 && c.size+size > c.budget {
+		evicted := c.policy.Evict()
+		i := slices.IndexFunc(c.entries, func(cached *segment) bool { return cached.index == evicted })
+		if i < 0 {
+			// Not a cached one, keep the rest rather than loop forever
+			break
+		}
+		c.size -= c.entries[i].cacheSize()
+		copy(c.entries[i:], c.entries[i+1:])
+		c.entries[len(c.entries)-1] = nil
+		c.entries = c.entries[:len(c.entries)-1]
+		c.evictions++
+	}
+
+	c.entries = append(c.entries, seg)
+	c.size += size
+	c.policy.Insert(seg.index)
+}
+
+// holds reports whether a segment holding the given index is cached,
+// without counting as a read.
+func (c *segmentCache) holds(index uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, seg := range c.entries {
+		if index >= seg.index && index < seg.index+uint64(len(seg.cpos)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clear drops every cached segment. The counters are kept.
+func (c *segmentCache) clear() {
+	c.mu.Lock()
+	c.entries = nil
+	c.size = 0
+	c.policy = nil
+	c.mu.Unlock()
+}
+
+// newEvictionPolicy returns a policy from the given constructor, or an LRU
+// policy when there is none.
+func newEvictionPolicy(evictor func() EvictionPolicy) EvictionPolicy {
+	if evictor == nil {
+		return NewLRUEviction()
+	}
+	return evictor()
+}
@@ -0,0 +1,150 @@
+package jellywal
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestDecompressCache checks that the decompression cache keeps files
+// within its budget, evicting the least recently read, and refuses files
+// larger than the budget.
+func TestDecompressCache(t *testing.T) {
+	c := decompressCache{budget: 30}
+	file := func(n byte) []byte { return bytes.Repeat([]byte{n}, 10) }
+
+	c.put(1, "a", file(1))
+	c.put(2, "b", file(2))
+	c.put(3, "c", file(3))
+	if got := c.get("a"); !bytes.Equal(got, file(1)) {
+		t.Fatalf("get a: got %v", got)
+	}
+
+	// b is the least recently read now
+	c.put(4, "d", file(4))
+	if c.get("b") != nil {
+		t.Fatal("least recently read file kept")
+	}
+	for _, path := range []string{"a", "c", "d"} {
+		if c.get(path) == nil {
+			t.Fatalf("file %s evicted", path)
+		}
+	}
+	if c.size != 30 {
+		t.Fatalf("%d bytes cached, want 30", c.size)
+	}
+
+	c.put(5, "e", bytes.Repeat([]byte{5}, 31))
+	if c.get("e") != nil {
+		t.Fatal("file larger than the budget cached")
+	}
+
+	// Another file of a cached segment replaces it
+	c.put(1, "a.zst", file(6))
+	if got := c.get("a.zst"); !bytes.Equal(got, file(6)) || c.get("a") != nil || c.size != 30 {
+		t.Fatalf("replaced file: got %v with %d bytes cached", got, c.size)
+	}
+
+	c.clear()
+	if c.get("c") != nil || c.size != 0 {
+		t.Fatal("files kept by clear")
+	}
+}
+
+// TestDecompressCacheLog checks that reads moving between compressed
+// segments are served from the decompression cache, and that a negative
+// budget disables it.
+func TestDecompressCacheLog(t *testing.T) {
+	for _, budget := range []int{0, -1} {
+		dir := t.TempDir()
+		cfg := Config{SegmentSize: 4096, SegmentCompression: CompressionZstd, DecompressCacheBytes: budget}
+		l := openTest(t, dir, cfg)
+		writeCompressible(t, l, 1, 100)
+		l = reopenTest(t, l, dir, cfg)
+		checkCompressible(t, l, 1, 100)
+
+		stats, err := l.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached := stats.DecompressCacheBytes > 0; cached != (budget >= 0) {
+			t.Fatalf("budget %d: %d bytes of decompressed data cached", budget, stats.DecompressCacheBytes)
+		}
+		if budget == 0 && stats.DecompressCacheBytes > 2*4096 {
+			t.Fatalf("%d bytes of decompressed data cached, over the default budget", stats.DecompressCacheBytes)
+		}
+	}
+}
+
+// TestSegmentCache checks that the segment cache keeps segments within its
+// budget, evicting the least recently read, and always keeps the last one.
+func TestSegmentCache(t *testing.T) {
+	seg := func(index uint64) *segment {
+		return &segment{index: index, cbuf: make([]byte, 100), cpos: make([]bytepos, 10)}
+	}
+	size := seg(0).cacheSize()
+	c := segmentCache{budget: 3 * size}
+
+	for _, index := range []uint64{1, 11, 21} {
+		c.put(seg(index))
+	}
+	if c.get(5) == nil {
+		t.Fatal("get 5: segment 1 not cached")
+	}
+
+	// Segment 11 is the least recently read now
+	c.put(seg(31))
+	if c.get(15) != nil {
+		t.Fatal("least recently read segment kept")
+	}
+	for _, index := range []uint64{1, 21, 31} {
+		if c.get(index) == nil {
+			t.Fatalf("segment %d evicted", index)
+		}
+	}
+	if c.size != 3*size || c.hits != 4 || c.misses != 1 || c.evictions != 1 {
+		t.Fatalf("got size %d, %d hits, %d misses, %d evictions", c.size, c.hits, c.misses, c.evictions)
+	}
+
+	c.budget = 0
+	c.put(seg(41))
+	if len(c.entries) != 1 || c.get(41) == nil {
+		t.Fatalf("got %d segments cached, want the last one", len(c.entries))
+	}
+}
+
+// TestSegmentCacheLog checks that random reads over sealed segments are
+// served from the cache within its budget, and that the counters follow.
+func TestSegmentCacheLog(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SegmentCacheBytes: 4096}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 1000)
+	l = reopenTest(t, l, dir, cfg)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		index := uint64(r.Intn(900) + 1)
+		data, err := l.Read(index)
+		if err != nil {
+			t.Fatalf("read %d: %v", index, err)
+		}
+		if !bytes.Equal(data, testEntry(index)) {
+			t.Fatalf("read %d: got %q, want %q", index, data, testEntry(index))
+		}
+		if size := l.scache.size; size > cfg.SegmentCacheBytes && len(l.scache.entries) > 1 {
+			t.Fatalf("%d bytes cached, over the budget", size)
+		}
+	}
+
+	stats, err := l.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CacheHits == 0 || stats.CacheMisses == 0 || stats.CacheEvictions == 0 {
+		t.Fatalf("got %d hits, %d misses, %d evictions; want some of each", stats.CacheHits, stats.CacheMisses, stats.CacheEvictions)
+	}
+	if stats.CacheMisses-stats.CacheEvictions > uint64(len(l.scache.entries)) {
+		t.Fatalf("%d misses and %d evictions with %d segments cached", stats.CacheMisses, stats.CacheEvictions, len(l.scache.entries))
+	}
+}
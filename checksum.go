@@ -0,0 +1,186 @@
+package jellywal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/crc64"
+	"math/bits"
+)
+
+// Checksum is an algorithm used to checksum log entries. The ID of the
+// algorithm is recorded in the header of every segment, so segments written
+// with different algorithms can live in the same log.
+type Checksum struct {
+	Name string                   // Name used in error messages
+	ID   uint8                    // Recorded in segment headers, 128 and above are for custom algorithms
+	Size int                      // Size of the checksum in bytes, between 1 and 8
+	Sum  func(data []byte) uint64 // Checksums an encoded entry, only the low Size bytes are stored
+}
+
+var (
+	// ChecksumCRC32C is the Castagnoli CRC32, which is hardware accelerated
+	// on most platforms. It is the default where it is, see CPUFeatures.
+	ChecksumCRC32C = &Checksum{Name: "crc32c", ID: 1, Size: 4, Sum: crc32c}
+
+	// ChecksumCRC64 is the ECMA CRC64.
+	ChecksumCRC64 = &Checksum{Name: "crc64", ID: 2, Size: 8, Sum: crc64ecma}
+
+	// ChecksumXXHash64 is the 64-bit xxHash, which is faster than the CRCs
+	// on large entries when no CRC instructions are available.
+	ChecksumXXHash64 = &Checksum{Name: "xxhash64", ID: 3, Size: 8, Sum: xxhash64}
+)
+
+// builtinChecksums holds the built-in algorithms by ID.
+var builtinChecksums = []*Checksum{ChecksumCRC32C, ChecksumCRC64, ChecksumXXHash64}
+
+// validate checks that a custom algorithm does not clash with the built-in
+// ones and can be stored.
+func (c *Checksum) validate() error {
+	for _, builtin := range builtinChecksums {
+		if c == builtin {
+			return nil
+		}
+	}
+
+	if c.ID < 128 {
+		return errors.New("custom checksum IDs must be 128 or above")
+	} else if c.Size < 1 || c.Size > 8 {
+		return errors.New("checksum size must be between 1 and 8 bytes")
+	} else if c.Sum == nil {
+		return errors.New("checksum has no Sum function")
+	}
+
+	return nil
+}
+
+// lookupChecksum returns the algorithm with the given ID, which is either
+// built in or the one configured for the log.
+func (l *Log) lookupChecksum(id uint8) (*Checksum, bool) {
+	if l.config.Checksum.ID == id {
+		return l.config.Checksum, true
+	}
+
+	for _, builtin := range builtinChecksums {
+		if builtin.ID == id {
+			return builtin, true
+		}
+	}
+
+	return nil, false
+}
+
+// appendSum appends the checksum of data to dst.
+func (c *Checksum) appendSum(dst []byte, data []byte) []byte {
+	sum := c.Sum(data)
+	for i := 0; i < c.Size; i++ {
+		dst = append(dst, byte(sum>>(8*i)))
+	}
+	return dst
+}
+
+// readSum reads a checksum stored by appendSum.
+func (c *Checksum) readSum(buf []byte) uint64 {
+	var sum uint64
+	for i := 0; i < c.Size; i++ {
+		sum |= uint64(buf[i]) << (8 * i)
+	}
+	return sum
+}
+
+// mask truncates a computed checksum to the stored size.
+func (c *Checksum) mask(sum uint64) uint64 {
+	if c.Size == 8 {
+		return sum
+	}
+	return sum & (1<<(8*c.Size) - 1)
+}
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+	ecma       = crc64.MakeTable(crc64.ECMA)
+)
+
+func crc32c(data []byte) uint64 {
+	return uint64(crc32.Checksum(data, castagnoli))
+}
+
+func crc64ecma(data []byte) uint64 {
+	return crc64.Checksum(data, ecma)
+}
+
+// The xxHash primes are variables so the wrapping arithmetic on them is not
+// rejected at compile time.
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 returns the 64-bit xxHash of data with a zero seed.
+func xxhash64(data []byte) uint64 {
+	n := len(data)
+
+	var h uint64
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for len(data) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(data[24:]))
+			data = data[32:]
+		}
+
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		data = data[4:]
+	}
+
+	for ; len(data) > 0; data = data[1:] {
+		h ^= uint64(data[0]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
@@ -0,0 +1,147 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// corruptEntry changes the data of the test entry at index in the segment
+// file at path, leaving its checksum as it was.
+func corruptEntry(tb testing.TB, path string, index uint64) {
+	tb.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	entry := testEntry(index)
+	at := bytes.Index(data, entry)
+	if at < 0 {
+		tb.Fatalf("entry %d not found in %s", index, path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	last := at + len(entry) - 1
+	if _, err := file.WriteAt([]byte{data[last] ^ 1}, int64(last)); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// TestChecksumRead checks that reading a damaged entry of a sealed segment
+// fails with a CorruptError locating it, while the other segments stay
+// readable.
+func TestChecksumRead(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	sealed := l.segments[0]
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, sealed.path, 3)
+
+	l = openTest(t, dir, cfg)
+	_, err := l.Read(3)
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) || !errors.Is(err, ErrCorrupt) || !errors.Is(err, errChecksum) {
+		t.Fatalf("read of a damaged entry: got %v, want a checksum CorruptError", err)
+	}
+	if corrupt.Path != sealed.path {
+		t.Fatalf("corruption reported in %q, want %q", corrupt.Path, sealed.path)
+	}
+
+	last := l.segments[1].index
+	if data, err := l.Read(last); err != nil || !bytes.Equal(data, testEntry(last)) {
+		t.Fatalf("read %d: got %q, %v", last, data, err)
+	}
+}
+
+// TestChecksumTail checks that Open refuses a tail segment with a damaged
+// entry, which is not what a crash leaves behind.
+func TestChecksumTail(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, l.segments[0].path, 5)
+
+	if _, err := Open(dir, nil); !errors.Is(err, ErrCorrupt) || !errors.Is(err, errChecksum) {
+		t.Fatalf("open: got %v, want a checksum mismatch", err)
+	}
+}
+
+// TestChecksumVectors checks the built-in algorithms against their
+// reference values.
+func TestChecksumVectors(t *testing.T) {
+	for _, test := range []struct {
+		sum  *Checksum
+		data string
+		want uint64
+	}{
+		{ChecksumCRC32C, "123456789", 0xe3069283},
+		{ChecksumCRC64, "123456789", 0x995dc9bbdf1939fa},
+		{ChecksumXXHash64, "", 0xef46db3751d8e999},
+		{ChecksumXXHash64, "abc", 0x44bc2cf5ad770999},
+	} {
+		if got := test.sum.Sum([]byte(test.data)); got != test.want {
+			t.Errorf("%s(%q) = %#x, want %#x", test.sum.Name, test.data, got, test.want)
+		}
+	}
+}
+
+// TestChecksumMixed checks that segments written with different algorithms,
+// custom ones included, are read back whatever the algorithm configured.
+func TestChecksumMixed(t *testing.T) {
+	custom := &Checksum{Name: "sum16", ID: 200, Size: 2, Sum: func(data []byte) uint64 {
+		var sum uint64
+		for _, b := range data {
+			sum = sum*31 + uint64(b)
+		}
+		return sum
+	}}
+
+	dir := t.TempDir()
+	var last uint64
+	for _, sum := range []*Checksum{ChecksumCRC32C, ChecksumCRC64, ChecksumXXHash64, custom} {
+		cfg := Config{SegmentSize: 1024, Checksum: sum}
+		l := openTest(t, dir, cfg)
+		writeEntries(t, l, last+1, last+200)
+		last += 200
+		l = reopenTest(t, l, dir, cfg)
+		checkEntries(t, l, 1, last)
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := openTest(t, dir, Config{Checksum: custom})
+	report, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("mixed checksums do not verify: %v", report.Problems)
+	}
+}
+
+// TestChecksumInvalid checks that custom algorithms are validated by Open.
+func TestChecksumInvalid(t *testing.T) {
+	sum := func([]byte) uint64 { return 0 }
+	for _, custom := range []*Checksum{
+		{Name: "low", ID: 5, Size: 4, Sum: sum},
+		{Name: "empty", ID: 200, Size: 0, Sum: sum},
+		{Name: "wide", ID: 200, Size: 9, Sum: sum},
+		{Name: "nil", ID: 200, Size: 4},
+	} {
+		if _, err := Open(t.TempDir(), &Config{Checksum: custom}); err == nil {
+			t.Fatalf("open with checksum %s succeeded", custom.Name)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package jellywal
+
+// Codec transforms the data of every entry on its way to and from disk, so
+// applications can layer their own compression, encryption or framing onto
+// the log. Encode is applied after Compression, so a codec that encrypts
+// does not defeat it, and before Encryption, see Config.Pipeline. Every
+// entry in the log goes through the codec, which therefore has to stay the
+// same for the lifetime of the log, and entries written before it was
+// configured are no longer readable.
+//
+// Both methods may be called concurrently and must not modify or retain
+// their argument.
+type Codec interface {
+	// Encode returns the data to store for an entry.
+	Encode(data []byte) ([]byte, error)
+
+	// Decode returns the entry data that was passed to Encode.
+	Decode(data []byte) ([]byte, error)
+}
@@ -0,0 +1,104 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+// errCodec is returned by xorCodec when it is set to fail.
+var errCodec = errors.New("codec failure")
+
+// xorCodec flips every bit of the entries it encodes, recording the size of
+// the largest one.
+type xorCodec struct {
+	mu      sync.Mutex
+	largest int
+	fail    bool // Whether Encode and Decode fail
+}
+
+func (c *xorCodec) Encode(data []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail {
+		return nil, errCodec
+	}
+	c.largest = max(c.largest, len(data))
+	return c.flip(data), nil
+}
+
+func (c *xorCodec) Decode(data []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail {
+		return nil, errCodec
+	}
+	return c.flip(data), nil
+}
+
+func (c *xorCodec) flip(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = ^b
+	}
+	return out
+}
+
+// TestCodec checks that entries go through the codec on their way to and
+// from disk.
+func TestCodec(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, Codec: &xorCodec{}}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+
+	for _, seg := range l.segments {
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("entry")) {
+			t.Fatalf("segment %d holds entries as they were written", seg.index)
+		}
+	}
+	checkEntries(t, l, 1, 500)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+}
+
+// TestCodecAfterCompression checks that the codec is applied to compressed
+// entries.
+func TestCodecAfterCompression(t *testing.T) {
+	codec := &xorCodec{}
+	l := openTest(t, t.TempDir(), Config{Compression: CompressionZstd, Codec: codec})
+	writeCompressible(t, l, 1, 10)
+	if codec.largest >= len(compressibleEntry(10)) {
+		t.Fatalf("codec got %d bytes, want the compressed entry", codec.largest)
+	}
+	checkCompressible(t, l, 1, 10)
+}
+
+// TestCodecErrors checks that codec failures fail the write, leaving the log
+// as it was, and the read.
+func TestCodecErrors(t *testing.T) {
+	dir := t.TempDir()
+	codec := &xorCodec{}
+	l := openTest(t, dir, Config{SegmentSize: 1024, Codec: codec})
+	writeEntries(t, l, 1, 500)
+	l = reopenTest(t, l, dir, Config{SegmentSize: 1024, Codec: codec})
+
+	codec.fail = true
+	if err := l.Write(501, testEntry(501)); !errors.Is(err, errCodec) {
+		t.Fatalf("write: got %v, want the codec error", err)
+	}
+	if _, err := l.Read(1); !errors.Is(err, errCodec) {
+		t.Fatalf("read: got %v, want the codec error", err)
+	}
+
+	codec.fail = false
+	checkEntries(t, l, 1, 500)
+	writeEntries(t, l, 501, 510)
+}
@@ -0,0 +1,199 @@
+package jellywal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// startSuffix marks a segment file rewritten by a compacting TruncateFront,
+// Split or Merge that is not in place yet. Open completes the rewrite when the
+// manifest lists it already, and removes it as a stray otherwise.
+const startSuffix = ".START"
+
+// writeStartFile writes data to the START file of the segment file at
+// finalPath, compressed according to its suffix, and returns its size.
+func (l *Log) writeStartFile(finalPath string, data []byte) (int64, error) {
+	tempPath := filepath.Join(l.path, "TEMP"+segmentSuffixes[segmentFileCompression(finalPath)])
+	if err := writeSegmentFile(tempPath, l.config.FilePerms, data); err != nil {
+		os.Remove(tempPath)
+		return 0, noSpaceError(fmt.Errorf("failed to write log segment: %w", err))
+	}
+
+	startPath := finalPath + startSuffix
+	if err := os.Rename(tempPath, startPath); err != nil {
+		os.Remove(tempPath)
+		return 0, fmt.Errorf("failed to rename log segment: %w", err)
+	}
+
+	info, err := os.Stat(startPath)
+	if err != nil {
+		os.Remove(startPath)
+		return 0, fmt.Errorf("failed to stat log segment: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// compactable reports whether the loaded segment can be rewritten to start
+// at a later index. Headerless segments are left as they are, and so are
+// segments whose entries are bound to their first index when there is no
+// key to bind them again.
+func (l *Log) compactable(seg *segment) bool {
+	if seg.hdr == 0 {
+		return false
+	}
+	return seg.flags()&segmentFlagBound == 0 || l.keys != nil
+}
+
+// truncateFrontCompact is truncateFront for an index falling in the middle
+// of the loaded segment at segIdx, which it rewrites to start at the index
+// so the entries in front of it do not take up space until the segment is
+// removed. The rewritten segment is put in place in steps Open can pick up
+// from after a crash: it is written as a START file, the manifest is
+// updated to list it, the old files are removed and it is renamed to its
+// final name.
+func (l *Log) truncateFrontCompact(segIdx int, seg *segment, index uint64) error {
+	found := l.segments[segIdx]
+	isTail := segIdx == len(l.segments)-1
+
+	data, _, err := l.segmentRange(seg, index, seg.index+uint64(len(seg.cpos)), !isTail)
+	if err != nil {
+		return err
+	}
+
+	finalPath := filepath.Join(l.path, l.segmentName(index)+segmentSuffixes[segmentFileCompression(found.path)])
+	startPath := finalPath + startSuffix
+	size, err := l.writeStartFile(finalPath, data)
+	if err != nil {
+		return err
+	}
+
+	// The START file has to be durable before the manifest refers to it
+	if err := l.syncDir(); err != nil {
+		os.Remove(startPath)
+		return err
+	}
+
+	compacted := &segment{
+		index: index,
+		path:  finalPath,
+		size:  size,
+		last:  found.last,
+		seal:  found.seal,
+	}
+	segments := append([]*segment{compacted}, l.segments[segIdx+1:]...)
+	if err := l.writeManifest(segments, index, 0); err != nil {
+		os.Remove(startPath)
+		return noSpaceError(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return err
+	}
+
+	// Any errors from here on will not corrupt the data on disk, but leave
+	// the in-memory state inconsistent. Flag the log as corrupt so the user
+	// can recover by calling RecoverCorrupt, or Close followed by Open.
+	if isTail {
+		if err := l.sfile.Close(); err != nil {
+			return l.markCorrupt(fmt.Errorf("failed to close tail segment: %w", err))
+		}
+	}
+
+	for _, removed := range l.segments[:segIdx+1] {
+		if err := l.discardSegmentFile(removed); err != nil {
+			return l.markCorrupt(fmt.Errorf("failed to remove truncated log segment: %w", err))
+		}
+	}
+
+	if err := os.Rename(startPath, finalPath); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to rename START log segment: %w", err))
+	}
+	if !isTail {
+		l.protectSegmentFile(finalPath)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return l.markCorrupt(err)
+	}
+
+	l.segments = segments
+	l.firstIndex = index
+	l.clearCache()
+
+	if isTail {
+		if err := l.reopenTail(compacted); err != nil {
+			return err
+		}
+		if compacted.rfile != nil {
+			// The segment is too large to append to in memory
+			if err := l.sealStreamedTail(); err != nil {
+				return l.markCorrupt(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// segmentRange returns the data of a segment holding the entries of the
+// loaded segment from the first index up to the end index, exclusive, with
+// a footer unless it is to be the tail, along with the positions of the
+// entries.
+func (l *Log) segmentRange(seg *segment, first, end uint64, sealed bool) ([]byte, []bytepos, error) {
+	data := appendSegmentHeader(nil, seg.format(), seg.sum, seg.flags())
+	data, positions, err := l.appendSegmentRange(data, make([]bytepos, 0, end-first), seg, first, end, first)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A range cut from the middle of a batch has to end it, or its last
+	// entries would be taken for a torn write
+	if len(positions) > 0 {
+		seg.format().endBatch(data[positions[len(positions)-1].start:], seg.sum)
+	}
+
+	if sealed {
+		data = appendSegmentFooter(data, data, first, positions, seg.sum)
+	}
+
+	return data, positions, nil
+}
+
+// appendSegmentRange appends the entries of the loaded segment from the
+// given index up to the end index, exclusive, to the data of a segment
+// starting at first, and their positions to positions. Entries bound to the
+// first index of the segment are encrypted again, with the key they were
+// encrypted with, to bind them to first.
+func (l *Log) appendSegmentRange(data []byte, positions []bytepos, seg *segment, from, end, first uint64) ([]byte, []bytepos, error) {
+	flags, format := seg.flags(), seg.format()
+	for i := from; i < end; i++ {
+		raw, err := seg.entryBytes(seg.cpos[i-seg.index])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entry, entryFlags, _, err := format.decodeEntry(raw, seg.sum)
+		if err == nil && flags&segmentFlagBound != 0 {
+			var plaintext []byte
+			if plaintext, err = l.keys.decrypt(entry, segmentAAD(flags, seg.index, i)); err == nil {
+				keyID := binary.LittleEndian.Uint32(entry)
+				entry, err = l.keys.encryptWith(keyID, plaintext, segmentAAD(flags, first, i))
+			}
+		}
+		if err != nil {
+			return nil, nil, locateCorruption(err, seg.path, i, 0)
+		}
+
+		start := len(data)
+		data = format.appendEntry(data, entry, entryFlags&(entryMore|entryCompressed), seg.sum)
+		positions = append(positions, bytepos{start, len(data)})
+	}
+	// Entries were copied from the mapping up to here
+	runtime.KeepAlive(seg.mmap)
+
+	return data, positions, nil
+}
@@ -0,0 +1,100 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCompactOnTruncate checks that TruncateFront rewrites the segment it
+// cuts through to start at the new first index, sealed or tail, and that
+// the log stays writable.
+func TestCompactOnTruncate(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg.SegmentSize = 1024
+			cfg.CompactOnTruncate = true
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+
+			if err := l.TruncateFront(250); err != nil {
+				t.Fatal(err)
+			}
+			if index := l.segments[0].index; index != 250 {
+				t.Fatalf("first segment starts at %d, want 250", index)
+			}
+			if name := filepath.Base(l.segments[0].path); name[:segmentDigits] != l.segmentName(250) {
+				t.Fatalf("first segment file named %s", name)
+			}
+			checkEntries(t, l, 250, 500)
+
+			// Cut through the tail
+			if err := l.TruncateFront(498); err != nil {
+				t.Fatal(err)
+			}
+			if len(l.segments) != 1 || l.segments[0].index != 498 {
+				t.Fatalf("got %d segments from %d, want the tail from 498", len(l.segments), l.segments[0].index)
+			}
+			writeEntries(t, l, 501, 600)
+
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 498, 600)
+			checkVerify(t, l)
+		})
+	}
+}
+
+// TestCompactOnTruncateInterrupted checks that Open completes a compaction
+// that was recorded in the manifest before a crash, and removes the
+// rewritten segment of one that was not.
+func TestCompactOnTruncateInterrupted(t *testing.T) {
+	for _, recorded := range []bool{false, true} {
+		dir := t.TempDir()
+		cfg := Config{SegmentSize: 1024, CompactOnTruncate: true}
+		l := openTest(t, dir, cfg)
+		writeEntries(t, l, 1, 500)
+		if err := l.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		crashed := crashCopy(t, dir)
+
+		if err := l.TruncateFront(250); err != nil {
+			t.Fatal(err)
+		}
+		compacted := l.segments[0].path
+
+		// Crash once the rewritten segment was written, and maybe recorded
+		data, err := os.ReadFile(compacted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := filepath.Join(crashed, filepath.Base(compacted)+startSuffix)
+		if err := os.WriteFile(start, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if recorded {
+			manifest, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(crashed, manifestFileName), manifest, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		l = openTest(t, crashed, cfg)
+		if _, err := os.Stat(start); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("recorded %v: START file left in place: %v", recorded, err)
+		}
+		if recorded {
+			checkEntries(t, l, 250, 500)
+		} else {
+			checkEntries(t, l, 1, 500)
+		}
+		writeEntries(t, l, 501, 510)
+		l = reopenTest(t, l, crashed, cfg)
+		checkVerify(t, l)
+	}
+}
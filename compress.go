@@ -0,0 +1,188 @@
+package jellywal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression is an algorithm used to compress entry data. Compressed
+// entries are flagged with entryCompressed and their data starts with the
+// algorithm, so segments can mix compressed and raw entries written with
+// any algorithm.
+type Compression uint8
+
+const (
+	// CompressionNone stores entries as they are. It is the default.
+	CompressionNone Compression = iota
+
+	// CompressionSnappy is the Snappy block format, which is very fast but
+	// compresses the least.
+	CompressionSnappy
+
+	// CompressionZstd is Zstandard at its default level, which compresses
+	// best at a higher CPU cost.
+	CompressionZstd
+
+	// CompressionLZ4 is the LZ4 block format, which decompresses fastest.
+	CompressionLZ4
+
+	// CompressionZstdDict is Zstandard with a dictionary trained on a
+	// sample of the entries written to the log, see DictionaryPolicy,
+	// which compresses small entries with a shared structure far better.
+	// Entries are compressed with CompressionZstd until the dictionary is
+	// trained. It is stored in the DICTIONARY file of the log, which must
+	// be kept with the segments. Only valid for entry compression.
+	CompressionZstdDict
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionLZ4:
+		return "lz4"
+	case CompressionZstdDict:
+		return "zstd-dict"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// validate checks that the algorithm is known.
+func (c Compression) validate() error {
+	if c > CompressionZstdDict {
+		return fmt.Errorf("unknown compression algorithm %d", uint8(c))
+	}
+	return nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodec returns the shared zstd encoder and decoder, which are safe for
+// concurrent use through EncodeAll and DecodeAll.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxCompressedEntrySize))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// maxCompressedEntrySize is the size of the largest entry that gets
+// compressed, larger ones are stored raw. Decompression refuses to produce
+// more, so damaged or hostile data cannot make it allocate without bounds.
+const maxCompressedEntrySize = 64 << 20
+
+// maxCompressionRatio bounds the decompressed size claimed by Snappy and
+// LZ4 data before any memory is allocated for it. Neither format can reach
+// it, so larger claims come from damaged or hostile data.
+const maxCompressionRatio = 255
+
+// appendCompressed appends the algorithm followed by data compressed with
+// it to dst, compressing straight into its spare capacity. It reports
+// false, returning dst at its original length, when compression does not
+// make the data smaller or the data is too large.
+// CompressionZstdDict falls back to CompressionZstd while dict is nil.
+func (c Compression) appendCompressed(dst, data []byte, dict *zstdDict) ([]byte, bool) {
+	if len(data) > maxCompressedEntrySize {
+		return dst, false
+	}
+
+	if c == CompressionZstdDict && dict == nil {
+		c = CompressionZstd
+	}
+
+	start := len(dst)
+	switch c {
+	case CompressionSnappy:
+		bound := s2.MaxEncodedLen(len(data))
+		dst = slices.Grow(append(dst, byte(c)), bound)
+		compressed := s2.EncodeSnappy(dst[len(dst):len(dst)+bound], data)
+		dst = dst[:len(dst)+len(compressed)]
+	case CompressionZstd:
+		encoder, _ := zstdCodec()
+		dst = encoder.EncodeAll(data, append(dst, byte(c)))
+	case CompressionZstdDict:
+		dst = dict.encoder.EncodeAll(data, append(dst, byte(c)))
+	case CompressionLZ4:
+		bound := lz4.CompressBlockBound(len(data))
+		dst = slices.Grow(binary.AppendUvarint(append(dst, byte(c)), uint64(len(data))), bound)
+		n, err := lz4.CompressBlock(data, dst[len(dst):len(dst)+bound], nil)
+		if err != nil || n == 0 {
+			// Incompressible
+			return dst[:start], false
+		}
+		dst = dst[:len(dst)+n]
+	default:
+		return dst, false
+	}
+
+	if len(dst)-start >= len(data) {
+		return dst[:start], false
+	}
+
+	return dst, true
+}
+
+// decompressEntry decompresses the data of an entry flagged with
+// entryCompressed, using dict for CompressionZstdDict. Errors are
+// CorruptErrors with an offset relative to data.
+func decompressEntry(data []byte, dict *zstdDict) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, &CorruptError{Err: errDecompress, Expected: "compression algorithm", Found: "no data"}
+	}
+
+	c, src := Compression(data[0]), data[1:]
+	var decompressed []byte
+	var err error
+	switch c {
+	case CompressionSnappy:
+		var n int
+		if n, err = s2.DecodedLen(src); err == nil && (n > maxCompressionRatio*len(src) || n > maxCompressedEntrySize) {
+			err = fmt.Errorf("decompressed size %d is out of bounds", n)
+		} else if err == nil {
+			decompressed, err = s2.Decode(nil, src)
+		}
+	case CompressionZstd:
+		_, decoder := zstdCodec()
+		decompressed, err = decoder.DecodeAll(src, nil)
+	case CompressionZstdDict:
+		if dict == nil {
+			return nil, &CorruptError{Err: errDecompress, Expected: "zstd dictionary", Found: "no " + dictionaryFileName + " file"}
+		}
+		decompressed, err = dict.decoder.DecodeAll(src, nil)
+	case CompressionLZ4:
+		size, n := binary.Uvarint(src)
+		if n <= 0 || size > uint64(min(maxCompressionRatio*len(src), maxCompressedEntrySize)) {
+			err = errors.New("invalid decompressed size")
+			break
+		}
+		decompressed = make([]byte, size)
+		var written int
+		if written, err = lz4.UncompressBlock(src[n:], decompressed); err == nil && uint64(written) != size {
+			err = fmt.Errorf("decompressed %d bytes, expected %d", written, size)
+		}
+	default:
+		return nil, &CorruptError{Err: errDecompress, Expected: "compression algorithm", Found: c.String()}
+	}
+
+	if err != nil {
+		return nil, &CorruptError{Err: errDecompress, Expected: c.String() + " data", Found: err.Error()}
+	}
+
+	return decompressed, nil
+}
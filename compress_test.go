@@ -0,0 +1,242 @@
+package jellywal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// compressibleEntry returns the compressible data the compression tests
+// write at index.
+func compressibleEntry(index uint64) []byte {
+	return bytes.Repeat(testEntry(index), 50)
+}
+
+// writeCompressible writes compressible entries first through last.
+func writeCompressible(tb testing.TB, l *Log, first, last uint64) {
+	tb.Helper()
+	for i := first; i <= last; i++ {
+		if err := l.Write(i, compressibleEntry(i)); err != nil {
+			tb.Fatalf("write %d: %v", i, err)
+		}
+	}
+}
+
+// checkCompressible checks that the log holds the compressible entries
+// first through last.
+func checkCompressible(tb testing.TB, l *Log, first, last uint64) {
+	tb.Helper()
+	for i := first; i <= last; i++ {
+		data, err := l.Read(i)
+		if err != nil {
+			tb.Fatalf("read %d: %v", i, err)
+		}
+		if !bytes.Equal(data, compressibleEntry(i)) {
+			tb.Fatalf("read %d: got %d bytes, want %d", i, len(data), len(compressibleEntry(i)))
+		}
+	}
+}
+
+// diskSize returns the size of the segment files of the log.
+func diskSize(tb testing.TB, l *Log) int64 {
+	tb.Helper()
+	var size int64
+	for _, seg := range l.segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+// TestCompression checks that every algorithm shrinks compressible entries
+// and reads them back, before and after reopening.
+func TestCompression(t *testing.T) {
+	raw := openTest(t, t.TempDir(), Config{})
+	writeCompressible(t, raw, 1, 100)
+	rawSize := diskSize(t, raw)
+
+	for _, compression := range []Compression{CompressionSnappy, CompressionZstd, CompressionLZ4} {
+		t.Run(compression.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{Compression: compression}
+			l := openTest(t, dir, cfg)
+			writeCompressible(t, l, 1, 100)
+			if size := diskSize(t, l); size*2 > rawSize {
+				t.Fatalf("compressed to %d bytes from %d", size, rawSize)
+			}
+			checkCompressible(t, l, 1, 100)
+
+			l = reopenTest(t, l, dir, cfg)
+			checkCompressible(t, l, 1, 100)
+			checkVerify(t, l)
+		})
+	}
+}
+
+// TestCompressionMixed checks that a log written with every algorithm in
+// turn, and none, reads back whatever the algorithm configured.
+func TestCompressionMixed(t *testing.T) {
+	dir := t.TempDir()
+	var last uint64
+	for _, compression := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd, CompressionLZ4, CompressionNone} {
+		cfg := Config{SegmentSize: 4096, Compression: compression}
+		l := openTest(t, dir, cfg)
+		writeCompressible(t, l, last+1, last+50)
+		writeEntries(t, l, last+51, last+60)
+		last += 60
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := openTest(t, dir, Config{})
+	for first := uint64(1); first < last; first += 60 {
+		checkCompressible(t, l, first, first+49)
+		for i := first + 50; i < first+60; i++ {
+			if data, err := l.Read(i); err != nil || !bytes.Equal(data, testEntry(i)) {
+				t.Fatalf("read %d: got %q, %v", i, data, err)
+			}
+		}
+	}
+	checkVerify(t, l)
+}
+
+// TestSegmentCompression checks that sealed segments are compressed as a
+// whole while the tail stays raw, and that they read back, before and after
+// reopening without the setting.
+func TestSegmentCompression(t *testing.T) {
+	for _, compression := range []Compression{CompressionSnappy, CompressionZstd, CompressionLZ4} {
+		t.Run(compression.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{SegmentSize: 4096, SegmentCompression: compression}
+			l := openTest(t, dir, cfg)
+			writeCompressible(t, l, 1, 100)
+			if len(l.segments) < 3 {
+				t.Fatalf("got %d segments, want several", len(l.segments))
+			}
+
+			for i, seg := range l.segments {
+				want := compression
+				if i == len(l.segments)-1 {
+					want = CompressionNone
+				}
+				if got := segmentFileCompression(seg.path); got != want {
+					t.Fatalf("segment %d stored with %v, want %v", seg.index, got, want)
+				}
+				if _, err := os.Stat(seg.path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkCompressible(t, l, 1, 100)
+
+			l = reopenTest(t, l, dir, Config{SegmentSize: 4096})
+			checkCompressible(t, l, 1, 100)
+			checkVerify(t, l)
+		})
+	}
+}
+
+// TestSegmentCompressionDelay checks that with a delay the most recently
+// sealed segments stay raw while the older ones are compressed in the
+// background.
+func TestSegmentCompressionDelay(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 4096, SegmentCompression: CompressionZstd, SegmentCompressionDelay: 2}
+	l := openTest(t, dir, cfg)
+	writeCompressible(t, l, 1, 200)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.RLock()
+		done := l.coldDone == nil
+		l.mu.RUnlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cold segments not compressed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(l.segments) < 5 {
+		t.Fatalf("got %d segments, want several", len(l.segments))
+	}
+	for i, seg := range l.segments {
+		want := CompressionZstd
+		if i >= len(l.segments)-1-cfg.SegmentCompressionDelay {
+			want = CompressionNone
+		}
+		if got := segmentFileCompression(seg.path); got != want {
+			t.Fatalf("segment %d of %d stored with %v, want %v", i, len(l.segments), got, want)
+		}
+	}
+	checkCompressible(t, l, 1, 200)
+
+	l = reopenTest(t, l, dir, Config{SegmentSize: 4096})
+	checkCompressible(t, l, 1, 200)
+	checkVerify(t, l)
+}
+
+// tailEntryFlags returns the flags the entry at index is stored with in the
+// tail segment.
+func tailEntryFlags(tb testing.TB, l *Log, index uint64) byte {
+	tb.Helper()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	tail := l.segments[len(l.segments)-1]
+	pos := tail.cpos[index-tail.index]
+	_, flags, _, err := tail.format().decodeEntry(tail.cbuf[pos.start:pos.end], tail.sum)
+	if err != nil {
+		tb.Fatalf("decode %d: %v", index, err)
+	}
+	return flags
+}
+
+// TestCompressionThreshold checks that entries below the threshold are
+// stored raw and the ones at or above it compressed.
+func TestCompressionThreshold(t *testing.T) {
+	small := bytes.Repeat([]byte("a"), 40)
+	large := bytes.Repeat([]byte("a"), 100)
+	tests := []struct {
+		threshold    int
+		small, large bool // Whether the entries are compressed
+	}{
+		{0, false, true},
+		{-1, true, true},
+		{40, true, true},
+		{41, false, true},
+		{200, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.threshold), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{Compression: CompressionZstd, CompressionThreshold: tt.threshold}
+			l := openTest(t, dir, cfg)
+			if err := l.Write(1, small); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Write(2, large); err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range []bool{tt.small, tt.large} {
+				index := uint64(i + 1)
+				if got := tailEntryFlags(t, l, index)&entryCompressed != 0; got != want {
+					t.Fatalf("entry %d compressed: %v, want %v", index, got, want)
+				}
+			}
+
+			l = reopenTest(t, l, dir, Config{})
+			for index, want := range map[uint64][]byte{1: small, 2: large} {
+				if data, err := l.Read(index); err != nil || !bytes.Equal(data, want) {
+					t.Fatalf("read %d: got %q, %v", index, data, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package jellywal
+
+import "context"
+
+// WriteContext is like Write but gives up when ctx is done while waiting for
+// the lock or for the write and fsync to complete. When ctx expires after the
+// write has started, the entry may still be appended; check LastIndex to
+// find out.
+func (l *Log) WriteContext(ctx context.Context, index uint64, data []byte) error {
+	if err := l.lockContext(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer l.mu.Unlock()
+		done <- l.write(index, data)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadContext is like Read but gives up when ctx is done while waiting for
+// the lock.
+func (l *Log) ReadContext(ctx context.Context, index uint64) ([]byte, error) {
+	if err := l.rlockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer l.mu.RUnlock()
+
+	return l.read(index)
+}
+
+// SyncContext fsyncs the tail segment, giving up when ctx is done while
+// waiting for the lock or for the fsync to complete. An abandoned fsync keeps
+// running in the background and holds the lock until it finishes.
+func (l *Log) SyncContext(ctx context.Context) error {
+	if err := l.lockContext(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer l.mu.Unlock()
+		done <- l.sync()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lockContext acquires the write lock, giving up when ctx is done first.
+func (l *Log) lockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if l.mu.TryLock() {
+		return nil
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		l.mu.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// The lock will eventually be acquired, hand it straight back
+		go func() {
+			<-locked
+			l.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// rlockContext acquires the read lock, giving up when ctx is done first.
+func (l *Log) rlockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if l.mu.TryRLock() {
+		return nil
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		l.mu.RLock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// The lock will eventually be acquired, hand it straight back
+		go func() {
+			<-locked
+			l.mu.RUnlock()
+		}()
+		return ctx.Err()
+	}
+}
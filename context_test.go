@@ -0,0 +1,69 @@
+package jellywal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestContext checks that the context variants behave like the plain
+// calls when the context stays live.
+func TestContext(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	ctx := context.Background()
+	for i := uint64(1); i <= 10; i++ {
+		if err := l.WriteContext(ctx, i, testEntry(i)); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if err := l.SyncContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := l.ReadContext(ctx, 5)
+	if err != nil || !bytes.Equal(data, testEntry(5)) {
+		t.Fatalf("read 5: got %q, %v", data, err)
+	}
+	if err := l.WriteContext(ctx, 20, nil); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("got %v, want ErrOutOfOrder", err)
+	}
+}
+
+// TestContextDone checks that the context variants give up on a context
+// that is done, before and while waiting for the lock.
+func TestContextDone(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 1)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WriteContext(canceled, 2, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("write: got %v, want context.Canceled", err)
+	}
+	if _, err := l.ReadContext(canceled, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("read: got %v, want context.Canceled", err)
+	}
+	if err := l.SyncContext(canceled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("sync: got %v, want context.Canceled", err)
+	}
+
+	// Hold the lock as a long write would
+	l.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := l.WriteContext(ctx, 2, testEntry(2))
+	_, readErr := l.ReadContext(ctx, 1)
+	l.mu.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("write waiting for the lock: got %v, want context.DeadlineExceeded", err)
+	}
+	if !errors.Is(readErr, context.DeadlineExceeded) {
+		t.Fatalf("read waiting for the lock: got %v, want context.DeadlineExceeded", readErr)
+	}
+
+	// The abandoned lock attempts must not hold the lock once they give up
+	writeEntries(t, l, 2, 3)
+	checkEntries(t, l, 1, 3)
+}
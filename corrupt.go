@@ -0,0 +1,105 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The kinds of corruption found in segment files, wrapped by CorruptError.
+var (
+	// errTornEntry is reported when the last entry in a segment is cut
+	// short, which is what a crash in the middle of a write leaves behind.
+	errTornEntry = errors.New("incomplete entry")
+
+	// errTornHeader is reported when a segment file ends inside its header,
+	// which is what a crash right after creating the segment leaves behind.
+	errTornHeader = errors.New("incomplete segment header")
+
+	// errChecksum is reported when an entry does not match its checksum.
+	errChecksum = errors.New("checksum mismatch")
+
+	// errBadHeader is reported when an entry size does not decode.
+	errBadHeader = errors.New("invalid entry header")
+
+	// errUnknownFlags is reported when an entry uses flags that were never
+	// defined.
+	errUnknownFlags = errors.New("unknown entry flags")
+
+	// errFooter is reported when the footer of a sealed segment is damaged.
+	// No entries are affected, the segment only loses its index.
+	errFooter = errors.New("segment footer does not match its entries")
+
+	// errOverlap is reported when a segment holds entries past the start of
+	// the next segment.
+	errOverlap = errors.New("segment overlaps the next one")
+
+	// errMissing is reported when a sealed segment ends before the next
+	// segment starts and gaps are not allowed.
+	errMissing = errors.New("entries are missing")
+
+	// errDecompress is reported when a compressed entry fails to
+	// decompress.
+	errDecompress = errors.New("entry does not decompress")
+
+	// errDecrypt is reported when an encrypted entry fails authentication.
+	errDecrypt = errors.New("entry does not decrypt")
+)
+
+// CorruptError describes corrupt data found in a segment file. It matches
+// ErrCorrupt with errors.Is, and unwraps to the kind of corruption.
+type CorruptError struct {
+	Path       string // Path of the segment file, empty when unknown
+	Offset     int64  // Byte offset of the corrupt data in the file
+	FirstIndex uint64 // First index affected, 0 when unknown
+	LastIndex  uint64 // Last index affected, 0 when unknown
+	Expected   string // Value that should have been found, if any
+	Found      string // Value that was found instead
+	Err        error  // Kind of corruption
+}
+
+func (e *CorruptError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: %v", ErrCorrupt, e.Err)
+	if e.Path != "" {
+		fmt.Fprintf(&b, " in %s", e.Path)
+	}
+	fmt.Fprintf(&b, " at offset %d", e.Offset)
+
+	if e.FirstIndex != 0 && e.LastIndex > e.FirstIndex {
+		fmt.Fprintf(&b, " affecting indexes %d to %d", e.FirstIndex, e.LastIndex)
+	} else if e.FirstIndex != 0 {
+		fmt.Fprintf(&b, " affecting index %d", e.FirstIndex)
+	}
+
+	if e.Expected != "" || e.Found != "" {
+		fmt.Fprintf(&b, ": expected %s, found %s", e.Expected, e.Found)
+	}
+
+	return b.String()
+}
+
+// Is reports whether target is ErrCorrupt.
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// locateCorruption fills in the segment path of a CorruptError in err along
+// with the range of indexes it affects, when not known yet. Other errors are
+// returned unchanged.
+func locateCorruption(err error, path string, first, last uint64) error {
+	var corrupt *CorruptError
+	if errors.As(err, &corrupt) {
+		if corrupt.Path == "" {
+			corrupt.Path = path
+		}
+		if corrupt.FirstIndex == 0 && first != 0 {
+			corrupt.FirstIndex, corrupt.LastIndex = first, last
+		}
+	}
+	return err
+}
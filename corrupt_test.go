@@ -0,0 +1,61 @@
+package jellywal
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestCorruptError checks that a CorruptError matches ErrCorrupt, unwraps
+// to its kind and describes where it was found.
+func TestCorruptError(t *testing.T) {
+	for _, test := range []struct {
+		err  *CorruptError
+		want string
+	}{
+		{
+			&CorruptError{Offset: 12, Err: errTornEntry},
+			"log corrupt: incomplete entry at offset 12",
+		},
+		{
+			&CorruptError{Path: "seg", Offset: 8, FirstIndex: 5, Err: errBadHeader},
+			"log corrupt: invalid entry header in seg at offset 8 affecting index 5",
+		},
+		{
+			&CorruptError{Path: "seg", Offset: 8, FirstIndex: 5, LastIndex: 9, Expected: "0x1", Found: "0x2", Err: errChecksum},
+			"log corrupt: checksum mismatch in seg at offset 8 affecting indexes 5 to 9: expected 0x1, found 0x2",
+		},
+	} {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+		if !errors.Is(test.err, ErrCorrupt) || !errors.Is(test.err, test.err.Err) {
+			t.Errorf("%v does not match ErrCorrupt and its kind", test.err)
+		}
+	}
+}
+
+// TestCorruptErrorLocated checks that a checksum mismatch found on read
+// carries the index affected and both checksums.
+func TestCorruptErrorLocated(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, l.segments[0].path, 3)
+
+	l = openTest(t, dir, cfg)
+	_, err := l.Read(3)
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("got %v, want a CorruptError", err)
+	}
+	if corrupt.FirstIndex != 3 || corrupt.Offset == 0 {
+		t.Fatalf("corruption located at index %d, offset %d; want index 3", corrupt.FirstIndex, corrupt.Offset)
+	}
+	if corrupt.Expected == "" || corrupt.Found == "" || corrupt.Expected == corrupt.Found {
+		t.Fatalf("checksums %q and %q, want two different ones", corrupt.Expected, corrupt.Found)
+	}
+}
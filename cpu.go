@@ -0,0 +1,79 @@
+package jellywal
+
+import (
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/cpu"
+)
+
+// CPUFeatures holds the CPU features that speed up checksums and
+// compression, detected once at init. The checksum and compression code
+// picks its accelerated paths from the same features and falls back to
+// portable code without them.
+type CPUFeatures struct {
+	// CRC32 is set when ChecksumCRC32C runs on CRC32 instructions: SSE4.2
+	// on amd64, the CRC extension on arm64, vector instructions on ppc64le
+	// and s390x.
+	CRC32 bool
+
+	// BMI2 is set when zstd decoding uses its amd64 assembly.
+	BMI2 bool
+
+	// AVX2 is set when the amd64 AVX2 extension is available. None of the
+	// built-in algorithms depend on it, it is there for custom Checksum and
+	// Codec implementations to pick their own paths.
+	AVX2 bool
+}
+
+// String describes the active paths, for example
+// "crc32c=hardware zstd=bmi2 avx2".
+func (f CPUFeatures) String() string {
+	paths := []string{"crc32c=generic"}
+	if f.CRC32 {
+		paths[0] = "crc32c=hardware"
+	}
+	if f.BMI2 {
+		paths = append(paths, "zstd=bmi2")
+	}
+	if f.AVX2 {
+		paths = append(paths, "avx2")
+	}
+	return strings.Join(paths, " ")
+}
+
+var cpuFeatures = detectCPU()
+
+// Features returns the CPU features detected at init.
+func Features() CPUFeatures {
+	return cpuFeatures
+}
+
+func detectCPU() CPUFeatures {
+	var f CPUFeatures
+	switch runtime.GOARCH {
+	case "amd64":
+		f.CRC32 = cpu.X86.HasSSE42
+		f.BMI2 = cpu.X86.HasBMI2
+		f.AVX2 = cpu.X86.HasAVX2
+	case "arm64":
+		// Every Apple CPU has the CRC extension, but the features are
+		// only read from the kernel on Linux
+		f.CRC32 = cpu.ARM64.HasCRC32 || runtime.GOOS == "darwin" || runtime.GOOS == "ios"
+	case "ppc64le":
+		f.CRC32 = true
+	case "s390x":
+		f.CRC32 = cpu.S390X.HasVX
+	}
+	return f
+}
+
+// defaultChecksum returns the checksum algorithm used when none is
+// configured: CRC32C when the CPU computes it in hardware, otherwise
+// xxHash64, which is much faster than a CRC in software.
+func defaultChecksum() *Checksum {
+	if cpuFeatures.CRC32 {
+		return ChecksumCRC32C
+	}
+	return ChecksumXXHash64
+}
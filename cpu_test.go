@@ -0,0 +1,45 @@
+package jellywal
+
+import "testing"
+
+// TestCPUFeaturesString checks the description of the active paths.
+func TestCPUFeaturesString(t *testing.T) {
+	for _, test := range []struct {
+		features CPUFeatures
+		want     string
+	}{
+		{CPUFeatures{}, "crc32c=generic"},
+		{CPUFeatures{CRC32: true}, "crc32c=hardware"},
+		{CPUFeatures{CRC32: true, BMI2: true, AVX2: true}, "crc32c=hardware zstd=bmi2 avx2"},
+	} {
+		if got := test.features.String(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+// TestDefaultChecksum checks that the default checksum follows the CRC32
+// support of the CPU, and that logs opened without one use it.
+func TestDefaultChecksum(t *testing.T) {
+	if Features() != detectCPU() {
+		t.Fatalf("features %v, detected %v", Features(), detectCPU())
+	}
+
+	saved := cpuFeatures
+	defer func() { cpuFeatures = saved }()
+	for _, test := range []struct {
+		crc32 bool
+		want  *Checksum
+	}{
+		{true, ChecksumCRC32C},
+		{false, ChecksumXXHash64},
+	} {
+		cpuFeatures.CRC32 = test.crc32
+		l := openTest(t, t.TempDir(), Config{})
+		if l.config.Checksum != test.want {
+			t.Fatalf("hardware CRC32 %v: default checksum %s, want %s", test.crc32, l.config.Checksum.Name, test.want.Name)
+		}
+		writeEntries(t, l, 1, 10)
+		checkVerify(t, l)
+	}
+}
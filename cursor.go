@@ -0,0 +1,261 @@
+package jellywal
+
+import "errors"
+
+// errTailSnapshot is returned by snapshotLocked holding only the segment
+// view when the segment to snapshot turns out to be the tail.
+var errTailSnapshot = errors.New("snapshot of the tail needs the read lock")
+
+// Cursor iterates over the entries of a log, either oldest to newest or
+// newest to oldest. It holds a stable view of the log taken when it was
+// created: entries written afterwards are not visible, and the segment
+// buffers it walks are never modified by later writes. Once it walks past
+// its first segment, it loads the next one in the background while the
+// current one is read.
+type Cursor struct {
+	log     *Log
+	index   uint64            // Index of the current entry
+	first   uint64            // First index visible to the cursor
+	last    uint64            // Last index visible to the cursor
+	reverse bool              // Walk from newest to oldest
+	segment *segment          // Snapshot of the segment holding the current entry
+	ahead   <-chan prefetched // Next segment being loaded in the background, nil when none
+	data    []byte            // Data of the current entry
+	err     error
+	closed  bool
+}
+
+// Cursor returns a cursor positioned before the entry at startIndex. Call
+// Next to advance to the first entry. Returns ErrNotFound when startIndex is
+// outside of the log bounds.
+func (l *Log) Cursor(startIndex uint64) (*Cursor, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.corrupt.Load() {
+		return nil, ErrCorrupt
+	} else if l.closed {
+		return nil, ErrClosed
+	}
+
+	if startIndex == 0 || startIndex < l.firstIndex || startIndex > l.lastIndex {
+		return nil, ErrNotFound
+	}
+
+	return &Cursor{
+		log:   l,
+		index: startIndex - 1,
+		first: l.firstIndex,
+		last:  l.lastIndex,
+	}, nil
+}
+
+// CursorReverse returns a cursor positioned after the entry at fromIndex
+// that walks towards the front of the log. Call Next to move to the entry at
+// fromIndex. Returns ErrNotFound when fromIndex is outside of the log bounds.
+func (l *Log) CursorReverse(fromIndex uint64) (*Cursor, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.corrupt.Load() {
+		return nil, ErrCorrupt
+	} else if l.closed {
+		return nil, ErrClosed
+	}
+
+	if fromIndex == 0 || fromIndex < l.firstIndex || fromIndex > l.lastIndex {
+		return nil, ErrNotFound
+	}
+
+	return &Cursor{
+		log:     l,
+		index:   fromIndex + 1,
+		first:   l.firstIndex,
+		last:    l.lastIndex,
+		reverse: true,
+	}, nil
+}
+
+// Next advances the cursor to the next entry in its direction. It returns
+// false when there are no more entries or an error occurred, which is
+// reported by Err.
+func (c *Cursor) Next() bool {
+	if c.closed || c.err != nil {
+		return false
+	}
+
+	next := c.index + 1
+	if c.reverse {
+		next = c.index - 1
+	}
+
+	if next < c.first || next > c.last {
+		return false
+	}
+
+	// The segment positions index lets us step backwards as cheaply as
+	// forwards, we only reload when leaving the current segment.
+	if c.segment == nil || next < c.segment.index || next >= c.segment.index+uint64(len(c.segment.cpos)) {
+		c.segment, c.err = c.loadSegment(next)
+		if c.err != nil {
+			return false
+		}
+
+		// Step over a gap left by AllowGaps writes
+		if next < c.segment.index {
+			next = c.segment.index
+		} else if segmentLast := c.segment.index + uint64(len(c.segment.cpos)) - 1; next > segmentLast {
+			next = segmentLast
+		}
+
+		if next < c.first || next > c.last {
+			return false
+		}
+	}
+
+	entry, err := c.segment.entryData(next, c.log.entryDecoder())
+	if err != nil {
+		c.err = err
+		return false
+	}
+
+	c.data = make([]byte, len(entry))
+	copy(c.data, entry)
+	c.index = next
+
+	return true
+}
+
+// Index returns the index of the current entry.
+func (c *Cursor) Index() uint64 {
+	return c.index
+}
+
+// Data returns the data of the current entry.
+func (c *Cursor) Data() []byte {
+	return c.data
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close releases the cursor. Next always returns false afterwards.
+func (c *Cursor) Close() error {
+	c.closed = true
+	c.segment = nil
+	c.ahead = nil
+	c.data = nil
+
+	return nil
+}
+
+// loadSegment returns a snapshot of the segment holding the given index,
+// the one loaded in the background when it is ready. A cursor leaving a
+// segment for the next walks sequentially, so the one after is loaded in
+// the background from then on.
+func (c *Cursor) loadSegment(index uint64) (*segment, error) {
+	var seg *segment
+	if c.ahead != nil {
+		seg = takePrefetched(c.ahead, index)
+		c.ahead = nil
+	}
+
+	sequential := c.segment != nil
+	if seg == nil {
+		var err error
+		if seg, err = c.log.snapshotSegment(index, c.reverse); err != nil {
+			return nil, err
+		}
+	}
+
+	if next, ok := nextSegmentIndex(seg, c.first, c.last, c.reverse); ok && sequential {
+		c.ahead = c.log.prefetchSegment(next, c.reverse)
+	}
+
+	return seg, nil
+}
+
+// snapshotSegment returns a copy of the segment holding the given index.
+// The copy shares the cached buffers, which are append only and replaced
+// rather than modified in place, so it stays valid across later writes.
+// When the index falls into a gap, the nearest segment in the direction of
+// the walk is returned instead. Sealed segments are snapshotted through
+// the segment view without any lock, so walking the history never waits for
+// writes.
+func (l *Log) snapshotSegment(index uint64, reverse bool) (*segment, error) {
+	view := l.rlockEntry(index)
+	snapshot, err := l.snapshotLocked(index, reverse, view != nil)
+	l.runlockEntry(view)
+
+	if err == errTailSnapshot {
+		// The walk stepped over a gap into the tail
+		l.mu.RLock()
+		snapshot, err = l.snapshotLocked(index, reverse, false)
+		l.mu.RUnlock()
+	}
+
+	return snapshot, err
+}
+
+// snapshotLocked is snapshotSegment for a caller holding the read lock, or
+// the segment view when sealed is set.
+func (l *Log) snapshotLocked(index uint64, reverse, sealed bool) (*segment, error) {
+	if l.corrupt.Load() {
+		return nil, ErrCorrupt
+	} else if l.closed {
+		return nil, ErrClosed
+	}
+
+	if index < l.firstIndex || !sealed && index > l.lastIndex {
+		return nil, ErrNotFound
+	}
+
+	loaded, err := l.loadSegment(index)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk past the gap until we reach a segment holding entries
+	segIdx := l.findSegment(index)
+	for index-loaded.index >= uint64(len(loaded.cpos)) {
+		if reverse && len(loaded.cpos) > 0 {
+			break
+		}
+
+		if reverse {
+			segIdx--
+		} else {
+			segIdx++
+		}
+
+		if segIdx < 0 || segIdx >= len(l.segments) {
+			return nil, ErrNotFound
+		} else if sealed && segIdx == len(l.segments)-1 {
+			return nil, errTailSnapshot
+		}
+
+		loaded, err = l.loadSegment(l.segments[segIdx].index)
+		if err != nil {
+			return nil, err
+		}
+		index = loaded.index
+	}
+
+	if !reverse {
+		// Replay and forward cursors walk the segment front to back
+		loaded.adviseSequential()
+	}
+
+	return &segment{
+		path:  loaded.path,
+		index: loaded.index,
+		cbuf:  loaded.cbuf,
+		cpos:  loaded.cpos,
+		rfile: loaded.rfile,
+		mmap:  loaded.mmap,
+		sum:   loaded.sum,
+		hdr:   loaded.hdr,
+	}, nil
+}
@@ -0,0 +1,151 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// walk collects the indexes the cursor visits, checking their data.
+func walk(tb testing.TB, c *Cursor) []uint64 {
+	tb.Helper()
+	var indexes []uint64
+	for c.Next() {
+		if !bytes.Equal(c.Data(), testEntry(c.Index())) {
+			tb.Fatalf("entry %d: got %q, want %q", c.Index(), c.Data(), testEntry(c.Index()))
+		}
+		indexes = append(indexes, c.Index())
+	}
+	if err := c.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return indexes
+}
+
+// checkWalk checks that the cursor visits first through last, in the order
+// given by them.
+func checkWalk(tb testing.TB, c *Cursor, first, last uint64) {
+	tb.Helper()
+	indexes := walk(tb, c)
+	n := max(first, last) - min(first, last) + 1
+	if uint64(len(indexes)) != n {
+		tb.Fatalf("visited %d entries, want %d", len(indexes), n)
+	}
+	for i, index := range indexes {
+		want := first + uint64(i)
+		if last < first {
+			want = first - uint64(i)
+		}
+		if index != want {
+			tb.Fatalf("visited %d as entry %d, want %d", index, i, want)
+		}
+	}
+}
+
+// cursorConfigs are the configurations the cursor tests run with.
+var cursorConfigs = map[string]Config{
+	"raw":      {},
+	"mmap":     {MmapSegments: true},
+	"segments": {SegmentCompression: CompressionZstd},
+	"streamed": {StreamSegmentBytes: 100},
+}
+
+// TestCursor checks that a cursor walks the entries from its start index
+// to the end of the log, across segments.
+func TestCursor(t *testing.T) {
+	for name, cfg := range cursorConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			l = reopenTest(t, l, dir, cfg)
+
+			for _, start := range []uint64{1, 250, 500} {
+				c, err := l.Cursor(start)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkWalk(t, c, start, 500)
+			}
+		})
+	}
+}
+
+// TestCursorSnapshot checks that a cursor does not see entries written
+// after it was created, and that truncating the log does not disturb it.
+func TestCursorSnapshot(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 100)
+
+	c, err := l.Cursor(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, 101, 200)
+	if err := l.TruncateBack(150); err != nil {
+		t.Fatal(err)
+	}
+	checkWalk(t, c, 1, 100)
+}
+
+// TestCursorBounds checks that cursors cannot start outside of the log and
+// stop once closed.
+func TestCursorBounds(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.TruncateFront(3); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, start := range []uint64{0, 2, 11} {
+		if _, err := l.Cursor(start); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("cursor at %d: got %v, want ErrNotFound", start, err)
+		}
+	}
+
+	c, err := l.Cursor(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Next() || c.Index() != 3 {
+		t.Fatalf("first entry %d, want 3", c.Index())
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Next() {
+		t.Fatal("closed cursor moved on")
+	}
+}
+
+// TestCursorReverse checks that a reverse cursor walks the entries from its
+// start index to the front of the log, across segments.
+func TestCursorReverse(t *testing.T) {
+	for name, cfg := range cursorConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			l = reopenTest(t, l, dir, cfg)
+			if err := l.TruncateFront(20); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, from := range []uint64{500, 250, 20} {
+				c, err := l.CursorReverse(from)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkWalk(t, c, from, 20)
+			}
+
+			for _, from := range []uint64{0, 19, 501} {
+				if _, err := l.CursorReverse(from); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("reverse cursor at %d: got %v, want ErrNotFound", from, err)
+				}
+			}
+		})
+	}
+}
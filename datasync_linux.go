@@ -0,0 +1,27 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+	"syscall"
+)
+
+// dsyncFlag makes writes to a file durable before they return without
+// flushing metadata that isn't needed to read the data back.
+const dsyncFlag = syscall.O_DSYNC
+
+// datasync flushes the file data to stable storage using fdatasync, which
+// skips metadata such as timestamps that isn't needed to read the data back.
+// The file size is still flushed, so appends remain durable.
+func datasync(file *os.File) error {
+	for {
+		err := syscall.Fdatasync(int(file.Fd()))
+		if err != syscall.EINTR {
+			if err != nil {
+				return &os.PathError{Op: "fdatasync", Path: file.Name(), Err: err}
+			}
+			return nil
+		}
+	}
+}
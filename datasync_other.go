@@ -0,0 +1,15 @@
+//go:build !linux
+
+package jellywal
+
+import "os"
+
+// dsyncFlag makes writes to a file durable before they return. O_DSYNC is
+// not portable, so the stricter O_SYNC is used.
+const dsyncFlag = os.O_SYNC
+
+// datasync flushes the file to stable storage. Platforms without fdatasync
+// fall back to a full fsync.
+func datasync(file *os.File) error {
+	return file.Sync()
+}
@@ -0,0 +1,29 @@
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDatasync checks that datasync flushes an open file and reports the
+// failure on a closed one.
+func TestDatasync(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := datasync(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := datasync(file); err == nil {
+		t.Fatal("datasync of a closed file succeeded")
+	}
+}
@@ -0,0 +1,138 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// dictionaryFileName is the file in the log directory holding the zstd
+// dictionary trained for CompressionZstdDict. It is a raw content
+// dictionary, made of sampled entries, which the zstd command line tool
+// accepts as well.
+const dictionaryFileName = "DICTIONARY"
+
+// zstdDictID identifies the dictionary in the frames compressed with it.
+// It is outside the ranges reserved by the zstd format.
+const zstdDictID = 0x4a57414c // "JWAL"
+
+// Defaults for DictionaryPolicy.
+const (
+	DefaultDictionarySamples = 1000
+	DefaultDictionarySize    = 64 << 10
+)
+
+// DictionaryPolicy controls how the zstd dictionary used by
+// CompressionZstdDict is trained.
+type DictionaryPolicy struct {
+	Samples int // Number of entries sampled before training. Default is 1000.
+	Size    int // Max size of the dictionary in bytes. Default is 64 KB.
+}
+
+// zstdDict is a loaded dictionary with an encoder and decoder using it,
+// both safe for concurrent use through EncodeAll and DecodeAll.
+type zstdDict struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// newZstdDict prepares an encoder and decoder for a dictionary.
+func newZstdDict(dict []byte) (*zstdDict, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderDictRaw(zstdDictID, dict))
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxCompressedEntrySize), zstd.WithDecoderDictRaw(zstdDictID, dict))
+	if err != nil {
+		return nil, err
+	}
+
+	return &zstdDict{encoder: encoder, decoder: decoder}, nil
+}
+
+// loadDictionary loads the dictionary of the log, if it has one. It is
+// loaded whatever the configured compression, so the entries compressed
+// with it stay readable.
+func (l *Log) loadDictionary() error {
+	dict, err := os.ReadFile(filepath.Join(l.path, dictionaryFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read zstd dictionary: %w", err)
+	}
+
+	d, err := newZstdDict(dict)
+	if err != nil {
+		return fmt.Errorf("failed to load zstd dictionary: %w", err)
+	}
+	l.dict.Store(d)
+
+	return nil
+}
+
+// sampleEntry records the data of a written entry for dictionary training,
+// until enough samples are collected. Entries larger than the dictionary
+// gain little from it and are not sampled. The caller must hold the write
+// lock.
+func (l *Log) sampleEntry(data []byte) {
+	if len(data) > l.config.Dictionary.Size || len(l.dictSamples) >= l.config.Dictionary.Samples {
+		return
+	}
+	l.dictSamples = append(l.dictSamples, append([]byte(nil), data...))
+}
+
+// maybeTrainDictionary trains the dictionary once enough entries are
+// sampled, and persists it before any entry is compressed with it. Training
+// is best effort: when it fails, the log keeps compressing without a
+// dictionary and sampling starts over. The caller must hold the write lock.
+//
+// The dictionary is the most recent samples concatenated, up to the
+// configured size. zstd matches entries against it like against earlier
+// data in the same frame, which is what makes small entries compress.
+func (l *Log) maybeTrainDictionary() {
+	if len(l.dictSamples) < l.config.Dictionary.Samples {
+		return
+	}
+
+	samples := l.dictSamples
+	l.dictSamples = nil
+
+	start, size := len(samples), 0
+	for start > 0 && size+len(samples[start-1]) <= l.config.Dictionary.Size {
+		start--
+		size += len(samples[start])
+	}
+	dict := make([]byte, 0, size)
+	for _, sample := range samples[start:] {
+		dict = append(dict, sample...)
+	}
+	if len(dict) < 8 {
+		// Too little to match against
+		return
+	}
+
+	d, err := newZstdDict(dict)
+	if err != nil {
+		return
+	}
+
+	tempPath := filepath.Join(l.path, "TEMP"+dictionaryFileName)
+	if err := writeFileSync(tempPath, dict, l.config.FilePerms); err != nil {
+		os.Remove(tempPath)
+		return
+	}
+
+	if err := os.Rename(tempPath, filepath.Join(l.path, dictionaryFileName)); err != nil {
+		return
+	}
+
+	if err := l.syncDir(); err != nil {
+		return
+	}
+
+	l.dict.Store(d)
+}
@@ -0,0 +1,54 @@
+package jellywal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// structuredEntry returns a small entry sharing its structure with the
+// others, which compresses poorly on its own.
+func structuredEntry(index uint64) []byte {
+	return []byte(fmt.Sprintf(`{"user":"user-%d","action":"login","source":"gateway","index":%d}`, index%7, index))
+}
+
+// TestDictionary checks that CompressionZstdDict trains a dictionary once
+// enough entries are sampled, that it compresses small entries better than
+// zstd on its own, and that the entries read back after reopening.
+func TestDictionary(t *testing.T) {
+	sizes := make(map[Compression]int64)
+	for _, compression := range []Compression{CompressionZstd, CompressionZstdDict} {
+		dir := t.TempDir()
+		cfg := Config{
+			Compression:          compression,
+			CompressionThreshold: 1,
+			Dictionary:           DictionaryPolicy{Samples: 50, Size: 4096},
+		}
+		l := openTest(t, dir, cfg)
+		for i := uint64(1); i <= 1000; i++ {
+			if err := l.Write(i, structuredEntry(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sizes[compression] = diskSize(t, l)
+
+		_, err := os.Stat(filepath.Join(dir, dictionaryFileName))
+		if trained := err == nil; trained != (compression == CompressionZstdDict) {
+			t.Fatalf("%v: dictionary trained %v", compression, trained)
+		}
+
+		l = reopenTest(t, l, dir, cfg)
+		for i := uint64(1); i <= 1000; i++ {
+			data, err := l.Read(i)
+			if err != nil || !bytes.Equal(data, structuredEntry(i)) {
+				t.Fatalf("%v: read %d: got %q, %v", compression, i, data, err)
+			}
+		}
+	}
+
+	if sizes[CompressionZstdDict] >= sizes[CompressionZstd] {
+		t.Fatalf("%d bytes with a dictionary, %d without", sizes[CompressionZstdDict], sizes[CompressionZstd])
+	}
+}
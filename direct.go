@@ -0,0 +1,82 @@
+package jellywal
+
+import "unsafe"
+
+// directBlockSize is the alignment of the buffers, offsets and lengths of
+// DirectIO and AlignWrites writes, which covers the logical block size of
+// common devices.
+const directBlockSize = 4096
+
+// alignsWrites reports whether the writes to the tail are padded to whole
+// blocks. Padding is only told apart from entries in segments with a
+// header, so older tails are never padded, and written without O_DIRECT
+// under DirectIO.
+func (l *Log) alignsWrites(tail *segment) bool {
+	return (l.config.DirectIO && directIOSupported || l.config.AlignWrites) && tail.hdr > 0
+}
+
+// writeTailDirect is writeTail for DirectIO. The tail file is switched to
+// O_DIRECT and written in whole blocks by writeTailAligned.
+func (l *Log) writeTailDirect(bufs [][]byte) error {
+	if l.directFile != l.sfile {
+		// Best effort, filesystems without O_DIRECT are written through
+		// the page cache the same way
+		setDirectIO(l.sfile)
+		l.directFile = l.sfile
+	}
+
+	return l.writeTailAligned(bufs)
+}
+
+// writeTailAligned is writeTail for block aligned writes. The tail file is
+// written from the start of the block holding the end of the data written
+// so far, the buffers following it, through an aligned buffer padded with
+// zeros to a whole number of blocks. The padding is overwritten by the next
+// write and not taken for entries by Open.
+func (l *Log) writeTailAligned(bufs [][]byte) error {
+	tail := l.segments[len(l.segments)-1]
+	start := l.written &^ (directBlockSize - 1)
+	n := l.written - start
+	for _, buf := range bufs {
+		n += len(buf)
+	}
+
+	block := l.directBuffer((n + directBlockSize - 1) &^ (directBlockSize - 1))
+	n = copy(block, tail.cbuf[start:l.written])
+	for _, buf := range bufs {
+		n += copy(block[n:], buf)
+	}
+	clear(block[n:])
+
+	off := int64(start)
+	return l.retry("write", func() error {
+		for len(block) > 0 {
+			n, err := l.writeAtTail(block, off)
+			block, off = block[n:], off+int64(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// directBuffer returns a buffer of the given size aligned to
+// directBlockSize, reusing the one from the previous aligned write.
+func (l *Log) directBuffer(size int) []byte {
+	if cap(l.dbuf) < size {
+		buf := make([]byte, size+directBlockSize)
+		skip := int(-uintptr(unsafe.Pointer(&buf[0])) & (directBlockSize - 1))
+		l.dbuf = buf[skip : skip+size]
+	}
+	return l.dbuf[:size]
+}
+
+// writeAtTail writes p to the tail segment file at the given offset,
+// through the ring when there is one.
+func (l *Log) writeAtTail(p []byte, off int64) (int, error) {
+	if l.ring != nil {
+		return l.ring.writev(l.sfile, [][]byte{p}, off)
+	}
+	return l.sfile.WriteAt(p, off)
+}
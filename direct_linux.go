@@ -0,0 +1,24 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// directIOSupported reports whether DirectIO is supported on the platform.
+const directIOSupported = true
+
+// setDirectIO switches the file to O_DIRECT, which makes its writes bypass
+// the page cache.
+func setDirectIO(file *os.File) error {
+	flags, err := unix.FcntlInt(file.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = unix.FcntlInt(file.Fd(), unix.F_SETFL, flags|unix.O_DIRECT)
+	return err
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestDirectIOFlag checks that the tail file is switched to O_DIRECT once
+// written, on filesystems that support it.
+func TestDirectIOFlag(t *testing.T) {
+	dir := t.TempDir()
+	probe, err := os.Create(filepath.Join(dir, "probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setDirectIO(probe)
+	probe.Close()
+	os.Remove(probe.Name())
+	if err != nil {
+		t.Skip(err)
+	}
+
+	l := openTest(t, dir, Config{DirectIO: true})
+	writeEntries(t, l, 1, 10)
+	flags, err := unix.FcntlInt(l.sfile.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&unix.O_DIRECT == 0 {
+		t.Fatal("tail file not switched to O_DIRECT")
+	}
+	l = reopenTest(t, l, dir, Config{})
+	checkEntries(t, l, 1, 10)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package jellywal
+
+import (
+	"errors"
+	"os"
+)
+
+// directIOSupported reports whether DirectIO is supported on the platform.
+const directIOSupported = false
+
+// setDirectIO reports that O_DIRECT is not supported.
+func setDirectIO(file *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,154 @@
+package jellywal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDirectIO checks that the tail is written in whole blocks, that sealed
+// segments end with their footer rather than padding, and that the log
+// reopens with its entries after a crash and after Close.
+func TestDirectIO(t *testing.T) {
+	configs := map[string]Config{
+		"plain":    {},
+		"rotated":  {SegmentSize: 10000},
+		"buffered": {SegmentSize: 10000, WriteBufferSize: 3000, SyncPolicy: SyncPolicy{Mode: SyncNever}},
+		"uring":    {SegmentSize: 10000, IOUring: true},
+		"streamed": {SegmentSize: 10000, StreamSegmentBytes: 1},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.DirectIO = true
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			if err := l.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, seg := range l.segments {
+				data, err := os.ReadFile(seg.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i < len(l.segments)-1 && !bytes.HasSuffix(data, []byte(footerMagic)) {
+					t.Fatalf("sealed segment %d does not end with its footer", seg.index)
+				}
+				if i == len(l.segments)-1 && directIOSupported && len(data)%directBlockSize != 0 {
+					t.Fatalf("tail file size %d is not a multiple of %d", len(data), directBlockSize)
+				}
+			}
+
+			crashed := crashCopy(t, dir)
+			if err := l.TruncateBack(450); err != nil {
+				t.Fatal(err)
+			}
+			writeEntries(t, l, 451, 600)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 600)
+			checkVerify(t, l)
+
+			l = openTest(t, crashed, cfg)
+			checkEntries(t, l, 1, 500)
+			writeEntries(t, l, 501, 600)
+			l = reopenTest(t, l, crashed, Config{})
+			checkEntries(t, l, 1, 600)
+		})
+	}
+}
+
+// TestDirectIOHeaderless checks that a tail written before segment headers
+// were introduced is not padded, as its padding would be taken for entries,
+// and that the segments after it are.
+func TestDirectIOHeaderless(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"direct":  {DirectIO: true, SegmentSize: 1024},
+		"aligned": {AlignWrites: true, SegmentSize: 1024},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, Config{})
+			path := l.segments[0].path
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var data []byte
+			for i := uint64(1); i <= 4; i++ {
+				data = EntryFormatVarint.appendEntry(data, testEntry(i), 0, ChecksumCRC32C)
+			}
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			l = openTest(t, dir, cfg)
+			writeEntries(t, l, 5, 8)
+			if err := l.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 8)
+
+			writeEntries(t, l, 9, 500)
+			if len(l.segments) < 2 {
+				t.Fatal("headerless tail not cycled")
+			}
+			if err := l.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			tail, err := os.ReadFile(l.segments[len(l.segments)-1].path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (cfg.AlignWrites || directIOSupported) && len(tail)%directBlockSize != 0 {
+				t.Fatalf("tail file size %d is not a multiple of %d", len(tail), directBlockSize)
+			}
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 500)
+			checkVerify(t, l)
+		})
+	}
+}
+
+// TestPaddingStart checks that the zeros running up to the end of a segment
+// file are found, across several reads, and that a headerless segment is
+// never taken to be padded.
+func TestPaddingStart(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		hdr   int
+		data  int // Bytes of data after the header
+		zeros int // Zeros after the data
+	}{
+		{"none", segmentHeaderSize, 100, 0},
+		{"block", segmentHeaderSize, 100, directBlockSize - 100 - segmentHeaderSize},
+		{"long", segmentHeaderSize, 100, 3 * streamBufferSize},
+		{"only padding", segmentHeaderSize, 0, 2 * streamBufferSize},
+		{"headerless", 0, 100, 100},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte{1}, test.hdr+test.data)
+			content = append(content, make([]byte, test.zeros)...)
+			path := filepath.Join(t.TempDir(), "segment")
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			want := int64(test.hdr + test.data)
+			if test.hdr == 0 {
+				want = int64(len(content))
+			}
+			got, err := paddingStart(file, test.hdr, int64(len(content)))
+			if err != nil || got != want {
+				t.Fatalf("got %d, %v; want %d", got, err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fileFlags returns the open flags of the file, as reported by procfs.
+func fileFlags(tb testing.TB, file *os.File) int {
+	tb.Helper()
+	info, err := os.ReadFile(fmt.Sprintf("/proc/self/fdinfo/%d", file.Fd()))
+	if err != nil {
+		tb.Skip(err)
+	}
+	for _, line := range strings.Split(string(info), "\n") {
+		if value, ok := strings.CutPrefix(line, "flags:"); ok {
+			flags, err := strconv.ParseInt(strings.TrimSpace(value), 8, 64)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return int(flags)
+		}
+	}
+	tb.Fatal("no flags in fdinfo")
+	return 0
+}
+
+// TestSyncDSync checks that SyncDSync opens every tail with O_DSYNC, when
+// the log is created, when the tail rotates and when it is reopened, and
+// that other modes do not.
+func TestSyncDSync(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SyncPolicy: SyncPolicy{Mode: SyncDSync}}
+	l := openTest(t, dir, cfg)
+	checkDSync := func(want bool) {
+		t.Helper()
+		if got := fileFlags(t, l.sfile)&syscall.O_DSYNC != 0; got != want {
+			t.Fatalf("tail opened with O_DSYNC %v, want %v", got, want)
+		}
+	}
+
+	checkDSync(true)
+	writeEntries(t, l, 1, 500)
+	checkDSync(true)
+	if l.DurableIndex() != 500 {
+		t.Fatalf("durable index %d, want 500", l.DurableIndex())
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	checkDSync(true)
+	checkEntries(t, l, 1, 500)
+
+	l = reopenTest(t, l, dir, Config{SegmentSize: 1024})
+	checkDSync(false)
+}
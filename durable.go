@@ -0,0 +1,112 @@
+package jellywal
+
+// DurableIndex returns the highest index known to be on stable storage.
+// Entries up to and including it survive a crash, later entries may be lost
+// depending on the sync policy. It never blocks on the log lock.
+func (l *Log) DurableIndex() uint64 {
+	return l.durable.Load()
+}
+
+// Barrier returns once every write that completed before the call is on
+// stable storage, along with the entries queued by WriteAsync before it.
+// Unlike Sync it only fsyncs when there are entries past the durable index,
+// so concurrent barriers share a single fsync and writers can pipeline
+// writes under a relaxed sync policy, placing barriers only at the points
+// that need durability.
+func (l *Log) Barrier() error {
+	l.flushAsync()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	}
+
+	if l.durable.Load() >= l.lastIndex && l.unsynced == 0 {
+		return nil
+	}
+
+	return l.syncTail()
+}
+
+// setDurable advances the durable index and wakes the OnDurable notifier.
+// The caller must hold the write lock.
+func (l *Log) setDurable(index uint64) {
+	if index <= l.durable.Load() {
+		return
+	}
+
+	l.durable.Store(index)
+	if l.durableWake != nil {
+		select {
+		case l.durableWake <- struct{}{}:
+		default:
+			// A wakeup is already pending and will pick up the new index
+		}
+	}
+}
+
+// lowerDurable moves the durable index back after entries were truncated.
+// The caller must hold the write lock.
+func (l *Log) lowerDurable(index uint64) {
+	l.durableEpoch++
+	if index < l.durable.Load() {
+		l.durable.Store(index)
+	}
+}
+
+// startNotifier starts the goroutine delivering OnDurable callbacks. They
+// are invoked outside of the log lock, in order, and coalesced when the
+// durable index advances faster than the callback returns.
+func (l *Log) startNotifier() {
+	if l.config.OnDurable == nil {
+		return
+	}
+
+	l.durableWake = make(chan struct{}, 1)
+	l.durableStop = make(chan struct{})
+	l.durableDone = make(chan struct{})
+	go l.runNotifier(l.durableWake, l.durableStop, l.durableDone)
+}
+
+// stopNotifier signals the notifier to deliver any pending advance and stop.
+// It returns a channel closed once the notifier has exited, or nil when no
+// notifier is running. The caller must hold the write lock.
+func (l *Log) stopNotifier() chan struct{} {
+	if l.durableStop == nil {
+		return nil
+	}
+
+	close(l.durableStop)
+	done := l.durableDone
+	l.durableWake = nil
+	l.durableStop = nil
+	l.durableDone = nil
+
+	return done
+}
+
+func (l *Log) runNotifier(wake, stop, done chan struct{}) {
+	defer close(done)
+
+	var notified uint64
+	notify := func() {
+		if index := l.durable.Load(); index != notified {
+			notified = index
+			l.config.OnDurable(index)
+		}
+	}
+
+	for {
+		select {
+		case <-wake:
+			notify()
+		case <-stop:
+			notify()
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package jellywal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestDurableIndex checks that the durable index follows syncs and drops
+// back with truncations.
+func TestDurableIndex(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SyncPolicy: SyncPolicy{Mode: SyncNever}})
+	checkDurable := func(want uint64) {
+		t.Helper()
+		if got := l.DurableIndex(); got != want {
+			t.Fatalf("durable index %d, want %d", got, want)
+		}
+	}
+
+	writeEntries(t, l, 1, 10)
+	checkDurable(0)
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkDurable(10)
+
+	if err := l.TruncateBack(5); err != nil {
+		t.Fatal(err)
+	}
+	checkDurable(5)
+
+	writeEntries(t, l, 6, 8)
+	checkDurable(5)
+	if err := l.Barrier(); err != nil {
+		t.Fatal(err)
+	}
+	checkDurable(8)
+}
+
+// TestOnDurable checks that OnDurable reports the durable index in
+// increasing order, up to the last advance before Close.
+func TestOnDurable(t *testing.T) {
+	var mu sync.Mutex
+	var reported []uint64
+	l := openTest(t, t.TempDir(), Config{
+		SyncPolicy: SyncPolicy{Mode: SyncNever},
+		OnDurable: func(index uint64) {
+			mu.Lock()
+			reported = append(reported, index)
+			mu.Unlock()
+		},
+	})
+
+	for i := uint64(1); i <= 50; i++ {
+		writeEntries(t, l, i, i)
+		if i%10 == 0 {
+			if err := l.Sync(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) == 0 || reported[len(reported)-1] != 50 {
+		t.Fatalf("reported %v, want it to end at 50", reported)
+	}
+	for i := 1; i < len(reported); i++ {
+		if reported[i] <= reported[i-1] {
+			t.Fatalf("reported %v, want increasing indexes", reported)
+		}
+	}
+}
+
+// TestBarrier checks that Barrier makes the writes before it durable, only
+// syncs when there is something to sync, and fails on a closed log.
+func TestBarrier(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{
+		SyncPolicy:      SyncPolicy{Mode: SyncNever},
+		WriteBufferSize: 1 << 20,
+	})
+	writeEntries(t, l, 1, 10)
+	if err := l.Barrier(); err != nil {
+		t.Fatal(err)
+	}
+	if durable := l.DurableIndex(); durable != 10 {
+		t.Fatalf("durable index %d after barrier, want 10", durable)
+	}
+	crashed := openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 1, 10)
+
+	synced := l.lastSync
+	if err := l.Barrier(); err != nil {
+		t.Fatal(err)
+	}
+	if l.lastSync != synced {
+		t.Fatal("barrier synced with nothing to sync")
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Barrier(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("barrier after close: got %v, want ErrClosed", err)
+	}
+}
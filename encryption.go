@@ -0,0 +1,194 @@
+package jellywal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// KeyProvider supplies the AES-256 keys entries are encrypted with, so they
+// can come from a KMS, a secrets manager or the environment. Keys are
+// identified by an ID stored with every entry, which lets the current key
+// change while entries encrypted with earlier keys stay readable. Rewrap
+// moves existing entries to a new key, so old ones can be retired.
+//
+// Both methods may be called concurrently. CurrentKey is called for every
+// write and Key whenever a key is first needed, so providers backed by a
+// remote service should cache.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the 32 byte key new entries are
+	// encrypted with.
+	CurrentKey() (id uint32, key []byte, err error)
+
+	// Key returns the 32 byte key with the given ID. The key behind an ID
+	// must never change.
+	Key(id uint32) ([]byte, error)
+}
+
+// Encrypted entries are sealed with AES-256-GCM under a random nonce. Their
+// data is laid out as:
+//
+//	key ID[4] | nonce[12] | ciphertext | tag[16]
+//
+// In segments flagged with segmentFlagBound, the additional data of every
+// entry holds its position, so it cannot be replayed or moved elsewhere in
+// the log without failing authentication. Segments encrypted before it was
+// introduced lack the flag and are decrypted without additional data.
+//
+// Entries are encrypted after compression and the Codec, which would gain
+// nothing from ciphertext, and checksummed afterwards, so damage is still
+// told apart from a wrong key. Segments holding encrypted entries carry
+// segmentFlagEncrypted, every entry but the footer is encrypted in them.
+const (
+	encryptionKeySize = 32
+	encryptionIDSize  = 4
+	encryptionNonce   = 12
+)
+
+// keyring encrypts and decrypts entries with the keys of a KeyProvider,
+// caching a cipher for every key ID used.
+type keyring struct {
+	provider KeyProvider
+	aeads    sync.Map // Key ID to cipher.AEAD
+}
+
+func newKeyring(provider KeyProvider) *keyring {
+	return &keyring{provider: provider}
+}
+
+// aead returns the cipher for a key ID, fetching the key from the provider
+// unless key is given or it is cached.
+func (k *keyring) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	if aead, ok := k.aeads.Load(id); ok {
+		return aead.(cipher.AEAD), nil
+	}
+
+	if key == nil {
+		var err error
+		if key, err = k.provider.Key(id); err != nil {
+			return nil, fmt.Errorf("failed to get encryption key %d: %w", id, err)
+		}
+	}
+
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key %d is %d bytes, expected %d", id, len(key), encryptionKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _ := k.aeads.LoadOrStore(id, aead)
+	return actual.(cipher.AEAD), nil
+}
+
+// entryAAD returns the additional data binding an encrypted entry to its
+// position: the index of the entry and the first index of its segment,
+// which identifies the segment.
+func entryAAD(segmentIndex, index uint64) []byte {
+	return appendEntryAAD(make([]byte, 0, 16), segmentIndex, index)
+}
+
+// appendEntryAAD appends the additional data of entryAAD to dst.
+func appendEntryAAD(dst []byte, segmentIndex, index uint64) []byte {
+	dst = binary.LittleEndian.AppendUint64(dst, segmentIndex)
+	return binary.LittleEndian.AppendUint64(dst, index)
+}
+
+// segmentAAD returns the additional data for an entry of a segment with
+// the given flags, nil when its entries are not bound to their position.
+func segmentAAD(flags uint16, segmentIndex, index uint64) []byte {
+	if flags&segmentFlagBound == 0 {
+		return nil
+	}
+	return entryAAD(segmentIndex, index)
+}
+
+// appendSegmentAAD appends the additional data of segmentAAD to dst, and
+// returns nil when there is none.
+func appendSegmentAAD(dst []byte, flags uint16, segmentIndex, index uint64) []byte {
+	if flags&segmentFlagBound == 0 {
+		return nil
+	}
+	return appendEntryAAD(dst, segmentIndex, index)
+}
+
+// encrypt returns data encrypted with the current key and authenticated
+// along with aad.
+func (k *keyring) encrypt(data, aad []byte) ([]byte, error) {
+	return k.appendEncrypted(nil, data, aad)
+}
+
+// appendEncrypted appends data encrypted like encrypt to dst.
+func (k *keyring) appendEncrypted(dst, data, aad []byte) ([]byte, error) {
+	id, key, err := k.provider.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current encryption key: %w", err)
+	}
+
+	aead, err := k.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendSealed(dst, aead, id, data, aad)
+}
+
+// encryptWith returns data encrypted with the key with the given ID and
+// authenticated along with aad.
+func (k *keyring) encryptWith(id uint32, data, aad []byte) ([]byte, error) {
+	aead, err := k.aead(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendSealed(nil, aead, id, data, aad)
+}
+
+// appendSealed appends data encrypted with aead under a random nonce,
+// prefixed with the key ID and the nonce, to dst.
+func appendSealed(dst []byte, aead cipher.AEAD, id uint32, data, aad []byte) ([]byte, error) {
+	start := len(dst)
+	dst = slices.Grow(dst, encryptionIDSize+encryptionNonce+len(data)+aead.Overhead())
+	dst = binary.LittleEndian.AppendUint32(dst, id)
+	dst = dst[:start+encryptionIDSize+encryptionNonce]
+	nonce := dst[start+encryptionIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(dst, nonce, data, aad), nil
+}
+
+// decrypt returns the plaintext of encrypted entry data, authenticated
+// along with aad. Data that fails authentication is reported as a
+// CorruptError.
+func (k *keyring) decrypt(data, aad []byte) ([]byte, error) {
+	if len(data) < encryptionIDSize+encryptionNonce {
+		return nil, &CorruptError{Err: errDecrypt, Expected: "encrypted entry", Found: fmt.Sprintf("%d bytes", len(data))}
+	}
+
+	id := binary.LittleEndian.Uint32(data)
+	aead, err := k.aead(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, ciphertext := data[encryptionIDSize:encryptionIDSize+encryptionNonce], data[encryptionIDSize+encryptionNonce:]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, &CorruptError{Err: errDecrypt, Expected: fmt.Sprintf("data authenticated by key %d", id), Found: err.Error()}
+	}
+
+	return plaintext, nil
+}
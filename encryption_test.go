@@ -0,0 +1,168 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// testKeys is a KeyProvider holding its keys in memory.
+type testKeys struct {
+	mu      sync.Mutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// newTestKeys returns a provider with keys 1 through n, the first of which
+// is current.
+func newTestKeys(n int) *testKeys {
+	k := &testKeys{current: 1, keys: make(map[uint32][]byte)}
+	for id := uint32(1); id <= uint32(n); id++ {
+		k.keys[id] = bytes.Repeat([]byte{byte(id)}, encryptionKeySize)
+	}
+	return k
+}
+
+func (k *testKeys) CurrentKey() (uint32, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *testKeys) Key(id uint32) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("no key %d", id)
+	}
+	return key, nil
+}
+
+// TestEncryption checks that entries are stored encrypted and read back
+// with the keys of the provider, before and after reopening.
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, Encryption: newTestKeys(1)}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 300)
+	writeBatchEntries(t, l, 301, 310)
+
+	for _, seg := range l.segments {
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("entry")) {
+			t.Fatalf("segment %d holds entries in the clear", seg.index)
+		}
+	}
+	checkEntries(t, l, 1, 310)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 310)
+	checkVerify(t, l)
+}
+
+// TestEncryptionWrongKey checks that entries do not read back without
+// their key.
+func TestEncryptionWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{SegmentSize: 1024, Encryption: newTestKeys(1)})
+	writeEntries(t, l, 1, 300)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := newTestKeys(1)
+	wrong.keys[1] = bytes.Repeat([]byte{0xff}, encryptionKeySize)
+	l = openTest(t, dir, Config{SegmentSize: 1024, Encryption: wrong})
+	if _, err := l.Read(1); !errors.Is(err, errDecrypt) {
+		t.Fatalf("read with the wrong key: got %v, want errDecrypt", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(dir, &Config{SegmentSize: 1024})
+	if err == nil {
+		defer l.Close()
+		_, err = l.Read(1)
+	}
+	if !errors.Is(err, ErrNoKeyProvider) {
+		t.Fatalf("read without a key provider: got %v, want ErrNoKeyProvider", err)
+	}
+}
+
+// TestRewrap checks that after Rewrap the log reads back with only the new
+// key, and that it needs a key provider.
+func TestRewrap(t *testing.T) {
+	dir := t.TempDir()
+	keys := newTestKeys(2)
+	cfg := Config{SegmentSize: 1024, Encryption: keys}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 300)
+
+	keys.mu.Lock()
+	keys.current = 2
+	keys.mu.Unlock()
+	writeEntries(t, l, 301, 400)
+	if err := l.Rewrap(2); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, l, 1, 400)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	retired := newTestKeys(2)
+	delete(retired.keys, 1)
+	retired.current = 2
+	l = openTest(t, dir, Config{SegmentSize: 1024, Encryption: retired})
+	checkEntries(t, l, 1, 400)
+	checkVerify(t, l)
+
+	plain := openTest(t, t.TempDir(), Config{})
+	if err := plain.Rewrap(1); !errors.Is(err, ErrNoKeyProvider) {
+		t.Fatalf("rewrap without a key provider: got %v, want ErrNoKeyProvider", err)
+	}
+}
+
+// TestEncryptionBound checks that an encrypted entry moved to another
+// position in the log fails authentication, although its checksum holds.
+func TestEncryptionBound(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{Encryption: newTestKeys(1)}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 3)
+	tail := l.segments[0]
+	first, second := tail.cpos[0], tail.cpos[1]
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the first two entries, which have the same size
+	data, err := os.ReadFile(tail.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := bytes.Clone(data[first.start:first.end])
+	copy(data[first.start:], data[second.start:second.end])
+	copy(data[second.start:], entry)
+	if err := os.WriteFile(tail.path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, cfg)
+	for _, index := range []uint64{1, 2} {
+		if _, err := l.Read(index); !errors.Is(err, errDecrypt) {
+			t.Fatalf("read of moved entry %d: got %v, want errDecrypt", index, err)
+		}
+	}
+	if data, err := l.Read(3); err != nil || !bytes.Equal(data, testEntry(3)) {
+		t.Fatalf("read 3: got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,134 @@
+package jellywal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxWriteFromSize is the largest entry WriteFrom accepts, so the entries
+// of a segment stay within the 32 bit offsets of its footer.
+const maxWriteFromSize = math.MaxUint32
+
+// WriteFrom appends an entry of the given size read from r at the given
+// index, like Write. The entry is read straight into the cached tail
+// segment, so a large payload such as a snapshot or a blob is not held in
+// memory twice, once by the application and once by the log. Entries the
+// log transforms on their way to disk, which are those Compression applies
+// to along with every entry under a Codec or Encryption, are read into a
+// buffer of their own first.
+//
+// The entry is not streamed to the file: like every entry in the tail, all
+// of it is kept in the cache, so WriteFrom needs size bytes of memory.
+// Entries larger than a segment footer can index, 4 GB, are refused with
+// ErrEntryTooLarge before anything is allocated.
+//
+// Writes are blocked while r is read, so it should be readily readable,
+// such as a file. It is an error for r to hold fewer than size bytes, the
+// rest of r is left unread. WriteFrom does not take part in group commit.
+func (l *Log) WriteFrom(index uint64, r io.Reader, size int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if size < 0 {
+		return fmt.Errorf("invalid size %d of entry %d", size, index)
+	} else if size > maxWriteFromSize || size > math.MaxInt {
+		return fmt.Errorf("%w: entry %d takes %d bytes", ErrEntryTooLarge, index, size)
+	}
+
+	tail, err := l.prepareTail(index)
+	if err != nil {
+		return err
+	}
+
+	if l.transforms(tail, int(size)) {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("failed to read entry %d: %w", index, err)
+		}
+		// The tail is prepared already, preparing it again would start
+		// another segment at the same index when the entry leaves a gap
+		l.wbatch.Clear()
+		l.wbatch.Write(index, data)
+		return l.appendBatch(tail, &l.wbatch)
+	}
+
+	format := tail.format()
+	if !format.fits(int(size)) {
+		return fmt.Errorf("%w: entry %d takes %d bytes", ErrEntryTooLarge, index, size)
+	}
+
+	mark, cposMark := len(tail.cbuf), len(tail.cpos)
+	l.growTail(tail, maxHeaderSize+int(size)+tail.sum.Size)
+	tail.cbuf = format.appendEntryHeader(tail.cbuf, int(size), 0)
+	n := len(tail.cbuf)
+	tail.cbuf = tail.cbuf[:n+int(size)]
+	if _, err := io.ReadFull(r, tail.cbuf[n:]); err != nil {
+		// Nothing was written yet
+		tail.cbuf = tail.cbuf[:mark]
+		return fmt.Errorf("failed to read entry %d: %w", index, err)
+	}
+	tail.cbuf = tail.sum.appendSum(tail.cbuf, tail.cbuf[mark:])
+	tail.cpos = append(tail.cpos, bytepos{mark, len(tail.cbuf)})
+
+	return l.commitTail(tail, mark, cposMark, index)
+}
+
+// transforms reports whether an entry of the given size appended to the
+// tail is compressed, encoded or encrypted before it is written.
+func (l *Log) transforms(tail *segment, size int) bool {
+	return (l.config.Compression != CompressionNone && size >= l.config.CompressionThreshold) ||
+		l.config.Codec != nil || tail.encrypted()
+}
+
+// ReadInto writes the data of the entry at the given index to w, like Read,
+// and returns the number of bytes written. Entries stored as they are, in
+// the tail or in a segment the log has loaded, are written straight from
+// the cache rather than copied into a buffer of their own first, so a large
+// entry can be passed on to a file or a connection without materializing
+// it once more. Compressed, encoded and encrypted entries are decoded into
+// a buffer first. The log is not locked while w is written to.
+func (l *Log) ReadInto(index uint64, w io.Writer) (int64, error) {
+	data, err := l.readShared(index)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// ReaderFor returns a reader over the data of the entry at the given index,
+// for consumers that pull, such as a decompressor. Like ReadInto it reads
+// entries stored as they are from the cache, which the reader holds on to
+// until it is dropped.
+func (l *Log) ReaderFor(index uint64) (*bytes.Reader, error) {
+	data, err := l.readShared(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// readShared returns the data of the entry at the given index, which may
+// point into the cached buffers of the log and must not be modified. The
+// cached buffers are append only and replaced rather than modified in
+// place, so it stays valid after the lock is released.
+func (l *Log) readShared(index uint64) ([]byte, error) {
+	defer l.runlockEntry(l.rlockEntry(index))
+
+	return l.readNoCopy(index)
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package jellywal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isNoSpace reports whether err was caused by a full disk.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// isTransient reports whether err is a transient I/O error that is worth
+// retrying, as returned by network filesystems and overloaded disks.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
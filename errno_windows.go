@@ -0,0 +1,26 @@
+//go:build windows
+
+package jellywal
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorHandleDiskFull  syscall.Errno = 39   // ERROR_HANDLE_DISK_FULL
+	errorDiskFull        syscall.Errno = 112  // ERROR_DISK_FULL
+	errorSemTimeout      syscall.Errno = 121  // ERROR_SEM_TIMEOUT
+	errorWorkingSetQuota syscall.Errno = 1453 // ERROR_WORKING_SET_QUOTA
+)
+
+// isNoSpace reports whether err was caused by a full disk.
+func isNoSpace(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
+
+// isTransient reports whether err is a transient I/O error that is worth
+// retrying, as returned by network shares and overloaded disks.
+func isTransient(err error) bool {
+	return errors.Is(err, errorSemTimeout) || errors.Is(err, errorWorkingSetQuota)
+}
@@ -0,0 +1,171 @@
+package jellywal
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// evictionPolicies are the policies the eviction tests run with.
+var evictionPolicies = map[string]func() EvictionPolicy{
+	"lru":   NewLRUEviction,
+	"clock": NewClockEviction,
+	"arc":   NewARCEviction,
+}
+
+// TestEvictionPolicyCached checks that the policies only ever evict cached
+// segments, each of them once, under random reads.
+func TestEvictionPolicyCached(t *testing.T) {
+	for name, newPolicy := range evictionPolicies {
+		t.Run(name, func(t *testing.T) {
+			p := newPolicy()
+			cached := make(map[uint64]bool)
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 100000; i++ {
+				index := uint64(r.Intn(50))
+				if cached[index] {
+					p.Access(index)
+					continue
+				}
+
+				if len(cached) == 8 {
+					evicted := p.Evict()
+					if !cached[evicted] {
+						t.Fatalf("evicted segment %d, which is not cached", evicted)
+					}
+					delete(cached, evicted)
+				}
+				p.Insert(index)
+				cached[index] = true
+			}
+
+			for len(cached) > 0 {
+				evicted := p.Evict()
+				if !cached[evicted] {
+					t.Fatalf("evicted segment %d, which is not cached", evicted)
+				}
+				delete(cached, evicted)
+			}
+		})
+	}
+}
+
+// TestEvictionPolicyLRU checks that LRU evicts the least recently read
+// segment.
+func TestEvictionPolicyLRU(t *testing.T) {
+	p := NewLRUEviction()
+	p.Insert(1)
+	p.Insert(2)
+	p.Insert(3)
+	p.Access(1)
+	p.Access(2)
+	for _, want := range []uint64{3, 1, 2} {
+		if got := p.Evict(); got != want {
+			t.Fatalf("evicted %d, want %d", got, want)
+		}
+	}
+}
+
+// TestEvictionPolicyClock checks that CLOCK passes over segments read since
+// the last sweep.
+func TestEvictionPolicyClock(t *testing.T) {
+	p := NewClockEviction()
+	p.Insert(1)
+	p.Insert(2)
+	p.Insert(3)
+	p.Access(1)
+	if got := p.Evict(); got != 2 {
+		t.Fatalf("evicted %d, want 2", got)
+	}
+}
+
+// TestEvictionPolicyARC checks that a scan reading segments once does not
+// evict the segments read more than once.
+func TestEvictionPolicyARC(t *testing.T) {
+	p := NewARCEviction()
+	p.Insert(1)
+	p.Insert(2)
+	p.Access(1)
+	p.Access(2)
+
+	cached := 2
+	for index := uint64(3); index <= 20; index++ {
+		if cached == 4 {
+			if evicted := p.Evict(); evicted == 1 || evicted == 2 {
+				t.Fatalf("scan evicted segment %d, which was read again", evicted)
+			}
+			cached--
+		}
+		p.Insert(index)
+		cached++
+	}
+}
+
+// countingEviction is an LRU policy counting the calls the caches make.
+type countingEviction struct {
+	EvictionPolicy
+	inserts, evicts *int
+}
+
+func (p countingEviction) Insert(index uint64) {
+	*p.inserts++
+	p.EvictionPolicy.Insert(index)
+}
+
+func (p countingEviction) Evict() uint64 {
+	*p.evicts++
+	return p.EvictionPolicy.Evict()
+}
+
+// TestEvictionPolicyLog checks that the caches of a log evict through the
+// configured policy and keep serving the right entries.
+func TestEvictionPolicyLog(t *testing.T) {
+	policies := map[string]func() EvictionPolicy{"default": nil}
+	for name, newPolicy := range evictionPolicies {
+		policies[name] = newPolicy
+	}
+
+	for name, newPolicy := range policies {
+		for _, compression := range []Compression{CompressionNone, CompressionZstd} {
+			t.Run(name+"/"+compression.String(), func(t *testing.T) {
+				var inserts, evicts int
+				cfg := Config{
+					SegmentSize:          4096,
+					SegmentCacheBytes:    20000,
+					DecompressCacheBytes: 20000,
+					SegmentCompression:   compression,
+				}
+				if newPolicy != nil {
+					cfg.CacheEviction = func() EvictionPolicy {
+						return countingEviction{newPolicy(), &inserts, &evicts}
+					}
+				}
+
+				l := openTest(t, t.TempDir(), cfg)
+				writeEntries(t, l, 1, 3000)
+
+				r := rand.New(rand.NewSource(2))
+				for i := 0; i < 5000; i++ {
+					index := uint64(r.Intn(3000) + 1)
+					data, err := l.Read(index)
+					if err != nil {
+						t.Fatalf("read %d: %v", index, err)
+					}
+					if string(data) != string(testEntry(index)) {
+						t.Fatalf("read %d: got %q, want %q", index, data, testEntry(index))
+					}
+				}
+
+				stats, err := l.Stats()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stats.CacheEvictions == 0 {
+					t.Fatal("no segments evicted")
+				}
+				if newPolicy != nil && (inserts == 0 || evicts == 0) {
+					t.Fatalf("policy called for %d inserts and %d evictions", inserts, evicts)
+				}
+			})
+		}
+	}
+}
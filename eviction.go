@@ -0,0 +1,161 @@
+package jellywal
+
+import "slices"
+
+// EvictionPolicy decides which segment a cache evicts once it runs out of
+// budget, so the caches can be tuned to the way the log is read. Caches
+// identify segments by their first index. Every cache has a policy of its
+// own and calls it under its lock, so the methods are never called
+// concurrently. See Config.CacheEviction.
+type EvictionPolicy interface {
+	// Insert records that the segment starting at index was cached.
+	Insert(index uint64)
+
+	// Access records a read of a cached segment.
+	Access(index uint64)
+
+	// Evict forgets a cached segment and returns its index, for the cache
+	// to drop it. It is only called while segments are cached, and must
+	// return one of them.
+	Evict() uint64
+}
+
+// NewLRUEviction returns a policy evicting the least recently read segment,
+// which suits reads moving through the history of the log.
+func NewLRUEviction() EvictionPolicy {
+	return &lruEviction{}
+}
+
+type lruEviction struct {
+	order []uint64 // Least recently used first
+}
+
+func (p *lruEviction) Insert(index uint64) {
+	p.order = append(p.order, index)
+}
+
+func (p *lruEviction) Access(index uint64) {
+	if i := slices.Index(p.order, index); i >= 0 {
+		copy(p.order[i:], p.order[i+1:])
+		p.order[len(p.order)-1] = index
+	}
+}
+
+func (p *lruEviction) Evict() uint64 {
+	index := p.order[0]
+	p.order = p.order[1:]
+	return index
+}
+
+// NewClockEviction returns a CLOCK policy, which approximates LRU without
+// reordering segments on every read. Segments are kept in a ring swept by a
+// hand, which evicts the first one not read since the last sweep.
+func NewClockEviction() EvictionPolicy {
+	return &clockEviction{}
+}
+
+type clockEviction struct {
+	ring []clockEntry
+	hand int
+}
+
+type clockEntry struct {
+	index      uint64
+	referenced bool
+}
+
+func (p *clockEviction) Insert(index uint64) {
+	// Behind the hand, so it is swept last
+	p.ring = slices.Insert(p.ring, p.hand, clockEntry{index: index})
+	p.hand++
+}
+
+func (p *clockEviction) Access(index uint64) {
+	for i := range p.ring {
+		if p.ring[i].index == index {
+			p.ring[i].referenced = true
+			return
+		}
+	}
+}
+
+func (p *clockEviction) Evict() uint64 {
+	for {
+		if p.hand >= len(p.ring) {
+			p.hand = 0
+		}
+
+		if entry := &p.ring[p.hand]; entry.referenced {
+			entry.referenced = false
+			p.hand++
+			continue
+		}
+
+		index := p.ring[p.hand].index
+		p.ring = slices.Delete(p.ring, p.hand, p.hand+1)
+		return index
+	}
+}
+
+// NewARCEviction returns an adaptive replacement cache (ARC) policy. It
+// keeps segments read once apart from segments read again, and evicts from
+// either side depending on which one recently evicted segments are read
+// back from. A replay reading every segment once then does not push out
+// the segments that random reads keep coming back to.
+func NewARCEviction() EvictionPolicy {
+	return &arcEviction{}
+}
+
+type arcEviction struct {
+	recent   []uint64 // Cached segments read once, least recently used first
+	frequent []uint64 // Cached segments read more than once
+	ghostRec []uint64 // Segments recently evicted from recent
+	ghostFrq []uint64 // Segments recently evicted from frequent
+	target   int      // Number of segments recent aims for
+}
+
+func (p *arcEviction) Insert(index uint64) {
+	cached := len(p.recent) + len(p.frequent) + 1
+	if i := slices.Index(p.ghostRec, index); i >= 0 {
+		// Evicted too early from recent, make room for more of them
+		p.target = min(p.target+max(len(p.ghostFrq)/len(p.ghostRec), 1), cached)
+		p.ghostRec = slices.Delete(p.ghostRec, i, i+1)
+		p.frequent = append(p.frequent, index)
+	} else if i := slices.Index(p.ghostFrq, index); i >= 0 {
+		p.target = max(p.target-max(len(p.ghostRec)/len(p.ghostFrq), 1), 0)
+		p.ghostFrq = slices.Delete(p.ghostFrq, i, i+1)
+		p.frequent = append(p.frequent, index)
+	} else {
+		p.recent = append(p.recent, index)
+	}
+
+	// Remember as many evicted segments as are cached
+	for len(p.ghostRec) > 0 && len(p.recent)+len(p.ghostRec) > cached {
+		p.ghostRec = p.ghostRec[1:]
+	}
+	for len(p.ghostFrq) > 0 && len(p.ghostRec)+len(p.ghostFrq) > cached {
+		p.ghostFrq = p.ghostFrq[1:]
+	}
+}
+
+func (p *arcEviction) Access(index uint64) {
+	if i := slices.Index(p.recent, index); i >= 0 {
+		p.recent = slices.Delete(p.recent, i, i+1)
+		p.frequent = append(p.frequent, index)
+	} else if i := slices.Index(p.frequent, index); i >= 0 {
+		copy(p.frequent[i:], p.frequent[i+1:])
+		p.frequent[len(p.frequent)-1] = index
+	}
+}
+
+func (p *arcEviction) Evict() uint64 {
+	var index uint64
+	if len(p.recent) > 0 && (len(p.recent) > p.target || len(p.frequent) == 0) {
+		index, p.recent = p.recent[0], p.recent[1:]
+		p.ghostRec = append(p.ghostRec, index)
+	} else {
+		index, p.frequent = p.frequent[0], p.frequent[1:]
+		p.ghostFrq = append(p.ghostFrq, index)
+	}
+	return index
+}
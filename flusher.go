@@ -0,0 +1,59 @@
+package jellywal
+
+import "time"
+
+// startFlusher starts the background goroutine that fsyncs the tail segment
+// when SyncPolicy.Mode is SyncInterval with an Interval set, so unsynced
+// writes become durable even when no further writes arrive.
+func (l *Log) startFlusher() {
+	policy := l.config.SyncPolicy
+	if l.config.ReadOnly || policy.Mode != SyncInterval || policy.Interval <= 0 {
+		return
+	}
+
+	l.flushStop = make(chan struct{})
+	l.flushDone = make(chan struct{})
+	go l.runFlusher(l.flushStop, l.flushDone, policy.Interval)
+}
+
+// stopFlusher signals the background flusher to stop and returns a channel
+// that is closed once it has exited, or nil when no flusher is running. The
+// caller must hold the write lock.
+func (l *Log) stopFlusher() chan struct{} {
+	if l.flushStop == nil {
+		return nil
+	}
+
+	close(l.flushStop)
+	done := l.flushDone
+	l.flushStop = nil
+	l.flushDone = nil
+
+	return done
+}
+
+func (l *Log) runFlusher(stop, done chan struct{}, interval time.Duration) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		var err error
+		if !l.closed && !l.corrupt.Load() && l.unsynced > 0 && time.Since(l.lastSync) >= interval {
+			err = l.syncTailAsync()
+		}
+		l.mu.Unlock()
+
+		if err != nil && l.config.OnSyncError != nil {
+			l.config.OnSyncError(err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package jellywal
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Sealed segments end with a footer indexing their entries, so they can be
+// loaded without decoding every entry. The footer is stored as an entry
+// flagged with entryFooter, which stops a sequential scan, followed by a
+// fixed size trailer pointing back at it:
+//
+//	footer entry: first index[8] | last index[8] | count[4] | offsets[4*count] | segment checksum
+//	trailer:      footer offset[4] | magic[4]
+//
+// Offsets are relative to the start of the file. The segment checksum
+// covers every byte in front of the footer, using the checksum algorithm of
+// the entries, so a sealed segment can be verified in a single pass. It is
+// missing from footers written before it was introduced. Segments too large
+// for 32 bit offsets are sealed without a footer and scanned instead.
+const (
+	footerMagic       = "JFTR"
+	footerTrailerSize = 8
+)
+
+// segmentFooter is the decoded footer of a sealed segment.
+type segmentFooter struct {
+	positions []bytepos // Positions of the indexed entries
+	off       int       // Offset of the footer in the file
+	sum       []byte    // Checksum of the segment in front of the footer, nil when not recorded
+}
+
+// matches reports whether the segment data in front of the footer matches
+// the recorded segment checksum.
+func (f *segmentFooter) matches(data []byte, sum *Checksum) bool {
+	return f.sum != nil && sum.readSum(f.sum) == sum.mask(sum.Sum(data[:f.off]))
+}
+
+// appendSegmentFooter appends the footer and trailer for a segment holding
+// the entries at positions, the first of which has the given index, to dst.
+// The footer is placed right after the segment data, which it checksums,
+// and encoded in its entry format. Returns dst unchanged when the segment
+// is too large to be indexed.
+func appendSegmentFooter(dst []byte, segment []byte, index uint64, positions []bytepos, sum *Checksum) []byte {
+	off := len(segment)
+	format := segmentFormat(segment)
+	if len(positions) == 0 || off > math.MaxUint32 || !format.fits(20+4*len(positions)+sum.Size) {
+		return dst
+	}
+
+	payload := make([]byte, 0, 20+4*len(positions)+sum.Size)
+	payload = binary.LittleEndian.AppendUint64(payload, index)
+	payload = binary.LittleEndian.AppendUint64(payload, index+uint64(len(positions))-1)
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(positions)))
+	for _, pos := range positions {
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(pos.start))
+	}
+	payload = sum.appendSum(payload, segment)
+
+	dst = format.appendEntry(dst, payload, entryFooter, sum)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(off))
+	return append(dst, footerMagic...)
+}
+
+// readSegmentFooter returns the entry positions recorded in the footer of a
+// sealed segment, which must start at the given index. It reports false
+// when the segment has no usable footer and has to be scanned.
+func readSegmentFooter(data []byte, hdr int, index uint64, sum *Checksum) ([]bytepos, bool) {
+	footer, ok := decodeSegmentFooter(data, hdr, index, sum)
+	return footer.positions, ok
+}
+
+// decodeSegmentFooter decodes the footer of a sealed segment, which must
+// start at the given index. It reports false when the segment has no usable
+// footer.
+func decodeSegmentFooter(data []byte, hdr int, index uint64, sum *Checksum) (segmentFooter, bool) {
+	if len(data) < hdr+footerTrailerSize || string(data[len(data)-len(footerMagic):]) != footerMagic {
+		return segmentFooter{}, false
+	}
+
+	trailer := len(data) - footerTrailerSize
+	off := int(binary.LittleEndian.Uint32(data[trailer:]))
+	if off < hdr || off >= trailer {
+		return segmentFooter{}, false
+	}
+
+	payload, flags, n, err := segmentFormat(data).decodeEntry(data[off:trailer], sum)
+	if err != nil || flags&entryFooter == 0 || off+n != trailer || len(payload) < 20 {
+		return segmentFooter{}, false
+	}
+
+	first := binary.LittleEndian.Uint64(payload)
+	last := binary.LittleEndian.Uint64(payload[8:])
+	count := binary.LittleEndian.Uint32(payload[16:])
+	if first != index || count == 0 || last != first+uint64(count)-1 || uint64(len(payload)-20)/4 < uint64(count) {
+		return segmentFooter{}, false
+	}
+
+	offsets := payload[20 : 20+4*int(count)]
+	footer := segmentFooter{off: off}
+	switch rest := payload[20+len(offsets):]; len(rest) {
+	case 0:
+	case sum.Size:
+		footer.sum = rest
+	default:
+		return segmentFooter{}, false
+	}
+
+	footer.positions = make([]bytepos, count)
+	end := off
+	for i := int(count) - 1; i >= 0; i-- {
+		start := int(binary.LittleEndian.Uint32(offsets[4*i:]))
+		if start < hdr || start >= end {
+			return segmentFooter{}, false
+		}
+		footer.positions[i] = bytepos{start, end}
+		end = start
+	}
+
+	if end != hdr {
+		return segmentFooter{}, false
+	}
+
+	return footer, true
+}
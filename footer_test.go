@@ -0,0 +1,120 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestSegmentFooter checks that sealed segments end with a footer indexing
+// every one of their entries, and the tail does not.
+func TestSegmentFooter(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+
+	for i, seg := range l.segments {
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		footer, ok := decodeSegmentFooter(data, segmentHeaderSize, seg.index, l.config.Checksum)
+		if i == len(l.segments)-1 {
+			if ok {
+				t.Fatal("tail segment has a footer")
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("segment %d has no footer", seg.index)
+		}
+		if !footer.matches(data, l.config.Checksum) {
+			t.Fatalf("segment %d does not match its footer checksum", seg.index)
+		}
+
+		next := l.segments[i+1].index
+		if uint64(len(footer.positions)) != next-seg.index {
+			t.Fatalf("segment %d indexes %d entries, want %d", seg.index, len(footer.positions), next-seg.index)
+		}
+		for j, pos := range footer.positions {
+			index := seg.index + uint64(j)
+			if !bytes.Contains(data[pos.start:pos.end], testEntry(index)) {
+				t.Fatalf("footer position of entry %d holds %q", index, data[pos.start:pos.end])
+			}
+		}
+	}
+}
+
+// TestSegmentFooterDamaged checks that a segment whose footer is damaged is
+// scanned instead, losing no entries.
+func TestSegmentFooterDamaged(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	sealed := l.segments[0]
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(sealed.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer, ok := decodeSegmentFooter(data, segmentHeaderSize, sealed.index, ChecksumCRC32C)
+	if !ok {
+		t.Fatal("sealed segment has no footer")
+	}
+	data[footer.off+30] ^= 0xff
+	if _, ok := readSegmentFooter(data, segmentHeaderSize, sealed.index, ChecksumCRC32C); ok {
+		t.Fatal("damaged footer was accepted")
+	}
+	if err := os.WriteFile(sealed.path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 1, 500)
+}
+
+// TestSegmentChecksum checks that Verify reports a segment checksum that
+// does not match intact entries, and that Repair rewrites it.
+func TestSegmentChecksum(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	sealed := l.segments[1]
+	sum := l.config.Checksum
+	data, err := os.ReadFile(sealed.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer, ok := decodeSegmentFooter(data, segmentHeaderSize, sealed.index, sum)
+	if !ok {
+		t.Fatal("sealed segment has no footer")
+	}
+
+	// Seal the same entries with the checksum of other data
+	other := bytes.Clone(data[:footer.off])
+	other[len(other)-1] ^= 1
+	data = appendSegmentFooter(bytes.Clone(data[:footer.off]), other, sealed.index, footer.positions, sum)
+	if err := os.WriteFile(sealed.path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !errors.Is(report.Problems[0].Err, errFooter) {
+		t.Fatalf("got problems %v, want a footer mismatch", report.Problems)
+	}
+	if report.Entries != 500 {
+		t.Fatalf("%d entries passed, want 500", report.Entries)
+	}
+
+	if _, err := l.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, l, 1, 500)
+	checkVerify(t, l)
+}
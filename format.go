@@ -0,0 +1,163 @@
+package jellywal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EntryFormat selects how the size and flags in front of every entry are
+// encoded. It is recorded in the segment header version, so segments of
+// either format can be read whatever the setting.
+type EntryFormat uint8
+
+const (
+	// EntryFormatVarint prefixes entries with a uvarint, which takes the
+	// least space for small entries. It is the default, and the only
+	// format understood by versions before EntryFormat was introduced.
+	EntryFormatVarint EntryFormat = iota
+
+	// EntryFormatFixed prefixes entries with a fixed 4 byte little-endian
+	// word, which decodes faster and puts the data of an entry at a known
+	// offset from its start. Entries are limited to maxFixedEntrySize.
+	// Segments in this format are written as version 2 and cannot be read
+	// by versions before it was introduced.
+	EntryFormatFixed
+)
+
+// fixedHeaderSize is the size of the entry header of EntryFormatFixed.
+const fixedHeaderSize = 4
+
+// maxHeaderSize is the size of the largest entry header of either format.
+const maxHeaderSize = binary.MaxVarintLen64
+
+// maxFixedEntrySize is the size of the largest entry EntryFormatFixed can
+// hold, after compression and encryption, as the flags take the low bits
+// of its header.
+const maxFixedEntrySize = 1<<(32-entryFlagBits) - 1
+
+func (f EntryFormat) String() string {
+	switch f {
+	case EntryFormatVarint:
+		return "varint"
+	case EntryFormatFixed:
+		return "fixed"
+	}
+	return fmt.Sprintf("format(%d)", uint8(f))
+}
+
+// validate checks that the format is known.
+func (f EntryFormat) validate() error {
+	if f > EntryFormatFixed {
+		return fmt.Errorf("unknown entry format %d", uint8(f))
+	}
+	return nil
+}
+
+// version returns the segment version recorded for segments in the format.
+func (f EntryFormat) version() uint8 {
+	if f == EntryFormatFixed {
+		return segmentVersionFixed
+	}
+	return segmentVersionVarint
+}
+
+// versionFormat returns the entry format of segments at the given version.
+func versionFormat(version uint8) EntryFormat {
+	if version == segmentVersionFixed {
+		return EntryFormatFixed
+	}
+	return EntryFormatVarint
+}
+
+// segmentFormat returns the entry format of segment data, which starts with
+// its header unless the segment is headerless.
+func segmentFormat(data []byte) EntryFormat {
+	if len(data) < segmentHeaderSize || string(data[:len(segmentMagic)]) != segmentMagic {
+		return EntryFormatVarint
+	}
+	return versionFormat(data[4])
+}
+
+// fits reports whether entry data of the given size can be stored in the
+// format.
+func (f EntryFormat) fits(size int) bool {
+	return f != EntryFormatFixed || size <= maxFixedEntrySize
+}
+
+// appendEntry appends a data_size|flags + data + checksum encoded entry to
+// dst, with the header encoded in the format. The data must fit it.
+func (f EntryFormat) appendEntry(dst []byte, data []byte, flags byte, sum *Checksum) []byte {
+	start := len(dst)
+	dst = f.appendEntryHeader(dst, len(data), flags)
+	dst = append(dst, data...)
+	return sum.appendSum(dst, dst[start:])
+}
+
+// appendEntryHeader appends the header of a checksummed entry of the given
+// size to dst. The data and the checksum of both are appended after it.
+func (f EntryFormat) appendEntryHeader(dst []byte, size int, flags byte) []byte {
+	header := uint64(size)<<entryFlagBits | uint64(flags|entryChecksum)
+	if f == EntryFormatFixed {
+		return binary.LittleEndian.AppendUint32(dst, uint32(header))
+	}
+	return binary.AppendUvarint(dst, header)
+}
+
+// decodeHeader decodes the data_size|flags header at the start of buf and
+// returns it along with its size. Like binary.Uvarint, the size is 0 when
+// buf is too small and negative when the header is invalid.
+func (f EntryFormat) decodeHeader(buf []byte) (uint64, int) {
+	if f != EntryFormatFixed {
+		return binary.Uvarint(buf)
+	}
+
+	if len(buf) < fixedHeaderSize {
+		return 0, 0
+	}
+	return uint64(binary.LittleEndian.Uint32(buf)), fixedHeaderSize
+}
+
+// endBatch clears the entryMore flag of the encoded entry at the start of
+// buf in place, updating its checksum, so it ends the batch it was written
+// in. It reports whether the entry was changed. Clearing a flag never
+// changes the size of the header, so the entry keeps its size.
+func (f EntryFormat) endBatch(buf []byte, sum *Checksum) bool {
+	header, n := f.decodeHeader(buf)
+	if n <= 0 || header&entryMore == 0 {
+		return false
+	}
+
+	var sumSize int
+	if header&entryChecksum != 0 {
+		sumSize = sum.Size
+	}
+
+	end := n + int(header>>entryFlagBits)
+	if len(buf) < end+sumSize {
+		return false
+	}
+
+	header &^= entryMore
+	if f == EntryFormatFixed {
+		binary.LittleEndian.PutUint32(buf, uint32(header))
+	} else {
+		var encoded [binary.MaxVarintLen64]byte
+		if binary.PutUvarint(encoded[:], header) != n {
+			return false
+		}
+		copy(buf, encoded[:n])
+	}
+
+	if sumSize > 0 {
+		sum.appendSum(buf[:end], buf[:end])
+	}
+	return true
+}
+
+// maxHeaderSize returns the size of the largest entry header of the format.
+func (f EntryFormat) maxHeaderSize() int {
+	if f == EntryFormatFixed {
+		return fixedHeaderSize
+	}
+	return binary.MaxVarintLen64
+}
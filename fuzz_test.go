@@ -0,0 +1,147 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// fuzzChecksum accepts every entry, which lets the fuzzer reach past the
+// checksums into the structure of segments and footers.
+var fuzzChecksum = &Checksum{Name: "fuzz", ID: 200, Size: 1, Sum: func([]byte) uint64 { return 0 }}
+
+// fuzzSegment builds a sealed segment holding the given entries, used to
+// seed the corpus.
+func fuzzSegment(sum *Checksum, index uint64, entries ...string) []byte {
+	data := appendSegmentHeader(nil, EntryFormatVarint, sum, 0)
+	var positions []bytepos
+	for _, entry := range entries {
+		start := len(data)
+		data = EntryFormatVarint.appendEntry(data, []byte(entry), 0, sum)
+		positions = append(positions, bytepos{start, len(data)})
+	}
+	return appendSegmentFooter(data, data, index, positions, sum)
+}
+
+func FuzzDecodeEntry(f *testing.F) {
+	f.Add(EntryFormatVarint.appendEntry(nil, []byte("hello"), 0, ChecksumCRC32C))
+	f.Add(EntryFormatVarint.appendEntry(nil, nil, entryMore, ChecksumCRC32C))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		data, flags, n, err := EntryFormatVarint.decodeEntry(buf, ChecksumCRC32C)
+		if n < 0 || n > len(buf) {
+			t.Fatalf("encoded size %d out of bounds for %d bytes", n, len(buf))
+		}
+		if err != nil {
+			var corrupt *CorruptError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("error is not a CorruptError: %v", err)
+			}
+			return
+		}
+
+		// Encoding the entry again gives back the same data and flags
+		encoded := EntryFormatVarint.appendEntry(nil, data, flags&^entryChecksum, ChecksumCRC32C)
+		again, againFlags, _, err := EntryFormatVarint.decodeEntry(encoded, ChecksumCRC32C)
+		if err != nil || !bytes.Equal(again, data) || againFlags != flags|entryChecksum {
+			t.Fatalf("entry does not round trip: %x, %v", encoded, err)
+		}
+	})
+}
+
+func FuzzParseSegment(f *testing.F) {
+	f.Add(fuzzSegment(ChecksumCRC32C, 1, "a", "bb", "ccc"))
+	f.Add(fuzzSegment(fuzzChecksum, 1, "a", "bb", "ccc"))
+	f.Add(fuzzSegment(fuzzChecksum, 42, "only"))
+	f.Add(appendSegmentHeader(nil, EntryFormatVarint, ChecksumCRC64, 0))
+	f.Add(appendSegmentHeader(nil, EntryFormatVarint, ChecksumCRC32C, segmentFlagEncrypted))
+	f.Add(appendSegmentHeader(nil, EntryFormatFixed, ChecksumCRC32C, 0))
+	f.Add(EntryFormatVarint.appendEntry(nil, []byte("legacy"), 0, ChecksumCRC32C))
+	f.Add([]byte("JWAL"))
+
+	l := &Log{config: *DefaultConfig}
+	l.config.Checksum = fuzzChecksum
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, err := l.readSegmentHeader(data)
+		if err != nil {
+			return
+		}
+		if header.size > len(data) {
+			t.Fatalf("header size %d past %d bytes", header.size, len(data))
+		}
+
+		positions, valid, _ := parseSegmentEntries(data, header.size, header.sum)
+		if valid < header.size || valid > len(data) {
+			t.Fatalf("valid prefix %d out of bounds", valid)
+		}
+		checkPositions(t, positions, header.size, valid)
+
+		for i := range positions {
+			seg := &segment{index: 1, cbuf: data, cpos: positions, sum: header.sum}
+			if _, err := seg.entryData(uint64(i)+1, entryDecoder{}); err != nil {
+				t.Fatalf("parsed entry %d does not decode: %v", i, err)
+			}
+		}
+
+		if footer, ok := decodeSegmentFooter(data, header.size, 1, header.sum); ok {
+			checkPositions(t, footer.positions, header.size, footer.off)
+			footer.matches(data, header.sum)
+		}
+	})
+}
+
+func FuzzDecodeManifest(f *testing.F) {
+	f.Add((&manifest{first: 1, segments: []manifestSegment{{first: 1}}}).encode())
+	f.Add((&manifest{first: 7, truncate: 9, segments: []manifestSegment{{1, 4, 1700000000000000000, 0}, {5, 0, 0, 0}}}).encode())
+	f.Add((&manifest{first: 1, segments: []manifestSegment{{1, 4, 1700000000000000000, 4096}, {5, 0, 0, 0}}}).encode())
+	f.Add([]byte("jellywal-manifest 1\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := decodeManifest(data)
+		if err != nil {
+			return
+		}
+
+		// A valid manifest encodes back to the same bytes
+		again, err := decodeManifest(m.encode())
+		if err != nil {
+			t.Fatalf("encoded manifest does not decode: %v", err)
+		}
+		if again.first != m.first || again.truncate != m.truncate || len(again.segments) != len(m.segments) {
+			t.Fatalf("manifest does not round trip: %+v != %+v", again, m)
+		}
+	})
+}
+
+// checkPositions checks that positions are ordered, non-empty and within
+// the given bounds.
+func checkPositions(t *testing.T, positions []bytepos, start, end int) {
+	t.Helper()
+
+	prev := start
+	for i, pos := range positions {
+		if pos.start < prev || pos.end <= pos.start || pos.end > end {
+			t.Fatalf("entry %d at %d to %d is out of bounds %d to %d", i, pos.start, pos.end, prev, end)
+		}
+		prev = pos.end
+	}
+}
+
+func FuzzDecompressEntry(f *testing.F) {
+	data := bytes.Repeat([]byte("jellywal "), 16)
+	for _, c := range []Compression{CompressionSnappy, CompressionZstd, CompressionLZ4} {
+		compressed, _ := c.appendCompressed(nil, data, nil)
+		f.Add(compressed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := decompressEntry(data, nil); err != nil {
+			var corrupt *CorruptError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("error is not a CorruptError: %v", err)
+			}
+		}
+	})
+}
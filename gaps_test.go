@@ -0,0 +1,85 @@
+package jellywal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestAllowGaps checks that with AllowGaps writes may skip ahead, and that
+// the skipped indexes read as missing, are stepped over by cursors and are
+// counted by Len, before and after reopening.
+func TestAllowGaps(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, AllowGaps: true}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 10)
+	writeEntries(t, l, 100, 300)
+	writeBatchEntries(t, l, 1000, 1010)
+
+	want := append(append(indexRange(1, 10), indexRange(100, 300)...), indexRange(1000, 1010)...)
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			l = reopenTest(t, l, dir, cfg)
+		}
+		if got := l.Len(); got != 1010 {
+			t.Fatalf("got %d entries, want 1010", got)
+		}
+		for _, index := range []uint64{11, 99, 301, 999} {
+			if _, err := l.Read(index); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("read %d in a gap: got %v, want ErrNotFound", index, err)
+			}
+		}
+
+		c, err := l.Cursor(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := walk(t, c); !slices.Equal(got, want) {
+			t.Fatalf("cursor visited %v, want %v", got, want)
+		}
+		c, err = l.Cursor(50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := walk(t, c); !slices.Equal(got, want[10:]) {
+			t.Fatalf("cursor from a gap visited %v, want %v", got, want[10:])
+		}
+	}
+}
+
+// TestAllowGapsOrder checks that indexes must still increase with
+// AllowGaps, that only the first entry of a batch may skip ahead, and that
+// gaps are rejected without it.
+func TestAllowGapsOrder(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{AllowGaps: true})
+	writeEntries(t, l, 1, 10)
+	for _, index := range []uint64{0, 5, 10} {
+		if err := l.Write(index, testEntry(index)); !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("write %d: got %v, want ErrOutOfOrder", index, err)
+		}
+	}
+
+	var b Batch
+	b.Write(20, testEntry(20))
+	b.Write(30, testEntry(30))
+	if err := l.WriteBatch(&b); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("batch with a gap inside: got %v, want ErrOutOfOrder", err)
+	}
+	checkEntries(t, l, 1, 10)
+
+	l = openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Write(20, testEntry(20)); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("write skipping ahead without AllowGaps: got %v, want ErrOutOfOrder", err)
+	}
+}
+
+// indexRange returns the indexes first through last.
+func indexRange(first, last uint64) []uint64 {
+	var indexes []uint64
+	for i := first; i <= last; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
@@ -1,3 +1,9 @@
 module github.com/davidandw190/jellywal
 
 go 1.21.0
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/pierrec/lz4/v4 v4.1.21
+	golang.org/x/sys v0.26.0
+)
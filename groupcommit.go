@@ -0,0 +1,152 @@
+package jellywal
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+)
+
+// errLeader is sent to a queued writer to hand it group commit leadership.
+var errLeader = errors.New("group commit leader")
+
+// writeRequest is a single Write waiting to be group committed.
+type writeRequest struct {
+	index uint64
+	data  []byte
+	done  chan error
+	flush bool // Holds no entry, completed once the ones queued before it are written
+}
+
+// groupWrite appends an entry using group commit. Concurrent writers queue
+// their entries and one of them, the leader, writes the whole queue with a
+// single file write and fsync while the others wait for its result. While
+// the leader is busy new writers keep queueing, so the next group grows with
+// the fsync latency.
+func (l *Log) groupWrite(index uint64, data []byte) error {
+	req := writeRequestPool.Get().(*writeRequest)
+	req.index, req.data = index, data
+	defer func() {
+		req.data = nil
+		writeRequestPool.Put(req)
+	}()
+
+	l.gmu.Lock()
+	l.gqueue = append(l.gqueue, req)
+	if l.gleader {
+		l.gmu.Unlock()
+		if err := <-req.done; err != errLeader {
+			return err
+		}
+	} else {
+		l.gleader = true
+		l.gmu.Unlock()
+	}
+
+	// We are the leader, commit everything queued so far
+	l.mu.Lock()
+	l.gmu.Lock()
+	group := l.gqueue
+	l.gqueue = l.gspare[:0]
+	l.gmu.Unlock()
+
+	// The results are handed out before unlocking, the async writer may
+	// commit the next group right after
+	results := l.commitGroup(group)
+	var result error
+	for i, r := range group {
+		if r == req {
+			result = results[i]
+		} else {
+			r.done <- results[i]
+		}
+	}
+	l.mu.Unlock()
+
+	// Hand leadership to the oldest queued writer, if any, keeping the
+	// queue of this group for the next one
+	clear(group)
+	l.gmu.Lock()
+	l.gspare = group
+	if len(l.gqueue) > 0 {
+		l.gqueue[0].done <- errLeader
+	} else {
+		l.gleader = false
+	}
+	l.gmu.Unlock()
+
+	return result
+}
+
+// commitGroup writes the group as one batch and returns the result of each
+// request. Requests are ordered by index first, so writers racing for
+// consecutive indexes all succeed regardless of their arrival order. The
+// caller must hold the write lock, the results are only valid until the
+// next group is committed.
+func (l *Log) commitGroup(group []*writeRequest) []error {
+	results := slices.Grow(l.gresult[:0], len(group))[:len(group)]
+	clear(results)
+	l.gresult = results
+
+	var err error
+	if l.corrupt.Load() {
+		err = ErrCorrupt
+	} else if l.closed {
+		err = ErrClosed
+	} else if l.config.ReadOnly {
+		err = ErrReadOnly
+	}
+
+	if err != nil {
+		for i := range results {
+			results[i] = err
+		}
+		return results
+	}
+
+	order := l.gorder[:0]
+	for i := range group {
+		order = append(order, i)
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(group[a].index, group[b].index)
+	})
+	l.gorder = order
+
+	// Batches must be contiguous, so a write that skips ahead starts a new
+	// batch of its own
+	l.wbatch.Clear()
+	pending := l.gpending[:0]
+	commit := func() {
+		if len(pending) == 0 {
+			return
+		}
+
+		err := l.writeBatch(&l.wbatch)
+		for _, i := range pending {
+			results[i] = err
+		}
+		l.wbatch.Clear()
+		pending = pending[:0]
+	}
+
+	prev := l.lastIndex
+	for _, i := range order {
+		index := group[i].index
+		if index != prev+1 && (!l.config.AllowGaps || index <= prev) {
+			results[i] = ErrOutOfOrder
+			continue
+		}
+
+		if index != prev+1 {
+			commit()
+		}
+
+		l.wbatch.Write(index, group[i].data)
+		pending = append(pending, i)
+		prev = index
+	}
+	commit()
+	l.gpending = pending
+
+	return results
+}
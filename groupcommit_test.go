@@ -0,0 +1,87 @@
+package jellywal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestGroupCommitOrder checks that a group is written in index order
+// whatever the order its writers arrived in, and that only the requests
+// that do not fit fail.
+func TestGroupCommitOrder(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 2)
+
+	var group []*writeRequest
+	for _, index := range []uint64{5, 3, 7, 4, 2} {
+		group = append(group, &writeRequest{index: index, data: testEntry(index)})
+	}
+	l.mu.Lock()
+	results := l.commitGroup(group)
+	l.mu.Unlock()
+
+	for i, req := range group {
+		want := error(nil)
+		if req.index == 7 || req.index == 2 {
+			want = ErrOutOfOrder
+		}
+		if !errors.Is(results[i], want) {
+			t.Fatalf("write %d: got %v, want %v", req.index, results[i], want)
+		}
+	}
+	checkEntries(t, l, 1, 5)
+}
+
+// TestGroupCommitConcurrent checks that concurrent writers under SyncAlways
+// all get their entries written and synced.
+func TestGroupCommitConcurrent(t *testing.T) {
+	const writers, perWriter = 8, 100
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{SegmentSize: 4096})
+
+	// Each writer takes the next free index, retrying when another one
+	// got there first
+	var mu sync.Mutex
+	next := uint64(1)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < perWriter; n++ {
+				mu.Lock()
+				index := next
+				next++
+				mu.Unlock()
+
+				for {
+					err := l.Write(index, testEntry(index))
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, ErrOutOfOrder) {
+						t.Error(err)
+						return
+					}
+					last, _ := l.LastIndex()
+					if last >= index {
+						t.Errorf("write %d failed after it was written", index)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	const last = writers * perWriter
+	if durable := l.DurableIndex(); durable != last {
+		t.Fatalf("durable index %d, want %d", durable, last)
+	}
+	l = reopenTest(t, l, dir, Config{SegmentSize: 4096})
+	checkEntries(t, l, 1, last)
+}
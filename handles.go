@@ -0,0 +1,157 @@
+package jellywal
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// fileHandles keeps the read handles of streamed segment files open up to
+// MaxOpenFiles, closing the least recently used idle ones beyond it. A
+// closed handle is opened again by its next read, so a log with thousands
+// of streamed segments does not hold a file descriptor for each of them.
+type fileHandles struct {
+	mu     sync.Mutex
+	limit  int            // Max open handles, 0 leaves them all open
+	open   []*segmentFile // Open handles, least recently used first
+	closed bool           // Set once the log is closed
+}
+
+// segmentFile is the read handle of a streamed segment file.
+type segmentFile struct {
+	handles *fileHandles
+	path    string
+	info    os.FileInfo // File the handle was opened on, nil when unknown
+	file    *os.File    // Nil while closed to stay within the limit
+	busy    int         // Reads in progress, which keep it open
+	closed  bool        // Closed for good
+}
+
+// wrap adopts the open file as a segment read handle.
+func (h *fileHandles) wrap(file *os.File) *segmentFile {
+	f := &segmentFile{handles: h, path: file.Name(), file: file}
+	if h.limit <= 0 {
+		return f
+	}
+
+	f.info, _ = file.Stat()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.open = append(h.open, f)
+	h.evict()
+	return f
+}
+
+// acquire returns the open file of the handle, opening it again when it
+// was closed, and keeps it open until release.
+func (h *fileHandles) acquire(f *segmentFile) (*os.File, error) {
+	if h.limit <= 0 {
+		return f.file, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if f.closed || h.closed {
+		return nil, fmt.Errorf("failed to read log segment file: %w", os.ErrClosed)
+	}
+
+	if f.file == nil {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log segment file: %w", err)
+		}
+
+		// The path may name a different file by now, after a rewrite or
+		// removal of the segment the handle was opened for
+		if info, err := file.Stat(); err != nil || f.info == nil || !os.SameFile(info, f.info) {
+			file.Close()
+			return nil, fmt.Errorf("failed to open log segment file: %s was replaced", f.path)
+		}
+
+		f.file = file
+		h.open = append(h.open, f)
+	} else if i := slices.Index(h.open, f); i >= 0 {
+		copy(h.open[i:], h.open[i+1:])
+		h.open[len(h.open)-1] = f
+	}
+
+	f.busy++
+	h.evict()
+	return f.file, nil
+}
+
+// release ends a read of the handle.
+func (h *fileHandles) release(f *segmentFile) {
+	if h.limit <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	f.busy--
+	h.evict()
+	h.mu.Unlock()
+}
+
+// evict closes the least recently used idle handles beyond the limit. Busy
+// handles are left open, the limit is exceeded while they are all busy.
+// The caller must hold the lock.
+func (h *fileHandles) evict() {
+	for i := 0; i < len(h.open) && len(h.open) > h.limit; {
+		f := h.open[i]
+		if f.busy > 0 {
+			i++
+			continue
+		}
+
+		f.file.Close()
+		f.file = nil
+		h.open = slices.Delete(h.open, i, i+1)
+	}
+}
+
+// closeAll closes every handle for good once the log is closed.
+func (h *fileHandles) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, f := range h.open {
+		f.file.Close()
+		f.file = nil
+	}
+	h.open = nil
+}
+
+// ReadAt reads from the segment file at the given offset.
+func (f *segmentFile) ReadAt(p []byte, off int64) (int, error) {
+	file, err := f.handles.acquire(f)
+	if err != nil {
+		return 0, err
+	}
+	defer f.handles.release(f)
+
+	return file.ReadAt(p, off)
+}
+
+// Close closes the handle for good.
+func (f *segmentFile) Close() error {
+	if f.handles.limit <= 0 {
+		return f.file.Close()
+	}
+
+	f.handles.mu.Lock()
+	defer f.handles.mu.Unlock()
+
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+
+	f.handles.open = slices.DeleteFunc(f.handles.open, func(open *segmentFile) bool { return open == f })
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
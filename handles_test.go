@@ -0,0 +1,103 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileHandles checks that handles beyond the limit are closed, least
+// recently used first, and opened again by their next read, unless their
+// file was replaced.
+func TestFileHandles(t *testing.T) {
+	dir := t.TempDir()
+	h := &fileHandles{limit: 2}
+	var handles []*segmentFile
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, h.wrap(file))
+	}
+	defer h.closeAll()
+
+	isOpen := func(f *segmentFile) bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return f.file != nil
+	}
+	read := func(f *segmentFile) (string, error) {
+		buf := make([]byte, 1)
+		_, err := f.ReadAt(buf, 0)
+		return string(buf), err
+	}
+
+	if isOpen(handles[0]) || !isOpen(handles[1]) || !isOpen(handles[2]) {
+		t.Fatal("least recently used handle not closed")
+	}
+	if got, err := read(handles[0]); err != nil || got != "a" {
+		t.Fatalf("read a: got %q, %v", got, err)
+	}
+	if !isOpen(handles[0]) || isOpen(handles[1]) || !isOpen(handles[2]) {
+		t.Fatal("handle not opened again, or the least recently used kept")
+	}
+
+	// b is replaced while its handle is closed
+	if err := os.WriteFile(handles[1].path+".new", []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(handles[1].path+".new", handles[1].path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := read(handles[1]); err == nil {
+		t.Fatalf("read replaced file: got %q", got)
+	}
+
+	if err := handles[2].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read(handles[2]); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("read closed handle: got %v, want os.ErrClosed", err)
+	}
+}
+
+// TestMaxOpenFiles checks that a log reading many streamed segments keeps
+// no more of them open than MaxOpenFiles.
+func TestMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 1000)
+
+	cfg.StreamSegmentBytes, cfg.MaxOpenFiles = 100, 2
+	l = reopenTest(t, l, dir, cfg)
+	if len(l.segments) < 10 {
+		t.Fatalf("got %d segments, want many", len(l.segments))
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		index := uint64(r.Intn(1000) + 1)
+		data, err := l.Read(index)
+		if err != nil {
+			t.Fatalf("read %d: %v", index, err)
+		}
+		if !bytes.Equal(data, testEntry(index)) {
+			t.Fatalf("read %d: got %q, want %q", index, data, testEntry(index))
+		}
+		l.files.mu.Lock()
+		open := len(l.files.open)
+		l.files.mu.Unlock()
+		if open > cfg.MaxOpenFiles {
+			t.Fatalf("%d segment files open, over %d", open, cfg.MaxOpenFiles)
+		}
+	}
+	checkEntries(t, l, 1, 1000)
+}
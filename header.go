@@ -0,0 +1,190 @@
+package jellywal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Segment files start with a fixed size header:
+//
+//	magic[4] | version | checksum ID | flags[2]
+//
+// Version 1 prefixes entries with uvarints and version 2 with fixed 4 byte
+// words, see EntryFormat. Otherwise both are the same.
+//
+// Segments written before headers were introduced start directly with their
+// first entry and are treated as version 0. Their first byte can never match
+// the magic, as it would set an entry flag that was not in use, so both
+// kinds can be told apart. The same rule tells foreign files apart from
+// headerless segments.
+//
+// The version changes whenever older code could misread a segment. Features
+// that older code must refuse rather than ignore are marked with a flag, any
+// flag unknown to the reader makes it reject the segment.
+const (
+	segmentMagic      = "JWAL"
+	segmentVersion    = 2 // Highest version understood
+	segmentHeaderSize = 8
+
+	segmentVersionVarint = 1
+	segmentVersionFixed  = 2
+
+	// segmentFlagEncrypted marks segments whose entries are encrypted.
+	segmentFlagEncrypted uint16 = 1 << 0
+
+	// segmentFlagBound marks encrypted segments whose entries are bound to
+	// their position, see entryAAD.
+	segmentFlagBound uint16 = 1 << 1
+
+	// segmentFlagsKnown holds every segment flag this version understands.
+	segmentFlagsKnown = segmentFlagEncrypted | segmentFlagBound
+
+	// headerlessEntryFlags holds the entry flags in use before segment
+	// headers were introduced, the only ones the first entry of a
+	// headerless segment can have.
+	headerlessEntryFlags = entryMore | entryChecksum
+)
+
+// segmentHeader is the decoded header of a segment file.
+type segmentHeader struct {
+	version uint8     // Format version, 0 for headerless segments
+	sum     *Checksum // Checksum algorithm of the entries
+	flags   uint16    // Format features used by the segment
+	size    int       // Size of the header in the file
+}
+
+// appendSegmentHeader appends a segment header for entries in the given
+// format, checksummed with sum and using the given segment flags to dst.
+func appendSegmentHeader(dst []byte, format EntryFormat, sum *Checksum, flags uint16) []byte {
+	dst = append(dst, segmentMagic...)
+	dst = append(dst, format.version(), sum.ID)
+	return binary.LittleEndian.AppendUint16(dst, flags)
+}
+
+// newSegmentFlags returns the segment flags for new segments, according to
+// the configuration.
+func (l *Log) newSegmentFlags() uint16 {
+	if l.config.Encryption != nil {
+		return segmentFlagEncrypted | segmentFlagBound
+	}
+	return 0
+}
+
+// flags returns the segment flags from the header in the cached buffer.
+func (s *segment) flags() uint16 {
+	if s.hdr != segmentHeaderSize {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(s.cbuf[6:])
+}
+
+// format returns the entry format from the header in the cached buffer.
+func (s *segment) format() EntryFormat {
+	if s.hdr != segmentHeaderSize {
+		return EntryFormatVarint
+	}
+	return versionFormat(s.cbuf[4])
+}
+
+// format returns the entry format of the segment.
+func (h segmentHeader) format() EntryFormat {
+	return versionFormat(h.version)
+}
+
+// encrypted reports whether the entries of a segment are encrypted.
+func (s *segment) encrypted() bool {
+	return s.flags()&segmentFlagEncrypted != 0
+}
+
+// readSegmentHeader decodes the header at the start of segment data.
+// Headerless segments checksum their entries with CRC32C, their first entry
+// is checked to make sure the data is a segment at all.
+func (l *Log) readSegmentHeader(data []byte) (segmentHeader, error) {
+	if len(data) < segmentHeaderSize && len(data) > 0 && bytes.HasPrefix([]byte(segmentMagic), data[:min(len(data), len(segmentMagic))]) {
+		return segmentHeader{}, &CorruptError{Err: errTornHeader}
+	}
+
+	if len(data) < len(segmentMagic) || string(data[:len(segmentMagic)]) != segmentMagic {
+		if len(data) > 0 {
+			header, n := binary.Uvarint(data)
+			if n <= 0 || header&entryFlagMask&^headerlessEntryFlags != 0 {
+				return segmentHeader{}, ErrForeignFile
+			}
+		}
+		return segmentHeader{sum: ChecksumCRC32C}, nil
+	}
+
+	h := segmentHeader{
+		version: data[4],
+		flags:   binary.LittleEndian.Uint16(data[6:]),
+		size:    segmentHeaderSize,
+	}
+
+	if h.version == 0 || h.version > segmentVersion {
+		return segmentHeader{}, fmt.Errorf("%w: segment version %d", ErrUnsupportedFormat, h.version)
+	}
+
+	if unknown := h.flags &^ segmentFlagsKnown; unknown != 0 {
+		return segmentHeader{}, fmt.Errorf("%w: segment flags %#x", ErrUnsupportedFormat, unknown)
+	}
+
+	var ok bool
+	h.sum, ok = l.lookupChecksum(data[5])
+	if !ok {
+		return segmentHeader{}, fmt.Errorf("%w: unknown checksum algorithm %d", ErrUnsupportedFormat, data[5])
+	}
+
+	return h, nil
+}
+
+// segmentTempSuffix is added to the name of a segment file while it is
+// being created. Open removes the ones left behind by a crash.
+const segmentTempSuffix = ".tmp"
+
+// createSegmentFile creates a segment file at path, opened for use as the
+// tail, and writes the header for the configured checksum algorithm to it.
+// The header is returned so it can seed the cached segment buffer. A file
+// from the recycle pool is reused when there is one.
+//
+// The file is set up under a temp name and only renamed to path once its
+// header is synced, so a crash never leaves an empty or partial segment
+// behind. The caller is responsible for syncing the directory.
+func (l *Log) createSegmentFile(path string) (*os.File, []byte, error) {
+	tempPath := path + segmentTempSuffix
+
+	// Truncated by the open below either way
+	l.takeRecycled(tempPath)
+
+	file, err := l.openTailFile(tempPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fail := func(err error) (*os.File, []byte, error) {
+		file.Close()
+		os.Remove(tempPath)
+		return nil, nil, err
+	}
+
+	header := appendSegmentHeader(nil, l.config.EntryFormat, l.config.Checksum, l.newSegmentFlags())
+	if _, err := file.Write(header); err != nil {
+		return fail(err)
+	}
+
+	if l.config.Preallocate {
+		// Best effort, appends allocate as they go without it
+		preallocate(file, l.segmentSize.Load())
+	}
+
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		return fail(err)
+	}
+
+	return file, header, nil
+}
@@ -0,0 +1,125 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSegmentHeader checks the header written at the start of segments.
+func TestSegmentHeader(t *testing.T) {
+	for _, test := range []struct {
+		cfg     Config
+		version byte
+		sum     byte
+	}{
+		{Config{}, segmentVersionVarint, ChecksumCRC32C.ID},
+		{Config{Checksum: ChecksumXXHash64}, segmentVersionVarint, ChecksumXXHash64.ID},
+		{Config{EntryFormat: EntryFormatFixed, Checksum: ChecksumCRC64}, segmentVersionFixed, ChecksumCRC64.ID},
+	} {
+		l := openTest(t, t.TempDir(), test.cfg)
+		writeEntries(t, l, 1, 1)
+		data, err := os.ReadFile(l.segments[0].path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []byte{'J', 'W', 'A', 'L', test.version, test.sum, 0, 0}
+		if string(data[:segmentHeaderSize]) != string(want) {
+			t.Fatalf("header % x, want % x", data[:segmentHeaderSize], want)
+		}
+	}
+}
+
+// TestReadSegmentHeader checks that readSegmentHeader tells segments of
+// every version apart from foreign and unsupported files.
+func TestReadSegmentHeader(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	var headerless []byte
+	headerless = EntryFormatVarint.appendEntry(headerless, []byte("data"), 0, ChecksumCRC32C)
+
+	for _, test := range []struct {
+		name    string
+		data    string
+		version uint8
+		err     error
+	}{
+		{"varint", "JWAL\x01\x01\x00\x00", segmentVersionVarint, nil},
+		{"fixed", "JWAL\x02\x03\x00\x00", segmentVersionFixed, nil},
+		{"headerless", string(headerless), 0, nil},
+		{"empty", "", 0, nil},
+		{"torn", "JWA", 0, errTornHeader},
+		{"foreign", "hello, world", 0, ErrForeignFile},
+		{"newer", "JWAL\x09\x01\x00\x00", 0, ErrUnsupportedFormat},
+		{"zero version", "JWAL\x00\x01\x00\x00", 0, ErrUnsupportedFormat},
+		{"unknown flags", "JWAL\x01\x01\x00\x80", 0, ErrUnsupportedFormat},
+		{"unknown checksum", "JWAL\x01\x7f\x00\x00", 0, ErrUnsupportedFormat},
+	} {
+		h, err := l.readSegmentHeader([]byte(test.data))
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		if err == nil && h.version != test.version {
+			t.Fatalf("%s: version %d, want %d", test.name, h.version, test.version)
+		}
+	}
+}
+
+// TestOpenForeignFile checks that Open refuses a segment file replaced by
+// one that is not a segment.
+func TestOpenForeignFile(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(l.segments[0].path, []byte("hello, world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, nil); !errors.Is(err, ErrForeignFile) {
+		t.Fatalf("open: got %v, want ErrForeignFile", err)
+	}
+}
+
+// TestSegmentTempFiles checks that new segments leave no temp files behind,
+// and that Open removes the ones a crash during their creation left.
+func TestSegmentTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	temps, err := filepath.Glob(filepath.Join(dir, "*"+segmentTempSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(temps) != 0 {
+		t.Fatalf("temp files left behind: %v", temps)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash while creating the next segment and the spare one
+	temps = []string{
+		filepath.Join(dir, l.segmentName(501)+segmentTempSuffix),
+		filepath.Join(dir, spareFileName+segmentTempSuffix),
+	}
+	for i, path := range temps {
+		if err := os.WriteFile(path, []byte(segmentMagic)[:i], 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l = openTest(t, dir, cfg)
+	for _, path := range temps {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("temp file %s left in place: %v", filepath.Base(path), err)
+		}
+	}
+	checkEntries(t, l, 1, 500)
+	writeEntries(t, l, 501, 600)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 600)
+}
@@ -0,0 +1,98 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// With ImmutableSegments, the files of sealed segments are made read-only
+// once written, and the manifest records their sizes. A sealed segment
+// whose file has a different size on Open was changed behind the log's
+// back, unless a crash came between a rewrite by the log and the manifest
+// recording its new size. It is verified to tell the two apart.
+
+// errChangedSegment is reported when the file of a sealed segment changed
+// size and no longer verifies.
+var errChangedSegment = errors.New("sealed segment file was changed")
+
+// protectSegmentFile makes the file of a sealed segment read-only. It is
+// best effort, the size check on Open does not depend on it.
+func (l *Log) protectSegmentFile(path string) {
+	if l.config.ImmutableSegments && !l.config.ReadOnly {
+		setWritable(path, l.config.FilePerms, false)
+	}
+}
+
+// unprotectSegmentFile makes the file of a segment writable again before
+// the log writes to it in place. Files protected before ImmutableSegments
+// was turned off are made writable as well.
+func (l *Log) unprotectSegmentFile(path string) {
+	if !l.config.ReadOnly {
+		setWritable(path, l.config.FilePerms, true)
+	}
+}
+
+// recordSegmentSizes writes the manifest again after a sealed segment file
+// was rewritten in place, so it records the new size. It is best effort:
+// Open verifies a segment whose size is not the recorded one and takes the
+// new size when it verifies. The caller must hold the write lock.
+func (l *Log) recordSegmentSizes() {
+	if l.recordsSizes() && l.writeManifest(l.segments, l.firstIndex, 0) == nil {
+		l.syncDir()
+	}
+}
+
+// recordsSizes reports whether the manifest records the sizes of the sealed
+// segment files, which ImmutableSegments checks them against and LazyOpen
+// takes them from.
+func (l *Log) recordsSizes() bool {
+	return l.config.ImmutableSegments || l.config.LazyOpen
+}
+
+// statsLazily reports whether Open takes the sizes of sealed segment files
+// from the manifest rather than stating them. ImmutableSegments needs the
+// actual sizes to check them against the recorded ones.
+func (l *Log) statsLazily() bool {
+	return l.config.LazyOpen && !l.config.ImmutableSegments
+}
+
+// checkSegmentSizes checks the sizes of the sealed segment files against
+// those recorded in the manifest, and reports whether the manifest has to
+// be written again, for a segment that still verifies with a different
+// size or one without a recorded size. Segments that do not verify
+// fail Open with a CorruptError, or are left for RecoverQuarantine to
+// quarantine.
+func (l *Log) checkSegmentSizes(m *manifest) (bool, error) {
+	var resized bool
+	for i, ms := range m.segments[:max(len(m.segments)-1, 0)] {
+		seg := l.segments[i]
+		if ms.size == 0 && l.recordsSizes() {
+			// Sealed before ImmutableSegments or LazyOpen was turned on
+			l.protectSegmentFile(seg.path)
+			resized = true
+			continue
+		} else if ms.size == 0 || seg.size == ms.size {
+			continue
+		}
+
+		var report VerifyReport
+		l.verifySegment(&report, seg, m.segments[i+1].first-1, false)
+		if report.OK() {
+			resized = true
+			continue
+		} else if l.config.Recovery == RecoverQuarantine {
+			continue
+		}
+
+		return false, &CorruptError{
+			Path:       seg.path,
+			FirstIndex: ms.first,
+			LastIndex:  ms.last,
+			Err:        errChangedSegment,
+			Expected:   fmt.Sprintf("%d bytes", ms.size),
+			Found:      fmt.Sprintf("%d bytes", seg.size),
+		}
+	}
+
+	return resized, nil
+}
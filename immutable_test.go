@@ -0,0 +1,90 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// appendGarbage appends bytes to the file at path behind the log's back.
+func appendGarbage(tb testing.TB, path string) {
+	tb.Helper()
+	if err := os.Chmod(path, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := file.Write([]byte("garbage")); err != nil {
+		tb.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// TestImmutableSegments checks that a sealed segment file changed behind
+// the log's back fails Open, or is quarantined with RecoverQuarantine.
+func TestImmutableSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, ImmutableSegments: true}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	changed := l.segments[len(l.segments)-2]
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendGarbage(t, changed.path)
+	_, err := Open(dir, &cfg)
+	var corrupt *CorruptError
+	if !errors.Is(err, errChangedSegment) || !errors.As(err, &corrupt) || corrupt.Path != changed.path {
+		t.Fatalf("got %v, want a changed segment error for %s", err, filepath.Base(changed.path))
+	}
+
+	cfg.Recovery = RecoverQuarantine
+	l = openTest(t, dir, cfg)
+	if err := l.Damage(); !errors.As(err, &corrupt) || corrupt.Path != changed.path {
+		t.Fatalf("damage %v, want %s reported", err, filepath.Base(changed.path))
+	}
+	names := quarantined(t, dir)
+	base := filepath.Base(changed.path)
+	if len(names) < 2 || !strings.HasPrefix(names[0], base) || !strings.HasSuffix(names[1], ".report") {
+		t.Fatalf("quarantined %v, want %s and its report first", names, base)
+	}
+	checkEntries(t, l, 1, changed.index-1)
+}
+
+// TestImmutableSegmentsRewritten checks that sealed segments the log cuts
+// or compacts itself keep opening, with their new sizes recorded.
+func TestImmutableSegmentsRewritten(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			cfg.ImmutableSegments = true
+			cfg.CompactOnTruncate = true
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			if err := l.TruncateFront(10); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.TruncateBack(l.segments[1].index + 5); err != nil {
+				t.Fatal(err)
+			}
+			last := l.segments[1].index + 5
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 10, last)
+
+			writeEntries(t, l, last+1, 600)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 10, 600)
+			checkVerify(t, l)
+		})
+	}
+}
@@ -1,31 +1,497 @@
 package jellywal
 
 import (
-	"encoding/binary"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
+// Each entry starts with a header holding the data size shifted left by
+// entryFlagBits, with the entry flags in the low bits, encoded according to
+// the EntryFormat of its segment.
 const (
-	DefaultSegmentSize = 20 * 1024 * 1024 // 20 MB
-	DefaultDirPerms    = 0750
-	DefaultFilePerms   = 0640
+	entryFlagBits = 4
+	entryFlagMask = 1<<entryFlagBits - 1
+
+	// entryMore marks an entry that is followed by more entries of the same
+	// batch. The last entry of a batch has it cleared, which commits it.
+	entryMore = 1 << 0
+
+	// entryChecksum marks an entry followed by a checksum of its header and
+	// data, computed with the algorithm recorded in the segment header.
+	// Entries written before checksums were introduced lack it.
+	entryChecksum = 1 << 1
+
+	// entryFooter marks the footer written to a segment when it is sealed.
+	// It is not a log entry, and ends the entries of the segment.
+	entryFooter = 1 << 2
+
+	// entryCompressed marks an entry whose data starts with the compression
+	// algorithm, followed by the data compressed with it.
+	entryCompressed = 1 << 3
+
+	// entryFlagsKnown holds every entry flag this version understands.
+	entryFlagsKnown = entryMore | entryChecksum | entryFooter | entryCompressed
+)
+
+const (
+	DefaultSegmentSize  = 20 * 1024 * 1024 // 20 MB
+	DefaultDirPerms     = 0750
+	DefaultFilePerms    = 0640
+	DefaultSyncInterval = 100 * time.Millisecond
+	DefaultRetryBackoff = 10 * time.Millisecond
+	DefaultMaxBackoff   = time.Second
+
+	DefaultCompressionThreshold = 64
+)
+
+var (
+	// ErrCorrupt is returned when the log is corrupt. Corrupt data found in
+	// a segment file is reported with a CorruptError, which matches it.
+	ErrCorrupt = errors.New("log corrupt")
+
+	// ErrClosed is returned when an operation cannot be completed because
+	// the log is closed.
+	ErrClosed = errors.New("log closed")
+
+	// ErrNotFound is returned when an entry is not found.
+	ErrNotFound = errors.New("not found")
+
+	// ErrOutOfOrder is returned from Write() when the index is not equal to
+	// LastIndex()+1. It's required that log monotonically grows by one and has
+	// no gaps. Thus, the series 10,11,12,13,14 is valid, but 10,11,13,14 is
+	// not because there's a gap between 11 and 13. Also, 10,12,11,13 is not
+	// valid because 12 and 11 are out of order. With AllowGaps set only the
+	// latter is rejected.
+	ErrOutOfOrder = errors.New("out of order")
+
+	// ErrReadOnly is returned from mutating calls on a log opened with
+	// ReadOnly set.
+	ErrReadOnly = errors.New("log is read-only")
+
+	// ErrLocked is returned from Open when another process holds the lock
+	// on the log directory.
+	ErrLocked = errors.New("log is locked by another process")
+
+	// ErrNoSpace is returned when a write fails because the disk is full.
+	// The partial write is rolled back, so the log stays readable and the
+	// write can be retried once space has been freed.
+	ErrNoSpace = errors.New("no space left on device")
+
+	// ErrOutOfRange is returned from TruncateFront() and TruncateBack() when
+	// the index is not in the range of the log's first and last index.
+	ErrOutOfRange = errors.New("out of range")
+
+	// ErrUnsupportedFormat is returned from Open when a segment was written
+	// by a newer version, or with a feature or checksum algorithm that is
+	// not available.
+	ErrUnsupportedFormat = errors.New("unsupported segment format")
+
+	// ErrForeignFile is returned from Open when a file named like a segment
+	// does not hold log data. The file is left untouched.
+	ErrForeignFile = errors.New("not a log segment file")
+
+	// ErrNoKeyProvider is returned when reading or appending to a segment
+	// with encrypted entries while Config.Encryption is not set.
+	ErrNoKeyProvider = errors.New("log is encrypted but no key provider is configured")
+
+	// ErrEntryTooLarge is returned from Write, WriteBatch and WriteFrom when
+	// an entry is too large for the EntryFormat of the tail segment.
+	ErrEntryTooLarge = errors.New("entry too large")
+)
+
+// SyncMode selects when writes are fsynced to disk.
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs after every write. This is the most durable mode.
+	SyncAlways SyncMode = iota
+
+	// SyncInterval fsyncs once the policy Interval has elapsed or Bytes have
+	// been written since the last fsync, whichever comes first. A crash may
+	// lose the writes made since the last fsync.
+	SyncInterval
+
+	// SyncNever leaves flushing to the operating system. Durability points
+	// can still be established by calling Sync.
+	SyncNever
+
+	// SyncDSync opens the tail segment with O_DSYNC (O_SYNC where that is
+	// unavailable), so every write is durable when it returns without a
+	// separate fsync call.
+	SyncDSync
+)
+
+// RecoveryMode selects how Open deals with damage at the end of the tail
+// segment, where an interrupted write leaves it.
+type RecoveryMode int
+
+const (
+	// RecoverTorn truncates a torn final write, the only damage a crash
+	// can cause, and fails Open on any other damage.
+	RecoverTorn RecoveryMode = iota
+
+	// RecoverStrict fails Open on any anomaly, including a torn final
+	// write. Every sealed segment is verified as well, which reads the
+	// whole log.
+	RecoverStrict
+
+	// RecoverTruncate truncates the tail segment at the first damaged
+	// entry, discarding it and every entry after it.
+	RecoverTruncate
+
+	// RecoverReadOnly truncates torn writes like RecoverTorn, but opens the
+	// log read-only up to the first damaged entry instead of failing, so
+	// the data can be salvaged. The damaged file is left untouched.
+	RecoverReadOnly
+
+	// RecoverQuarantine verifies every segment like RecoverStrict, but
+	// moves unreadable segment files into the corrupt subdirectory of the
+	// log, next to a report of their problems, instead of failing Open.
+	// The log continues with the remaining segments as far as index
+	// continuity allows: quarantined segments at the front move the first
+	// index up, one anywhere else takes every later segment with it unless
+	// AllowGaps is set. Damage at the end of the tail is truncated like
+	// RecoverTruncate, after a copy of the file is quarantined.
+	RecoverQuarantine
 )
 
+// SyncPolicy controls how often the tail segment is fsynced.
+type SyncPolicy struct {
+	Mode     SyncMode      // When to fsync. Default is SyncAlways.
+	Interval time.Duration // Max time between fsyncs for SyncInterval.
+	Bytes    int           // Max unsynced bytes for SyncInterval.
+}
+
 // Config for configuring the log
 type Config struct {
-	Sync        bool        // Enable fsync after writes for more durability
+	SyncPolicy  SyncPolicy  // When to fsync writes. Default is every write.
 	SegmentSize int         // Size of each log segment. Default is 20 MB.
 	DirPerms    os.FileMode // Directory permissions.
 	FilePerms   os.FileMode // Log file permissions.
+
+	// SegmentMaxAge seals the tail once it has been written to for this
+	// long, even when it is not full, so segments cover a bounded time
+	// span for time based retention and archiving. The age is measured
+	// from when the tail was started or the log opened. Default is zero,
+	// which only rotates by size.
+	SegmentMaxAge time.Duration
+
+	// MaxDiskBytes caps the total size of the segment files. Once a sealed
+	// segment takes the log over it, the oldest segments are deleted as if
+	// by TruncateFront until it fits again, keeping at least the segment
+	// holding the last entry. Default is zero, which keeps every segment.
+	MaxDiskBytes int64
+
+	// CompactOnTruncate rewrites the segment TruncateFront cuts through to
+	// start at the new first index, so the entries in front of it give
+	// their space back right away rather than once the whole segment is
+	// removed. The rewrite is crash safe, Open completes one that was
+	// interrupted. Default is false, which leaves the segment as it is.
+	CompactOnTruncate bool
+
+	// PunchHoles frees the disk blocks of the entries TruncateFront removes
+	// from a segment it cuts through without rewriting the segment, once it
+	// is sealed. Only raw segment files that are neither mapped nor
+	// streamed are punched. Supported on Linux filesystems with hole
+	// punching, ignored elsewhere. CompactOnTruncate takes precedence.
+	PunchHoles bool
+
+	// ImmutableSegments makes the files of sealed segments read-only and
+	// records their sizes in the manifest, so Open catches a sealed segment
+	// changed by another tool or by accident. A segment whose size changed
+	// and no longer verifies fails Open with a CorruptError, or is
+	// quarantined with RecoverQuarantine. Their content is checked as usual
+	// by RecoverStrict, Verify and the scrubber. Files are only made
+	// read-only on Unix. Default is false.
+	ImmutableSegments bool
+
+	// LazyOpen records the sizes of sealed segment files in the manifest,
+	// like ImmutableSegments, and has Open take them from there rather than
+	// stat every file, so opening a log with a long history only costs
+	// listing the directory and loading the tail segment. Sealed segments
+	// are loaded when first read either way. A sealed segment changed
+	// behind the log's back is then only caught by the checksums of its
+	// entries as it is read, not by Open, unless ImmutableSegments is set
+	// too, which keeps Open stating every file to check its size. Logs
+	// opened without it before are stated once more, until the manifest
+	// records all sizes.
+	LazyOpen bool
+
+	// RetentionAge deletes sealed segments once they were sealed this long
+	// ago, which is when their newest entry was written or shortly after.
+	// A background janitor checks for them every tenth of the age, at least
+	// once a minute. As with MaxDiskBytes, the segment holding the last
+	// entry is kept, so combine it with SegmentMaxAge for logs that stop
+	// receiving writes. Default is zero, which keeps segments forever.
+	RetentionAge time.Duration
+
+	// OnRetention is called with the range of indexes deleted whenever
+	// retention truncates the front of the log. It is called while the log
+	// lock is held and must not call into the log.
+	OnRetention func(first, last uint64)
+
+	// Archiver is handed every segment TruncateFront or retention is about
+	// to delete, which is only deleted once it is archived. Archiving does
+	// not hold up writes: retention leaves the segments to the background
+	// janitor, which is woken up as segments are sealed, and deletes them
+	// once they are archived. Default is none.
+	Archiver Archiver
+
+	// SegmentPrefix and SegmentSuffix are put around the 20 digit index
+	// segment files are named after, such as a ".wal" suffix, so segments
+	// can share a directory with files of other tools and follow their
+	// conventions. Files not following the scheme are left alone. They
+	// must stay the same for the life of a log, as segments named by
+	// another scheme are not found. Defaults are empty.
+	SegmentPrefix string
+	SegmentSuffix string
+
+	// ReadOnly opens the log without write file handles, never creating or
+	// modifying files. Mutating calls return ErrReadOnly. The log reflects
+	// the directory contents at the time Open was called.
+	ReadOnly bool
+
+	// NoLock skips taking the exclusive directory lock on Open. Only use it
+	// when the filesystem does not support locking and access is otherwise
+	// coordinated, two writers on one directory will corrupt the log.
+	NoLock bool
+
+	// AllowGaps lets writes skip ahead of LastIndex()+1, for example to
+	// restart numbering after installing a snapshot. The skipped indexes
+	// form a gap: reading them returns ErrNotFound, iteration steps over
+	// them, and they are still counted by Len. Indexes must keep increasing,
+	// and within a batch only the first entry may skip ahead.
+	AllowGaps bool
+
+	// OnSyncError is called with errors from the background flusher that
+	// fsyncs the tail when SyncPolicy.Mode is SyncInterval. Without it such
+	// errors go unreported until the next write or Sync fails.
+	OnSyncError func(err error)
+
+	// OnDurable is called with the new DurableIndex whenever it advances.
+	// Calls are made from a dedicated goroutine, outside of the log lock,
+	// and may be coalesced so not every intermediate index is reported.
+	OnDurable func(index uint64)
+
+	// Retry controls retries of tail writes and fsyncs that fail with a
+	// transient error. Retries are disabled by default.
+	Retry RetryPolicy
+
+	// SegmentSizing adapts the size segments are sealed at to the write
+	// rate, in place of SegmentSize. Disabled by default.
+	SegmentSizing SizingPolicy
+
+	// OnRetry is called before each retry with the operation ("write" or
+	// "sync"), the failed attempt number and its error.
+	OnRetry func(op string, attempt int, err error)
+
+	// Recovery selects how Open deals with a damaged tail segment. Default
+	// is RecoverTorn. The damage found is reported by Damage.
+	Recovery RecoveryMode
+
+	// OpenConcurrency is the number of segments Open stats, and verifies
+	// with RecoverStrict or RecoverQuarantine, at the same time, so opening
+	// a log with many segments is bound by the disk rather than by their
+	// number. Default is zero, which uses GOMAXPROCS.
+	OpenConcurrency int
+
+	// ScrubInterval enables a background scrubber that re-reads one sealed
+	// segment every interval, cycling through the log, and verifies it like
+	// Verify. Latent corruption is then found before the data is needed.
+	ScrubInterval time.Duration
+
+	// OnScrubProblem is called with every problem the scrubber finds, from
+	// the scrubber goroutine and outside of the log lock.
+	OnScrubProblem func(problem Problem)
+
+	// Checksum is the algorithm used to checksum the entries of new
+	// segments. Default is ChecksumCRC32C, or ChecksumXXHash64 on CPUs
+	// without CRC32 instructions. Segments written with other
+	// algorithms stay readable, custom ones only while configured here.
+	Checksum *Checksum
+
+	// EntryFormat is the encoding of the entry headers of new segments.
+	// Default is EntryFormatVarint. Segments written in either format stay
+	// readable, existing segments, including the current tail, keep theirs.
+	EntryFormat EntryFormat
+
+	// SegmentCompression is the algorithm used to compress whole segments
+	// as they are sealed, which saves more space than compressing entries.
+	// Reading a compressed segment decompresses all of it. Default is
+	// CompressionNone. Compressed segments stay readable whatever the
+	// setting.
+	SegmentCompression Compression
+
+	// SegmentCompressionDelay keeps the most recently sealed segments raw
+	// and compresses a sealed segment in the background once this many
+	// later segments have been sealed, so neither writes nor reads of
+	// recent entries pay for compression. Default is zero, which compresses
+	// segments as they are sealed.
+	SegmentCompressionDelay int
+
+	// SegmentCacheBytes bounds the memory used to keep the entries of
+	// recently read sealed segments loaded, so random reads over the
+	// history of the log do not load them again. The most recently read
+	// segment is always kept. Default is four times SegmentSize, negative
+	// only keeps the most recently read segment.
+	SegmentCacheBytes int64
+
+	// StreamSegmentBytes loads segment files larger than this by streaming
+	// them through a small buffer rather than reading them whole. Only the
+	// positions of their entries are kept in memory, entries are read from
+	// the file when needed. A tail this large is sealed on Open, so appends
+	// go to a new segment. Compressed segments are always read whole.
+	// Default is zero, which reads every segment whole.
+	StreamSegmentBytes int64
+
+	// MaxOpenFiles caps the number of streamed segment files kept open for
+	// reading, so a log with thousands of them stays within the file
+	// descriptor limit of the process, along with any other logs it has
+	// open. The least recently read ones are closed beyond it and opened
+	// again when read. The tail file appended to is not counted. Default
+	// is zero, which keeps every streamed segment open while it is cached.
+	MaxOpenFiles int
+
+	// Preallocate allocates disk space for SegmentSize bytes as segments
+	// are created, without changing their size, so appends do not allocate
+	// extents mid-write, which evens out write latency and reduces
+	// fragmentation. The space past the end of a segment is released when
+	// it is sealed. Supported on Linux and macOS, ignored elsewhere.
+	Preallocate bool
+
+	// WriteBufferSize keeps up to this many bytes of written entries in
+	// memory before writing them to the tail file together, so frequent
+	// small writes between fsyncs cost one write call rather than one each.
+	// Buffered entries are readable right away and written before every
+	// fsync, but a crash of the process loses them, as a crash of the
+	// machine loses unsynced ones. SyncAlways and SyncDSync fsync every
+	// write and gain nothing from it. Default is zero, which writes every
+	// batch to the file as it is written.
+	WriteBufferSize int
+
+	// IOUring submits the writes and fsyncs of the tail segment to an
+	// io_uring on Linux, for deployments chasing the most IOPS out of NVMe
+	// drives. The fsyncs of the SyncInterval policy are submitted without
+	// waiting for them, a reaper goroutine completes them and advances
+	// DurableIndex, and reports failed ones to OnSyncError. Plain system
+	// calls are used where io_uring is unavailable, including kernels
+	// before 5.6 and other platforms.
+	IOUring bool
+
+	// DirectIO writes the tail segment with O_DIRECT on Linux, bypassing
+	// the page cache for predictable write latency, and so entries the
+	// application caches itself are not cached twice. Writes are made in
+	// whole blocks of 4 KB, the last one padded with zeros and written again
+	// by the next write, so it pays off for writes of several KB, or along
+	// with WriteBufferSize. Ignored on other platforms.
+	DirectIO bool
+
+	// AlignWrites writes the tail segment in whole blocks of 4 KB like
+	// DirectIO does, but through the page cache and on every platform.
+	// Each write starts at the block holding the end of the data written
+	// before and is padded with zeros to the end of its last block, which
+	// the next write fills in. The device then never has to read a block
+	// to update part of it, which evens out write latency on SSDs and SMR
+	// drives. Tail segments created before segment headers are written as
+	// usual.
+	AlignWrites bool
+
+	// MmapSegments maps sealed segment files into memory rather than
+	// reading them into the heap, so reads are served from the page cache
+	// and cached segments cost little resident memory. Replay and forward
+	// cursors advise the kernel to read ahead. Only raw files are mapped,
+	// and only on Unix, other segments are loaded as usual.
+	MmapSegments bool
+
+	// RecycleSegments keeps up to this many files of segments deleted by
+	// truncation or retention and reuses them for new tail segments, which
+	// saves creating and removing a file on every rotation of a log whose
+	// front is truncated as fast as it grows. Reused files are truncated
+	// before they are written to. Default is zero, which removes them.
+	RecycleSegments int
+
+	// SparseIndexInterval writes a sidecar file next to each sealed raw
+	// segment recording the offset of every this many entries. A read of a
+	// segment that is not cached then only reads the entries from the
+	// nearest recorded one up to the wanted one, rather than loading the
+	// whole segment, which speeds up random reads into cold history.
+	// Default is zero, which writes no sidecars.
+	SparseIndexInterval int
+
+	// DecompressCacheBytes bounds the memory used to keep the decompressed
+	// data of recently read compressed segments, on top of the segments
+	// the log caches for reads, so reads moving between segments do not
+	// decompress them again. Default is twice SegmentSize, negative
+	// disables the cache.
+	DecompressCacheBytes int
+
+	// CacheEviction creates the policy deciding which segments the segment
+	// cache and the decompression cache evict once they are full, called
+	// for each of them and again whenever they are cleared. LRU suits
+	// replaying and tailing the log, ARC mixes of replays and random reads
+	// of hot segments, see NewLRUEviction, NewClockEviction and
+	// NewARCEviction. Default is nil, which evicts the least recently read
+	// segment.
+	CacheEviction func() EvictionPolicy
+
+	// MaxCacheBytes bounds the memory of every cache of the log together,
+	// for applications running under a hard memory limit. It is split
+	// between the segment cache, which gets eleven sixteenths of it, the
+	// decompression cache, which gets a quarter, and the cached sparse
+	// index sidecars, which get the rest, and takes precedence over
+	// SegmentCacheBytes and DecompressCacheBytes. The most recently read
+	// segment and sidecar are kept even when they exceed their share, and
+	// the tail segment and the WriteBufferSize are on top of it. Stats
+	// reports the memory held by each cache. Default is zero, which sizes
+	// the caches by their own settings and keeps every sidecar read.
+	MaxCacheBytes int64
+
+	// Compression is the algorithm used to compress entry data. Entries
+	// that do not get smaller, or are larger than 64 MB, are stored raw.
+	// Default is CompressionNone.
+	// Compressed entries stay readable whatever the setting.
+	Compression Compression
+
+	// CompressionThreshold is the size in bytes below which entries are
+	// stored raw without trying to compress them, as tiny entries rarely
+	// get smaller. Default is 64 bytes, or none with CompressionZstdDict,
+	// which is meant for small entries. Negative compresses every entry.
+	CompressionThreshold int
+
+	// Dictionary controls the training of the dictionary used by
+	// CompressionZstdDict.
+	Dictionary DictionaryPolicy
+
+	// Codec transforms the data of every entry, after compression, before
+	// it is written and again when it is read. Default is none. It has to
+	// stay the same for the lifetime of the log.
+	Codec Codec
+
+	// Encryption encrypts entries with AES-256-GCM under keys from the
+	// KeyProvider. It applies to segments created once it is set, existing
+	// segments, including the current tail, keep their entries as they
+	// are. Encrypted segments need it to be read. Default is none.
+	Encryption KeyProvider
+
+	// PrecreateSegment creates and fsyncs the next segment file in the
+	// background once the tail is three quarters full, so the write that
+	// triggers a rotation does not pay for creating it.
+	PrecreateSegment bool
 }
 
 // DefaultConfig for the log
 var DefaultConfig = &Config{
-	Sync:        true, // Fsync after every write
+	SyncPolicy:  SyncPolicy{Mode: SyncAlways},
 	SegmentSize: DefaultSegmentSize,
 	DirPerms:    DefaultDirPerms,
 	FilePerms:   DefaultFilePerms,
@@ -33,23 +499,90 @@ var DefaultConfig = &Config{
 
 // Log represents a write-ahead log, also known as an append only log
 type Log struct {
-	mu       sync.RWMutex
-	path     string     // Absolute path to log directory
-	segments []*segment // All known log segments
-	sfile    *os.File   // Tail segment file handle
-	wbatch   Batch      // Reusable write batch
+	mu          sync.RWMutex                // Guards the log
+	view        atomic.Pointer[segmentView] // Segments for readers of sealed ones, see rlockEntry
+	path        string                      // Absolute path to log directory
+	segments    []*segment                  // All known log segments
+	firstIndex  uint64                      // Index of the first entry in log
+	lastIndex   uint64                      // Index of the last entry in log
+	sfile       *os.File                    // Tail segment file handle
+	wbatch      Batch                       // Reusable write batch
+	lastSync    time.Time                   // Time of the last tail fsync
+	tailStarted time.Time                   // Time the tail was started or the log opened
+	tailBase    int                         // Bytes the tail held at tailStarted
+	segmentSize atomic.Int64                // Size the tail is sealed at, adapted with SegmentSizing
+	unsynced    int                         // Bytes written to the tail since the last fsync
+	written     int                         // End of the tail data in its file, the rest is buffered
+	flushStop   chan struct{}
+	flushDone   chan struct{}
+	rotateStop  chan struct{}
+	rotateDone  chan struct{}
+	janitorStop chan struct{}
+	janitorDone chan struct{}
+	janitorKick chan struct{} // Wakes the janitor to archive the segments retention waits for
+	scrubStop   chan struct{}
+	scrubDone   chan struct{}
+	ring        *uring        // Tail writes and fsyncs go through it with IOUring
+	directFile  *os.File      // Tail file switched to O_DIRECT with DirectIO
+	dbuf        []byte        // Aligned buffer of block aligned writes
+	spare       *os.File      // Pre-created next segment file
+	spareHeader []byte        // Header written to the spare file
+	spareDone   chan struct{} // Closed once the spare being created is ready
+	coldDone    chan struct{} // Closed once the background compression of cold segments stops
+
+	gmu      sync.Mutex      // Guards the group commit state
+	gqueue   []*writeRequest // Writes waiting to be group committed
+	gspare   []*writeRequest // Queue of the last group, reused for the next one
+	gleader  bool            // A group commit leader is active
+	gresult  []error         // Results of the group being committed, by request
+	gorder   []int           // Requests of the group by index
+	gpending []int           // Requests of the batch being written
+
+	amu      sync.RWMutex       // Guards the WriteAsync queue, held for reading while queueing
+	aqueue   chan *writeRequest // Entries queued by WriteAsync, nil until the first one
+	adone    chan struct{}      // Closed once the async writer has exited
+	astopped bool               // Set by Close, WriteAsync fails from then on
+
+	durable      atomic.Uint64 // Highest index known to be on stable storage
+	durableEpoch uint64        // Bumped when entries past the durable index are truncated
+	durableWake  chan struct{}
+	durableStop  chan struct{}
+	durableDone  chan struct{}
+	dlock        *dirLock // Exclusive lock on the log directory
+
+	archiveMu sync.Mutex // Serializes archiving segments without the log lock, taken before mu
+
+	scache   segmentCache // Recently read non-tail segments
+	dcache   decompressCache
+	icache   sparseCache // Sidecars of sealed segments by path
+	recycled recyclePool // Deleted segment files kept for reuse
+	files    fileHandles // Read handles of streamed segments
+
+	dict        atomic.Pointer[zstdDict] // Dictionary for CompressionZstdDict, nil until trained
+	dictSamples [][]byte                 // Entries sampled to train the dictionary
+	keys        *keyring                 // Encryption keys, nil without Config.Encryption
 
 	config  Config
+	damage  error // Damage found in the tail segment by Open
 	closed  bool
-	corrupt bool
+	corrupt atomic.Bool // Set without withdrawing the view, so readers of sealed segments load it
 }
 
 // Segment represents a single segment file.
 type segment struct {
-	path  string    // Path of the segment file
-	index uint64    // First index of the segment
-	cbuf  []byte    // Cached entries buffer
-	cpos  []bytepos // Cached entries positions in the buffer
+	path  string       // Path of the segment file
+	index uint64       // First index of the segment
+	cbuf  []byte       // Cached entries buffer
+	cpos  []bytepos    // Cached entries positions in the buffer
+	size  int64        // Size of the segment file, tracked for sealed segments
+	last  uint64       // Last index of a sealed segment, 0 when unknown
+	seal  time.Time    // Time a sealed segment was sealed, zero when unknown
+	rfile *segmentFile // Read handle of a streamed segment, whose cbuf only holds the header
+	mmap  *mapping     // Mapping cbuf points into, nil when it is on the heap
+	sum   *Checksum    // Checksum algorithm of the cached entries
+	hdr   int          // Size of the segment header in the cached buffer
+
+	archived os.FileInfo // File as it was last handed to the Archiver, nil if never
 }
 
 // bpos represents byte positions in a buffer
@@ -58,13 +591,15 @@ type bytepos struct {
 	end   int // One byte past pos
 }
 
+// Batch of entries. Used to write multiple entries at once using WriteBatch.
 type Batch struct {
 	entries []batchEntry
 	datas   []byte
 }
 
 type batchEntry struct {
-	size int
+	index uint64
+	size  int
 }
 
 func (c *Config) Validate() {
@@ -79,132 +614,2413 @@ func (c *Config) Validate() {
 	if c.FilePerms == 0 {
 		c.FilePerms = DefaultFilePerms
 	}
+
+	if c.Checksum == nil {
+		c.Checksum = defaultChecksum()
+	}
+
+	if c.SyncPolicy.Mode == SyncInterval && c.SyncPolicy.Interval <= 0 && c.SyncPolicy.Bytes <= 0 {
+		c.SyncPolicy.Interval = DefaultSyncInterval
+	}
+
+	if c.CompressionThreshold == 0 && c.Compression != CompressionZstdDict {
+		c.CompressionThreshold = DefaultCompressionThreshold
+	}
+
+	if c.MaxCacheBytes > 0 {
+		decompress := c.MaxCacheBytes / 16 * decompressCacheShare
+		index := c.MaxCacheBytes / 16 * indexCacheShare
+		c.SegmentCacheBytes = c.MaxCacheBytes - decompress - index
+		c.DecompressCacheBytes = int(decompress)
+		if c.DecompressCacheBytes == 0 {
+			c.DecompressCacheBytes = -1
+		}
+	}
+
+	if c.SegmentCacheBytes == 0 {
+		c.SegmentCacheBytes = 4 * int64(c.SegmentSize)
+	}
+
+	if c.DecompressCacheBytes == 0 {
+		c.DecompressCacheBytes = 2 * c.SegmentSize
+	}
+
+	if c.Dictionary.Samples <= 0 {
+		c.Dictionary.Samples = DefaultDictionarySamples
+	}
+
+	if c.Dictionary.Size <= 0 {
+		c.Dictionary.Size = DefaultDictionarySize
+	}
+
+	if c.SegmentSizing.TargetAge > 0 {
+		if c.SegmentSizing.MinSize <= 0 {
+			c.SegmentSizing.MinSize = max(c.SegmentSize/segmentSizingRange, 1)
+		}
+
+		if c.SegmentSizing.MaxSize < c.SegmentSizing.MinSize {
+			c.SegmentSizing.MaxSize = max(c.SegmentSize*segmentSizingRange, c.SegmentSizing.MinSize)
+		}
+	}
+
+	if c.Retry.Attempts > 1 {
+		if c.Retry.Backoff <= 0 {
+			c.Retry.Backoff = DefaultRetryBackoff
+		}
+
+		if c.Retry.MaxBackoff < c.Retry.Backoff {
+			c.Retry.MaxBackoff = max(DefaultMaxBackoff, c.Retry.Backoff)
+		}
+	}
 }
 
-// loadSegments loads existing log segments from the log directory.
-func (l *Log) loadSegments() error {
-	files, err := os.ReadDir(l.path)
+// Open opens the log at the given path, creating the directory if needed.
+// A nil config uses DefaultConfig.
+func Open(path string, config *Config) (*Log, error) {
+	if config == nil {
+		config = DefaultConfig
+	}
+	cfg := *config
+	cfg.Validate()
+
+	if err := cfg.Checksum.validate(); err != nil {
+		return nil, fmt.Errorf("invalid checksum: %w", err)
+	}
+
+	if err := cfg.EntryFormat.validate(); err != nil {
+		return nil, fmt.Errorf("invalid entry format: %w", err)
+	}
+
+	if err := cfg.Compression.validate(); err != nil {
+		return nil, fmt.Errorf("invalid compression: %w", err)
+	}
+
+	if err := cfg.SegmentCompression.validate(); err != nil {
+		return nil, fmt.Errorf("invalid segment compression: %w", err)
+	} else if cfg.SegmentCompression == CompressionZstdDict {
+		return nil, fmt.Errorf("invalid segment compression: %s is only valid for entries", cfg.SegmentCompression)
+	}
+
+	if err := cfg.validatePipeline(); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
+
+	if err := cfg.validateNaming(); err != nil {
+		return nil, fmt.Errorf("invalid segment naming: %w", err)
+	}
+
+	path, err := filepath.Abs(path)
 	if err != nil {
-		return fmt.Errorf("failed to read log directory: %w", err)
+		return nil, fmt.Errorf("failed to resolve log path: %w", err)
 	}
 
-	for _, file := range files {
-		name := file.Name()
+	if !cfg.ReadOnly {
+		if err := os.MkdirAll(path, cfg.DirPerms); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+	}
 
-		if file.IsDir() || len(name) < 20 {
-			continue
+	l := &Log{path: path, config: cfg}
+	l.scache.budget = max(cfg.SegmentCacheBytes, 0)
+	l.scache.evictor = cfg.CacheEviction
+	l.segmentSize.Store(int64(cfg.SegmentSize))
+	l.dcache.budget = max(cfg.DecompressCacheBytes, 0)
+	l.dcache.evictor = cfg.CacheEviction
+	if cfg.MaxCacheBytes > 0 {
+		// A share rounded down to nothing still bounds the cache
+		l.icache.budget = max(cfg.MaxCacheBytes/16*indexCacheShare, 1)
+	}
+	l.recycled.limit = max(cfg.RecycleSegments, 0)
+	l.files.limit = max(cfg.MaxOpenFiles, 0)
+	if cfg.Encryption != nil {
+		l.keys = newKeyring(cfg.Encryption)
+	}
+	if !cfg.ReadOnly && !cfg.NoLock {
+		// Read-only logs never write, so they may coexist with a writer
+		l.dlock, err = lockDir(path, cfg.FilePerms)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		index, err := strconv.ParseUint(name[:20], 10, 64)
-		if err != nil || index == 0 {
-			continue
+	if err := l.loadDictionary(); err != nil {
+		if l.dlock != nil {
+			l.dlock.release()
 		}
+		return nil, err
+	}
 
-		if len(name) == 20 {
-			segment := &segment{
-				index: index,
-				path:  filepath.Join(l.path, name),
+	if !l.config.ReadOnly {
+		if err := l.loadRecyclePool(); err != nil {
+			if l.dlock != nil {
+				l.dlock.release()
 			}
-			l.segments = append(l.segments, segment)
+			return nil, err
 		}
 	}
 
-	if len(l.segments) == 0 {
-		// Create a new log in this case
-		if err := l.createInitialSegment(); err != nil {
-			return fmt.Errorf("failed to create initial log segment: %w", err)
+	if err := l.loadSegments(); err != nil {
+		if l.dlock != nil {
+			l.dlock.release()
 		}
-	} else {
-		// Open the last segment for appending
-		lastSegment := l.segments[len(l.segments)-1]
-		if err := l.openLastSegment(lastSegment); err != nil {
-			return fmt.Errorf("failed to open last log segment: %w", err)
+		return nil, err
+	}
+	if l.config.ReadOnly {
+		// Also the case when RecoverReadOnly found damage
+		l.durable.Store(l.lastIndex)
+	} else if err := l.syncTail(); err != nil {
+		// Entries left behind by a crashed process may not have reached
+		// the disk yet, sync them so they can be reported as durable
+		l.close()
+		return nil, err
+	} else if l.segments[len(l.segments)-1].rfile != nil {
+		// The tail is too large to append to in memory
+		if err := l.sealStreamedTail(); err != nil {
+			l.close()
+			return nil, err
 		}
 	}
 
-	return nil
+	l.startRing()
+	l.startFlusher()
+	l.startRotator()
+	l.startJanitor()
+	l.startNotifier()
+	l.startScrubber()
+
+	// Catch up on the cold segments left raw when the log was last closed,
+	// and on retention in case its limits were lowered
+	l.lockSegments()
+	l.maybeCompressCold()
+	l.enforceRetention()
+	l.unlockSegments()
+
+	return l, nil
 }
 
-func (l *Log) createInitialSegment() error {
-	initialSegment := &segment{
-		index: 1,
-		path:  filepath.Join(l.path, segmentName(1)),
+// Close flushes and fsyncs the tail segment, stops the background flusher,
+// releases the directory lock and drops all cached segments. The log is
+// closed even when one of those steps fails, and every later call returns
+// ErrClosed.
+func (l *Log) Close() error {
+	// Write the entries queued by WriteAsync first, which takes the lock
+	l.stopAsyncWriter()
+
+	l.lockSegments()
+	flusherDone := l.stopFlusher()
+	rotatorDone := l.stopRotator()
+	janitorDone := l.stopJanitor()
+	scrubberDone := l.stopScrubber()
+	err := l.close()
+	notifierDone := l.stopNotifier()
+	spareDone := l.spareDone
+	coldDone := l.coldDone
+	l.unlockSegments()
+
+	// Wait outside of the lock, the flusher needs it to notice the stop
+	if flusherDone != nil {
+		<-flusherDone
 	}
 
-	l.segments = append(l.segments, initialSegment)
+	if rotatorDone != nil {
+		<-rotatorDone
+	}
 
-	file, err := os.OpenFile(initialSegment.path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, l.config.FilePerms)
-	if err != nil {
-		return fmt.Errorf("failed to create initial log segment file: %w", err)
+	if janitorDone != nil {
+		<-janitorDone
 	}
 
-	l.sfile = file
+	if scrubberDone != nil {
+		<-scrubberDone
+	}
 
-	return nil
+	if spareDone != nil {
+		<-spareDone
+	}
+
+	if coldDone != nil {
+		<-coldDone
+	}
+
+	if notifierDone != nil {
+		<-notifierDone
+	}
+
+	return err
 }
 
-// openLastSegment opens the last log segment for appending.
-func (l *Log) openLastSegment(lastSegment *segment) error {
-	file, err := os.OpenFile(lastSegment.path, os.O_WRONLY, l.config.FilePerms)
-	if err != nil {
-		return fmt.Errorf("failed to open last log segment file: %w", err)
+// close closes the log. The caller must hold the write lock.
+func (l *Log) close() error {
+	if l.closed {
+		if l.corrupt.Load() {
+			return ErrCorrupt
+		}
+		return ErrClosed
 	}
+	l.closed = true
 
-	l.sfile = file
+	var errs []error
+	if l.sfile != nil {
+		// A corrupt log may have closed its tail already
+		if !l.corrupt.Load() {
+			if err := l.flushTail(); err != nil {
+				errs = append(errs, err)
+			}
+		}
 
-	if _, err := l.sfile.Seek(0, 2); err != nil {
-		return fmt.Errorf("failed to seek in last log segment file: %w", err)
+		if err := l.sfile.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			errs = append(errs, fmt.Errorf("failed to sync tail segment: %w", err))
+		} else if err == nil {
+			l.setDurable(l.lastIndex)
+		}
+
+		if err := l.sfile.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			errs = append(errs, fmt.Errorf("failed to close tail segment: %w", err))
+		}
 	}
 
-	// Load the last segment entries
-	if err := l.loadSegmentEntries(lastSegment); err != nil {
-		return fmt.Errorf("failed to load last log segment entries: %w", err)
+	if err := l.stopRing(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close io_uring: %w", err))
 	}
 
-	return nil
+	if err := l.dropSpare(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove spare segment: %w", err))
+	}
+
+	if l.dlock != nil {
+		if err := l.dlock.release(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release directory lock: %w", err))
+		}
+	}
+
+	l.segments[len(l.segments)-1].cbuf = nil
+	l.segments[len(l.segments)-1].cpos = nil
+	l.clearCache()
+	l.files.closeAll()
+
+	if l.corrupt.Load() {
+		errs = append(errs, ErrCorrupt)
+	}
+
+	return errors.Join(errs...)
 }
 
-func segmentName(index uint64) string {
-	return fmt.Sprintf("%020d", index)
+// Opened reports whether the log is open, that is Close has not been called.
+func (l *Log) Opened() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return !l.closed
 }
 
-// loadSegmentEntries reads entries from the specified log segment file and populates the segment.
-func (l *Log) loadSegmentEntries(segment *segment) error {
-	data, err := os.ReadFile(segment.path)
-	if err != nil {
-		return fmt.Errorf("failed to read log segment file: %w", err)
+// Damage returns the damage Open found at the end of the tail segment and
+// recovered from according to Config.Recovery, or nil when there was none.
+// With RecoverReadOnly a non-nil result that is not a torn write means the
+// log was opened read-only. With RecoverQuarantine it is the first problem
+// of the first quarantined segment, when any was.
+func (l *Log) Damage() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.damage
+}
+
+// Closed reports whether Close has been called on the log.
+func (l *Log) Closed() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.closed
+}
+
+// FirstIndex returns the index of the first entry in the log. Returns zero
+// when the log has no entries.
+func (l *Log) FirstIndex() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.corrupt.Load() {
+		return 0, ErrCorrupt
+	} else if l.closed {
+		return 0, ErrClosed
 	}
 
-	entryBuffer := data
-	var entryPositions []bytepos
-	var currentPosition int
+	// The firstIndex is always one or more, even when there are no entries,
+	// in which case it is past the lastIndex.
+	if l.lastIndex < l.firstIndex {
+		return 0, nil
+	}
 
-	for len(data) > 0 {
-		var bytesRead int
-		bytesRead, err = l.loadNextBinaryEntry(data)
-		if err != nil {
-			return fmt.Errorf("failed to load binary entry from log segment: %w", err)
-		}
+	return l.firstIndex, nil
+}
 
-		data = data[bytesRead:]
-		entryPositions = append(entryPositions, bytepos{currentPosition, currentPosition + bytesRead})
-		currentPosition += bytesRead
+// LastIndex returns the index of the last entry in the log. When the log has
+// no entries it returns one less than the index the next entry must be
+// written at, which is zero for a new log.
+func (l *Log) LastIndex() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.corrupt.Load() {
+		return 0, ErrCorrupt
+	} else if l.closed {
+		return 0, ErrClosed
 	}
 
-	segment.cbuf = entryBuffer
-	segment.cpos = entryPositions
+	return l.lastIndex, nil
+}
+
+// Len returns the number of entries in the log. It is derived from the first
+// and last index, so it is cheap enough to poll. Gaps left by AllowGaps
+// writes are included in the count.
+func (l *Log) Len() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.len()
+}
+
+// len returns the number of entries. The caller must hold the lock.
+func (l *Log) len() uint64 {
+	if l.lastIndex < l.firstIndex {
+		return 0
+	}
+	return l.lastIndex - l.firstIndex + 1
+}
+
+// Write appends an entry to the log. The index must be exactly one greater
+// than LastIndex, otherwise ErrOutOfOrder is returned. When every write is
+// fsynced, concurrent calls are group committed to share a single fsync.
+func (l *Log) Write(index uint64, data []byte) error {
+	if mode := l.config.SyncPolicy.Mode; mode == SyncAlways || mode == SyncDSync {
+		return l.groupWrite(index, data)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.write(index, data)
+}
+
+// write appends a single entry. The caller must hold the write lock.
+func (l *Log) write(index uint64, data []byte) error {
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	l.wbatch.Clear()
+	l.wbatch.Write(index, data)
+
+	return l.writeBatch(&l.wbatch)
+}
+
+// Sync flushes and fsyncs the tail segment. With a relaxed SyncPolicy it
+// establishes a durability point for everything written so far.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.sync()
+}
+
+// sync fsyncs the tail segment. The caller must hold the write lock.
+func (l *Log) sync() error {
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return l.syncTail()
+}
+
+// flushTail writes the entries buffered under WriteBufferSize to the tail
+// segment file. A failed write is cut off by seeking back to the end of the
+// data written before, the next flush writes all of them again.
+func (l *Log) flushTail() error {
+	tail := l.segments[len(l.segments)-1]
+	if l.written >= len(tail.cbuf) {
+		return nil
+	}
+
+	if err := l.writeTail(tail.cbuf[l.written:]); err != nil {
+		err = fmt.Errorf("failed to write to tail segment: %w", err)
+		if _, serr := l.sfile.Seek(int64(l.written), io.SeekStart); serr != nil {
+			return l.markCorrupt(fmt.Errorf("failed to roll back tail segment: %v: %w", serr, err))
+		}
+		return err
+	}
+	l.written = len(tail.cbuf)
+
 	return nil
 }
 
-// loadNextBinaryEntry reads the size of the next binary entry and returns the number of bytes read.
-func (l *Log) loadNextBinaryEntry(data []byte) (int, error) {
-	// data_size + data
-	size, bytesRead := binary.Uvarint(data)
-	if bytesRead <= 0 {
-		return 0, ErrCorrupt
+// syncTail fsyncs the tail segment file and resets the sync policy state.
+// Only the data needs to reach the disk, so fdatasync is used where
+// available.
+func (l *Log) syncTail() error {
+	if err := l.flushTail(); err != nil {
+		return err
 	}
-	if uint64(len(data)-bytesRead) < size {
-		return 0, ErrCorrupt
+
+	if err := l.retry("sync", l.datasyncTail); err != nil {
+		return fmt.Errorf("failed to sync tail segment: %w", err)
 	}
-	return bytesRead + int(size), nil
+
+	l.lastSync = time.Now()
+	l.unsynced = 0
+	l.setDurable(l.lastIndex)
+
+	return nil
+}
+
+// maybeSync fsyncs the tail segment after a write of n bytes when the sync
+// policy calls for it.
+func (l *Log) maybeSync(n int) error {
+	l.unsynced += n
+
+	policy := l.config.SyncPolicy
+	switch policy.Mode {
+	case SyncAlways:
+		return l.syncTail()
+	case SyncDSync:
+		// The write itself was synchronous
+		if err := l.flushTail(); err != nil {
+			return err
+		}
+		l.unsynced = 0
+		l.setDurable(l.lastIndex)
+	case SyncInterval:
+		if (policy.Bytes > 0 && l.unsynced >= policy.Bytes) ||
+			(policy.Interval > 0 && time.Since(l.lastSync) >= policy.Interval) {
+			return l.syncTailAsync()
+		}
+	}
+
+	return nil
+}
+
+// WriteBatch writes the entries in the batch to the log in the order that
+// they were added to the batch, using a single lock acquisition and a single
+// fsync. The batch is atomic: after a crash either all of its entries are
+// recovered or none of them. The batch is cleared upon a successful return.
+func (l *Log) WriteBatch(b *Batch) error {
+	return l.WriteBatchWithOptions(b, WriteOptions{})
+}
+
+// WriteOptions override the log's sync behavior for a single write.
+type WriteOptions struct {
+	// Sync fsyncs the write before returning even when the SyncPolicy
+	// would not, for example to make a transaction commit durable.
+	Sync bool
+}
+
+// WriteWithOptions is like Write but applies the given options.
+func (l *Log) WriteWithOptions(index uint64, data []byte, opts WriteOptions) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.write(index, data); err != nil {
+		return err
+	}
+
+	return l.applyWriteOptions(opts)
+}
+
+// WriteBatchWithOptions is like WriteBatch but applies the given options.
+func (l *Log) WriteBatchWithOptions(b *Batch, opts WriteOptions) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	if err := l.writeBatch(b); err != nil {
+		return err
+	}
+
+	return l.applyWriteOptions(opts)
+}
+
+// applyWriteOptions runs the post write steps requested by opts. The caller
+// must hold the write lock.
+func (l *Log) applyWriteOptions(opts WriteOptions) error {
+	if opts.Sync && l.unsynced > 0 {
+		return l.syncTail()
+	}
+	return nil
+}
+
+// writeBatch appends all entries in the batch to the tail segment with a
+// single write. Every entry but the last carries the entryMore flag, so a
+// batch cut short by a crash is recognized and discarded on Open. To keep
+// that recovery local to the tail, batches never span segments: the tail is
+// only cycled before a batch, which makes SegmentSize a soft limit.
+func (l *Log) writeBatch(b *Batch) error {
+	// Check that all indexes in the batch are sane. Only the first entry may
+	// skip ahead, which prepareTail checks, the rest of the batch must be
+	// contiguous.
+	first := b.entries[0].index
+	for i, entry := range b.entries {
+		if entry.index != first+uint64(i) {
+			return ErrOutOfOrder
+		}
+	}
+
+	tail, err := l.prepareTail(first)
+	if err != nil {
+		return err
+	}
+
+	return l.appendBatch(tail, b)
+}
+
+// appendBatch appends the entries in the batch to the tail prepared for
+// them by prepareTail, transforming them as configured, and commits them.
+// The caller must hold the write lock.
+func (l *Log) appendBatch(tail *segment, b *Batch) error {
+	var keys *keyring
+	if tail.encrypted() {
+		if keys = l.keys; keys == nil {
+			return ErrNoKeyProvider
+		}
+	}
+
+	format := tail.format()
+	mark, cposMark := len(tail.cbuf), len(tail.cpos)
+	l.growTail(tail, len(b.datas)+len(b.entries)*(maxHeaderSize+tail.sum.Size))
+	datas := b.datas
+	dict := l.dict.Load()
+	scratch := getEntryScratch()
+	defer putEntryScratch(scratch)
+	for i, entry := range b.entries {
+		var flags byte
+		if i < len(b.entries)-1 {
+			flags = entryMore
+		}
+
+		data := datas[:entry.size]
+		if l.config.Compression == CompressionZstdDict && dict == nil {
+			l.sampleEntry(data)
+		}
+		if len(data) >= l.config.CompressionThreshold {
+			compressed, ok := l.config.Compression.appendCompressed(scratch.compressed[:0], data, dict)
+			if ok {
+				data = compressed
+				flags |= entryCompressed
+			}
+			scratch.compressed = compressed[:0]
+		}
+
+		var err error
+		if l.config.Codec != nil {
+			if data, err = l.config.Codec.Encode(data); err != nil {
+				err = fmt.Errorf("failed to encode entry %d: %w", entry.index, err)
+			}
+		}
+		if err == nil && keys != nil {
+			scratch.aad = appendSegmentAAD(scratch.aad[:0], tail.flags(), tail.index, entry.index)
+			if data, err = keys.appendEncrypted(scratch.sealed[:0], data, scratch.aad); err != nil {
+				err = fmt.Errorf("failed to encrypt entry %d: %w", entry.index, err)
+			} else {
+				scratch.sealed = data[:0]
+			}
+		}
+		if err == nil && !format.fits(len(data)) {
+			err = fmt.Errorf("%w: entry %d takes %d bytes", ErrEntryTooLarge, entry.index, len(data))
+		}
+		if err != nil {
+			// Nothing was written yet
+			tail.cbuf = tail.cbuf[:mark]
+			tail.cpos = tail.cpos[:cposMark]
+			return err
+		}
+
+		start := len(tail.cbuf)
+		tail.cbuf = format.appendEntry(tail.cbuf, data, flags, tail.sum)
+		tail.cpos = append(tail.cpos, bytepos{start, len(tail.cbuf)})
+		datas = datas[entry.size:]
+	}
+
+	if err := l.commitTail(tail, mark, cposMark, b.entries[len(b.entries)-1].index); err != nil {
+		return err
+	}
+
+	if l.config.Compression == CompressionZstdDict && dict == nil {
+		l.maybeTrainDictionary()
+	}
+	b.Clear()
+	return nil
+}
+
+// prepareTail readies the tail for entries starting at first, which must
+// follow the last entry of the log, or skip ahead of it with AllowGaps. The
+// tail is cycled when it is full or expired, or the entries leave a gap. The
+// caller must hold the write lock.
+func (l *Log) prepareTail(first uint64) (*segment, error) {
+	if first != l.lastIndex+1 && (!l.config.AllowGaps || first <= l.lastIndex) {
+		return nil, ErrOutOfOrder
+	}
+
+	if first != l.lastIndex+1 && l.len() == 0 {
+		// An empty log simply restarts at the first index of the batch
+		l.withdrawView()
+		err := l.reset(first)
+		l.publishView()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tail := l.segments[len(l.segments)-1]
+	if len(tail.cbuf) >= int(l.segmentSize.Load()) || l.tailExpired(tail) || first != l.lastIndex+1 {
+		// Tail segment has reached capacity or its age, or the batch leaves
+		// a gap, start a new segment at the first entry of the batch
+		l.withdrawView()
+		err := l.cycle(first)
+		l.publishView()
+		if err != nil {
+			return nil, err
+		}
+		tail = l.segments[len(l.segments)-1]
+	}
+
+	return tail, nil
+}
+
+// commitTail makes the entries appended to the tail past mark, and its
+// positions past cposMark, part of the log up to the given last index. They
+// are written to the file unless WriteBufferSize keeps them buffered, and
+// synced as the sync policy calls for. On error they are rolled back. The
+// caller must hold the write lock.
+func (l *Log) commitTail(tail *segment, mark, cposMark int, last uint64) error {
+	prevLastIndex := l.lastIndex
+	if len(tail.cbuf)-l.written >= l.config.WriteBufferSize {
+		if err := l.flushTail(); err != nil {
+			return l.rollbackTail(tail, mark, cposMark, prevLastIndex, err)
+		}
+	}
+	l.lastIndex = last
+
+	if err := l.maybeSync(len(tail.cbuf) - mark); err != nil {
+		return l.rollbackTail(tail, mark, cposMark, prevLastIndex, err)
+	}
+
+	l.maybePrecreate(tail)
+	return nil
+}
+
+// rollbackTail discards the entries appended to the tail after a failed
+// write, restoring both the cache and the file so the write can be retried.
+// The log is only flagged corrupt when the file cannot be restored.
+func (l *Log) rollbackTail(tail *segment, mark, cposMark int, lastIndex uint64, cause error) error {
+	tail.cbuf = tail.cbuf[:mark]
+	tail.cpos = tail.cpos[:cposMark]
+	l.lastIndex = lastIndex
+
+	// Entries buffered before the failed write are kept, only the ones
+	// written to the file past the mark are cut off
+	l.written = min(l.written, mark)
+	if err := l.sfile.Truncate(int64(l.written)); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to roll back tail segment: %v: %w", err, cause))
+	}
+
+	if _, err := l.sfile.Seek(int64(l.written), io.SeekStart); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to roll back tail segment: %v: %w", err, cause))
+	}
+
+	return noSpaceError(cause)
+}
+
+// noSpaceError wraps err with ErrNoSpace when it was caused by a full disk.
+func noSpaceError(err error) error {
+	if isNoSpace(err) {
+		return fmt.Errorf("%w: %w", ErrNoSpace, err)
+	}
+	return err
+}
+
+// cycle closes the current tail segment and starts a new one beginning at
+// the given index. The caller must have withdrawn the segment view, see
+// lockSegments.
+func (l *Log) cycle(index uint64) error {
+	current := l.segments[len(l.segments)-1]
+	tail := &segment{
+		index: index,
+		path:  filepath.Join(l.path, l.segmentName(index)),
+	}
+
+	// Create the new tail before sealing the current one, so a failure
+	// leaves the log writable
+	file, header := l.takeSpare(tail.path)
+	if file == nil {
+		var err error
+		file, header, err = l.createSegmentFile(tail.path)
+		if err != nil {
+			return noSpaceError(fmt.Errorf("failed to create log segment file: %w", err))
+		}
+	}
+	tail.cbuf = header
+	tail.hdr = len(header)
+	tail.sum = l.config.Checksum
+
+	abandon := func(err error) error {
+		file.Close()
+		os.Remove(tail.path)
+		return err
+	}
+
+	// Index the entries of the segment being sealed, the footer is written
+	// in one go with the entries still buffered and synced along with them
+	mark, cposMark := len(current.cbuf), len(current.cpos)
+	footer := appendSegmentFooter(nil, current.cbuf, current.index, current.cpos, current.sum)
+	if err := l.writeTail(current.cbuf[l.written:], footer); err != nil {
+		err = fmt.Errorf("failed to write segment footer: %w", err)
+		return abandon(l.rollbackTail(current, mark, cposMark, l.lastIndex, err))
+	}
+	l.written = mark
+
+	if l.alignsWrites(current) {
+		// The footer has to end the file to be found, cut off the padding
+		if err := l.sfile.Truncate(int64(mark + len(footer))); err != nil {
+			err = fmt.Errorf("failed to write segment footer: %w", err)
+			return abandon(l.rollbackTail(current, mark, cposMark, l.lastIndex, err))
+		}
+	}
+
+	if err := l.syncTail(); err != nil {
+		return abandon(l.rollbackTail(current, mark, cposMark, l.lastIndex, err))
+	}
+
+	// The new tail only becomes part of the log once the manifest lists it
+	current.last = l.lastIndex
+	current.seal = time.Now()
+	current.size = int64(mark + len(footer))
+	segments := append(l.segments[:len(l.segments):len(l.segments)], tail)
+	if err := l.writeManifest(segments, l.firstIndex, 0); err != nil {
+		current.last = 0
+		current.seal = time.Time{}
+		current.size = 0
+		return abandon(l.rollbackTail(current, mark, cposMark, l.lastIndex, err))
+	}
+
+	if l.config.Preallocate {
+		// Release the space allocated past the end of the sealed segment
+		l.sfile.Truncate(int64(mark + len(footer)))
+	}
+	closeErr := l.sfile.Close()
+	l.protectSegmentFile(current.path)
+
+	// The sealed segment is no longer cached, it will be loaded on demand
+	sealed, positions := current.cbuf[:mark], current.cpos
+	current.cbuf = nil
+	current.cpos = nil
+
+	l.adaptSegmentSize(mark-l.tailBase, time.Since(l.tailStarted))
+	l.sfile = file
+	l.tailStarted = time.Now()
+	l.tailBase = 0
+	l.written = len(header)
+	l.segments = segments
+
+	if closeErr != nil {
+		return fmt.Errorf("failed to close sealed segment: %w", closeErr)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return err
+	}
+
+	if l.config.SegmentCompression != CompressionNone && l.config.SegmentCompressionDelay <= 0 {
+		if err := l.compressSealed(current, sealed, footer); err != nil {
+			return err
+		}
+	} else {
+		l.writeSparseIndex(current, positions)
+		l.punchFront(current, positions, l.firstIndex)
+		l.maybeCompressCold()
+	}
+
+	l.enforceRetention()
+	return nil
+}
+
+// lockSegments acquires the write lock and withdraws the segment view,
+// which changes to the segment list, the first index or the files of sealed
+// segments are made under. Writes to the tail only take the write lock.
+func (l *Log) lockSegments() {
+	l.mu.Lock()
+	l.withdrawView()
+}
+
+// unlockSegments publishes the segment view again and releases the write
+// lock.
+func (l *Log) unlockSegments() {
+	l.publishView()
+	l.mu.Unlock()
+}
+
+// rlockEntry makes the entry at the given index safe to read. Entries of
+// sealed segments are read through the published segment view without any
+// lock, so reading them never waits for writes and fsyncs of the tail, it
+// is returned and keeps the segments as they are until released.
+// Everything else takes the read lock and gets nil. Release either with
+// runlockEntry.
+func (l *Log) rlockEntry(index uint64) *segmentView {
+	if v := l.acquireView(); v != nil {
+		if v.sealed(index) {
+			return v
+		}
+		v.release()
+	}
+
+	l.mu.RLock()
+	return nil
+}
+
+// runlockEntry releases what rlockEntry acquired.
+func (l *Log) runlockEntry(v *segmentView) {
+	if v != nil {
+		v.release()
+	} else {
+		l.mu.RUnlock()
+	}
+}
+
+// Read returns a copy of the entry at the given index. Returns ErrNotFound
+// when the index is outside of the log bounds.
+func (l *Log) Read(index uint64) ([]byte, error) {
+	defer l.runlockEntry(l.rlockEntry(index))
+
+	return l.read(index)
+}
+
+// read returns a copy of the entry at the given index. The caller must hold
+// the read lock, or the segment view for entries of sealed segments.
+func (l *Log) read(index uint64) ([]byte, error) {
+	entry, err := l.readNoCopy(index)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, len(entry))
+	copy(data, entry)
+
+	return data, nil
+}
+
+// ReadAt copies the entry at the given index into buf and returns the number
+// of bytes copied. When buf is too small nothing is copied and the entry size
+// is returned along with io.ErrShortBuffer, so the caller can grow buf to at
+// least that size and retry. Reusing buf keeps read loops allocation free.
+func (l *Log) ReadAt(index uint64, buf []byte) (int, error) {
+	defer l.runlockEntry(l.rlockEntry(index))
+
+	entry, err := l.readNoCopy(index)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(buf) < len(entry) {
+		return len(entry), io.ErrShortBuffer
+	}
+
+	return copy(buf, entry), nil
+}
+
+// ReadNoCopy returns the entry at the given index without copying it. The
+// returned slice points into the segment cache: it must not be modified and
+// is only valid until the next call that mutates the log. Use Read when the
+// data needs to outlive that. Compressed entries are decompressed into a
+// new buffer.
+func (l *Log) ReadNoCopy(index uint64) ([]byte, error) {
+	defer l.runlockEntry(l.rlockEntry(index))
+
+	return l.readNoCopy(index)
+}
+
+// readNoCopy returns the cached entry at the given index. The caller must
+// hold the read lock, or the segment view for entries of sealed segments.
+func (l *Log) readNoCopy(index uint64) ([]byte, error) {
+	if l.corrupt.Load() {
+		return nil, ErrCorrupt
+	} else if l.closed {
+		return nil, ErrClosed
+	}
+
+	// The last index moves with writes, it is only looked at for entries
+	// past the sealed segments, which are read under the read lock
+	if index == 0 || index < l.firstIndex || index >= l.segments[len(l.segments)-1].index && index > l.lastIndex {
+		return nil, ErrNotFound
+	}
+
+	if data, ok := l.readSparse(index); ok {
+		return data, nil
+	}
+
+	segment, err := l.loadSegment(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return segment.entryData(index, l.entryDecoder())
+}
+
+// ReadRange returns copies of all entries from lo through hi, inclusive.
+// The lock is held once for the whole range and entries are decoded
+// straight from the cached segment buffers. Returns ErrNotFound when any
+// part of the range is outside of the log bounds.
+func (l *Log) ReadRange(lo, hi uint64) ([][]byte, error) {
+	var datas [][]byte
+	err := l.readRange(lo, hi, func(entry []byte) {
+		if datas == nil {
+			// The range is known to be in the log by now
+			datas = make([][]byte, 0, hi-lo+1)
+		}
+
+		data := make([]byte, len(entry))
+		copy(data, entry)
+		datas = append(datas, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return datas, nil
+}
+
+// ReadRangeInto reads the entries from lo through hi, inclusive, like
+// ReadRange, but into buffers provided by the caller rather than a new one
+// for each entry, for replication senders shipping windows of entries over
+// and over. The data of the entries is appended to arena one after another
+// and a slice of it for each entry to bufs, and both are returned, grown as
+// needed, so they can be passed in again for the next window. On error they
+// are returned as they were passed in.
+func (l *Log) ReadRangeInto(lo, hi uint64, bufs [][]byte, arena []byte) ([][]byte, []byte, error) {
+	bufsMark, arenaMark := len(bufs), len(arena)
+	err := l.readRange(lo, hi, func(entry []byte) {
+		if len(bufs) == bufsMark {
+			bufs = slices.Grow(bufs, int(hi-lo+1))
+		}
+
+		start := len(arena)
+		arena = append(arena, entry...)
+		bufs = append(bufs, arena[start:])
+	})
+	if err != nil {
+		clear(bufs[bufsMark:])
+		return bufs[:bufsMark], arena[:arenaMark], err
+	}
+
+	// Point the slices at the final arena, growing it moved the data
+	off := arenaMark
+	for i, buf := range bufs[bufsMark:] {
+		bufs[bufsMark+i] = arena[off : off+len(buf) : off+len(buf)]
+		off += len(buf)
+	}
+
+	return bufs, arena, nil
+}
+
+// readRange calls fn with the data of every entry from lo through hi, in
+// order, holding the lock once for the whole range. The data must not be
+// retained by fn.
+func (l *Log) readRange(lo, hi uint64, fn func(data []byte)) error {
+	defer l.runlockEntry(l.rlockEntry(hi))
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	}
+
+	if lo == 0 || lo > hi || lo < l.firstIndex || hi >= l.segments[len(l.segments)-1].index && hi > l.lastIndex {
+		return ErrNotFound
+	}
+
+	for index := lo; index <= hi; {
+		segment, err := l.loadSegment(index)
+		if err != nil {
+			return err
+		}
+
+		segmentLast := segment.index + uint64(len(segment.cpos)) - 1
+		if index > segmentLast {
+			// The range crosses a gap
+			return ErrNotFound
+		}
+
+		for ; index <= hi && index <= segmentLast; index++ {
+			entry, err := segment.entryData(index, l.entryDecoder())
+			if err != nil {
+				return err
+			}
+			fn(entry)
+		}
+	}
+
+	return nil
+}
+
+// Replay streams every entry in the log, oldest first, to fn. Segments are
+// loaded one at a time so the whole log is never held in memory, the next
+// one in the background while fn is called for the entries of the current
+// one. The data passed to fn is only valid for the duration of the call and
+// must be copied if retained. Replay stops at the first error returned by fn
+// and returns it.
+func (l *Log) Replay(fn func(index uint64, data []byte) error) error {
+	l.mu.RLock()
+	if l.corrupt.Load() {
+		l.mu.RUnlock()
+		return ErrCorrupt
+	} else if l.closed {
+		l.mu.RUnlock()
+		return ErrClosed
+	}
+	first, last := l.firstIndex, l.lastIndex
+	l.mu.RUnlock()
+
+	var ahead <-chan prefetched
+	for index := first; index <= last; {
+		var segment *segment
+		if ahead != nil {
+			segment = takePrefetched(ahead, index)
+		}
+		if segment == nil {
+			var err error
+			if segment, err = l.snapshotSegment(index, false); err != nil {
+				return err
+			}
+		}
+
+		ahead = nil
+		if next, ok := nextSegmentIndex(segment, first, last, false); ok {
+			ahead = l.prefetchSegment(next, false)
+		}
+
+		if index < segment.index {
+			// Step over a gap
+			index = segment.index
+		}
+
+		segmentLast := segment.index + uint64(len(segment.cpos)) - 1
+		for ; index <= last && index <= segmentLast; index++ {
+			data, err := segment.entryData(index, l.entryDecoder())
+			if err != nil {
+				return err
+			}
+
+			if err := fn(index, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// entryDecoder holds what it takes to undo the transformations applied to
+// entry data on write.
+type entryDecoder struct {
+	codec Codec     // Config.Codec
+	dict  *zstdDict // Dictionary for CompressionZstdDict
+	keys  *keyring  // Keys of encrypted entries
+}
+
+// entryDecoder returns the decoder for the entries of the log.
+func (l *Log) entryDecoder() entryDecoder {
+	return entryDecoder{codec: l.config.Codec, dict: l.dict.Load(), keys: l.keys}
+}
+
+// entryData decodes the entry at the given index from the cached buffer, or
+// the file of a streamed segment, and returns its data, without copying
+// unless it has to be read, decrypted, decoded or decompressed, or comes
+// from a mapped segment.
+func (s *segment) entryData(index uint64, dec entryDecoder) ([]byte, error) {
+	if index < s.index || index-s.index >= uint64(len(s.cpos)) {
+		// The index falls into a gap
+		return nil, ErrNotFound
+	}
+
+	pos := s.cpos[index-s.index]
+	raw, err := s.entryBytes(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decodeEntryData(raw, pos.start, index, dec)
+}
+
+// decodeEntryData decodes the encoded entry raw, found at offset start in
+// the segment file, into the data of the entry at the given index.
+func (s *segment) decodeEntryData(raw []byte, start int, index uint64, dec entryDecoder) ([]byte, error) {
+	data, flags, _, err := s.format().decodeEntry(raw, s.sum)
+	if err == nil && s.encrypted() {
+		if dec.keys == nil {
+			return nil, ErrNoKeyProvider
+		}
+		data, err = dec.keys.decrypt(data, segmentAAD(s.flags(), s.index, index))
+	}
+	if err == nil && dec.codec != nil {
+		if data, err = dec.codec.Decode(data); err != nil {
+			return nil, fmt.Errorf("failed to decode entry %d: %w", index, err)
+		}
+	}
+	if err == nil && flags&entryCompressed != 0 {
+		data, err = decompressEntry(data, dec.dict)
+	} else if err == nil && s.mmap != nil && !s.encrypted() {
+		// Nothing pointing into the mapping may outlive the segment
+		data = bytes.Clone(data)
+	}
+	// The finalizer of the mapping must not unmap it while it is read
+	runtime.KeepAlive(s.mmap)
+	if err != nil {
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			corrupt.Offset += int64(start)
+		}
+		return nil, locateCorruption(err, s.path, index, index)
+	}
+
+	return data, nil
+}
+
+// Stats holds statistics about a log.
+type Stats struct {
+	Entries    uint64 // Number of entries in the log
+	Segments   int    // Number of segment files
+	DiskBytes  int64  // Total size of all segment files
+	TailBytes  int64  // Size of the tail segment file
+	CacheBytes int64  // Memory held by cached segment buffers and positions
+
+	DecompressCacheBytes int64 // Memory held by the decompression cache
+	IndexCacheBytes      int64 // Memory held by cached sparse index sidecars
+
+	CacheHits      uint64 // Reads of sealed segments served by the segment cache
+	CacheMisses    uint64 // Reads of sealed segments that loaded the segment
+	CacheEvictions uint64 // Segments evicted from the segment cache
+}
+
+// Stats returns statistics about the log. It is computed from in-memory
+// segment metadata and does not touch the disk.
+func (l *Log) Stats() (Stats, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.corrupt.Load() {
+		return Stats{}, ErrCorrupt
+	} else if l.closed {
+		return Stats{}, ErrClosed
+	}
+
+	var stats Stats
+	stats.Entries = l.len()
+	stats.Segments = len(l.segments)
+	tail := l.segments[len(l.segments)-1]
+	for _, seg := range l.segments[:len(l.segments)-1] {
+		stats.DiskBytes += seg.size
+	}
+	stats.TailBytes = int64(len(tail.cbuf))
+	stats.DiskBytes += stats.TailBytes
+
+	stats.CacheBytes = tail.cacheSize()
+	l.scache.mu.Lock()
+	stats.CacheBytes += l.scache.size
+	stats.CacheHits = l.scache.hits
+	stats.CacheMisses = l.scache.misses
+	stats.CacheEvictions = l.scache.evictions
+	l.scache.mu.Unlock()
+
+	l.dcache.mu.Lock()
+	stats.DecompressCacheBytes = int64(l.dcache.size)
+	l.dcache.mu.Unlock()
+
+	l.icache.mu.Lock()
+	stats.IndexCacheBytes = l.icache.size
+	l.icache.mu.Unlock()
+
+	return stats, nil
+}
+
+// cacheSize returns the memory held by the cached entries of the segment.
+func (s *segment) cacheSize() int64 {
+	return int64(len(s.cbuf)) + int64(len(s.cpos))*int64(unsafe.Sizeof(bytepos{}))
+}
+
+// findSegment performs a binary search on the segments and returns the
+// position of the segment that contains the given index.
+func (l *Log) findSegment(index uint64) int {
+	i, j := 0, len(l.segments)
+	for i < j {
+		h := i + (j-i)/2
+		if index >= l.segments[h].index {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	return i - 1
+}
+
+// loadSegment returns the segment holding the given index with its entries
+// loaded. The tail segment is always cached, other segments are read from
+// disk on demand and kept in the segment cache.
+func (l *Log) loadSegment(index uint64) (*segment, error) {
+	tail := l.segments[len(l.segments)-1]
+	if index >= tail.index {
+		return tail, nil
+	}
+
+	if cached := l.scache.get(index); cached != nil {
+		return cached, nil
+	}
+
+	found := l.segments[l.findSegment(index)]
+	loaded := &segment{
+		index: found.index,
+		path:  found.path,
+	}
+
+	if err := l.loadSegmentEntries(loaded); err != nil {
+		return nil, err
+	}
+
+	l.scache.put(loaded)
+
+	return loaded, nil
+}
+
+// TruncateFront removes all entries prior to the given index. The index
+// becomes the new FirstIndex. Segments holding only removed entries are
+// deleted, the removed entries sharing a segment with the index take up
+// disk space until a later truncation deletes that segment, unless
+// CompactOnTruncate rewrites it without them. Segments are handed to the
+// configured Archiver before they are deleted, without holding the log lock.
+func (l *Log) TruncateFront(index uint64) error {
+	if l.config.Archiver != nil {
+		l.archiveMu.Lock()
+		defer l.archiveMu.Unlock()
+
+		if err := l.archivePending(l.pendingBefore(index)); err != nil {
+			return err
+		}
+	}
+
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return l.truncateFront(index)
+}
+
+func (l *Log) truncateFront(index uint64) error {
+	if index == 0 || l.lastIndex == 0 || index < l.firstIndex || index > l.lastIndex {
+		return ErrOutOfRange
+	}
+
+	if index == l.firstIndex {
+		// Nothing to truncate
+		return nil
+	}
+
+	segIdx := l.findSegment(index)
+	seg, err := l.loadSegment(index)
+	if err != nil {
+		return err
+	}
+
+	if index-seg.index >= uint64(len(seg.cpos)) {
+		// The index falls into a gap
+		return ErrNotFound
+	}
+
+	// Archive the segments to be removed while they are still listed, if
+	// they were not archived beforehand, an archiver failing leaves the log
+	// as it is
+	if err := l.archiveSegments(l.segments[:segIdx], l.segments[segIdx]); err != nil {
+		return err
+	}
+
+	if l.config.CompactOnTruncate && index > seg.index && l.compactable(seg) {
+		return l.truncateFrontCompact(segIdx, seg, index)
+	}
+
+	// Record the new first index before removing the segments in front of
+	// it. Once the manifest is durable the truncation is complete, segments
+	// left behind by a crash are removed as strays by Open. The entries in
+	// front of the index held by its own segment stay on disk until the
+	// segment itself is removed.
+	if err := l.writeManifest(l.segments[segIdx:], index, 0); err != nil {
+		return noSpaceError(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return err
+	}
+
+	removed := l.segments[:segIdx]
+	l.segments = append([]*segment{}, l.segments[segIdx:]...)
+	l.firstIndex = index
+	l.clearCache()
+
+	for _, seg := range removed {
+		if err := l.discardSegmentFile(seg); err != nil {
+			return fmt.Errorf("failed to remove truncated log segment: %w", err)
+		}
+	}
+
+	if len(l.segments) > 1 {
+		// The tail is punched once it is sealed
+		l.punchFront(l.segments[0], seg.cpos, index)
+	}
+
+	return nil
+}
+
+// TruncateBack removes all entries after the given index. The index
+// becomes the new LastIndex.
+func (l *Log) TruncateBack(index uint64) error {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return l.truncateBack(index)
+}
+
+func (l *Log) truncateBack(index uint64) error {
+	if index == 0 || l.lastIndex == 0 || index < l.firstIndex || index > l.lastIndex {
+		return ErrOutOfRange
+	}
+
+	if index == l.lastIndex {
+		// Nothing to truncate
+		return nil
+	}
+
+	segIdx := l.findSegment(index)
+	seg, err := l.loadSegment(index)
+	if err != nil {
+		return err
+	}
+
+	if index-seg.index >= uint64(len(seg.cpos)) {
+		// The index falls into a gap
+		return ErrNotFound
+	}
+
+	if segIdx == 0 && segIdx < len(l.segments)-1 && seg.index < l.firstIndex && !l.deadPrefixIntact(seg) {
+		// The new tail would be parsed from its start, which the punched
+		// entries in front of the first index would stop
+		if !l.compactable(seg) {
+			return ErrNoKeyProvider
+		}
+		if err := l.truncateFrontCompact(segIdx, seg, l.firstIndex); err != nil {
+			return err
+		}
+		return l.truncateBack(index)
+	}
+
+	return l.truncateBackSegment(segIdx, seg, index)
+}
+
+// truncateBackSegment removes all entries after the given index, which is
+// held by the loaded segment at segIdx.
+func (l *Log) truncateBackSegment(segIdx int, seg *segment, index uint64) error {
+	positions := seg.cpos[:index-seg.index+1]
+	end := positions[len(positions)-1].end
+
+	// The tail file is truncated in place, the entries buffered for it have
+	// to be in the file first
+	if err := l.flushTail(); err != nil {
+		return err
+	}
+
+	// The new last entry may have been written in the middle of a batch,
+	// it has to end it or Open would take it for a torn write. It is
+	// changed in a copy, the loaded segment may be shared with readers.
+	last := positions[len(positions)-1]
+	ended, err := seg.entryBytes(last)
+	if err != nil {
+		return err
+	}
+	if ended = bytes.Clone(ended); !seg.format().endBatch(ended, seg.sum) {
+		ended = nil
+	}
+
+	// Record the truncation in the manifest before touching any segment.
+	// Once it is durable the truncation will be completed by Open if we
+	// crash before it is done.
+	if err := l.writeManifest(l.segments[:segIdx+1], l.firstIndex, index); err != nil {
+		return noSpaceError(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return err
+	}
+
+	// Any errors from here on will not corrupt the data on disk, but leave
+	// the in-memory state inconsistent. Flag the log as corrupt so the user
+	// can recover by calling RecoverCorrupt, or Close followed by Open.
+	if err := l.sfile.Close(); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to close tail segment: %w", err))
+	}
+
+	for i := len(l.segments) - 1; i > segIdx; i-- {
+		if err := l.discardSegmentFile(l.segments[i]); err != nil {
+			return l.markCorrupt(fmt.Errorf("failed to remove truncated log segment: %w", err))
+		}
+	}
+
+	truncated := l.segments[segIdx]
+	if err := l.removeSparseIndex(truncated.path); err != nil {
+		return l.markCorrupt(err)
+	}
+	l.unprotectSegmentFile(truncated.path)
+	data := seg.cbuf[:end]
+	if ended != nil && seg.rfile == nil {
+		data = append(data[:last.start:last.start], ended...)
+	}
+	if segmentFileCompression(truncated.path) != CompressionNone {
+		if err := l.decompressSegment(truncated, data); err != nil {
+			return l.markCorrupt(err)
+		}
+	} else if seg.mmap != nil {
+		// Truncating in place would pull the pages from under the
+		// mappings still reading them
+		err := l.replaceSegmentFile(truncated, data)
+		runtime.KeepAlive(seg.mmap)
+		if err != nil {
+			return l.markCorrupt(err)
+		}
+	} else {
+		// The entry is rewritten before the truncation, so a crash in
+		// between leaves the later entries to finish the batch
+		if ended != nil {
+			if err := writeSegmentEntry(truncated.path, ended, last.start); err != nil {
+				return l.markCorrupt(err)
+			}
+		}
+		if err := os.Truncate(truncated.path, int64(end)); err != nil {
+			return l.markCorrupt(fmt.Errorf("failed to truncate log segment: %w", err))
+		}
+	}
+
+	if err := l.openLastSegment(truncated, RecoverTorn); err != nil {
+		return l.markCorrupt(err)
+	}
+
+	if err := datasync(l.sfile); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to sync truncated log segment: %w", err))
+	}
+
+	truncated.last = 0
+	segments := append([]*segment{}, l.segments[:segIdx+1]...)
+	if err := l.writeManifest(segments, l.firstIndex, 0); err != nil {
+		return l.markCorrupt(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return l.markCorrupt(err)
+	}
+
+	l.segments = segments
+	l.lastIndex = index
+	l.lowerDurable(index)
+	l.clearCache()
+
+	if truncated.rfile != nil {
+		// The segment is too large to append to in memory
+		if err := l.sealStreamedTail(); err != nil {
+			return l.markCorrupt(err)
+		}
+	}
+
+	return nil
+}
+
+// writeSegmentEntry overwrites the encoded entry at the given offset of a
+// segment file with one of the same size, and syncs it.
+func writeSegmentEntry(path string, entry []byte, offset int) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open log segment file: %w", err)
+	}
+
+	if _, err := file.WriteAt(entry, int64(offset)); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write log segment entry: %w", err)
+	}
+
+	if err := datasync(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync log segment entry: %w", err)
+	}
+
+	return file.Close()
+}
+
+// Reset deletes all entries and starts a fresh, empty log whose next entry
+// will be written at firstIndex. It is useful after installing a snapshot
+// that makes the existing log content obsolete.
+func (l *Log) Reset(firstIndex uint64) error {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	} else if l.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return l.reset(firstIndex)
+}
+
+func (l *Log) reset(firstIndex uint64) error {
+	if firstIndex == 0 {
+		return ErrOutOfRange
+	}
+
+	// Record the new first index with no segments before removing them.
+	// Once the manifest is durable the reset will be completed by Open if
+	// we crash before it is done.
+	if err := l.writeManifest(nil, firstIndex, 0); err != nil {
+		return noSpaceError(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return err
+	}
+
+	// Any errors from here on will not corrupt the data on disk, but leave
+	// the in-memory state inconsistent. Flag the log as corrupt so the user
+	// can recover by calling RecoverCorrupt, or Close followed by Open.
+	if err := l.sfile.Close(); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to close tail segment: %w", err))
+	}
+
+	for _, seg := range l.segments {
+		if err := l.discardSegmentFile(seg); err != nil {
+			return l.markCorrupt(fmt.Errorf("failed to remove reset log segment: %w", err))
+		}
+	}
+
+	tail := &segment{
+		index: firstIndex,
+		path:  filepath.Join(l.path, l.segmentName(firstIndex)),
+	}
+	file, header, err := l.createSegmentFile(tail.path)
+	if err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to create log segment file: %w", err))
+	}
+	l.sfile = file
+	l.tailStarted = time.Now()
+	l.tailBase = 0
+	l.written = len(header)
+	tail.cbuf = header
+	tail.hdr = len(header)
+	tail.sum = l.config.Checksum
+
+	segments := []*segment{tail}
+	if err := l.writeManifest(segments, firstIndex, 0); err != nil {
+		return l.markCorrupt(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return l.markCorrupt(err)
+	}
+
+	l.segments = segments
+	l.firstIndex = firstIndex
+	l.lastIndex = firstIndex - 1
+	l.lowerDurable(l.lastIndex)
+	l.setDurable(l.lastIndex)
+	l.clearCache()
+
+	return nil
+}
+
+// markCorrupt flags the log as corrupt and wraps err with ErrCorrupt.
+func (l *Log) markCorrupt(err error) error {
+	l.corrupt.Store(true)
+	return fmt.Errorf("%w: %v", ErrCorrupt, err)
+}
+
+// clearCache drops the cached non-tail segments.
+func (l *Log) clearCache() {
+	l.scache.clear()
+	l.dcache.clear()
+	l.icache.clear()
+}
+
+// syncDir fsyncs the log directory so segment creations, renames and
+// removals survive a power loss.
+func (l *Log) syncDir() error {
+	if err := syncDir(l.path); err != nil {
+		return fmt.Errorf("failed to sync log directory: %w", err)
+	}
+	return nil
+}
+
+// writeFileSync writes data to the named file and fsyncs it before closing.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Write adds an entry to the batch. The data is copied, so the caller may
+// reuse it after Write returns.
+func (b *Batch) Write(index uint64, data []byte) {
+	b.entries = append(b.entries, batchEntry{index, len(data)})
+	b.datas = append(b.datas, data...)
+}
+
+// Clear resets the batch so it can be reused.
+func (b *Batch) Clear() {
+	b.entries = b.entries[:0]
+	b.datas = b.datas[:0]
+}
+
+// loadSegments loads existing log segments from the log directory.
+func (l *Log) loadSegments() error {
+	m, err := l.readManifest()
+	if err != nil {
+		return err
+	}
+
+	firstIndex := uint64(1)
+	var resized bool
+	if m != nil {
+		if err := l.applyManifest(m); err != nil {
+			return err
+		}
+		if resized, err = l.checkSegmentSizes(m); err != nil {
+			return err
+		}
+		firstIndex = m.first
+	} else {
+		// Logs written before the manifest was introduced are loaded from
+		// the directory listing, and get a manifest once opened
+		if err := l.scanSegments(); err != nil {
+			return err
+		}
+		if len(l.segments) > 0 {
+			firstIndex = l.segments[0].index
+		}
+	}
+
+	var quarantined bool
+	if l.config.Recovery == RecoverQuarantine && len(l.segments) > 0 {
+		if quarantined, err = l.quarantineSegments(); err != nil {
+			return err
+		}
+		if len(l.segments) > 0 {
+			firstIndex = max(firstIndex, l.segments[0].index)
+		}
+	}
+
+	if len(l.segments) == 0 && l.config.ReadOnly {
+		// Present an empty log without touching the directory
+		l.segments = append(l.segments, &segment{
+			index: firstIndex,
+			path:  filepath.Join(l.path, l.segmentName(firstIndex)),
+			sum:   l.config.Checksum,
+		})
+	} else if len(l.segments) == 0 {
+		// Create a new log in this case
+		if err := l.createInitialSegment(firstIndex); err != nil {
+			return fmt.Errorf("failed to create initial log segment: %w", err)
+		}
+	} else {
+		// Open the last segment for appending
+		lastSegment := l.segments[len(l.segments)-1]
+		lastSegment.last = 0
+		if err := l.openLastSegment(lastSegment, l.config.Recovery); err != nil {
+			return fmt.Errorf("failed to open last log segment: %w", err)
+		}
+
+		if l.config.Recovery == RecoverStrict {
+			if err := l.verifySealed(); err != nil {
+				return err
+			}
+		}
+	}
+
+	l.firstIndex = firstIndex
+	lastSegment := l.segments[len(l.segments)-1]
+	l.lastIndex = lastSegment.index + uint64(len(lastSegment.cpos)) - 1
+
+	if m != nil && m.truncate != 0 && m.truncate < l.lastIndex && m.truncate >= lastSegment.index {
+		// A TruncateBack was interrupted before the tail was truncated
+		if err := l.finishTruncateTail(m.truncate); err != nil {
+			return err
+		}
+	}
+
+	if m == nil || m.truncate != 0 || len(m.segments) == 0 || quarantined || resized {
+		if l.config.ReadOnly {
+			return nil
+		}
+		if err := l.writeManifest(l.segments, l.firstIndex, 0); err != nil {
+			return err
+		}
+		return l.syncDir()
+	}
+
+	return nil
+}
+
+// finishTruncateTail removes the entries of the tail segment after the
+// given index.
+func (l *Log) finishTruncateTail(index uint64) error {
+	tail := l.segments[len(l.segments)-1]
+	tail.cpos = tail.cpos[:index-tail.index+1]
+	end := tail.cpos[len(tail.cpos)-1].end
+	if tail.rfile == nil {
+		tail.cbuf = tail.cbuf[:end]
+	}
+	l.lastIndex = index
+	l.written = min(l.written, end)
+
+	if l.config.ReadOnly {
+		return nil
+	}
+
+	// The new last entry may have been written in the middle of a batch,
+	// it has to end it or Open would take it for a torn write next time
+	pos := tail.cpos[len(tail.cpos)-1]
+	entry, err := tail.entryBytes(pos)
+	if err != nil {
+		return err
+	}
+	if tail.format().endBatch(entry, tail.sum) {
+		if _, err := l.sfile.WriteAt(entry, int64(pos.start)); err != nil {
+			return fmt.Errorf("failed to write last log segment entry: %w", err)
+		}
+	}
+
+	if err := l.sfile.Truncate(int64(end)); err != nil {
+		return fmt.Errorf("failed to truncate last log segment: %w", err)
+	}
+
+	if err := datasync(l.sfile); err != nil {
+		return fmt.Errorf("failed to sync last log segment: %w", err)
+	}
+
+	if _, err := l.sfile.Seek(int64(end), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in last log segment file: %w", err)
+	}
+
+	return nil
+}
+
+// scanSegments loads the log segments found in the log directory, for logs
+// that do not have a manifest.
+func (l *Log) scanSegments() error {
+	files, err := os.ReadDir(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	var found []os.DirEntry
+	startIdx, endIdx, resetIdx := -1, -1, -1
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			continue
+		}
+
+		index, kind, ok := l.parseSegmentName(name)
+		if !ok {
+			continue
+		}
+
+		isStart := kind == ".START"
+		isEnd := kind == ".END"
+		isReset := kind == ".RESET"
+		// Compressed segments only appear without a manifest when they are
+		// restored from an archive
+		if isSegmentKind(kind) || isStart || isEnd || isReset {
+			if isStart && startIdx == -1 {
+				startIdx = len(l.segments)
+			} else if isEnd && endIdx == -1 {
+				endIdx = len(l.segments)
+			} else if isReset && resetIdx == -1 {
+				resetIdx = len(l.segments)
+			}
+			l.segments = append(l.segments, &segment{
+				index: index,
+				path:  filepath.Join(l.path, name),
+			})
+			found = append(found, file)
+		}
+	}
+
+	err = l.forEachSegment(len(found), func(i int) error {
+		info, err := found[i].Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat log segment: %w", err)
+		}
+		l.segments[i].size = info.Size()
+		l.segments[i].seal = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if startIdx != -1 && endIdx != -1 {
+		// Only one truncation can be in flight at any time
+		return fmt.Errorf("found both START and END log segments: %w", ErrCorrupt)
+	}
+
+	// Interrupted truncations are completed on disk, unless the log is read
+	// only in which case they are only applied to the in-memory view.
+	if resetIdx != -1 {
+		// A Reset was interrupted after the RESET file was written, it
+		// supersedes every other segment.
+		if err := l.finishReset(resetIdx); err != nil {
+			return err
+		}
+	} else if startIdx != -1 {
+		// A TruncateFront was interrupted after the START file was written,
+		// finish it by deleting everything before it and renaming it.
+		if err := l.finishTruncateFront(startIdx); err != nil {
+			return err
+		}
+	}
+
+	if endIdx != -1 {
+		// A TruncateBack was interrupted after the END file was written,
+		// finish it by deleting everything after it and renaming it.
+		if err := l.finishTruncateBack(endIdx); err != nil {
+			return err
+		}
+	}
+
+	if (startIdx != -1 || endIdx != -1 || resetIdx != -1) && !l.config.ReadOnly {
+		if err := l.syncDir(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finishTruncateFront removes all segments before the START segment at
+// startIdx and renames the START file to its final segment name.
+func (l *Log) finishTruncateFront(startIdx int) error {
+	if l.config.ReadOnly {
+		l.segments = append([]*segment{}, l.segments[startIdx:]...)
+		return nil
+	}
+
+	for i := 0; i < startIdx; i++ {
+		if err := os.Remove(l.segments[i].path); err != nil {
+			return fmt.Errorf("failed to remove truncated log segment: %w", err)
+		}
+	}
+	l.segments = append([]*segment{}, l.segments[startIdx:]...)
+
+	startPath := l.segments[0].path
+	finalPath := startPath[:len(startPath)-len(".START")]
+	if err := os.Rename(startPath, finalPath); err != nil {
+		return fmt.Errorf("failed to rename START log segment: %w", err)
+	}
+	l.segments[0].path = finalPath
+
+	return nil
+}
+
+// finishTruncateBack removes all segments after the END segment at endIdx
+// and renames the END file to its final segment name.
+func (l *Log) finishTruncateBack(endIdx int) error {
+	for i := len(l.segments) - 1; i > endIdx && !l.config.ReadOnly; i-- {
+		if err := os.Remove(l.segments[i].path); err != nil {
+			return fmt.Errorf("failed to remove truncated log segment: %w", err)
+		}
+	}
+	l.segments = append([]*segment{}, l.segments[:endIdx+1]...)
+
+	if n := len(l.segments); n > 1 && l.segments[n-2].index == l.segments[n-1].index {
+		// The segment prior to the END segment shares its starting index and
+		// is replaced by the rename below.
+		l.segments[n-2] = l.segments[n-1]
+		l.segments = l.segments[:n-1]
+	}
+
+	endSegment := l.segments[len(l.segments)-1]
+	if l.config.ReadOnly {
+		return nil
+	}
+
+	finalPath := endSegment.path[:len(endSegment.path)-len(".END")]
+	if err := os.Rename(endSegment.path, finalPath); err != nil {
+		return fmt.Errorf("failed to rename END log segment: %w", err)
+	}
+	endSegment.path = finalPath
+
+	return nil
+}
+
+// finishReset removes all segments other than the RESET segment at
+// resetIdx and renames the RESET file to its final segment name.
+func (l *Log) finishReset(resetIdx int) error {
+	resetSegment := l.segments[resetIdx]
+	if !l.config.ReadOnly {
+		for i, seg := range l.segments {
+			if i == resetIdx {
+				continue
+			}
+			if err := os.Remove(seg.path); err != nil {
+				return fmt.Errorf("failed to remove reset log segment: %w", err)
+			}
+		}
+
+		finalPath := resetSegment.path[:len(resetSegment.path)-len(".RESET")]
+		if err := os.Rename(resetSegment.path, finalPath); err != nil {
+			return fmt.Errorf("failed to rename RESET log segment: %w", err)
+		}
+		resetSegment.path = finalPath
+	}
+
+	l.segments = []*segment{resetSegment}
+
+	return nil
+}
+
+func (l *Log) createInitialSegment(index uint64) error {
+	initialSegment := &segment{
+		index: index,
+		path:  filepath.Join(l.path, l.segmentName(index)),
+	}
+
+	l.segments = append(l.segments, initialSegment)
+
+	file, header, err := l.createSegmentFile(initialSegment.path)
+	if err != nil {
+		return fmt.Errorf("failed to create initial log segment file: %w", err)
+	}
+
+	l.sfile = file
+	l.tailStarted = time.Now()
+	l.tailBase = 0
+	l.written = len(header)
+	initialSegment.cbuf = header
+	initialSegment.hdr = len(header)
+	initialSegment.sum = l.config.Checksum
+
+	return l.syncDir()
+}
+
+// openTailFile opens a segment file for use as the tail, adding the
+// synchronous write flag when the sync policy asks for it.
+func (l *Log) openTailFile(path string, flag int) (*os.File, error) {
+	if l.config.SyncPolicy.Mode == SyncDSync {
+		flag |= dsyncFlag
+	}
+	return os.OpenFile(path, flag, l.config.FilePerms)
+}
+
+// openLastSegment opens the last log segment for appending. Read-only logs
+// only load its entries. A partially written final entry, left behind by a
+// crash in the middle of a write, is discarded and truncated away. So is a
+// partially written header, which is then written again. Other damage is
+// dealt with according to the recovery mode.
+func (l *Log) openLastSegment(lastSegment *segment, mode RecoveryMode) error {
+	stream, size, err := l.openStreamed(lastSegment.path)
+	if err != nil {
+		return err
+	}
+	var kept bool
+	defer func() {
+		if stream != nil && !kept {
+			stream.Close()
+		}
+	}()
+
+	var data []byte
+	if stream != nil {
+		// Only the header is read, the entries are indexed from the file
+		data = make([]byte, min(size, segmentHeaderSize))
+		if _, err := stream.ReadAt(data, 0); err != nil {
+			return fmt.Errorf("failed to read last log segment file: %w", err)
+		}
+	} else if data, err = readSegmentFile(lastSegment.path); err != nil {
+		return fmt.Errorf("failed to read last log segment file: %w", err)
+	} else {
+		size = int64(len(data))
+	}
+
+	if segmentFileCompression(lastSegment.path) != CompressionNone && !l.config.ReadOnly {
+		// The tail is always appended to raw
+		if err := l.decompressSegment(lastSegment, data); err != nil {
+			return err
+		}
+	}
+
+	header, err := l.readSegmentHeader(data)
+	tornHeader := errors.Is(err, errTornHeader)
+	if err != nil && !tornHeader {
+		return fmt.Errorf("failed to read last log segment %s: %w", lastSegment.path, err)
+	} else if tornHeader {
+		if err := l.recoverTail(mode, locateCorruption(err, lastSegment.path, 0, 0)); err != nil {
+			return err
+		}
+	}
+	sum, hdr := header.sum, header.size
+
+	var positions []bytepos
+	var valid int
+	var torn bool
+	rewrite := tornHeader || len(data) == 0
+	if rewrite {
+		// The segment was created but its header never fully made it to
+		// disk, start it over with a header for the configured algorithm
+		sum = l.config.Checksum
+		data = nil
+		if !l.config.ReadOnly {
+			data = appendSegmentHeader(nil, l.config.EntryFormat, sum, l.newSegmentFlags())
+		}
+		hdr = len(data)
+		valid = len(data)
+	} else {
+		if stream != nil {
+			positions, valid, err = parseSegmentStream(stream, hdr, size, header.format(), sum)
+		} else {
+			positions, valid, err = parseSegmentEntries(data, hdr, sum)
+		}
+		var corrupt *CorruptError
+		if err != nil && !errors.As(err, &corrupt) {
+			return err
+		} else if err != nil {
+			err = locateCorruption(err, lastSegment.path, lastSegment.index+uint64(len(positions)), 0)
+			if err := l.recoverTail(mode, err); err != nil {
+				return err
+			}
+			torn = true
+		}
+	}
+
+	if stream != nil && len(positions) > 0 {
+		// Only the header is cached, Open seals the segment unless the
+		// log is read-only
+		lastSegment.cbuf = data[:hdr]
+		lastSegment.rfile = l.files.wrap(stream)
+		kept = true
+	} else {
+		lastSegment.cbuf = data[:valid]
+	}
+	lastSegment.cpos = positions
+	lastSegment.sum = sum
+	lastSegment.hdr = hdr
+	l.written = valid
+
+	if l.config.ReadOnly {
+		return nil
+	}
+
+	// A sealed segment becoming the tail again may have been protected
+	l.unprotectSegmentFile(lastSegment.path)
+	file, err := l.openTailFile(lastSegment.path, os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("failed to open last log segment file: %w", err)
+	}
+
+	l.sfile = file
+	l.tailStarted = time.Now()
+	l.tailBase = valid
+
+	// A footer is left behind when we crashed after sealing the segment,
+	// but before creating the next one. Drop it so appends can resume.
+	if torn || rewrite || int64(valid) < size {
+		if err := l.sfile.Truncate(int64(valid)); err != nil {
+			return fmt.Errorf("failed to truncate torn write in last log segment: %w", err)
+		}
+
+		if rewrite {
+			if _, err := l.sfile.WriteAt(data, 0); err != nil {
+				return fmt.Errorf("failed to write last log segment header: %w", err)
+			}
+		}
+
+		if err := datasync(l.sfile); err != nil {
+			return fmt.Errorf("failed to sync last log segment: %w", err)
+		}
+	}
+
+	if l.config.Preallocate {
+		preallocate(l.sfile, l.segmentSize.Load())
+	}
+
+	if _, err := l.sfile.Seek(int64(valid), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in last log segment file: %w", err)
+	}
+
+	return nil
+}
+
+// recoverTail deals with damage found at the end of the tail segment on
+// Open. It returns an error when Open has to fail, otherwise the damage is
+// recorded and the valid prefix of the segment kept, either by truncating it
+// or by switching the log to read-only.
+func (l *Log) recoverTail(mode RecoveryMode, damage error) error {
+	torn := errors.Is(damage, errTornEntry) || errors.Is(damage, errTornHeader)
+
+	switch {
+	case mode == RecoverStrict:
+		return fmt.Errorf("failed to load last log segment entries: %w", damage)
+	case torn || mode == RecoverTruncate:
+		// Truncated by the caller
+	case mode == RecoverQuarantine:
+		// Keep the damaged file around before the caller truncates it
+		var corrupt *CorruptError
+		if errors.As(damage, &corrupt) {
+			problem := Problem{Path: corrupt.Path, Offset: corrupt.Offset, Index: corrupt.FirstIndex, Err: damage}
+			if err := l.quarantineCopy(corrupt.Path, []Problem{problem}); err != nil {
+				return err
+			}
+		}
+	case mode == RecoverReadOnly:
+		l.config.ReadOnly = true
+	default:
+		return fmt.Errorf("failed to load last log segment entries: %w", damage)
+	}
+
+	l.damage = damage
+	return nil
+}
+
+// verifySealed verifies every sealed segment, returning the first problem.
+func (l *Log) verifySealed() error {
+	return l.forEachSegment(len(l.segments)-1, func(i int) error {
+		var report VerifyReport
+		l.verifySegment(&report, l.segments[i], l.segments[i+1].index-1, false)
+		if !report.OK() {
+			return report.Problems[0].Err
+		}
+		return nil
+	})
+}
+
+// loadSegmentEntries reads entries from the specified log segment file and populates the segment.
+func (l *Log) loadSegmentEntries(segment *segment) error {
+	var mapped *mapping
+	if l.config.MmapSegments && segmentFileCompression(segment.path) == CompressionNone {
+		var err error
+		if mapped, err = mapSegmentFile(segment.path); err != nil {
+			return err
+		}
+	}
+
+	if mapped == nil {
+		stream, size, err := l.openStreamed(segment.path)
+		if err != nil {
+			return err
+		} else if stream != nil {
+			if err := l.streamSegmentEntries(segment, stream, size); err != nil {
+				stream.Close()
+				return err
+			}
+			return nil
+		}
+	}
+
+	var data []byte
+	var cached bool
+	if mapped != nil {
+		data = mapped.data
+	} else if data = l.dcache.get(segment.path); data != nil {
+		cached = true
+	} else {
+		var err error
+		if data, err = readSegmentFile(segment.path); err != nil {
+			return fmt.Errorf("failed to read log segment file: %w", err)
+		}
+	}
+
+	header, err := l.readSegmentHeader(data)
+	if err != nil {
+		return fmt.Errorf("failed to load log segment %s: %w", segment.path, err)
+	}
+	sum, hdr := header.sum, header.size
+
+	entryPositions, ok := readSegmentFooter(data, hdr, segment.index, sum)
+	if !ok {
+		entryPositions, _, err = parseSegmentEntries(data, hdr, sum)
+		if err != nil {
+			err = locateCorruption(err, segment.path, segment.index+uint64(len(entryPositions)), 0)
+			return fmt.Errorf("failed to load log segment entries: %w", err)
+		}
+	}
+
+	if !cached && segmentFileCompression(segment.path) != CompressionNone {
+		l.dcache.put(segment.index, segment.path, data)
+	}
+
+	segment.cbuf = data
+	segment.cpos = entryPositions
+	segment.sum = sum
+	segment.hdr = hdr
+	segment.mmap = mapped
+	return nil
+}
+
+// parseSegmentEntries returns the positions of the committed entries in
+// data along with the length of the committed prefix. Entries of a batch
+// that is missing its final entry are not committed and reported as torn,
+// and so is a final entry failing its checksum, as a crash can leave the
+// last write with its size in place but not all of its data. Parsing starts
+// after the hdr bytes of segment header. On error the entries committed so
+// far are still returned.
+func parseSegmentEntries(data []byte, hdr int, sum *Checksum) ([]bytepos, int, error) {
+	format := segmentFormat(data)
+	var entryPositions []bytepos
+	currentPosition := hdr
+	committed, committedPosition := 0, hdr
+
+	// Zeros decode as empty entries without a checksum, which segments with
+	// a header never hold, so zeros running up to the end of one are
+	// padding, such as left behind by DirectIO, rather than entries
+	padding := len(data)
+	if hdr > 0 {
+		for padding > hdr && data[padding-1] == 0 {
+			padding--
+		}
+	}
+
+	for currentPosition < padding {
+		bytesRead, flags, err := format.loadNextBinaryEntry(data[currentPosition:], sum)
+		if errors.Is(err, errChecksum) && flags&entryFooter != 0 {
+			// A damaged footer only loses the index, the entries are intact
+			break
+		}
+		if err != nil {
+			corrupt := err.(*CorruptError)
+			corrupt.Offset += int64(currentPosition)
+			if errors.Is(err, errChecksum) && currentPosition+bytesRead == len(data) {
+				corrupt.Err = errTornEntry
+			}
+			return entryPositions[:committed], committedPosition, err
+		}
+
+		if flags&entryFooter != 0 {
+			// The segment was sealed, nothing but its footer follows
+			break
+		}
+
+		entryPositions = append(entryPositions, bytepos{currentPosition, currentPosition + bytesRead})
+		currentPosition += bytesRead
+
+		if flags&entryMore == 0 {
+			committed = len(entryPositions)
+			committedPosition = currentPosition
+		}
+	}
+
+	if committed < len(entryPositions) {
+		// The final batch lacks its last entry
+		return entryPositions[:committed], committedPosition, &CorruptError{Offset: int64(committedPosition), Err: errTornEntry}
+	}
+
+	return entryPositions, currentPosition, nil
+}
+
+// loadNextBinaryEntry reads and verifies the next binary entry and returns
+// the number of bytes read along with the entry flags. On a checksum
+// mismatch the size of the entry is still returned.
+func (f EntryFormat) loadNextBinaryEntry(data []byte, sum *Checksum) (int, byte, error) {
+	_, flags, bytesRead, err := f.decodeEntry(data, sum)
+	return bytesRead, flags, err
+}
+
+// decodeEntry decodes the data_size|flags + data + checksum encoded entry
+// at the start of buf, with the header encoded in the format, verifying its
+// checksum when it has one. It returns the entry data, flags and encoded
+// size. Errors are CorruptErrors with an offset relative to buf. Sizes are
+// checked against the length of buf before use, so hostile input can
+// neither read out of bounds nor cause an allocation.
+func (f EntryFormat) decodeEntry(buf []byte, sum *Checksum) ([]byte, byte, int, error) {
+	header, n := f.decodeHeader(buf)
+	if n < 0 {
+		return nil, 0, 0, &CorruptError{Err: errBadHeader}
+	}
+
+	size := header >> entryFlagBits
+	flags := byte(header & entryFlagMask)
+	if flags&^entryFlagsKnown != 0 {
+		return nil, 0, 0, &CorruptError{
+			Err:      errUnknownFlags,
+			Expected: fmt.Sprintf("flags within %#x", entryFlagsKnown),
+			Found:    fmt.Sprintf("%#x", flags),
+		}
+	}
+
+	var sumSize uint64
+	if flags&entryChecksum != 0 {
+		sumSize = uint64(sum.Size)
+	}
+
+	if n == 0 || uint64(len(buf)-n) < size+sumSize {
+		// The entry runs past the end of the data
+		return nil, 0, 0, &CorruptError{Err: errTornEntry}
+	}
+
+	end := n + int(size)
+	if sumSize > 0 {
+		stored := sum.readSum(buf[end:])
+		if computed := sum.mask(sum.Sum(buf[:end])); stored != computed {
+			return nil, flags, end + sum.Size, &CorruptError{
+				Err:      errChecksum,
+				Expected: fmt.Sprintf("%s %0*x", sum.Name, 2*sum.Size, computed),
+				Found:    fmt.Sprintf("%0*x", 2*sum.Size, stored),
+			}
+		}
+	}
+
+	return buf[n:end], flags, end + int(sumSize), nil
 }
@@ -0,0 +1,338 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTest opens the log at dir and closes it when the test ends.
+func openTest(tb testing.TB, dir string, cfg Config) *Log {
+	tb.Helper()
+	l, err := Open(dir, &cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+	return l
+}
+
+// reopenTest closes the log and opens it again at dir.
+func reopenTest(tb testing.TB, l *Log, dir string, cfg Config) *Log {
+	tb.Helper()
+	if err := l.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return openTest(tb, dir, cfg)
+}
+
+// crashCopy copies the files of the log at dir to a new directory, as a
+// crash would leave them, and returns it. Entries buffered by the log are
+// not in the copy.
+func crashCopy(tb testing.TB, dir string) string {
+	tb.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	crashed := tb.TempDir()
+	for _, file := range files {
+		if file.IsDir() || file.Name() == lockFileName {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(crashed, file.Name()), data, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return crashed
+}
+
+// testEntry returns the data the tests write at index.
+func testEntry(index uint64) []byte {
+	return []byte(fmt.Sprintf("entry %d", index))
+}
+
+// writeEntries writes the test entries first through last one at a time.
+func writeEntries(tb testing.TB, l *Log, first, last uint64) {
+	tb.Helper()
+	for i := first; i <= last; i++ {
+		if err := l.Write(i, testEntry(i)); err != nil {
+			tb.Fatalf("write %d: %v", i, err)
+		}
+	}
+}
+
+// writeBatchEntries writes the test entries first through last as a batch.
+func writeBatchEntries(tb testing.TB, l *Log, first, last uint64) {
+	tb.Helper()
+	var b Batch
+	for i := first; i <= last; i++ {
+		b.Write(i, testEntry(i))
+	}
+	if err := l.WriteBatch(&b); err != nil {
+		tb.Fatalf("write batch %d-%d: %v", first, last, err)
+	}
+}
+
+// checkEntries checks that the log holds exactly the test entries first
+// through last.
+func checkEntries(tb testing.TB, l *Log, first, last uint64) {
+	tb.Helper()
+	if got, err := l.FirstIndex(); err != nil || got != first {
+		tb.Fatalf("first index %d, %v; want %d", got, err, first)
+	}
+	if got, err := l.LastIndex(); err != nil || got != last {
+		tb.Fatalf("last index %d, %v; want %d", got, err, last)
+	}
+	for i := first; i <= last && last != 0; i++ {
+		data, err := l.Read(i)
+		if err != nil {
+			tb.Fatalf("read %d: %v", i, err)
+		}
+		if !bytes.Equal(data, testEntry(i)) {
+			tb.Fatalf("read %d: got %q, want %q", i, data, testEntry(i))
+		}
+	}
+}
+
+// TestWriteRead checks that entries written are read back, from the tail
+// and from sealed segments, before and after reopening.
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	checkEntries(t, l, 0, 0)
+
+	writeEntries(t, l, 1, 500)
+	if len(l.segments) < 2 {
+		t.Fatalf("got %d segments, want several", len(l.segments))
+	}
+	checkEntries(t, l, 1, 500)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	writeEntries(t, l, 501, 600)
+	checkEntries(t, l, 1, 600)
+}
+
+// TestWriteEmpty checks that empty entries are stored as such.
+func TestWriteEmpty(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	if err := l.Write(1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	l = reopenTest(t, l, dir, Config{})
+	data, err := l.Read(1)
+	if err != nil || len(data) != 0 {
+		t.Fatalf("got %q, %v; want an empty entry", data, err)
+	}
+}
+
+// TestWriteOutOfOrder checks that only the index following the last one is
+// accepted, and that rejected writes leave the log as it was.
+func TestWriteOutOfOrder(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	for _, index := range []uint64{0, 2} {
+		if err := l.Write(index, testEntry(index)); !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("write %d to an empty log: got %v, want ErrOutOfOrder", index, err)
+		}
+	}
+
+	writeEntries(t, l, 1, 5)
+	for _, index := range []uint64{1, 5, 7} {
+		if err := l.Write(index, testEntry(index)); !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("write %d: got %v, want ErrOutOfOrder", index, err)
+		}
+	}
+	checkEntries(t, l, 1, 5)
+}
+
+// TestReadNotFound checks that reads outside of the log fail with
+// ErrNotFound.
+func TestReadNotFound(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 5)
+	for _, index := range []uint64{0, 6, math.MaxUint64} {
+		if _, err := l.Read(index); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("read %d: got %v, want ErrNotFound", index, err)
+		}
+	}
+}
+
+// TestReadCopy checks that Read returns a copy the caller may modify.
+func TestReadCopy(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 1)
+
+	data, err := l.Read(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clear(data)
+	checkEntries(t, l, 1, 1)
+}
+
+// TestClosed checks that a closed log fails every call with ErrClosed.
+func TestClosed(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 1)
+	if !l.Opened() || l.Closed() {
+		t.Fatal("open log reported as closed")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Opened() || !l.Closed() {
+		t.Fatal("closed log reported as open")
+	}
+
+	if err := l.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("second close: got %v, want ErrClosed", err)
+	}
+	if err := l.Write(2, nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("write: got %v, want ErrClosed", err)
+	}
+	if _, err := l.Read(1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("read: got %v, want ErrClosed", err)
+	}
+}
+
+// TestCloseFlushes checks that Close writes out and syncs buffered entries.
+func TestCloseFlushes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		SyncPolicy:      SyncPolicy{Mode: SyncNever},
+		WriteBufferSize: 1 << 20,
+	}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 100)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if durable := l.DurableIndex(); durable != 100 {
+		t.Fatalf("durable index %d after close, want 100", durable)
+	}
+
+	l = openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, l, 1, 100)
+}
+
+// TestLen checks that Len follows writes, truncations and resets.
+func TestLen(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	checkLen := func(want uint64) {
+		t.Helper()
+		if got := l.Len(); got != want {
+			t.Fatalf("got %d entries, want %d", got, want)
+		}
+	}
+
+	checkLen(0)
+	writeEntries(t, l, 1, 500)
+	checkLen(500)
+	if err := l.TruncateFront(101); err != nil {
+		t.Fatal(err)
+	}
+	checkLen(400)
+	if err := l.TruncateBack(300); err != nil {
+		t.Fatal(err)
+	}
+	checkLen(200)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkLen(200)
+	if err := l.Reset(1000); err != nil {
+		t.Fatal(err)
+	}
+	checkLen(0)
+}
+
+// TestReadNoCopy checks that ReadNoCopy returns the entries, from the tail
+// and from sealed segments, whatever their encoding.
+func TestReadNoCopy(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			l := openTest(t, t.TempDir(), cfg)
+			writeEntries(t, l, 1, 500)
+			for i := uint64(1); i <= 500; i++ {
+				data, err := l.ReadNoCopy(i)
+				if err != nil {
+					t.Fatalf("read %d: %v", i, err)
+				}
+				if !bytes.Equal(data, testEntry(i)) {
+					t.Fatalf("read %d: got %q, want %q", i, data, testEntry(i))
+				}
+			}
+			if _, err := l.ReadNoCopy(501); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("read past the end: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+// TestReadNoCopyAllocs checks that ReadNoCopy of a cached entry does not
+// allocate.
+func TestReadNoCopyAllocs(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	for _, index := range []uint64{1, 500} {
+		if _, err := l.ReadNoCopy(index); err != nil {
+			t.Fatal(err)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			l.ReadNoCopy(index)
+		})
+		if allocs != 0 {
+			t.Fatalf("read %d: %v allocations, want none", index, allocs)
+		}
+	}
+}
+
+// TestReadAt checks that ReadAt copies entries into the buffer, and that a
+// buffer too small is left alone and the entry size returned.
+func TestReadAt(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+
+	buf := make([]byte, 64)
+	for i := uint64(1); i <= 500; i++ {
+		n, err := l.ReadAt(i, buf)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], testEntry(i)) {
+			t.Fatalf("read %d: got %q, want %q", i, buf[:n], testEntry(i))
+		}
+	}
+
+	short := []byte("xy")
+	n, err := l.ReadAt(100, short)
+	if !errors.Is(err, io.ErrShortBuffer) || n != len(testEntry(100)) {
+		t.Fatalf("short buffer: got %d, %v; want %d, io.ErrShortBuffer", n, err, len(testEntry(100)))
+	}
+	if string(short) != "xy" {
+		t.Fatalf("short buffer was written to: %q", short)
+	}
+	if n, err := l.ReadAt(100, make([]byte, n)); err != nil || n != len(testEntry(100)) {
+		t.Fatalf("exact buffer: got %d, %v", n, err)
+	}
+
+	if _, err := l.ReadAt(501, buf); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("read past the end: got %v, want ErrNotFound", err)
+	}
+}
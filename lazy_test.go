@@ -0,0 +1,81 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestLazyOpen checks that the sizes Open takes from the manifest are those
+// of the segment files, and that the log reads and writes as usual.
+func TestLazyOpen(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 512, LazyOpen: true}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 1000)
+	l = reopenTest(t, l, dir, cfg)
+
+	if len(l.segments) < 10 {
+		t.Fatalf("got %d segments, want many", len(l.segments))
+	}
+	var disk int64
+	for _, seg := range l.segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seg.size != info.Size() {
+			t.Fatalf("segment %d: size %d, file has %d", seg.index, seg.size, info.Size())
+		}
+		disk += info.Size()
+	}
+
+	stats, err := l.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DiskBytes != disk {
+		t.Fatalf("disk bytes %d, want %d", stats.DiskBytes, disk)
+	}
+
+	checkEntries(t, l, 1, 1000)
+	writeEntries(t, l, 1001, 1100)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 1100)
+}
+
+// TestLazyOpenImmutable checks that ImmutableSegments still catches a
+// sealed segment file that changed size under LazyOpen.
+func TestLazyOpenImmutable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 512, LazyOpen: true, ImmutableSegments: true}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 200)
+	sealed := l.segments[0].path
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(sealed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(sealed, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, &cfg); !errors.Is(err, errChangedSegment) {
+		t.Fatalf("open: got %v, want a changed segment error", err)
+	}
+
+	// LazyOpen alone leaves the file to the checksums of its entries
+	cfg.ImmutableSegments = false
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 1, 200)
+}
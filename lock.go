@@ -0,0 +1,62 @@
+package jellywal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// lockFileName is the name of the lock file inside the log directory.
+const lockFileName = "LOCK"
+
+// dirLock is an exclusive advisory lock on a log directory. The lock file
+// holds the PID of the owning process for diagnostics and stale lock
+// detection.
+type dirLock struct {
+	file *os.File
+	path string
+}
+
+// lockPath returns the path of the lock file for the log directory.
+func lockPath(dir string) string {
+	return filepath.Join(dir, lockFileName)
+}
+
+// writePID replaces the contents of the lock file with our PID.
+func (d *dirLock) writePID() error {
+	if err := d.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := d.file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		return err
+	}
+
+	return d.file.Sync()
+}
+
+// readLockPID returns the PID recorded in the lock file, or zero when it
+// cannot be determined.
+func readLockPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+// lockedError wraps ErrLocked with the PID of the lock owner when known.
+func lockedError(pid int) error {
+	if pid == 0 {
+		return ErrLocked
+	}
+	return fmt.Errorf("%w (pid %d)", ErrLocked, pid)
+}
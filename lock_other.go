@@ -0,0 +1,136 @@
+//go:build !unix
+
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// lockPIDWait is how long lockDir waits for the PID in a lock file that has
+// none yet, as its owner writes it only after creating the file.
+const lockPIDWait = 100 * time.Millisecond
+
+// lockBreakSuffix names the file a process creates next to the lock file
+// while it removes a stale one, so no two processes do it at once.
+const lockBreakSuffix = ".break"
+
+// lockDir creates the lock file in dir exclusively. Without flock the lock
+// survives a crash. On Windows, where it can be told whether a process is
+// running, an existing lock file whose owner is not is considered stale
+// and replaced. Elsewhere, and when the lock file still holds no PID after
+// lockPIDWait, it is considered held and has to be removed by hand.
+func lockDir(dir string, perm os.FileMode) (*dirLock, error) {
+	path := lockPath(dir)
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, perm)
+		if err == nil {
+			d := &dirLock{file: file, path: path}
+			if err := d.writePID(); err != nil {
+				d.release()
+				return nil, fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return d, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		pid, err := waitLockPID(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Released in the meantime
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read lock file: %w", err)
+		} else if pid == 0 || !processExited(pid) {
+			return nil, lockedError(pid)
+		}
+
+		if err := breakLock(path, pid, perm); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrLocked
+}
+
+// breakLock removes the stale lock file at path left behind by the exited
+// process pid. Only one process at a time gets to, holding the break file,
+// and only removes the lock file if it still names pid, so a lock taken
+// after the stale one was removed is never removed in turn.
+func breakLock(path string, pid int, perm os.FileMode) error {
+	guard, err := os.OpenFile(path+lockBreakSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if errors.Is(err, os.ErrExist) {
+		// Another process is breaking it
+		return lockedError(pid)
+	} else if err != nil {
+		return fmt.Errorf("failed to create lock break file: %w", err)
+	}
+	defer os.Remove(guard.Name())
+	defer guard.Close()
+
+	if readLockPID(path) != pid {
+		// Released or taken over in the meantime
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale lock file: %w", err)
+	}
+
+	return nil
+}
+
+// waitLockPID returns the PID recorded in the lock file, waiting up to
+// lockPIDWait for it to be written. It returns zero when there is still
+// none.
+func waitLockPID(path string) (int, error) {
+	deadline := time.Now().Add(lockPIDWait)
+	for {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+
+		if pid, err := strconv.Atoi(string(bytes.TrimSpace(data))); err == nil && pid != 0 {
+			return pid, nil
+		} else if time.Now().After(deadline) {
+			return 0, nil
+		}
+
+		time.Sleep(lockPIDWait / 10)
+	}
+}
+
+// release closes and removes the lock file.
+func (d *dirLock) release() error {
+	if err := d.file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(d.path)
+}
+
+// processExited reports whether the process with the given PID is known
+// to have exited. Only Windows can tell, where opening a process fails
+// once it is gone, everywhere else the owner of a lock file is assumed to
+// be running.
+func processExited(pid int) bool {
+	if pid == os.Getpid() || runtime.GOOS != "windows" {
+		return false
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return true
+	}
+	process.Release()
+
+	return false
+}
@@ -0,0 +1,72 @@
+//go:build !unix
+
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// TestLockWithoutPID checks that a lock file its owner has not written its
+// PID to yet is not taken for a stale one.
+func TestLockWithoutPID(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(lockPath(dir), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("open: got %v, want ErrLocked", err)
+	}
+	if _, err := os.Stat(lockPath(dir)); err != nil {
+		t.Fatalf("lock file removed: %v", err)
+	}
+}
+
+// TestLockStale checks that a lock file left behind by a process that is
+// gone is replaced on Windows, and considered held everywhere else, where
+// it cannot be told stale.
+func TestLockStale(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(lockPath(dir), []byte("1073741824\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(dir, nil)
+	if runtime.GOOS != "windows" {
+		if !errors.Is(err, ErrLocked) {
+			t.Fatalf("open: got %v, want ErrLocked", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := readLockPID(lockPath(dir)); pid != os.Getpid() {
+		t.Fatalf("lock file holds pid %d, want %d", pid, os.Getpid())
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLockStaleBreaking checks that a stale lock file is left alone while
+// another process is removing it.
+func TestLockStaleBreaking(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(lockPath(dir), []byte("1073741824\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockPath(dir)+lockBreakSuffix, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("open: got %v, want ErrLocked", err)
+	}
+	if pid := readLockPID(lockPath(dir)); pid != 1<<30 {
+		t.Fatalf("lock file holds pid %d, want the stale one", pid)
+	}
+}
@@ -0,0 +1,22 @@
+package jellywal
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestLockExclusive checks that a log directory can be opened by one Log at
+// a time, and again once it is closed.
+func TestLockExclusive(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+
+	if _, err := Open(dir, nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second open: got %v, want ErrLocked", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	openTest(t, dir, Config{})
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockDir takes an exclusive flock on the lock file in dir. The kernel drops
+// the lock when the owning process dies, so a lock file left behind by a
+// crashed process is stale and simply taken over.
+func lockDir(dir string, perm os.FileMode) (*dirLock, error) {
+	path := lockPath(dir)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, lockedError(readLockPID(path))
+		}
+		return nil, fmt.Errorf("failed to lock log directory: %w", err)
+	}
+
+	d := &dirLock{file: file, path: path}
+	if err := d.writePID(); err != nil {
+		d.release()
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	return d, nil
+}
+
+// release drops the lock. The lock file is left in place, removing it would
+// race with another process that already opened it.
+func (d *dirLock) release() error {
+	if err := syscall.Flock(int(d.file.Fd()), syscall.LOCK_UN); err != nil {
+		d.file.Close()
+		return err
+	}
+
+	return d.file.Close()
+}
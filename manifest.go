@@ -0,0 +1,411 @@
+package jellywal
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The MANIFEST file records the live segments of the log, and is replaced
+// atomically whenever they change. It is a small text file:
+//
+//	jellywal-manifest 1
+//	first <first index of the log>
+//	truncate <index>          (only while a TruncateBack is in flight)
+//	segment <first> <last> <sealed> [<size>]  (one line per sealed segment)
+//	segment <first>           (the tail segment)
+//	checksum <crc32c of the lines above>
+//
+// The seal time of a segment is in Unix nanoseconds. It is missing from
+// manifests written before it was introduced, the modification time of the
+// segment file stands in for it then. The size of the segment file is only
+// recorded with ImmutableSegments or LazyOpen.
+//
+// The manifest is written before segment files are removed, so a crash
+// during a truncation leaves the old files behind as strays that Open
+// cleans up. A new segment is only listed once its file exists, so a listed
+// but missing file means data was lost outside of the log's control.
+const (
+	manifestFileName = "MANIFEST"
+	manifestVersion  = 1
+)
+
+var (
+	// errManifest is reported when the manifest cannot be decoded.
+	errManifest = errors.New("invalid manifest")
+
+	// errMissingSegment is reported when a segment listed in the manifest
+	// does not exist.
+	errMissingSegment = errors.New("segment file is missing")
+
+	// errStraySegment is reported when a segment file between two listed
+	// segments is not listed in the manifest.
+	errStraySegment = errors.New("segment file is not listed in the manifest")
+)
+
+// manifest is the decoded MANIFEST file.
+type manifest struct {
+	first    uint64            // First index of the log
+	truncate uint64            // Index a TruncateBack in flight truncates to, 0 when none
+	segments []manifestSegment // Live segments in log order, the last one is the tail
+}
+
+// manifestSegment is a segment listed in the manifest.
+type manifestSegment struct {
+	first uint64 // First index of the segment
+	last  uint64 // Last index of a sealed segment, 0 when unknown
+	seal  int64  // Seal time of a sealed segment in Unix nanoseconds, 0 when unknown
+	size  int64  // Size of the file of a sealed segment, 0 when not recorded
+}
+
+func (m *manifest) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "jellywal-manifest %d\n", manifestVersion)
+	fmt.Fprintf(&b, "first %d\n", m.first)
+	if m.truncate != 0 {
+		fmt.Fprintf(&b, "truncate %d\n", m.truncate)
+	}
+	for _, seg := range m.segments {
+		if seg.last != 0 && seg.seal != 0 && seg.size != 0 {
+			fmt.Fprintf(&b, "segment %d %d %d %d\n", seg.first, seg.last, seg.seal, seg.size)
+		} else if seg.last != 0 && seg.seal != 0 {
+			fmt.Fprintf(&b, "segment %d %d %d\n", seg.first, seg.last, seg.seal)
+		} else if seg.last != 0 {
+			fmt.Fprintf(&b, "segment %d %d\n", seg.first, seg.last)
+		} else {
+			fmt.Fprintf(&b, "segment %d\n", seg.first)
+		}
+	}
+	fmt.Fprintf(&b, "checksum %08x\n", crc32.Checksum(b.Bytes(), castagnoli))
+	return b.Bytes()
+}
+
+// decodeManifest decodes a MANIFEST file.
+func decodeManifest(data []byte) (*manifest, error) {
+	invalid := func(offset int, found string) error {
+		return &CorruptError{
+			Offset:   int64(offset),
+			Err:      errManifest,
+			Expected: "manifest line",
+			Found:    strconv.Quote(found),
+		}
+	}
+
+	m := &manifest{}
+	var offset int
+	var sealed bool
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 0; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if sealed || len(fields) < 2 {
+			return nil, invalid(offset, line)
+		}
+
+		var values []uint64
+		for _, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if fields[0] == "checksum" {
+				value, err = strconv.ParseUint(field, 16, 32)
+			}
+			if err != nil {
+				return nil, invalid(offset, line)
+			}
+			values = append(values, value)
+		}
+
+		switch {
+		case lineNo == 0:
+			if fields[0] != "jellywal-manifest" || len(values) != 1 {
+				return nil, invalid(offset, line)
+			} else if values[0] != manifestVersion {
+				return nil, fmt.Errorf("%w: manifest version %d", ErrUnsupportedFormat, values[0])
+			}
+		case fields[0] == "first" && len(values) == 1:
+			m.first = values[0]
+		case fields[0] == "truncate" && len(values) == 1:
+			m.truncate = values[0]
+		case fields[0] == "segment" && len(values) <= 4:
+			seg := manifestSegment{first: values[0]}
+			if len(values) >= 2 {
+				seg.last = values[1]
+			}
+			if len(values) >= 3 {
+				seg.seal = int64(values[2])
+			}
+			if len(values) == 4 {
+				seg.size = int64(values[3])
+			}
+			if (len(values) >= 3 && (seg.last == 0 || seg.seal <= 0)) || (len(values) == 4 && seg.size <= 0) {
+				return nil, invalid(offset, line)
+			}
+			if n := len(m.segments); seg.first == 0 || n > 0 && seg.first <= m.segments[n-1].first {
+				return nil, invalid(offset, line)
+			}
+			m.segments = append(m.segments, seg)
+		case fields[0] == "checksum" && len(values) == 1:
+			if computed := crc32.Checksum(data[:offset], castagnoli); uint32(values[0]) != computed {
+				return nil, &CorruptError{
+					Offset:   int64(offset),
+					Err:      errChecksum,
+					Expected: fmt.Sprintf("crc32c %08x", computed),
+					Found:    fmt.Sprintf("%08x", values[0]),
+				}
+			}
+			sealed = true
+		default:
+			return nil, invalid(offset, line)
+		}
+
+		offset += len(line) + 1
+	}
+
+	if !sealed || offset != len(data) || m.first == 0 {
+		return nil, invalid(offset, "")
+	} else if len(m.segments) > 0 && m.first < m.segments[0].first {
+		return nil, invalid(0, fmt.Sprintf("first %d", m.first))
+	}
+
+	return m, nil
+}
+
+// covers returns the first index of the listed sealed segment holding the
+// entry at the given index, which is not the first index of a listed
+// segment, or 0 when there is none.
+func (m *manifest) covers(index uint64) uint64 {
+	i, _ := slices.BinarySearchFunc(m.segments, index, func(ms manifestSegment, index uint64) int {
+		return cmp.Compare(ms.first, index)
+	})
+	if i == 0 || m.segments[i-1].last < index {
+		return 0
+	}
+	return m.segments[i-1].first
+}
+
+// readManifest reads the MANIFEST file of the log, returning nil when the
+// log does not have one yet.
+func (l *Log) readManifest() (*manifest, error) {
+	path := filepath.Join(l.path, manifestFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, locateCorruption(err, path, 0, 0)
+	}
+
+	return m, nil
+}
+
+// writeManifest atomically replaces the MANIFEST file with one listing the
+// given segments, the last of which is the tail, and first index. A non
+// zero truncate index records a TruncateBack in flight. The caller syncs
+// the log directory to make the new manifest durable.
+func (l *Log) writeManifest(segments []*segment, first, truncate uint64) error {
+	m := &manifest{first: first, truncate: truncate}
+	for i, seg := range segments {
+		ms := manifestSegment{first: seg.index}
+		if i < len(segments)-1 {
+			ms.last = seg.last
+			if !seg.seal.IsZero() {
+				ms.seal = seg.seal.UnixNano()
+			}
+			if l.recordsSizes() {
+				ms.size = seg.size
+			}
+		}
+		m.segments = append(m.segments, ms)
+	}
+
+	tempPath := filepath.Join(l.path, manifestFileName+".tmp")
+	if err := writeFileSync(tempPath, m.encode(), l.config.FilePerms); err != nil {
+		return noSpaceError(fmt.Errorf("failed to write manifest: %w", err))
+	}
+
+	if err := os.Rename(tempPath, filepath.Join(l.path, manifestFileName)); err != nil {
+		return fmt.Errorf("failed to rename manifest: %w", err)
+	}
+
+	return nil
+}
+
+// applyManifest sets up the segments listed in the manifest. Segment files
+// missing from the directory fail the load. Strays left behind by an
+// interrupted truncation, before the first or after the last listed
+// segment, or by an interrupted merge, within the entries of a listed one,
+// are removed along with leftover truncation markers, or ignored for
+// read-only logs. Other strays between listed segments fail the load,
+// unless they are quarantined.
+func (l *Log) applyManifest(m *manifest) error {
+	files, err := os.ReadDir(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	listed := make(map[uint64]bool, len(m.segments))
+	for _, seg := range m.segments {
+		listed[seg.first] = true
+	}
+
+	found := make(map[uint64]os.DirEntry, len(m.segments))
+	starts := make(map[uint64]os.DirEntry)
+	merged := make(map[uint64]bool)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			continue
+		}
+
+		index, kind, ok := l.parseSegmentName(name)
+		if !ok {
+			if name == manifestFileName+".tmp" || name == spareFileName+segmentTempSuffix || strings.HasPrefix(name, "TEMP") {
+				l.removeStray(name)
+			}
+			continue
+		}
+
+		isSegment := isSegmentKind(kind)
+		isStart := strings.HasSuffix(kind, startSuffix) && isSegmentKind(strings.TrimSuffix(kind, startSuffix))
+		switch {
+		case isStart && listed[index]:
+			// A compacting TruncateFront or a Split may have been
+			// interrupted, sorted out below
+			starts[index] = file
+		case isSegment && listed[index] && found[index] != nil:
+			// A crash while switching a segment between its raw and
+			// compressed file leaves both, the raw one sorts first and is
+			// complete
+			l.removeStray(name)
+		case isSegment && listed[index]:
+			found[index] = file
+		case isSegment && m.covers(index) != 0:
+			// A Merge may have been interrupted, the file was merged into
+			// the listed segment holding its entries
+			merged[m.covers(index)] = true
+			l.removeStray(name)
+		case isSegment && len(m.segments) > 0 && index > m.segments[0].first && index < m.segments[len(m.segments)-1].first:
+			path := filepath.Join(l.path, name)
+			err := &CorruptError{Path: path, Err: errStraySegment}
+			if l.config.Recovery != RecoverQuarantine {
+				return err
+			}
+			if err := l.quarantine(path, []Problem{{Path: path, Index: index, Err: err}}); err != nil {
+				return err
+			}
+		case isSegment || isStart || kind == segmentTempSuffix || kind == ".END" || kind == ".RESET" || (kind == sparseIndexSuffix && !listed[index]):
+			l.removeStray(name)
+		}
+	}
+
+	// A rewrite made it into the manifest when it lists a START file with
+	// no other file for its index, Split and compaction both leave one
+	// behind until they are done. Only then does the first piece of a split
+	// replace the file of the segment it was split from. A merge made it
+	// when files of the segments merged away are left.
+	var pending bool
+	for index := range starts {
+		if found[index] == nil {
+			pending = true
+		}
+	}
+
+	// Pick the file of every listed segment first, so they can be stated
+	// all at once. Under LazyOpen only the ones the manifest records no
+	// size or seal time for are stated, the tail always is, and every one
+	// is with ImmutableSegments, which checks their sizes.
+	picked := make([]os.DirEntry, len(m.segments))
+	for i, ms := range m.segments {
+		file, ok := found[ms.first]
+		start, isStart := starts[ms.first]
+		if isStart && ok && !pending && !merged[ms.first] {
+			l.removeStray(start.Name())
+		} else if isStart {
+			if ok && file.Name() != strings.TrimSuffix(start.Name(), startSuffix) {
+				l.removeStray(file.Name())
+			}
+			file, ok = start, true
+		}
+		if !ok {
+			return &CorruptError{
+				Path:       filepath.Join(l.path, l.segmentName(ms.first)),
+				FirstIndex: ms.first,
+				LastIndex:  ms.last,
+				Err:        errMissingSegment,
+			}
+		}
+		picked[i] = file
+	}
+
+	infos := make([]os.FileInfo, len(picked))
+	err = l.forEachSegment(len(picked), func(i int) error {
+		if ms := m.segments[i]; l.statsLazily() && i < len(picked)-1 && ms.size != 0 && ms.seal != 0 {
+			return nil
+		}
+
+		info, err := picked[i].Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat log segment: %w", err)
+		}
+		infos[i] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var resumed bool
+	for i, ms := range m.segments {
+		file, info := picked[i], infos[i]
+		name := file.Name()
+		if file == starts[ms.first] && !l.config.ReadOnly {
+			name = strings.TrimSuffix(name, startSuffix)
+			if err := os.Rename(filepath.Join(l.path, file.Name()), filepath.Join(l.path, name)); err != nil {
+				return fmt.Errorf("failed to rename START log segment: %w", err)
+			}
+			resumed = true
+		}
+
+		size, seal := ms.size, time.Unix(0, ms.seal)
+		if info != nil {
+			size = info.Size()
+			if ms.seal == 0 {
+				seal = info.ModTime()
+			}
+		}
+
+		l.segments = append(l.segments, &segment{
+			index: ms.first,
+			path:  filepath.Join(l.path, name),
+			size:  size,
+			last:  ms.last,
+			seal:  seal,
+		})
+	}
+
+	if resumed {
+		return l.syncDir()
+	}
+
+	return nil
+}
+
+// removeStray removes a file left behind by an interrupted operation,
+// unless the log is read-only.
+func (l *Log) removeStray(name string) {
+	if !l.config.ReadOnly {
+		os.Remove(filepath.Join(l.path, name))
+	}
+}
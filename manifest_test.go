@@ -0,0 +1,108 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestManifest checks that the manifest lists the live segments and first
+// index as they change, and that it decodes to what was encoded.
+func TestManifest(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	if err := l.TruncateFront(100); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := l.readManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.first != 100 || m.truncate != 0 {
+		t.Fatalf("manifest records first %d and truncate %d, want 100 and 0", m.first, m.truncate)
+	}
+	if len(m.segments) != len(l.segments) {
+		t.Fatalf("manifest lists %d segments, want %d", len(m.segments), len(l.segments))
+	}
+	for i, ms := range m.segments {
+		if ms.first != l.segments[i].index {
+			t.Fatalf("manifest segment %d starts at %d, want %d", i, ms.first, l.segments[i].index)
+		}
+		if i < len(m.segments)-1 && ms.last != l.segments[i+1].index-1 {
+			t.Fatalf("manifest segment %d ends at %d, want %d", i, ms.last, l.segments[i+1].index-1)
+		}
+	}
+
+	decoded, err := decodeManifest(m.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Fatalf("decoded %+v, want %+v", decoded, m)
+	}
+}
+
+// TestManifestDamaged checks that Open fails on a damaged manifest and on a
+// listed segment file that is missing.
+func TestManifestDamaged(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		damage func(tb testing.TB, dir string, segments []*segment)
+		err    error
+	}{
+		{"manifest", func(tb testing.TB, dir string, segments []*segment) {
+			path := filepath.Join(dir, manifestFileName)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			data[len(data)/2] ^= 1
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				tb.Fatal(err)
+			}
+		}, errChecksum},
+		{"missing", func(tb testing.TB, dir string, segments []*segment) {
+			if err := os.Remove(segments[1].path); err != nil {
+				tb.Fatal(err)
+			}
+		}, errMissingSegment},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, Config{SegmentSize: 1024})
+			writeEntries(t, l, 1, 500)
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			test.damage(t, dir, l.segments)
+
+			if _, err := Open(dir, nil); !errors.Is(err, ErrCorrupt) || !errors.Is(err, test.err) {
+				t.Fatalf("open: got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// TestManifestLeftover checks that Open removes the temporary manifest an
+// interrupted update leaves behind and keeps the one it was replacing.
+func TestManifestLeftover(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	leftover := filepath.Join(dir, manifestFileName+".tmp")
+	if err := os.WriteFile(leftover, []byte("jellywal-manifest 1\nfirst 5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, Config{})
+	checkEntries(t, l, 1, 10)
+	if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("temporary manifest left in place: %v", err)
+	}
+}
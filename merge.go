@@ -0,0 +1,167 @@
+package jellywal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Merge coalesces runs of adjacent sealed segments into segments of up to
+// SegmentSize bytes each, such as after time-based rotation or truncations
+// left many small segments behind, so fewer files have to be kept open and
+// tracked. Segments with a different checksum algorithm, entry format or
+// encryption than the ones before them start a new run, and so do segments
+// whose entries are bound to their first index when there is no key to bind
+// them again. The tail is left alone. It returns the number of segments
+// merged away.
+//
+// The merged segment is written as a START file and only put in place once
+// the manifest lists it, so a crash leaves the segments either merged or as
+// they were. Entries in front of the first index are left out.
+func (l *Log) Merge() (int, error) {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return 0, ErrCorrupt
+	} else if l.closed {
+		return 0, ErrClosed
+	} else if l.config.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	var merged int
+	for i := 0; i < len(l.segments)-2; i++ {
+		n, err := l.mergeSegments(i)
+		if err != nil {
+			return merged, err
+		}
+		merged += n
+	}
+
+	return merged, nil
+}
+
+// mergeSegments merges the sealed segments following the one at segIdx into
+// it for as long as they fit, and returns the number merged.
+func (l *Log) mergeSegments(segIdx int) (int, error) {
+	found := l.segments[segIdx]
+	if found.size >= int64(l.config.SegmentSize) {
+		return 0, nil
+	}
+
+	first := max(found.index, l.firstIndex)
+	seg, err := l.loadSegment(first)
+	if err != nil {
+		return 0, err
+	} else if !l.compactable(seg) {
+		return 0, nil
+	}
+
+	// Append the segments one at a time while they are loaded, the cache
+	// may let go of them once the next one is loaded
+	flags, format, sum := seg.flags(), seg.format(), seg.sum
+	data := appendSegmentHeader(nil, format, sum, flags)
+	var positions []bytepos
+	end := segIdx
+	for next := first; end < len(l.segments)-1; end++ {
+		if end > segIdx {
+			if seg, err = l.loadSegment(l.segments[end].index); err != nil {
+				return 0, err
+			}
+		}
+
+		from := max(seg.index, first)
+		last := seg.index + uint64(len(seg.cpos))
+		if from >= last || seg.index > next || !l.compactable(seg) || seg.flags() != flags || seg.format() != format || seg.sum != sum {
+			break
+		}
+
+		live := seg.cpos[from-seg.index:]
+		if end > segIdx && len(data)+live[len(live)-1].end-live[0].start > l.config.SegmentSize {
+			break
+		}
+
+		if data, positions, err = l.appendSegmentRange(data, positions, seg, from, last, first); err != nil {
+			return 0, err
+		}
+		next = last
+	}
+	if end-segIdx < 2 {
+		return 0, nil
+	}
+
+	data = appendSegmentFooter(data, data, first, positions, sum)
+
+	suffix := segmentSuffixes[segmentFileCompression(found.path)]
+	finalPath := filepath.Join(l.path, l.segmentName(first)+suffix)
+	startPath := finalPath + startSuffix
+	size, err := l.writeStartFile(finalPath, data)
+	if err != nil {
+		return 0, err
+	}
+
+	// The START file has to be durable before the manifest refers to it
+	if err := l.syncDir(); err != nil {
+		os.Remove(startPath)
+		return 0, err
+	}
+
+	merged := &segment{
+		index: first,
+		path:  finalPath,
+		size:  size,
+		last:  first + uint64(len(positions)) - 1,
+		seal:  l.segments[end-1].seal,
+	}
+	segments := make([]*segment, 0, len(l.segments)-(end-segIdx)+1)
+	segments = append(segments, l.segments[:segIdx]...)
+	segments = append(segments, merged)
+	segments = append(segments, l.segments[end:]...)
+	if err := l.writeManifest(segments, l.firstIndex, 0); err != nil {
+		os.Remove(startPath)
+		return 0, noSpaceError(err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return 0, err
+	}
+
+	// Any errors from here on will not corrupt the data on disk, but leave
+	// the in-memory state inconsistent. Flag the log as corrupt so the user
+	// can recover by calling RecoverCorrupt, or Close followed by Open.
+	if err := l.removeSparseIndex(found.path); err != nil {
+		return 0, l.markCorrupt(err)
+	}
+
+	// The merged segment has to be in place before the segments it replaces
+	// go away, Open tells the merge was done by the files left over from them
+	if found.path != finalPath {
+		if err := l.discardSegmentFile(found); err != nil {
+			return 0, l.markCorrupt(fmt.Errorf("failed to remove merged log segment: %w", err))
+		}
+	}
+	if err := os.Rename(startPath, finalPath); err != nil {
+		return 0, l.markCorrupt(fmt.Errorf("failed to rename START log segment: %w", err))
+	}
+	l.protectSegmentFile(finalPath)
+
+	for _, removed := range l.segments[segIdx+1 : end] {
+		if err := l.discardSegmentFile(removed); err != nil {
+			return 0, l.markCorrupt(fmt.Errorf("failed to remove merged log segment: %w", err))
+		}
+	}
+
+	if err := l.syncDir(); err != nil {
+		return 0, l.markCorrupt(err)
+	}
+
+	l.segments = segments
+	l.clearCache()
+
+	if suffix == "" {
+		l.writeSparseIndex(merged, positions)
+	}
+
+	return end - segIdx - 1, nil
+}
@@ -0,0 +1,150 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// segmentSums returns the checksum algorithm of every segment of the log by
+// its first index.
+func segmentSums(tb testing.TB, l *Log) map[uint64]uint8 {
+	tb.Helper()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	sums := make(map[uint64]uint8)
+	for _, found := range l.segments {
+		seg, err := l.loadSegment(max(found.index, l.firstIndex))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		sums[found.index] = seg.sum.ID
+	}
+	return sums
+}
+
+// TestMerge checks that runs of small sealed segments are merged into
+// segments that fit SegmentSize, whatever their encoding, and that merging
+// again does nothing.
+func TestMerge(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg.SegmentSize = 256
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			if err := l.TruncateFront(20); err != nil {
+				t.Fatal(err)
+			}
+			segments := len(l.segments)
+
+			cfg.SegmentSize = 2048
+			l = reopenTest(t, l, dir, cfg)
+			merged, err := l.Merge()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if merged == 0 || len(l.segments) != segments-merged {
+				t.Fatalf("merged %d segments, %d of %d left", merged, len(l.segments), segments)
+			}
+			if len(l.segments) > segments/4 {
+				t.Fatalf("%d segments left of %d, want fewer", len(l.segments), segments)
+			}
+			checkSegmentSizes(t, l)
+			checkEntries(t, l, 20, 500)
+			if merged, err := l.Merge(); err != nil || merged != 0 {
+				t.Fatalf("merge again: got %d, %v; want 0", merged, err)
+			}
+
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 20, 500)
+			checkVerify(t, l)
+		})
+	}
+}
+
+// TestMergeMixed checks that segments with another checksum algorithm are
+// not merged with the ones before them.
+func TestMergeMixed(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 256}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 200)
+	cfg.Checksum = ChecksumCRC64
+	l = reopenTest(t, l, dir, cfg)
+	writeEntries(t, l, 201, 400)
+	before := segmentSums(t, l)
+
+	cfg.SegmentSize = 1 << 16
+	l = reopenTest(t, l, dir, cfg)
+	if _, err := l.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	after := segmentSums(t, l)
+	if len(after) >= len(before) {
+		t.Fatalf("%d segments left of %d, want fewer", len(after), len(before))
+	}
+	for i, seg := range l.segments[:len(l.segments)-1] {
+		next := l.segments[i+1].index
+		for index, sum := range before {
+			if index >= seg.index && index < next && sum != after[seg.index] {
+				t.Fatalf("segment %d with checksum %d merged into %d with checksum %d", index, sum, seg.index, after[seg.index])
+			}
+		}
+	}
+	checkEntries(t, l, 1, 400)
+	checkVerify(t, l)
+}
+
+// TestMergeInterrupted checks that Open completes a merge that was recorded
+// in the manifest before a crash, and removes the merged segment of one
+// that was not.
+func TestMergeInterrupted(t *testing.T) {
+	for _, recorded := range []bool{false, true} {
+		dir := t.TempDir()
+		cfg := Config{SegmentSize: 256}
+		l := openTest(t, dir, cfg)
+		writeEntries(t, l, 1, 200)
+		segments := len(l.segments)
+		cfg.SegmentSize = 1 << 16
+		l = reopenTest(t, l, dir, cfg)
+		crashed := crashCopy(t, dir)
+
+		if _, err := l.Merge(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Crash once the merged segment was written, and maybe recorded
+		merged := l.segments[0].path
+		data, err := os.ReadFile(merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := filepath.Join(crashed, filepath.Base(merged)+startSuffix)
+		if err := os.WriteFile(start, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if recorded {
+			manifest, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(crashed, manifestFileName), manifest, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		l = openTest(t, crashed, cfg)
+		if _, err := os.Stat(start); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("recorded %v: START file left in place: %v", recorded, err)
+		}
+		if want := map[bool]int{false: segments, true: 2}[recorded]; len(l.segments) != want {
+			t.Fatalf("recorded %v: got %d segments, want %d", recorded, len(l.segments), want)
+		}
+		checkEntries(t, l, 1, 200)
+		l = reopenTest(t, l, crashed, cfg)
+		checkEntries(t, l, 1, 200)
+		checkVerify(t, l)
+	}
+}
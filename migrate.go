@@ -0,0 +1,178 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Migrate rewrites the segments of the log at the given path from one
+// on-disk format version to a newer one, in place. Version 0 is the
+// headerless format written before segment headers were introduced, where
+// entries may lack checksums. Version 1 holds entries in EntryFormatVarint
+// and version 2 in EntryFormatFixed, migrating to version 2 fails with
+// ErrEntryTooLarge when an entry does not fit. Migrated segments get a
+// header, a checksum on every entry using the algorithm of the config, and
+// a footer once sealed. A nil config uses DefaultConfig.
+//
+// Every segment is written to a temp file and renamed over the original, so
+// a crash leaves each segment either fully migrated or untouched. Segments
+// already at toVersion are skipped, running Migrate again after a crash
+// finishes the job. Segments at any other version fail the migration with
+// ErrUnsupportedFormat. The log must not be open elsewhere.
+func Migrate(path string, fromVersion, toVersion int, config *Config) error {
+	if fromVersion < 0 || toVersion > segmentVersion || fromVersion > toVersion {
+		return fmt.Errorf("%w: cannot migrate from version %d to %d", ErrUnsupportedFormat, fromVersion, toVersion)
+	} else if fromVersion == toVersion {
+		return nil
+	}
+
+	if config == nil {
+		config = DefaultConfig
+	}
+	cfg := *config
+	cfg.ReadOnly = false
+
+	l, err := Open(path, &cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := l.migrate(fromVersion, toVersion); err != nil {
+		l.Close()
+		return err
+	}
+
+	return l.Close()
+}
+
+// migrate rewrites every segment at fromVersion, starting with the sealed
+// ones so the tail is only closed at the very end.
+func (l *Log) migrate(fromVersion, toVersion int) error {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return ErrCorrupt
+	} else if l.closed {
+		return ErrClosed
+	}
+
+	// The tail is migrated from its file, which has to hold the entries
+	// buffered for it
+	if err := l.flushTail(); err != nil {
+		return err
+	}
+
+	for i, seg := range l.segments {
+		isTail := i == len(l.segments)-1
+		if err := l.migrateSegment(seg, isTail, fromVersion, toVersion); err != nil {
+			return err
+		}
+	}
+
+	l.recordSegmentSizes()
+	l.clearCache()
+
+	return nil
+}
+
+// migrateSegment rewrites a single segment, unless it is at toVersion
+// already.
+func (l *Log) migrateSegment(seg *segment, isTail bool, fromVersion, toVersion int) error {
+	data, err := readSegmentFile(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to read log segment file: %w", err)
+	}
+
+	header, err := l.readSegmentHeader(data)
+	if err != nil {
+		return locateCorruption(err, seg.path, seg.index, 0)
+	}
+
+	switch int(header.version) {
+	case toVersion:
+		return nil
+	case fromVersion:
+	default:
+		return fmt.Errorf("%w: segment %s is at version %d, expected %d", ErrUnsupportedFormat, seg.path, header.version, fromVersion)
+	}
+
+	positions, _, err := parseSegmentEntries(data, header.size, header.sum)
+	if err != nil {
+		return locateCorruption(err, seg.path, seg.index+uint64(len(positions)), 0)
+	}
+
+	// Re-encode every entry with a checksum, keeping the batch markers and
+	// compression. Encrypted entries stay bound to the same segment index,
+	// so they are kept as they are along with the segment flags.
+	format := versionFormat(uint8(toVersion))
+	migrated := appendSegmentHeader(nil, format, l.config.Checksum, header.flags)
+	migratedPositions := make([]bytepos, 0, len(positions))
+	for _, pos := range positions {
+		entry, flags, _, err := header.format().decodeEntry(data[pos.start:pos.end], header.sum)
+		if err != nil {
+			return locateCorruption(err, seg.path, seg.index+uint64(len(migratedPositions)), 0)
+		} else if !format.fits(len(entry)) {
+			return fmt.Errorf("%w: entry %d of segment %s takes %d bytes", ErrEntryTooLarge, seg.index+uint64(len(migratedPositions)), seg.path, len(entry))
+		}
+
+		start := len(migrated)
+		migrated = format.appendEntry(migrated, entry, flags&(entryMore|entryCompressed), l.config.Checksum)
+		migratedPositions = append(migratedPositions, bytepos{start, len(migrated)})
+	}
+
+	if !isTail {
+		migrated = appendSegmentFooter(migrated, migrated, seg.index, migratedPositions, l.config.Checksum)
+	} else if err := l.sfile.Close(); err != nil {
+		return l.markCorrupt(fmt.Errorf("failed to close tail segment: %w", err))
+	}
+
+	// Sealed segments keep their compression, the tail is always raw
+	tempPath := filepath.Join(l.path, "TEMP"+segmentSuffixes[segmentFileCompression(seg.path)])
+	if err := writeSegmentFile(tempPath, l.config.FilePerms, migrated); err != nil {
+		os.Remove(tempPath)
+		err = noSpaceError(fmt.Errorf("failed to write migrated log segment: %w", err))
+		if isTail {
+			return errors.Join(err, l.reopenTail(seg))
+		}
+		return err
+	}
+
+	if err := os.Rename(tempPath, seg.path); err != nil {
+		err = fmt.Errorf("failed to rename migrated log segment: %w", err)
+		if isTail {
+			return errors.Join(err, l.reopenTail(seg))
+		}
+		return err
+	}
+
+	if isTail {
+		if err := l.reopenTail(seg); err != nil {
+			return err
+		}
+	} else {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			return fmt.Errorf("failed to stat migrated log segment: %w", err)
+		}
+		seg.size = info.Size()
+		l.protectSegmentFile(seg.path)
+
+		if err := l.removeSparseIndex(seg.path); err != nil {
+			return err
+		}
+	}
+
+	return l.syncDir()
+}
+
+// reopenTail opens the tail segment again after it was closed for a
+// rewrite, flagging the log as corrupt when that fails.
+func (l *Log) reopenTail(tail *segment) error {
+	if err := l.openLastSegment(tail, RecoverTorn); err != nil {
+		return l.markCorrupt(err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// segmentVersions returns the version of every segment file of the log.
+func segmentVersions(tb testing.TB, l *Log) []uint8 {
+	tb.Helper()
+	var versions []uint8
+	for _, seg := range l.segments {
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		header, err := l.readSegmentHeader(data)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		versions = append(versions, header.version)
+	}
+	return versions
+}
+
+// TestMigrate checks that Migrate rewrites every segment into the new
+// format without losing entries, and that running it again is harmless.
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 300)
+	writeBatchEntries(t, l, 301, 310)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Migrate(dir, segmentVersionVarint, segmentVersionFixed, &cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l = openTest(t, dir, cfg)
+	for _, version := range segmentVersions(t, l) {
+		if version != segmentVersionFixed {
+			t.Fatalf("segment at version %d after migration, want %d", version, segmentVersionFixed)
+		}
+	}
+	checkEntries(t, l, 1, 310)
+	checkVerify(t, l)
+	writeEntries(t, l, 311, 400)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 400)
+}
+
+// TestMigrateHeaderless checks that a segment written before headers were
+// introduced is migrated, its entries gaining checksums.
+func TestMigrateHeaderless(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	path := l.segments[0].path
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	for i := uint64(1); i <= 10; i++ {
+		data = EntryFormatVarint.appendEntry(data, testEntry(i), 0, ChecksumCRC32C)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(dir, 0, segmentVersionVarint, nil); err != nil {
+		t.Fatal(err)
+	}
+	l = openTest(t, dir, Config{})
+	if versions := segmentVersions(t, l); versions[0] != segmentVersionVarint {
+		t.Fatalf("segment at version %d after migration, want %d", versions[0], segmentVersionVarint)
+	}
+	checkEntries(t, l, 1, 10)
+	checkVerify(t, l)
+}
+
+// TestMigrateUnsupported checks that Migrate refuses versions it cannot
+// migrate between and segments at an unexpected version.
+func TestMigrateUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, versions := range [][2]int{{2, 1}, {1, segmentVersion + 1}, {-1, 1}, {0, 2}} {
+		if err := Migrate(dir, versions[0], versions[1], nil); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Fatalf("migrate from %d to %d: got %v, want ErrUnsupportedFormat", versions[0], versions[1], err)
+		}
+	}
+
+	l = openTest(t, dir, Config{})
+	checkEntries(t, l, 1, 10)
+}
@@ -0,0 +1,43 @@
+package jellywal
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+)
+
+// mapping is a sealed segment file mapped into memory for MmapSegments.
+// Cached segments and cursor snapshots share it without knowing about each
+// other, so it is unmapped by a finalizer once none of them refers to it.
+// Mapped files are never modified in place: truncating a mapped segment
+// replaces its file, so existing mappings keep their pages.
+type mapping struct {
+	data       []byte
+	sequential atomic.Bool // Advised for sequential reads
+}
+
+// mapSegmentFile maps the raw segment file at path, returning nil when it
+// cannot be mapped and has to be read instead.
+func mapSegmentFile(path string) (*mapping, error) {
+	data, err := mmapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map log segment file: %w", err)
+	} else if data == nil {
+		return nil, nil
+	}
+
+	m := &mapping{data: data}
+	runtime.SetFinalizer(m, func(m *mapping) {
+		munmap(m.data)
+	})
+
+	return m, nil
+}
+
+// adviseSequential hints the kernel that a mapped segment is about to be
+// walked front to back. Only the first walk of a mapping makes the call.
+func (s *segment) adviseSequential() {
+	if s.mmap != nil && s.mmap.sequential.CompareAndSwap(false, true) {
+		madviseSequential(s.mmap.data)
+	}
+}
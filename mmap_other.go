@@ -0,0 +1,16 @@
+//go:build !unix
+
+package jellywal
+
+// mmapFile is not supported, segment files are read into memory instead.
+func mmapFile(path string) ([]byte, error) {
+	return nil, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
+
+func madviseSequential(data []byte) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package jellywal
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestMmapReadDuringGC checks that entries read from mapped segments stay
+// intact while the collector runs the finalizers of dropped mappings.
+func TestMmapReadDuringGC(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{MmapSegments: true, SegmentSize: 512}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	l = reopenTest(t, l, dir, cfg)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+
+	for round := 0; round < 5; round++ {
+		checkEntries(t, l, 1, 500)
+	}
+}
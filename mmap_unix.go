@@ -0,0 +1,43 @@
+//go:build unix
+
+package jellywal
+
+import (
+	"math"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapFile maps the file at path read-only into memory. It returns nil
+// for files that cannot be mapped whole, empty ones included.
+func mmapFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size == 0 || size > math.MaxInt {
+		return nil, nil
+	}
+
+	return unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+}
+
+// munmap unmaps data mapped by mmapFile.
+func munmap(data []byte) error {
+	return unix.Munmap(data)
+}
+
+// madviseSequential tells the kernel the mapped data is read front to
+// back, so it reads ahead aggressively and reclaims pages behind.
+func madviseSequential(data []byte) error {
+	return unix.Madvise(data, unix.MADV_SEQUENTIAL)
+}
@@ -0,0 +1,113 @@
+//go:build unix
+
+package jellywal
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+// loadedSegment returns the sealed segment holding index with its entries
+// loaded.
+func loadedSegment(tb testing.TB, l *Log, index uint64) *segment {
+	tb.Helper()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	seg, err := l.loadSegment(index)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return seg
+}
+
+// TestMmapSegments checks that raw sealed segments are mapped, compressed
+// ones read into the heap, and that ReadNoCopy returns no memory of the
+// mapping, which may be unmapped.
+func TestMmapSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SegmentCompression: CompressionSnappy}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 200)
+	cfg.SegmentCompression = CompressionNone
+	l = reopenTest(t, l, dir, cfg)
+	writeEntries(t, l, 201, 500)
+
+	cfg.MmapSegments = true
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	if seg := loadedSegment(t, l, 1); seg.mmap != nil {
+		t.Fatal("compressed segment mapped")
+	}
+	seg := loadedSegment(t, l, 300)
+	if seg.mmap == nil {
+		t.Fatal("raw segment not mapped")
+	}
+
+	data, err := l.ReadNoCopy(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := uintptr(unsafe.Pointer(unsafe.SliceData(seg.mmap.data)))
+	if at := uintptr(unsafe.Pointer(unsafe.SliceData(data))); at >= start && at < start+uintptr(len(seg.mmap.data)) {
+		t.Fatal("entry read points into the mapping")
+	}
+	checkEntries(t, l, 1, 500)
+}
+
+// TestMmapTruncate checks that truncating a mapped segment leaves existing
+// mappings of it intact.
+func TestMmapTruncate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, MmapSegments: true}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	l = reopenTest(t, l, dir, cfg)
+
+	seg := loadedSegment(t, l, 1)
+	if seg.mmap == nil {
+		t.Fatal("segment not mapped")
+	}
+	mapped := bytes.Clone(seg.mmap.data)
+	last := seg.index + uint64(len(seg.cpos)) - 1
+	if err := l.TruncateBack(last - 5); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seg.mmap.data, mapped) {
+		t.Fatal("mapping of a truncated segment changed")
+	}
+
+	writeEntries(t, l, last-4, last+10)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, last+10)
+}
+
+// TestMmapCursorSequential checks that forward cursors advise sequential
+// reads of the segments they walk, and reverse ones do not.
+func TestMmapCursorSequential(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, MmapSegments: true, SegmentCacheBytes: 1 << 20}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	l = reopenTest(t, l, dir, cfg)
+
+	c, err := l.CursorReverse(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWalk(t, c, 100, 1)
+	c.Close()
+	if loadedSegment(t, l, 1).mmap.sequential.Load() {
+		t.Fatal("reverse cursor advised sequential reads")
+	}
+
+	c, err = l.Cursor(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWalk(t, c, 1, 500)
+	c.Close()
+	if !loadedSegment(t, l, 1).mmap.sequential.Load() {
+		t.Fatal("forward cursor did not advise sequential reads")
+	}
+}
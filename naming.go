@@ -0,0 +1,71 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment files are named after the index of their first entry, zero padded
+// to 20 digits so they sort in index order, between the configured
+// SegmentPrefix and SegmentSuffix. Whatever follows the suffix tells the
+// kind of file: nothing for a raw segment, the suffix of its algorithm for
+// a compressed one, .idx for a sparse index sidecar, and .START, .END or
+// .RESET for the files of truncations interrupted in older versions.
+const segmentDigits = 20
+
+// segmentName returns the name of the raw file of the segment starting at
+// the given index.
+func (l *Log) segmentName(index uint64) string {
+	return l.config.SegmentPrefix + fmt.Sprintf("%0*d", segmentDigits, index) + l.config.SegmentSuffix
+}
+
+// parseSegmentName parses a file name following the naming scheme of the
+// log into the index it starts at and what follows the suffix. It reports
+// false for any other name.
+func (l *Log) parseSegmentName(name string) (uint64, string, bool) {
+	rest, ok := strings.CutPrefix(name, l.config.SegmentPrefix)
+	if !ok || len(rest) < segmentDigits {
+		return 0, "", false
+	}
+
+	index, err := strconv.ParseUint(rest[:segmentDigits], 10, 64)
+	if err != nil || index == 0 {
+		return 0, "", false
+	}
+
+	rest, ok = strings.CutPrefix(rest[segmentDigits:], l.config.SegmentSuffix)
+	if !ok {
+		return 0, "", false
+	}
+
+	return index, rest, true
+}
+
+// isSegmentKind reports whether kind, what follows the suffix of a segment
+// file name, is that of a raw or compressed segment file.
+func isSegmentKind(kind string) bool {
+	return kind == "" || (segmentFileCompression(kind) != CompressionNone && segmentSuffixes[segmentFileCompression(kind)] == kind)
+}
+
+// validateNaming checks that segment file names built from the prefix and
+// suffix stay within the log directory and cannot be confused with the
+// other files the log keeps there.
+func (c *Config) validateNaming() error {
+	for _, part := range []string{c.SegmentPrefix, c.SegmentSuffix} {
+		if strings.ContainsAny(part, "/\\\x00") {
+			return errors.New("segment prefix and suffix must not contain path separators")
+		}
+	}
+
+	if strings.HasPrefix(c.SegmentPrefix, "TEMP") || strings.HasPrefix(c.SegmentPrefix, recyclePrefix) {
+		return fmt.Errorf("segment prefix %q is reserved for temporary files", c.SegmentPrefix)
+	}
+
+	if segmentFileCompression(c.SegmentSuffix) != CompressionNone || strings.HasSuffix(c.SegmentSuffix, sparseIndexSuffix) {
+		return fmt.Errorf("segment suffix %q is reserved", c.SegmentSuffix)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseSegmentName checks that names are parsed back into the index
+// and kind they were built from, and that other names are rejected.
+func TestParseSegmentName(t *testing.T) {
+	l := &Log{config: Config{SegmentPrefix: "wal-", SegmentSuffix: ".seg"}}
+	name := l.segmentName(42)
+	if name != "wal-00000000000000000042.seg" {
+		t.Fatalf("got name %q", name)
+	}
+
+	tests := []struct {
+		name  string
+		index uint64
+		kind  string
+		ok    bool
+	}{
+		{name, 42, "", true},
+		{name + ".zst", 42, ".zst", true},
+		{name + ".idx", 42, ".idx", true},
+		{"wal-00000000000000000042", 0, "", false},
+		{"00000000000000000042.seg", 0, "", false},
+		{"wal-00000000000000000000.seg", 0, "", false},
+		{"wal-0000000000000000004x.seg", 0, "", false},
+		{"wal-42.seg", 0, "", false},
+		{"MANIFEST", 0, "", false},
+	}
+	for _, tt := range tests {
+		index, kind, ok := l.parseSegmentName(tt.name)
+		if index != tt.index || kind != tt.kind || ok != tt.ok {
+			t.Errorf("parse %q: got %d, %q, %v; want %d, %q, %v", tt.name, index, kind, ok, tt.index, tt.kind, tt.ok)
+		}
+	}
+
+	for kind, want := range map[string]bool{"": true, ".sz": true, ".zst": true, ".lz4": true, ".idx": false, ".tmp": false, ".zst.tmp": false} {
+		if got := isSegmentKind(kind); got != want {
+			t.Errorf("isSegmentKind(%q) = %v, want %v", kind, got, want)
+		}
+	}
+}
+
+// TestSegmentNaming checks that segment files follow the configured prefix
+// and suffix, and that files of other tools in the directory are left
+// alone.
+func TestSegmentNaming(t *testing.T) {
+	dir := t.TempDir()
+	foreign := []string{"notes.txt", "00000000000000000001", "wal-backup.seg"}
+	for _, name := range foreign {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("not a segment"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{
+		SegmentSize:         1024,
+		SegmentPrefix:       "wal-",
+		SegmentSuffix:       ".seg",
+		SegmentCompression:  CompressionZstd,
+		SparseIndexInterval: 8,
+	}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	for _, seg := range l.segments {
+		name := filepath.Base(seg.path)
+		if !strings.HasPrefix(name, "wal-") || !strings.Contains(name, ".seg") {
+			t.Fatalf("segment file named %q", name)
+		}
+	}
+	if err := l.TruncateFront(250); err != nil {
+		t.Fatal(err)
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 250, 500)
+	checkVerify(t, l)
+	for _, name := range foreign {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != "not a segment" {
+			t.Fatalf("file %s of another tool touched: %q, %v", name, data, err)
+		}
+	}
+}
+
+// TestSegmentNamingInvalid checks that names which could leave the log
+// directory or be confused with its other files are rejected.
+func TestSegmentNamingInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{SegmentPrefix: "../"},
+		{SegmentSuffix: "/x"},
+		{SegmentPrefix: "TEMP"},
+		{SegmentPrefix: recyclePrefix},
+		{SegmentSuffix: ".zst"},
+		{SegmentSuffix: ".idx"},
+	} {
+		if l, err := Open(t.TempDir(), &cfg); err == nil {
+			l.Close()
+			t.Errorf("opened with prefix %q and suffix %q", cfg.SegmentPrefix, cfg.SegmentSuffix)
+		}
+	}
+}
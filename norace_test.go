@@ -0,0 +1,5 @@
+//go:build !race
+
+package jellywal
+
+const raceEnabled = false
@@ -0,0 +1,120 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestNoSpaceError checks that a full disk is reported as ErrNoSpace and
+// other errors are left alone.
+func TestNoSpaceError(t *testing.T) {
+	full := &os.PathError{Op: "write", Path: "segment", Err: syscall.ENOSPC}
+	if err := noSpaceError(fmt.Errorf("failed to write: %w", full)); !errors.Is(err, ErrNoSpace) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("got %v, want ErrNoSpace wrapping ENOSPC", err)
+	}
+	if err := noSpaceError(os.ErrPermission); errors.Is(err, ErrNoSpace) {
+		t.Fatalf("got %v, want no ErrNoSpace", err)
+	}
+}
+
+// TestWriteRollback checks that a write failing part way through its
+// append is rolled back, leaving the log readable and writable once the
+// cause is gone. The file size limit stands in for a full disk.
+func TestWriteRollback(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	tail := l.segments[len(l.segments)-1]
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	restore := limit
+	limit.Cur = uint64(len(tail.cbuf)) + 100
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skip(err)
+	}
+	big := bytes.Repeat([]byte("x"), 1000)
+	err := l.Write(11, big)
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &restore); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("write past the limit: got %v, want EFBIG", err)
+	}
+	if errors.Is(err, ErrCorrupt) {
+		t.Fatal("log flagged corrupt by a rolled back write")
+	}
+
+	checkEntries(t, l, 1, 10)
+	info, err := os.Stat(tail.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(tail.cbuf)) {
+		t.Fatalf("tail is %d bytes after the rollback, want %d", info.Size(), len(tail.cbuf))
+	}
+
+	writeEntries(t, l, 11, 20)
+	l = reopenTest(t, l, dir, Config{})
+	checkEntries(t, l, 1, 20)
+	if err := l.Damage(); err != nil {
+		t.Fatalf("damage %v, want none", err)
+	}
+}
+
+// TestWriteBufferRollback checks that buffered entries failing to be
+// written out are kept, and written by the next sync once the cause is
+// gone.
+func TestWriteBufferRollback(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SyncPolicy: SyncPolicy{Mode: SyncNever}, WriteBufferSize: 1 << 20}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 10)
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, 11, 100)
+	tail := l.segments[len(l.segments)-1]
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	restore := limit
+	limit.Cur = uint64(l.written) + 100
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skip(err)
+	}
+	err := l.Sync()
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &restore); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("sync past the limit: got %v, want EFBIG", err)
+	}
+	if errors.Is(err, ErrCorrupt) {
+		t.Fatal("log flagged corrupt by a failed flush")
+	}
+	checkEntries(t, l, 1, 100)
+
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(tail.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(tail.cbuf)) {
+		t.Fatalf("tail is %d bytes after the retried flush, want %d", info.Size(), len(tail.cbuf))
+	}
+	l = openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, l, 1, 100)
+}
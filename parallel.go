@@ -0,0 +1,49 @@
+package jellywal
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// forEachSegment calls fn with every index below n from up to
+// OpenConcurrency goroutines, so the per-segment work of Open keeps the
+// disk busy rather than waiting on one segment after another. It returns
+// the error of the lowest index that failed.
+func (l *Log) forEachSegment(n int, fn func(i int) error) error {
+	workers := l.config.OpenConcurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers = min(workers, n); workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
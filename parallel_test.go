@@ -0,0 +1,87 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// TestForEachSegment checks that every index is visited once whatever the
+// concurrency, and that the error of the lowest failing index is returned.
+func TestForEachSegment(t *testing.T) {
+	for _, workers := range []int{0, 1, 4, 100} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			l := &Log{config: Config{OpenConcurrency: workers}}
+			const n = 50
+			var visits [n]atomic.Int32
+			if err := l.forEachSegment(n, func(i int) error {
+				visits[i].Add(1)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			for i := range visits {
+				if got := visits[i].Load(); got != 1 {
+					t.Fatalf("index %d visited %d times", i, got)
+				}
+			}
+
+			errs := map[int]error{7: errors.New("7"), 30: errors.New("30")}
+			if err := l.forEachSegment(n, func(i int) error { return errs[i] }); err != errs[7] {
+				t.Fatalf("got %v, want the error of index 7", err)
+			}
+			if err := l.forEachSegment(0, func(i int) error { return errs[7] }); err != nil {
+				t.Fatalf("no indexes: got %v", err)
+			}
+		})
+	}
+}
+
+// TestOpenConcurrency checks that Open reports the same damage whatever the
+// number of segments it verifies at the same time.
+func TestOpenConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 1000)
+	segments := len(l.segments)
+	damaged := []*segment{l.segments[3], l.segments[segments-3]}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 8} {
+		cfg := Config{SegmentSize: 1024, Recovery: RecoverStrict, OpenConcurrency: workers}
+		l := openTest(t, dir, cfg)
+		if len(l.segments) != segments {
+			t.Fatalf("%d workers: got %d segments, want %d", workers, len(l.segments), segments)
+		}
+		for _, seg := range l.segments {
+			if seg.seal.IsZero() || seg.size == 0 {
+				t.Fatalf("%d workers: segment %d not stated", workers, seg.index)
+			}
+		}
+		checkEntries(t, l, 1, 1000)
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, seg := range damaged {
+		corruptEntry(t, seg.path, seg.index+1)
+	}
+	var want string
+	for _, workers := range []int{1, 8} {
+		cfg := Config{SegmentSize: 1024, Recovery: RecoverStrict, OpenConcurrency: workers}
+		_, err := Open(dir, &cfg)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("%d workers: got %v, want ErrCorrupt", workers, err)
+		}
+		if want == "" {
+			want = err.Error()
+		} else if err.Error() != want {
+			t.Fatalf("%d workers: got %v, want %s", workers, err, want)
+		}
+	}
+}
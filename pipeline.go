@@ -0,0 +1,65 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Entry data passes through a fixed pipeline of transforms on its way to
+// disk, and through the same transforms in reverse when it is read:
+//
+//  1. Compression, when the data gets smaller
+//  2. Codec
+//  3. Encryption, with a key from the KeyProvider
+//  4. Checksum, over the bytes stored, so it covers ciphertext
+//  5. SegmentCompression of whole segments once they are sealed
+//
+// Compressing before encrypting is the only order that works, ciphertext
+// does not compress, and checksumming the stored bytes lets damage be told
+// apart from a wrong key. The order cannot be changed, configurations that
+// would work against it are rejected by Open.
+
+// Pipeline returns the transforms applied to entry data on write, in order,
+// skipping the ones that are not configured. It describes the config as
+// given, without applying defaults.
+func (c *Config) Pipeline() []string {
+	var stages []string
+	if c.Compression != CompressionNone {
+		stages = append(stages, "compress "+c.Compression.String())
+	}
+	if c.Codec != nil {
+		stages = append(stages, "codec")
+	}
+	if c.Encryption != nil {
+		stages = append(stages, "encrypt aes-256-gcm")
+	}
+
+	sum := c.Checksum
+	if sum == nil {
+		sum = defaultChecksum()
+	}
+	stages = append(stages, "checksum "+sum.Name)
+
+	if c.SegmentCompression != CompressionNone {
+		stages = append(stages, "compress segments "+c.SegmentCompression.String())
+	}
+
+	return stages
+}
+
+// validatePipeline checks the transforms of the config against each other.
+func (c *Config) validatePipeline() error {
+	if c.Encryption == nil {
+		return nil
+	}
+
+	switch {
+	case c.SegmentCompression != CompressionNone:
+		// It would run after encryption
+		return errors.New("segment compression cannot compress encrypted entries, use entry compression instead")
+	case c.Compression == CompressionZstdDict:
+		return fmt.Errorf("%s stores samples of the entries unencrypted in the %s file", c.Compression, dictionaryFileName)
+	}
+
+	return nil
+}
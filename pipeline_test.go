@@ -0,0 +1,59 @@
+package jellywal
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestPipeline checks the transforms listed by Pipeline.
+func TestPipeline(t *testing.T) {
+	for _, test := range []struct {
+		cfg  Config
+		want []string
+	}{
+		{Config{Checksum: ChecksumCRC64}, []string{"checksum crc64"}},
+		{
+			Config{Compression: CompressionZstd, Codec: &xorCodec{}, Encryption: newTestKeys(1), Checksum: ChecksumXXHash64},
+			[]string{"compress zstd", "codec", "encrypt aes-256-gcm", "checksum xxhash64"},
+		},
+		{
+			Config{Checksum: ChecksumCRC32C, SegmentCompression: CompressionLZ4},
+			[]string{"checksum crc32c", "compress segments lz4"},
+		},
+	} {
+		if got := test.cfg.Pipeline(); !slices.Equal(got, test.want) {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+// TestPipelineInvalid checks that Open rejects encryption combined with
+// transforms that would work against it.
+func TestPipelineInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Encryption: newTestKeys(1), SegmentCompression: CompressionZstd},
+		{Encryption: newTestKeys(1), Compression: CompressionZstdDict},
+	} {
+		if _, err := Open(t.TempDir(), &cfg); err == nil {
+			t.Fatalf("open with pipeline %q succeeded", cfg.Pipeline())
+		}
+	}
+}
+
+// TestPipelineEncryptedCompressed checks that entries compressed and then
+// encrypted read back, and still take less space than they would raw.
+func TestPipelineEncryptedCompressed(t *testing.T) {
+	raw := openTest(t, t.TempDir(), Config{Encryption: newTestKeys(1)})
+	writeCompressible(t, raw, 1, 100)
+
+	dir := t.TempDir()
+	cfg := Config{Compression: CompressionZstd, Encryption: newTestKeys(1)}
+	l := openTest(t, dir, cfg)
+	writeCompressible(t, l, 1, 100)
+	if size, rawSize := diskSize(t, l), diskSize(t, raw); size*2 > rawSize {
+		t.Fatalf("compressed and encrypted to %d bytes, %d encrypted only", size, rawSize)
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	checkCompressible(t, l, 1, 100)
+}
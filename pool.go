@@ -0,0 +1,45 @@
+package jellywal
+
+import "sync"
+
+// maxPooledBuffer is the capacity beyond which scratch buffers are left to
+// the garbage collector rather than pooled, so a few large entries do not
+// keep large buffers around.
+const maxPooledBuffer = 1 << 20
+
+// entryScratch holds the buffers an entry is compressed and encrypted into
+// on its way to the tail, before it is copied there.
+type entryScratch struct {
+	compressed []byte
+	sealed     []byte
+	aad        []byte
+}
+
+// entryScratchPool keeps the scratch buffers of writes, so steady writes
+// do not allocate them for every entry.
+var entryScratchPool = sync.Pool{
+	New: func() any { return new(entryScratch) },
+}
+
+// getEntryScratch returns scratch buffers from the pool.
+func getEntryScratch() *entryScratch {
+	return entryScratchPool.Get().(*entryScratch)
+}
+
+// putEntryScratch returns scratch buffers to the pool, dropping the ones
+// grown beyond maxPooledBuffer.
+func putEntryScratch(s *entryScratch) {
+	if cap(s.compressed) > maxPooledBuffer {
+		s.compressed = nil
+	}
+	if cap(s.sealed) > maxPooledBuffer {
+		s.sealed = nil
+	}
+	entryScratchPool.Put(s)
+}
+
+// writeRequestPool keeps the requests of group committed writes along with
+// their channels.
+var writeRequestPool = sync.Pool{
+	New: func() any { return &writeRequest{done: make(chan error, 1)} },
+}
@@ -0,0 +1,20 @@
+//go:build darwin
+
+package jellywal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate allocates disk space for the file up to size bytes without
+// changing its size, so appends up to there do not allocate extents.
+func preallocate(file *os.File, size int64) error {
+	store := &unix.Fstore_t{
+		Flags:   unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size,
+	}
+	return unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store)
+}
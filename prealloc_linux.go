@@ -0,0 +1,20 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate allocates disk space for the file up to size bytes without
+// changing its size, so appends up to there do not allocate extents.
+func preallocate(file *os.File, size int64) error {
+	for {
+		err := unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
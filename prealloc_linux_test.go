@@ -0,0 +1,62 @@
+package jellywal
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// allocated returns the size of the file at path and the disk space
+// allocated for it.
+func allocated(tb testing.TB, path string) (int64, int64) {
+	tb.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return info.Size(), info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+// TestPreallocate checks that the tail has its space allocated without
+// changing its size, and that sealed segments release what they did not
+// use.
+func TestPreallocate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1 << 20, Preallocate: true}
+	l := openTest(t, dir, cfg)
+	if size, alloc := allocated(t, l.segments[0].path); alloc < int64(cfg.SegmentSize) || size >= int64(cfg.SegmentSize) {
+		t.Skipf("file of %d bytes allocated %d bytes, preallocation unsupported here", size, alloc)
+	}
+
+	data := bytes.Repeat([]byte("x"), 16<<10)
+	for i := uint64(1); i <= 100; i++ {
+		if err := l.Write(i, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.segments) < 2 {
+		t.Fatalf("got %d segments, want several", len(l.segments))
+	}
+	for i, seg := range l.segments {
+		size, alloc := allocated(t, seg.path)
+		if i < len(l.segments)-1 && alloc > size+64<<10 {
+			t.Fatalf("sealed segment of %d bytes keeps %d bytes allocated", size, alloc)
+		}
+		if i == len(l.segments)-1 && alloc < int64(cfg.SegmentSize) {
+			t.Fatalf("tail of %d bytes has %d bytes allocated, want %d", size, alloc, cfg.SegmentSize)
+		}
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	tail := l.segments[len(l.segments)-1]
+	if _, alloc := allocated(t, tail.path); alloc < int64(cfg.SegmentSize) {
+		t.Fatalf("reopened tail has %d bytes allocated, want %d", alloc, cfg.SegmentSize)
+	}
+	for i := uint64(1); i <= 100; i++ {
+		if got, err := l.Read(i); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("read %d: got %d bytes, %v", i, len(got), err)
+		}
+	}
+	checkVerify(t, l)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package jellywal
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocate is not supported, allocating by writing zeros would change
+// the size of the file, which readers take as its data.
+func preallocate(file *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,98 @@
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// spareFileName is the name of the pre-created next segment file. It is too
+// short to be mistaken for a segment, so a leftover spare is ignored on Open
+// and replaced by the next one.
+const spareFileName = "NEXT"
+
+// spareThreshold is the fraction of the segment size, in quarters, the tail has
+// to reach before the next segment file is pre-created.
+const spareThreshold = 3
+
+// maybePrecreate starts creating the next segment file in the background
+// once the tail is close to full. The caller must hold the write lock.
+func (l *Log) maybePrecreate(tail *segment) {
+	if !l.config.PrecreateSegment || l.spare != nil || l.spareDone != nil {
+		return
+	}
+
+	if len(tail.cbuf) < int(l.segmentSize.Load())/4*spareThreshold {
+		return
+	}
+
+	done := make(chan struct{})
+	l.spareDone = done
+	go l.precreate(done)
+}
+
+// precreate creates the spare segment file and fsyncs the log directory,
+// so cycle only has to rename it into place.
+func (l *Log) precreate(done chan struct{}) {
+	defer close(done)
+
+	path := filepath.Join(l.path, spareFileName)
+	file, header, err := l.createSegmentFile(path)
+	if err == nil {
+		if err = syncDir(l.path); err != nil {
+			file.Close()
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.spareDone == done {
+		l.spareDone = nil
+	}
+
+	if err != nil {
+		// Not fatal, cycle falls back to creating the segment itself
+		return
+	}
+
+	if l.closed {
+		file.Close()
+		os.Remove(path)
+		return
+	}
+
+	l.spare = file
+	l.spareHeader = header
+}
+
+// takeSpare renames the spare segment file to the given path and returns
+// it along with the header written to it, or nil when no spare is ready.
+// The caller must hold the write lock.
+func (l *Log) takeSpare(path string) (*os.File, []byte) {
+	file, header := l.spare, l.spareHeader
+	if file == nil {
+		return nil, nil
+	}
+	l.spare = nil
+	l.spareHeader = nil
+
+	if err := os.Rename(filepath.Join(l.path, spareFileName), path); err != nil {
+		file.Close()
+		return nil, nil
+	}
+
+	return file, header
+}
+
+// dropSpare closes and removes the spare segment file, if any. The caller
+// must hold the write lock.
+func (l *Log) dropSpare() error {
+	if l.spare == nil {
+		return nil
+	}
+
+	l.spare.Close()
+	l.spare = nil
+
+	return os.Remove(filepath.Join(l.path, spareFileName))
+}
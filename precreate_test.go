@@ -0,0 +1,98 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeUntilSpare writes entries after index until the spare segment file
+// is ready and returns the last index written.
+func writeUntilSpare(tb testing.TB, l *Log, index uint64) uint64 {
+	tb.Helper()
+	for {
+		index++
+		writeEntries(tb, l, index, index)
+
+		l.mu.RLock()
+		started := l.spareDone != nil || l.spare != nil
+		l.mu.RUnlock()
+		if started {
+			break
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.RLock()
+		ready := l.spare != nil
+		l.mu.RUnlock()
+		if ready {
+			return index
+		}
+		if time.Now().After(deadline) {
+			tb.Fatal("no spare segment created")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPrecreateSegment checks that the next segment file is created once
+// the tail is nearly full, that rotation takes it over, and that Close
+// removes an unused one.
+func TestPrecreateSegment(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, PrecreateSegment: true}
+	l := openTest(t, dir, cfg)
+	spare := filepath.Join(dir, spareFileName)
+
+	index := writeUntilSpare(t, l, 0)
+	if len(l.segments) != 1 {
+		t.Fatal("tail rotated before a spare was created")
+	}
+	before, err := os.Stat(spare)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for len(l.segments) == 1 {
+		index++
+		writeEntries(t, l, index, index)
+	}
+	after, err := os.Stat(l.segments[1].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatal("rotation did not take over the spare segment")
+	}
+
+	index = writeUntilSpare(t, l, index)
+	l = reopenTest(t, l, dir, cfg)
+	if _, err := os.Stat(spare); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("spare segment left after close: %v", err)
+	}
+	checkEntries(t, l, 1, index)
+}
+
+// TestPrecreateLeftover checks that a spare segment file left behind by a
+// crash is ignored by Open.
+func TestPrecreateLeftover(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, spareFileName), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, Config{SegmentSize: 1024, PrecreateSegment: true})
+	checkEntries(t, l, 1, 10)
+	writeEntries(t, l, 11, 500)
+	l = reopenTest(t, l, dir, Config{})
+	checkEntries(t, l, 1, 500)
+}
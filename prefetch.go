@@ -0,0 +1,44 @@
+package jellywal
+
+// prefetched is the result of loading a segment in the background.
+type prefetched struct {
+	index   uint64 // Index the segment was looked up for
+	segment *segment
+	err     error
+}
+
+// prefetchSegment snapshots the segment holding the given index in the
+// background, see snapshotSegment, so a sequential walk can decode the
+// current segment while the next one is read and parsed. Its files are
+// decompressed in the background as well. The result is delivered on the
+// returned channel, which never blocks the loading goroutine.
+func (l *Log) prefetchSegment(index uint64, reverse bool) <-chan prefetched {
+	ch := make(chan prefetched, 1)
+	go func() {
+		segment, err := l.snapshotSegment(index, reverse)
+		ch <- prefetched{index, segment, err}
+	}()
+	return ch
+}
+
+// takePrefetched waits for the segment loaded by prefetchSegment and
+// returns it when it was looked up for the given index, or nil so the
+// caller loads the segment itself, which also reports any error.
+func takePrefetched(ch <-chan prefetched, index uint64) *segment {
+	p := <-ch
+	if p.err != nil || p.index != index {
+		return nil
+	}
+	return p.segment
+}
+
+// nextSegmentIndex returns the index to prefetch the segment following seg
+// in the direction of a walk over first through last, and false when the
+// walk ends with seg.
+func nextSegmentIndex(seg *segment, first, last uint64, reverse bool) (uint64, bool) {
+	if reverse {
+		return seg.index - 1, seg.index > first
+	}
+	next := seg.index + uint64(len(seg.cpos))
+	return next, next <= last
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package jellywal
+
+import "os"
+
+// setWritable leaves the file alone. The read-only attribute of Windows
+// keeps files from being renamed over and removed, which the log does to
+// sealed segments, so only their sizes are checked on Open.
+func setWritable(path string, perm os.FileMode, writable bool) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package jellywal
+
+import "os"
+
+// setWritable makes the file at path writable with the write bits of perm,
+// or read-only, keeping its other bits.
+func setWritable(path string, perm os.FileMode, writable bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	mode := info.Mode().Perm() &^ 0o222
+	if writable {
+		mode |= perm & 0o222
+	}
+	if mode == info.Mode().Perm() {
+		return nil
+	}
+
+	return os.Chmod(path, mode)
+}
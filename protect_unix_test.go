@@ -0,0 +1,51 @@
+//go:build unix
+
+package jellywal
+
+import (
+	"os"
+	"testing"
+)
+
+// checkWritable checks that the segment files of the log are read-only when
+// sealed, and writable otherwise.
+func checkWritable(tb testing.TB, l *Log, sealedWritable bool) {
+	tb.Helper()
+	for i, seg := range l.segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		want := sealedWritable || i == len(l.segments)-1
+		if writable := info.Mode().Perm()&0o222 != 0; writable != want {
+			tb.Fatalf("segment %d: mode %v, want writable %v", seg.index, info.Mode().Perm(), want)
+		}
+	}
+}
+
+// TestProtectSegments checks that ImmutableSegments makes sealed segment
+// files read-only, including those sealed before it was turned on, and
+// that the log still truncates them once it is turned off.
+func TestProtectSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 300)
+	checkWritable(t, l, true)
+
+	cfg.ImmutableSegments = true
+	l = reopenTest(t, l, dir, cfg)
+	checkWritable(t, l, false)
+	writeEntries(t, l, 301, 500)
+	checkWritable(t, l, false)
+
+	cfg.ImmutableSegments = false
+	l = reopenTest(t, l, dir, cfg)
+	last := l.segments[1].index + 5
+	if err := l.TruncateBack(last); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, last+1, 600)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 600)
+}
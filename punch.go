@@ -0,0 +1,64 @@
+package jellywal
+
+import (
+	"os"
+	"runtime"
+)
+
+// With PunchHoles, the blocks of the entries TruncateFront removes from a
+// sealed segment it cuts through are freed, and read back as zeros. The
+// file keeps its size and the footer still indexes the entries left, so
+// their offsets stay the same. The segment checksum no longer matches, so
+// the entries left are verified one by one instead, and the tail cannot be
+// parsed from its start, so a punched segment is compacted before it
+// becomes the tail again.
+
+// punchFront punches the entries of the sealed segment, whose entries are
+// at positions, in front of the given index.
+// It is best effort: the entries stay on disk when it fails, as they do
+// without PunchHoles. The manifest recording the truncation must be durable
+// already.
+func (l *Log) punchFront(seg *segment, positions []bytepos, index uint64) {
+	if !l.config.PunchHoles || segmentFileCompression(seg.path) != CompressionNone || l.readInPlace(seg) {
+		return
+	} else if index <= seg.index || index-seg.index >= uint64(len(positions)) || positions[0].start == 0 {
+		return
+	}
+
+	l.unprotectSegmentFile(seg.path)
+	defer l.protectSegmentFile(seg.path)
+
+	file, err := os.OpenFile(seg.path, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	start, end := positions[0].start, positions[index-seg.index].start
+	if err := punchHole(file, int64(start), int64(end-start)); err == nil {
+		file.Sync()
+	}
+}
+
+// deadPrefixIntact reports whether the entries of the loaded segment in
+// front of the first index of the log can still be decoded, which is not
+// the case once they were punched. Zeros decode as empty entries without a
+// checksum, which only headerless segments hold and are never punched.
+func (l *Log) deadPrefixIntact(seg *segment) bool {
+	if seg.hdr == 0 {
+		return true
+	}
+
+	for i := seg.index; i < l.firstIndex && i-seg.index < uint64(len(seg.cpos)); i++ {
+		raw, err := seg.entryBytes(seg.cpos[i-seg.index])
+		if err != nil {
+			return false
+		}
+		if _, flags, n, err := seg.format().decodeEntry(raw, seg.sum); err != nil || n != len(raw) || flags&entryChecksum == 0 {
+			return false
+		}
+	}
+	// Keep the segment mapped through the last decode
+	runtime.KeepAlive(seg.mmap)
+	return true
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// punchHole deallocates the given range of the file, which then reads back
+// as zeros, without changing its size.
+func punchHole(file *os.File, off, length int64) error {
+	for {
+		err := unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, off, length)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
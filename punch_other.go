@@ -0,0 +1,13 @@
+//go:build !linux
+
+package jellywal
+
+import (
+	"errors"
+	"os"
+)
+
+// punchHole is not supported, the range keeps its blocks.
+func punchHole(file *os.File, off, length int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,181 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// quarantineDir is the subdirectory of the log directory that
+// RecoverQuarantine moves unreadable segment files into. Each file gets a
+// timestamp suffix, so repeated quarantines never clash, and a .report file
+// listing its problems.
+const quarantineDir = "corrupt"
+
+// quarantineSegments verifies every segment on Open and quarantines the
+// unreadable ones, along with the segments that cannot follow them without
+// breaking index continuity. The tail only needs a readable header, damage
+// to its entries is recovered by openLastSegment. It reports whether any
+// segment was removed from the log.
+func (l *Log) quarantineSegments() (bool, error) {
+	problems := make([][]Problem, len(l.segments))
+	l.forEachSegment(len(l.segments), func(i int) error {
+		var report VerifyReport
+		seg := l.segments[i]
+		if i < len(l.segments)-1 {
+			l.verifySegment(&report, seg, l.segments[i+1].index-1, false)
+		} else if err := l.checkTailHeader(seg); err != nil {
+			report.Problems = append(report.Problems, Problem{Path: seg.path, Index: seg.index, Err: err})
+		}
+		problems[i] = report.Problems
+		return nil
+	})
+
+	var keep []*segment
+	var quarantined bool
+	for i, seg := range l.segments {
+		if len(problems[i]) == 0 {
+			keep = append(keep, seg)
+			continue
+		}
+
+		if err := l.quarantine(seg.path, problems[i]); err != nil {
+			return false, err
+		}
+		quarantined = true
+		if l.damage == nil {
+			l.damage = problems[i][0].Err
+		}
+
+		if len(keep) == 0 || l.config.AllowGaps {
+			continue
+		}
+
+		// The later segments would leave a gap in the log
+		for _, later := range l.segments[i+1:] {
+			err := fmt.Errorf("follows quarantined segment %s", filepath.Base(seg.path))
+			if err := l.quarantine(later.path, []Problem{{Path: later.path, Index: later.index, Err: err}}); err != nil {
+				return false, err
+			}
+		}
+		break
+	}
+
+	l.segments = keep
+
+	return quarantined, nil
+}
+
+// checkTailHeader checks that the header of the tail segment can be read.
+// A torn header is not a problem, Open writes it again.
+func (l *Log) checkTailHeader(tail *segment) error {
+	data, err := readSegmentFile(tail.path)
+	if err != nil {
+		return fmt.Errorf("failed to read last log segment file: %w", err)
+	}
+
+	if _, err := l.readSegmentHeader(data); err != nil && !errors.Is(err, errTornHeader) {
+		return locateCorruption(err, tail.path, tail.index, 0)
+	}
+
+	return nil
+}
+
+// quarantine moves the segment file at path into the quarantine directory
+// and writes a report of its problems next to it. Read-only logs leave the
+// file in place.
+func (l *Log) quarantine(path string, problems []Problem) error {
+	if l.config.ReadOnly {
+		return nil
+	}
+
+	target, err := l.writeQuarantineReport(path, problems)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("failed to quarantine log segment: %w", err)
+	}
+
+	if err := syncDir(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("failed to sync quarantine directory: %w", err)
+	}
+
+	return l.syncDir()
+}
+
+// quarantineCopy copies the segment file at path into the quarantine
+// directory and writes a report of its problems next to it, leaving the
+// original in place. Read-only logs do nothing.
+func (l *Log) quarantineCopy(path string, problems []Problem) error {
+	if l.config.ReadOnly {
+		return nil
+	}
+
+	target, err := l.writeQuarantineReport(path, problems)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open damaged log segment: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, l.config.FilePerms)
+	if err != nil {
+		return fmt.Errorf("failed to create quarantined log segment: %w", err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return noSpaceError(fmt.Errorf("failed to copy damaged log segment: %w", err))
+	}
+
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync quarantined log segment: %w", err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to close quarantined log segment: %w", err)
+	}
+
+	if err := syncDir(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("failed to sync quarantine directory: %w", err)
+	}
+
+	return nil
+}
+
+// writeQuarantineReport creates the quarantine directory and writes the
+// report for the segment file at path into it. It returns the path the
+// segment file is to be quarantined at.
+func (l *Log) writeQuarantineReport(path string, problems []Problem) (string, error) {
+	dir := filepath.Join(l.path, quarantineDir)
+	if err := os.MkdirAll(dir, l.config.DirPerms); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	target := filepath.Join(dir, filepath.Base(path)+"."+now.Format("20060102T150405.000000000Z"))
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "segment: %s\n", filepath.Base(path))
+	fmt.Fprintf(&report, "quarantined: %s\n", now.Format(time.RFC3339Nano))
+	for _, problem := range problems {
+		fmt.Fprintf(&report, "problem: %s\n", problem)
+	}
+
+	if err := writeFileSync(target+".report", []byte(report.String()), l.config.FilePerms); err != nil {
+		return "", noSpaceError(fmt.Errorf("failed to write quarantine report: %w", err))
+	}
+
+	return target, nil
+}
@@ -0,0 +1,90 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// quarantined returns the names of the files in the quarantine directory of
+// the log at dir.
+func quarantined(tb testing.TB, dir string) []string {
+	tb.Helper()
+	files, err := os.ReadDir(filepath.Join(dir, quarantineDir))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+// TestQuarantine checks that RecoverQuarantine moves a damaged sealed
+// segment aside with a report, and continues with the segments index
+// continuity allows.
+func TestQuarantine(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		damaged   int  // Position of the damaged segment
+		allowGaps bool // Whether the log allows gaps
+	}{
+		{"front", 0, false},
+		{"middle", 2, false},
+		{"gap", 2, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{SegmentSize: 1024, AllowGaps: test.allowGaps}
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			damaged := l.segments[test.damaged]
+			end := l.segments[test.damaged+1].index - 1
+			corruptEntry(t, damaged.path, damaged.index+1)
+
+			cfg.Recovery = RecoverQuarantine
+			l = openTest(t, dir, cfg)
+			if err := l.Damage(); !errors.Is(err, errChecksum) {
+				t.Fatalf("damage %v, want errChecksum", err)
+			}
+			if _, err := os.Stat(damaged.path); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("damaged segment left in place: %v", err)
+			}
+			names := quarantined(t, dir)
+			base := filepath.Base(damaged.path)
+			if len(names) < 2 || !strings.HasPrefix(names[0], base) || !strings.HasSuffix(names[1], ".report") {
+				t.Fatalf("quarantined %v, want %s and its report first", names, base)
+			}
+
+			switch {
+			case test.damaged == 0:
+				checkEntries(t, l, end+1, 500)
+			case test.allowGaps:
+				for i := uint64(1); i <= 500; i++ {
+					_, err := l.Read(i)
+					if missing := i >= damaged.index && i <= end; missing != errors.Is(err, ErrNotFound) {
+						t.Fatalf("read %d: got %v", i, err)
+					}
+				}
+			default:
+				checkEntries(t, l, 1, damaged.index-1)
+			}
+
+			last, err := l.LastIndex()
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeEntries(t, l, last+1, last+10)
+			l = reopenTest(t, l, dir, Config{SegmentSize: 1024, AllowGaps: test.allowGaps})
+			if err := l.Damage(); err != nil {
+				t.Fatalf("damage %v after reopening, want none", err)
+			}
+		})
+	}
+}
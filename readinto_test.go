@@ -0,0 +1,108 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// readIntoConfigs are the configurations the ReadInto tests run with,
+// covering the ways a sealed segment is loaded and entries are stored.
+var readIntoConfigs = map[string]Config{
+	"raw":        {},
+	"mmap":       {MmapSegments: true},
+	"compressed": {Compression: CompressionSnappy, CompressionThreshold: 1},
+	"segments":   {SegmentCompression: CompressionZstd},
+	"streamed":   {StreamSegmentBytes: 100},
+	"sparse":     {SparseIndexInterval: 8},
+}
+
+// TestReadInto checks that ReadInto and ReaderFor return the entries Read
+// does, from the tail and from sealed segments.
+func TestReadInto(t *testing.T) {
+	for name, cfg := range readIntoConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 4096
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 1000)
+			l = reopenTest(t, l, dir, cfg)
+
+			for i := uint64(1); i <= 1000; i += 7 {
+				var buf bytes.Buffer
+				n, err := l.ReadInto(i, &buf)
+				if err != nil {
+					t.Fatalf("read %d: %v", i, err)
+				}
+				if n != int64(buf.Len()) || !bytes.Equal(buf.Bytes(), testEntry(i)) {
+					t.Fatalf("read %d: got %q, %d bytes written; want %q", i, buf.Bytes(), n, testEntry(i))
+				}
+
+				r, err := l.ReaderFor(i)
+				if err != nil {
+					t.Fatalf("reader %d: %v", i, err)
+				}
+				data, err := io.ReadAll(r)
+				if err != nil || !bytes.Equal(data, testEntry(i)) {
+					t.Fatalf("reader %d: got %q, %v; want %q", i, data, err, testEntry(i))
+				}
+			}
+
+			if _, err := l.ReadInto(5000, io.Discard); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("read past the end: got %v, want ErrNotFound", err)
+			}
+			if _, err := l.ReaderFor(5000); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("reader past the end: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+// recordingWriter keeps the buffers written to it and takes at most limit
+// bytes of each, when limit is positive.
+type recordingWriter struct {
+	writes [][]byte
+	limit  int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, p)
+	if w.limit > 0 && len(p) > w.limit {
+		return w.limit, nil
+	}
+	return len(p), nil
+}
+
+// TestReadIntoNoCopy checks that entries stored as they are reach the
+// writer straight from the cache, not through a copy of their own.
+func TestReadIntoNoCopy(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 10)
+
+	var w recordingWriter
+	for round := 0; round < 2; round++ {
+		if _, err := l.ReadInto(5, &w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if &w.writes[0][0] != &w.writes[1][0] {
+		t.Fatal("entry copied for each read")
+	}
+}
+
+// TestReadIntoShortWrite checks that a writer taking fewer bytes than the
+// entry holds fails ReadInto with io.ErrShortWrite and the count it took.
+func TestReadIntoShortWrite(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 10)
+
+	w := recordingWriter{limit: 3}
+	n, err := l.ReadInto(5, &w)
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("got %v, want io.ErrShortWrite", err)
+	}
+	if n != 3 {
+		t.Fatalf("got %d bytes written, want 3", n)
+	}
+}
@@ -0,0 +1,106 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirContents returns the contents of the files in dir by name.
+func dirContents(tb testing.TB, dir string) map[string]string {
+	tb.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	contents := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		contents[file.Name()] = string(data)
+	}
+	return contents
+}
+
+// TestReadOnly checks that a read-only log reads the entries, rejects
+// every change and leaves the files as they were.
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before := dirContents(t, dir)
+
+	cfg.ReadOnly = true
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 1, 500)
+
+	var b Batch
+	b.Write(501, nil)
+	changes := map[string]error{
+		"write":          l.Write(501, nil),
+		"batch":          l.WriteBatch(&b),
+		"truncate front": l.TruncateFront(10),
+		"truncate back":  l.TruncateBack(10),
+		"reset":          l.Reset(1),
+	}
+	for name, err := range changes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %v, want ErrReadOnly", name, err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	after := dirContents(t, dir)
+	if len(after) != len(before) {
+		t.Fatalf("got %d files after a read-only open, want %d", len(after), len(before))
+	}
+	for name, data := range before {
+		if after[name] != data {
+			t.Fatalf("file %s changed by a read-only open", name)
+		}
+	}
+}
+
+// TestReadOnlyAlongsideWriter checks that a log can be opened read-only
+// while it is open for writing, and sees the entries written before.
+func TestReadOnlyAlongsideWriter(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTest(t, dir, Config{ReadOnly: true})
+	checkEntries(t, r, 1, 10)
+	writeEntries(t, l, 11, 20)
+	checkEntries(t, r, 1, 10)
+}
+
+// TestReadOnlyEmpty checks that a read-only open of an empty directory
+// presents an empty log without creating any files.
+func TestReadOnlyEmpty(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{ReadOnly: true})
+	checkEntries(t, l, 0, 0)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := dirContents(t, dir); len(files) != 0 {
+		t.Fatalf("read-only open created %d files", len(files))
+	}
+}
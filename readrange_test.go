@@ -0,0 +1,159 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+// checkRange checks that datas holds the test entries from lo on.
+func checkRange(tb testing.TB, datas [][]byte, lo, hi uint64) {
+	tb.Helper()
+	if uint64(len(datas)) != hi-lo+1 {
+		tb.Fatalf("got %d entries, want %d", len(datas), hi-lo+1)
+	}
+	for i, data := range datas {
+		if index := lo + uint64(i); !bytes.Equal(data, testEntry(index)) {
+			tb.Fatalf("entry %d: got %q, want %q", index, data, testEntry(index))
+		}
+	}
+}
+
+// TestReadRange checks ReadRange and ReadRangeInto within and across
+// segments, and their bounds.
+func TestReadRange(t *testing.T) {
+	configs := map[string]Config{
+		"raw":        {},
+		"compressed": {MmapSegments: true, Compression: CompressionSnappy, CompressionThreshold: 1},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 4096
+			l := openTest(t, t.TempDir(), cfg)
+			writeEntries(t, l, 1, 1000)
+
+			var bufs [][]byte
+			var arena []byte
+			for lo := uint64(1); lo <= 900; lo += 50 {
+				datas, err := l.ReadRange(lo, lo+99)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkRange(t, datas, lo, lo+99)
+
+				bufs, arena, err = l.ReadRangeInto(lo, lo+99, bufs[:0], arena[:0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkRange(t, bufs, lo, lo+99)
+			}
+
+			bounds := [][2]uint64{{0, 5}, {6, 5}, {990, 1010}, {5, math.MaxUint64}}
+			for _, b := range bounds {
+				if _, err := l.ReadRange(b[0], b[1]); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("range %d-%d: got %v, want ErrNotFound", b[0], b[1], err)
+				}
+				if _, _, err := l.ReadRangeInto(b[0], b[1], nil, nil); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("range %d-%d into buffers: got %v, want ErrNotFound", b[0], b[1], err)
+				}
+			}
+		})
+	}
+}
+
+// TestReadRangeIntoReuse checks that ReadRangeInto fills the buffers passed
+// in when they are large enough, rather than allocating new ones.
+func TestReadRangeIntoReuse(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 100)
+
+	bufs := make([][]byte, 0, 10)
+	arena := make([]byte, 0, 1024)
+	got, gotArena, err := l.ReadRangeInto(11, 20, bufs, arena)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRange(t, got, 11, 20)
+	if &got[:1][0] != &bufs[:1][0] {
+		t.Fatal("entry slices not stored in the buffers passed in")
+	}
+	if &gotArena[0] != &arena[:1][0] {
+		t.Fatal("entry data not stored in the arena passed in")
+	}
+	var off int
+	for i, data := range got {
+		if &data[0] != &gotArena[off] {
+			t.Fatalf("entry %d does not point into the arena", 11+i)
+		}
+		off += len(data)
+	}
+}
+
+// TestReadRangeIntoShortBuffers checks that buffers too short for the range
+// are grown, keeping what they held, and that a failed read returns them
+// as they were passed in.
+func TestReadRangeIntoShortBuffers(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 100)
+
+	bufs := [][]byte{[]byte("kept")}
+	arena := []byte("kept")
+	got, gotArena, err := l.ReadRangeInto(1, 50, bufs, arena)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got[0]) != "kept" || string(gotArena[:4]) != "kept" {
+		t.Fatalf("contents passed in not kept: %q, %q", got[0], gotArena[:4])
+	}
+	checkRange(t, got[1:], 1, 50)
+
+	// A failed read leaves them as they were
+	got, gotArena, err = l.ReadRangeInto(90, 110, got, gotArena)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if len(got) != 51 || len(gotArena) != len(arena)+sumLen(1, 50) {
+		t.Fatalf("buffers changed on error: %d slices, %d bytes", len(got), len(gotArena))
+	}
+}
+
+// sumLen returns the total size of the test entries first through last.
+func sumLen(first, last uint64) int {
+	var n int
+	for i := first; i <= last; i++ {
+		n += len(testEntry(i))
+	}
+	return n
+}
+
+// TestReadRangeSealed checks that ReadRange returns copies of entries
+// spread over sealed segments of every kind after reopening.
+func TestReadRangeSealed(t *testing.T) {
+	configs := map[string]Config{
+		"raw":      {},
+		"mmap":     {MmapSegments: true},
+		"segments": {SegmentCompression: CompressionZstd},
+		"streamed": {StreamSegmentBytes: 100},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			l = reopenTest(t, l, dir, cfg)
+
+			datas, err := l.ReadRange(1, 500)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRange(t, datas, 1, 500)
+
+			for _, data := range datas {
+				clear(data)
+			}
+			checkEntries(t, l, 1, 500)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// recyclePrefix names the files of the recycle pool. The names are too
+// short to be mistaken for segments, so Open leaves them alone apart from
+// picking them up for the pool.
+const recyclePrefix = "RECYCLED."
+
+// recyclePool holds the files of deleted segments kept for reuse as new
+// tail segments with RecycleSegments. It has its own lock as the spare
+// segment file is created without holding the log lock.
+type recyclePool struct {
+	mu    sync.Mutex
+	limit int      // Max files kept
+	paths []string // Pooled files, oldest first
+	next  uint64   // Number for the next pooled file name
+}
+
+// loadRecyclePool picks up the files pooled when the log was last open, and
+// removes those beyond RecycleSegments.
+func (l *Log) loadRecyclePool() error {
+	files, err := os.ReadDir(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	p := &l.recycled
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, recyclePrefix) {
+			continue
+		}
+
+		path := filepath.Join(l.path, name)
+		n, err := strconv.ParseUint(name[len(recyclePrefix):], 10, 64)
+		if err != nil || len(p.paths) >= p.limit {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove recycled log segment: %w", err)
+			}
+			continue
+		}
+
+		p.paths = append(p.paths, path)
+		p.next = max(p.next, n+1)
+	}
+
+	return nil
+}
+
+// discardSegmentFile gets rid of the file of a deleted segment, moving it to
+// the recycle pool when there is room and removing it otherwise. The caller
+// is responsible for syncing the directory.
+func (l *Log) discardSegmentFile(seg *segment) error {
+	path := seg.path
+	if err := l.removeSparseIndex(path); err != nil {
+		return err
+	}
+
+	p := &l.recycled
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.paths) < p.limit && l.recyclable(seg) {
+		pooled := filepath.Join(l.path, recyclePrefix+strconv.FormatUint(p.next, 10))
+		if err := os.Rename(path, pooled); err == nil {
+			p.paths = append(p.paths, pooled)
+			p.next++
+			return nil
+		} else if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		// Fall back to removing it
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// recyclable reports whether the file of a deleted segment can be reused.
+// Files readers may still read from would give them the data of the new
+// segment, or fault once it is truncated.
+func (l *Log) recyclable(seg *segment) bool {
+	return !l.readInPlace(seg)
+}
+
+// readInPlace reports whether readers may read from the file of a sealed
+// segment in place, rather than from a copy, which is the case for raw
+// files mapped with MmapSegments or streamed with StreamSegmentBytes.
+func (l *Log) readInPlace(seg *segment) bool {
+	if segmentFileCompression(seg.path) != CompressionNone {
+		return false
+	}
+	return l.config.MmapSegments || (l.config.StreamSegmentBytes > 0 && seg.size > l.config.StreamSegmentBytes)
+}
+
+// takeRecycled renames a file from the recycle pool to path, and reports
+// whether there was one. The file still holds the data of the segment it
+// was deleted with and has to be truncated before use, as entries past the
+// end of the new data would otherwise be read back on Open.
+func (l *Log) takeRecycled(path string) bool {
+	p := &l.recycled
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.paths) > 0 {
+		pooled := p.paths[len(p.paths)-1]
+		p.paths = p.paths[:len(p.paths)-1]
+		if err := os.Rename(pooled, path); err == nil {
+			l.unprotectSegmentFile(path)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recycledFiles returns the files of the recycle pool in dir.
+func recycledFiles(tb testing.TB, dir string) []os.FileInfo {
+	tb.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var recycled []os.FileInfo
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), recyclePrefix) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, file.Name()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		recycled = append(recycled, info)
+	}
+	return recycled
+}
+
+// TestRecycleSegments checks that the files of deleted segments are pooled
+// up to RecycleSegments and reused for new segments, without their old
+// entries being read back.
+func TestRecycleSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, RecycleSegments: 2}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.TruncateFront(400); err != nil {
+		t.Fatal(err)
+	}
+	pooled := recycledFiles(t, dir)
+	if len(pooled) != 2 {
+		t.Fatalf("got %d recycled files, want 2", len(pooled))
+	}
+
+	writeEntries(t, l, 501, 800)
+	if n := len(recycledFiles(t, dir)); n != 0 {
+		t.Fatalf("%d recycled files left, want all reused", n)
+	}
+	for _, info := range pooled {
+		reused := false
+		for _, seg := range l.segments {
+			if segInfo, err := os.Stat(seg.path); err == nil && os.SameFile(info, segInfo) {
+				reused = true
+			}
+		}
+		if !reused {
+			t.Fatalf("recycled file %s not reused", info.Name())
+		}
+	}
+	checkEntries(t, l, 400, 800)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 400, 800)
+	checkVerify(t, l)
+}
+
+// TestRecycleSegmentsReopen checks that pooled files are picked up again
+// by Open, which removes those beyond RecycleSegments.
+func TestRecycleSegmentsReopen(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, RecycleSegments: 3}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.TruncateFront(400); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.RecycleSegments = 1
+	l = reopenTest(t, l, dir, cfg)
+	if n := len(recycledFiles(t, dir)); n != 1 {
+		t.Fatalf("got %d recycled files, want 1", n)
+	}
+	writeEntries(t, l, 501, 600)
+	if n := len(recycledFiles(t, dir)); n != 0 {
+		t.Fatalf("%d recycled files left, want all reused", n)
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 400, 600)
+}
+
+// TestRecycleSegmentsInPlace checks that the files of mapped segments,
+// which readers may still read from, are not recycled.
+func TestRecycleSegmentsInPlace(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, RecycleSegments: 2, MmapSegments: true}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.TruncateFront(400); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recycledFiles(t, dir)); n != 0 {
+		t.Fatalf("got %d recycled files, want none", n)
+	}
+	checkEntries(t, l, 400, 500)
+}
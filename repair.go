@@ -0,0 +1,200 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RepairReport is the result of Repair.
+type RepairReport struct {
+	Problems  []Problem // Problems found, as reported by Verify
+	FirstLost uint64    // First index removed by the repair, 0 when nothing was lost
+	LastLost  uint64    // Last index removed by the repair
+}
+
+// Repair verifies the log like Verify and salvages it by keeping the longest
+// valid prefix: the first corrupt entry and everything after it are removed,
+// as with TruncateBack. Damaged segment footers are rebuilt without losing
+// any entries. The report lists the problems found and the indexes that were
+// lost. Repairs are crash safe, an interrupted repair is completed by Open.
+func (l *Log) Repair() (*RepairReport, error) {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return nil, ErrCorrupt
+	} else if l.closed {
+		return nil, ErrClosed
+	} else if l.config.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	report := &RepairReport{}
+	for i, seg := range l.segments {
+		end := ^uint64(0)
+		if i < len(l.segments)-1 {
+			end = l.segments[i+1].index - 1
+		}
+
+		var found VerifyReport
+		l.verifySegment(&found, seg, end, i == len(l.segments)-1)
+		report.Problems = append(report.Problems, found.Problems...)
+		if found.OK() {
+			continue
+		}
+
+		footerOnly := true
+		for _, problem := range found.Problems {
+			footerOnly = footerOnly && errors.Is(problem.Err, errFooter)
+		}
+
+		if footerOnly {
+			if err := l.rebuildFooter(seg); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		return report, l.truncateCorrupt(report, i, end)
+	}
+
+	return report, nil
+}
+
+// truncateCorrupt removes the first corrupt entry of the segment at segIdx,
+// which may hold entries up to the end index, along with every later entry.
+func (l *Log) truncateCorrupt(report *RepairReport, segIdx int, end uint64) error {
+	lastIndex := l.lastIndex
+
+	// Find the valid entries in front of the corruption, going back to an
+	// earlier segment when there are none
+	var keep *segment
+	keepIdx := segIdx
+	if data, err := readSegmentFile(l.segments[segIdx].path); err == nil {
+		if header, err := l.readSegmentHeader(data); err == nil {
+			positions, _, _ := parseSegmentEntries(data, header.size, header.sum)
+			seg := l.segments[segIdx]
+			if uint64(len(positions)) > end-seg.index+1 {
+				positions = positions[:end-seg.index+1]
+			}
+			keep = &segment{
+				index: seg.index,
+				path:  seg.path,
+				cbuf:  data,
+				cpos:  positions,
+				sum:   header.sum,
+				hdr:   header.size,
+			}
+		}
+	}
+
+	for keep == nil || len(keep.cpos) == 0 {
+		keepIdx--
+		if keepIdx < 0 {
+			// Nothing is left, start over at the first index
+			report.FirstLost, report.LastLost = l.firstIndex, lastIndex
+			return l.reset(l.firstIndex)
+		}
+
+		keep = &segment{
+			index: l.segments[keepIdx].index,
+			path:  l.segments[keepIdx].path,
+		}
+		if err := l.loadSegmentEntries(keep); err != nil {
+			return err
+		}
+	}
+
+	index := keep.index + uint64(len(keep.cpos)) - 1
+	if index < l.firstIndex {
+		// Only entries already removed by TruncateFront are left
+		report.FirstLost, report.LastLost = l.firstIndex, lastIndex
+		return l.reset(l.firstIndex)
+	}
+
+	if err := l.truncateBackSegment(keepIdx, keep, index); err != nil {
+		return err
+	}
+
+	report.FirstLost, report.LastLost = index+1, lastIndex
+	return nil
+}
+
+// rebuildFooter replaces the damaged footer of a sealed segment. The new
+// file is written next to it and renamed over it, so a crash leaves either
+// one in place.
+func (l *Log) rebuildFooter(seg *segment) error {
+	data, err := readSegmentFile(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to read log segment file: %w", err)
+	}
+
+	header, err := l.readSegmentHeader(data)
+	if err != nil {
+		return err
+	}
+
+	positions, valid, err := parseSegmentEntries(data, header.size, header.sum)
+	if err != nil {
+		return err
+	}
+
+	rebuilt := appendSegmentFooter(data[:valid:valid], data[:valid], seg.index, positions, header.sum)
+
+	if err := l.replaceSegmentFile(seg, rebuilt); err != nil {
+		return fmt.Errorf("failed to replace repaired log segment: %w", err)
+	}
+
+	l.recordSegmentSizes()
+	l.clearCache()
+
+	return nil
+}
+
+// RecoverCorrupt returns a log flagged corrupt, after a failure that left
+// its in-memory state inconsistent with the disk, to a usable state without
+// reopening it. The segments are loaded again from disk as Open does,
+// completing interrupted truncations and dealing with a damaged tail
+// according to Config.Recovery, which Damage reports afterwards. It does
+// nothing when the log is not corrupt, and the log stays flagged when the
+// reload fails.
+func (l *Log) RecoverCorrupt() error {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.closed {
+		return ErrClosed
+	} else if !l.corrupt.Load() {
+		return nil
+	}
+
+	// The tail may have been closed already by the failed operation
+	if l.sfile != nil {
+		l.sfile.Close()
+		l.sfile = nil
+	}
+	l.dropSpare()
+
+	segments := l.segments
+	l.segments = nil
+	l.damage = nil
+	l.clearCache()
+
+	if err := l.loadSegments(); err != nil {
+		if l.sfile != nil {
+			l.sfile.Close()
+			l.sfile = nil
+		}
+		l.segments = segments
+		return fmt.Errorf("failed to recover corrupt log: %w", err)
+	}
+
+	l.corrupt.Store(false)
+	l.lowerDurable(l.lastIndex)
+	if l.config.ReadOnly {
+		l.durable.Store(l.lastIndex)
+		return nil
+	}
+
+	return l.syncTail()
+}
@@ -0,0 +1,136 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// checkVerify checks that the log verifies without problems.
+func checkVerify(tb testing.TB, l *Log) {
+	tb.Helper()
+	report, err := l.Verify()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if !report.OK() {
+		tb.Fatalf("log does not verify: %v", report.Problems)
+	}
+}
+
+// TestRepair checks that Repair keeps the entries before the first damaged
+// one, reports the ones it removed, and leaves a log that verifies and takes
+// new writes.
+func TestRepair(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	index := l.segments[1].index + 2
+	corruptEntry(t, l.segments[1].path, index)
+
+	report, err := l.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) == 0 {
+		t.Fatal("repair found no problems")
+	}
+	if report.FirstLost != index || report.LastLost != 500 {
+		t.Fatalf("lost %d to %d, want %d to 500", report.FirstLost, report.LastLost, index)
+	}
+	checkEntries(t, l, 1, index-1)
+	checkVerify(t, l)
+
+	writeEntries(t, l, index, 600)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 600)
+	checkVerify(t, l)
+}
+
+// TestRepairFooter checks that Repair rebuilds a damaged footer without
+// losing any entries.
+func TestRepairFooter(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	sealed := l.segments[0]
+	data, err := os.ReadFile(sealed.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer, ok := decodeSegmentFooter(data, segmentHeaderSize, sealed.index, l.config.Checksum)
+	if !ok {
+		t.Fatal("sealed segment has no footer")
+	}
+	data[footer.off+30] ^= 0xff
+	if err := os.WriteFile(sealed.path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := l.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) == 0 || report.FirstLost != 0 {
+		t.Fatalf("got problems %v and lost %d, want problems and nothing lost", report.Problems, report.FirstLost)
+	}
+	checkEntries(t, l, 1, 500)
+	checkVerify(t, l)
+}
+
+// TestRepairIntact checks that Repair leaves an intact log alone.
+func TestRepairIntact(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	report, err := l.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 || report.FirstLost != 0 {
+		t.Fatalf("got problems %v and lost %d from an intact log", report.Problems, report.FirstLost)
+	}
+	checkEntries(t, l, 1, 500)
+}
+
+// TestRecoverCorrupt checks that RecoverCorrupt reloads a log flagged
+// corrupt from disk, dealing with a torn tail, and makes it writable again.
+func TestRecoverCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.RecoverCorrupt(); err != nil {
+		t.Fatalf("recover of a healthy log: %v", err)
+	}
+
+	// Leave the tail torn on disk, as a failed write that could not be
+	// rolled back would
+	l.mu.Lock()
+	l.markCorrupt(errors.New("failed to roll back tail segment"))
+	tail := l.segments[len(l.segments)-1]
+	l.mu.Unlock()
+	info, err := os.Stat(tail.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(tail.path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(501, testEntry(501)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("write to a corrupt log: got %v, want ErrCorrupt", err)
+	}
+
+	if err := l.RecoverCorrupt(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Damage(); !errors.Is(err, errTornEntry) {
+		t.Fatalf("damage %v, want errTornEntry", err)
+	}
+	checkEntries(t, l, 1, 499)
+	if durable := l.DurableIndex(); durable != 499 {
+		t.Fatalf("durable index %d, want 499", durable)
+	}
+	writeEntries(t, l, 500, 600)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 600)
+}
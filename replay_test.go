@@ -0,0 +1,77 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestReplay checks that Replay passes every entry to fn, oldest first,
+// across segments of every kind.
+func TestReplay(t *testing.T) {
+	for name, cfg := range cursorConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			l = reopenTest(t, l, dir, cfg)
+			if err := l.TruncateFront(10); err != nil {
+				t.Fatal(err)
+			}
+
+			next := uint64(10)
+			err := l.Replay(func(index uint64, data []byte) error {
+				if index != next {
+					t.Fatalf("replayed %d, want %d", index, next)
+				}
+				if !bytes.Equal(data, testEntry(index)) {
+					t.Fatalf("entry %d: got %q, want %q", index, data, testEntry(index))
+				}
+				next++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != 501 {
+				t.Fatalf("replay stopped before %d, want 501", next)
+			}
+		})
+	}
+}
+
+// TestReplayStop checks that Replay stops at the first error returned by
+// fn and returns it.
+func TestReplayStop(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+
+	stop := errors.New("stop")
+	var replayed int
+	err := l.Replay(func(index uint64, data []byte) error {
+		replayed++
+		if index == 300 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("got %v, want the error returned by fn", err)
+	}
+	if replayed != 300 {
+		t.Fatalf("replayed %d entries, want 300", replayed)
+	}
+}
+
+// TestReplayEmpty checks that replaying an empty log calls fn for nothing.
+func TestReplayEmpty(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	err := l.Replay(func(index uint64, data []byte) error {
+		t.Fatalf("replayed %d from an empty log", index)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
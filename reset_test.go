@@ -0,0 +1,94 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestReset checks that Reset removes every entry and segment and that the
+// log continues at the new first index, before and after reopening.
+func TestReset(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	old := l.segments
+
+	if err := l.Reset(1000); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := l.LastIndex(); err != nil || got != 999 {
+		t.Fatalf("last index %d, %v after reset; want 999", got, err)
+	}
+	if l.Len() != 0 {
+		t.Fatalf("%d entries after reset, want none", l.Len())
+	}
+	if _, err := l.Read(500); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("read of a reset entry: got %v, want ErrNotFound", err)
+	}
+	for _, seg := range old {
+		if _, err := os.Stat(seg.path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("segment %s left after reset: %v", seg.path, err)
+		}
+	}
+	if err := l.Write(1, testEntry(1)); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("write before the new first index: got %v, want ErrOutOfOrder", err)
+	}
+
+	writeEntries(t, l, 1000, 1100)
+	checkEntries(t, l, 1000, 1100)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1000, 1100)
+}
+
+// TestResetEmpty checks that a reset with nothing written after it is kept
+// by Open.
+func TestResetEmpty(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Reset(50); err != nil {
+		t.Fatal(err)
+	}
+
+	l = reopenTest(t, l, dir, Config{})
+	if got, err := l.LastIndex(); err != nil || got != 49 {
+		t.Fatalf("last index %d, %v; want 49", got, err)
+	}
+	writeEntries(t, l, 50, 50)
+	checkEntries(t, l, 50, 50)
+}
+
+// TestResetInvalid checks that Reset rejects index zero and leaves the log
+// as it was.
+func TestResetInvalid(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 10)
+	if err := l.Reset(0); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("reset to 0: got %v, want ErrOutOfRange", err)
+	}
+	checkEntries(t, l, 1, 10)
+}
+
+// TestResetInterrupted checks that Open completes a reset that was recorded
+// in the manifest before a crash.
+func TestResetInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+
+	// Crash right after the reset was recorded
+	if err := l.writeManifest(nil, 1000, 0); err != nil {
+		t.Fatal(err)
+	}
+	crashed := crashCopy(t, dir)
+
+	l = openTest(t, crashed, Config{})
+	if got, err := l.LastIndex(); err != nil || got != 999 {
+		t.Fatalf("last index %d, %v; want 999", got, err)
+	}
+	writeEntries(t, l, 1000, 1010)
+	l = reopenTest(t, l, crashed, Config{})
+	checkEntries(t, l, 1000, 1010)
+}
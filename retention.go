@@ -0,0 +1,176 @@
+package jellywal
+
+import "time"
+
+// startJanitor starts the background goroutine that deletes the segments
+// older than RetentionAge, so they are deleted even when no segment is
+// sealed for a while, and archives the segments retention is to delete
+// with an Archiver.
+func (l *Log) startJanitor() {
+	if l.config.ReadOnly || l.config.RetentionAge <= 0 && (l.config.Archiver == nil || l.config.MaxDiskBytes <= 0) {
+		return
+	}
+
+	interval := time.Minute
+	if l.config.RetentionAge > 0 {
+		interval = min(max(l.config.RetentionAge/10, time.Millisecond), time.Minute)
+	}
+
+	l.janitorStop = make(chan struct{})
+	l.janitorDone = make(chan struct{})
+	l.janitorKick = make(chan struct{}, 1)
+	go l.runJanitor(l.janitorStop, l.janitorDone, l.janitorKick, interval)
+}
+
+// stopJanitor signals the janitor to stop and returns a channel that is
+// closed once it has exited, or nil when no janitor is running. The caller
+// must hold the write lock.
+func (l *Log) stopJanitor() chan struct{} {
+	if l.janitorStop == nil {
+		return nil
+	}
+
+	close(l.janitorStop)
+	done := l.janitorDone
+	l.janitorStop = nil
+	l.janitorDone = nil
+	l.janitorKick = nil
+
+	return done
+}
+
+func (l *Log) runJanitor(stop, done, kick chan struct{}, interval time.Duration) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-kick:
+		}
+
+		var err error
+		if l.config.Archiver != nil {
+			err = l.archiveExpired()
+		}
+
+		l.lockSegments()
+		pending := l.deleteExpired()
+		l.unlockSegments()
+
+		if pending && err == nil {
+			// More segments expired while archiving, a failing archiver
+			// waits for the next seal or tick instead
+			select {
+			case kick <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// kickJanitor wakes the janitor up to archive the segments retention is to
+// delete. The caller must hold the write lock.
+func (l *Log) kickJanitor() {
+	select {
+	case l.janitorKick <- struct{}{}:
+	default:
+	}
+}
+
+// archiveExpired archives the segments retention is to delete without
+// holding the log lock, so the next enforceRetention deletes them. Like
+// retention it is best effort, a failure is retried at the next run.
+func (l *Log) archiveExpired() error {
+	l.archiveMu.Lock()
+	defer l.archiveMu.Unlock()
+
+	l.mu.RLock()
+	var pending []pendingSegment
+	if keep := l.expiredSegments(); keep > 0 {
+		pending = l.unarchived(l.segments[:keep], l.segments[keep])
+	}
+	l.mu.RUnlock()
+
+	return l.archivePending(pending)
+}
+
+// enforceRetention deletes the oldest segments while the log is over
+// MaxDiskBytes or they are older than RetentionAge. Only whole segments are
+// deleted, and never the one holding the last entry, so the log keeps at
+// least its newest entries whatever the limits. With an Archiver only the
+// segments archived already are deleted, the janitor is woken up to
+// archive the others. Retention is best effort: a failure leaves the
+// segments in place and the next seal or janitor run tries again. The
+// caller must hold the write lock.
+func (l *Log) enforceRetention() {
+	if l.deleteExpired() {
+		l.kickJanitor()
+	}
+}
+
+// deleteExpired deletes the segments retention is to delete, only those
+// archived already with an Archiver, and reports whether any are left to
+// archive. The caller must hold the write lock.
+func (l *Log) deleteExpired() bool {
+	keep := l.expiredSegments()
+	pending := false
+	if l.config.Archiver != nil {
+		archived := 0
+		for archived < keep && l.isArchived(l.segments[archived]) {
+			archived++
+		}
+		pending = archived < keep
+		keep = archived
+	}
+
+	if keep == 0 {
+		return pending
+	}
+
+	first, index := l.firstIndex, l.segments[keep].index
+	if err := l.truncateFront(index); err != nil {
+		return pending
+	}
+
+	if l.config.OnRetention != nil {
+		l.config.OnRetention(first, index-1)
+	}
+	return pending
+}
+
+// expiredSegments returns the number of segments at the front of the log
+// that retention deletes. The caller must hold the read lock.
+func (l *Log) expiredSegments() int {
+	if l.config.ReadOnly || l.config.MaxDiskBytes <= 0 && l.config.RetentionAge <= 0 || l.closed || l.corrupt.Load() || l.lastIndex == 0 {
+		return 0
+	}
+
+	// The tail is cached, the sealed segments track their file size
+	tail := l.segments[len(l.segments)-1]
+	total := int64(len(tail.cbuf))
+	for _, seg := range l.segments[:len(l.segments)-1] {
+		total += seg.size
+	}
+
+	keep := 0
+	for keep < len(l.segments)-1 && l.segments[keep+1].index <= l.lastIndex && l.expired(l.segments[keep], total) {
+		total -= l.segments[keep].size
+		keep++
+	}
+
+	return keep
+}
+
+// expired reports whether retention deletes the sealed segment, the oldest
+// one left, with the log taking up total bytes.
+func (l *Log) expired(seg *segment, total int64) bool {
+	if l.config.MaxDiskBytes > 0 && total > l.config.MaxDiskBytes {
+		return true
+	}
+	return l.config.RetentionAge > 0 && !seg.seal.IsZero() && time.Since(seg.seal) >= l.config.RetentionAge
+}
@@ -0,0 +1,166 @@
+package jellywal
+
+import (
+	"testing"
+	"time"
+)
+
+// retained records the ranges of indexes retention reports deleting.
+type retained struct {
+	ranges [][2]uint64
+}
+
+func (r *retained) record(first, last uint64) {
+	r.ranges = append(r.ranges, [2]uint64{first, last})
+}
+
+// check checks that the ranges are contiguous and cover the indexes before
+// first.
+func (r *retained) check(tb testing.TB, first uint64) {
+	tb.Helper()
+	next := uint64(1)
+	for _, rng := range r.ranges {
+		if rng[0] != next || rng[1] < rng[0] {
+			tb.Fatalf("retention reported %v, want ranges from %d", r.ranges, next)
+		}
+		next = rng[1] + 1
+	}
+	if next != first {
+		tb.Fatalf("retention reported %v, want up to %d", r.ranges, first-1)
+	}
+}
+
+// TestMaxDiskBytes checks that the oldest segments are deleted once the log
+// exceeds its maximum size, and that the hook is told what was deleted.
+func TestMaxDiskBytes(t *testing.T) {
+	dir := t.TempDir()
+	var r retained
+	cfg := Config{SegmentSize: 1024, MaxDiskBytes: 4096, OnRetention: r.record}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 1000)
+
+	if size := diskSize(t, l); size > cfg.MaxDiskBytes+int64(cfg.SegmentSize)+64 {
+		t.Fatalf("log takes %d bytes, want about %d", size, cfg.MaxDiskBytes)
+	}
+	first, err := l.FirstIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == 1 {
+		t.Fatal("no segments deleted")
+	}
+	r.check(t, first)
+	checkEntries(t, l, first, 1000)
+
+	// Reopening counts the tail written since the last seal
+	l = reopenTest(t, l, dir, cfg)
+	reopened, err := l.FirstIndex()
+	if err != nil || reopened < first {
+		t.Fatalf("first index %d, %v after reopening; want at least %d", reopened, err, first)
+	}
+	r.check(t, reopened)
+	checkEntries(t, l, reopened, 1000)
+}
+
+// TestMaxDiskBytesKeepsLast checks that the segment holding the last entry
+// is kept whatever the limit.
+func TestMaxDiskBytesKeepsLast(t *testing.T) {
+	dir := t.TempDir()
+	var r retained
+	cfg := Config{SegmentSize: 1024, MaxDiskBytes: 1, OnRetention: r.record}
+	l := openTest(t, dir, cfg)
+	for i := uint64(1); i <= 500; i++ {
+		writeEntries(t, l, i, i)
+		if last, err := l.LastIndex(); err != nil || last != i {
+			t.Fatalf("last index %d, %v; want %d", last, err, i)
+		}
+		if _, err := l.Read(i); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+	}
+
+	first, err := l.FirstIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.check(t, first)
+	checkEntries(t, l, first, 500)
+	if len(l.segments) > 2 {
+		t.Fatalf("got %d segments, want at most 2", len(l.segments))
+	}
+}
+
+// TestMaxDiskBytesLowered checks that lowering the limit deletes segments
+// when the log is opened.
+func TestMaxDiskBytesLowered(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 1000)
+	segments := len(l.segments)
+
+	var r retained
+	cfg.MaxDiskBytes, cfg.OnRetention = 4096, r.record
+	l = reopenTest(t, l, dir, cfg)
+	if len(l.segments) >= segments {
+		t.Fatalf("%d segments left of %d, want fewer", len(l.segments), segments)
+	}
+	first, err := l.FirstIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.check(t, first)
+	checkEntries(t, l, first, 1000)
+}
+
+// TestRetentionAge checks that the janitor deletes the segments sealed
+// longer ago than the retention age, keeping the one holding the last
+// entry.
+func TestRetentionAge(t *testing.T) {
+	dir := t.TempDir()
+	var r retained
+	cfg := Config{SegmentSize: 1024, RetentionAge: 20 * time.Millisecond, OnRetention: r.record}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 200)
+	waitSegments(t, l, 1)
+
+	l.mu.RLock()
+	first := l.segments[0].index
+	r.check(t, first)
+	l.mu.RUnlock()
+	checkEntries(t, l, first, 200)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, first, 200)
+}
+
+// TestRetentionAgeSealTime checks that the seal times of segments survive
+// reopening, so segments are not kept longer than the retention age.
+func TestRetentionAgeSealTime(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 200)
+	var seals []time.Time
+	for _, seg := range l.segments[:len(l.segments)-1] {
+		if seg.seal.IsZero() {
+			t.Fatalf("segment %d has no seal time", seg.index)
+		}
+		seals = append(seals, seg.seal)
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	for i, seal := range seals {
+		if got := l.segments[i].seal; !got.Equal(seal) {
+			t.Fatalf("segment %d sealed at %v after reopening, want %v", l.segments[i].index, got, seal)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	cfg.RetentionAge = time.Since(seals[len(seals)-1]) / 2
+	l = reopenTest(t, l, dir, cfg)
+	if len(l.segments) != 1 {
+		t.Fatalf("got %d segments, want only the tail", len(l.segments))
+	}
+	checkEntries(t, l, l.segments[0].index, 200)
+}
@@ -0,0 +1,77 @@
+package jellywal
+
+import (
+	"io"
+	"time"
+)
+
+// RetryPolicy controls how tail writes and fsyncs that fail with transient
+// errors, such as EINTR or EAGAIN, are retried. Other errors are returned
+// right away.
+type RetryPolicy struct {
+	Attempts   int           // Max attempts including the first. Zero or one disables retries.
+	Backoff    time.Duration // Delay before the first retry, doubled for each further retry.
+	MaxBackoff time.Duration // Upper bound for the delay between retries.
+}
+
+// retry runs fn until it succeeds, fails with a non transient error or the
+// retry policy runs out of attempts. The caller must hold the write lock.
+func (l *Log) retry(op string, fn func() error) error {
+	policy := l.config.Retry
+	backoff := policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.Attempts || !isTransient(err) {
+			return err
+		}
+
+		if l.config.OnRetry != nil {
+			l.config.OnRetry(op, attempt, err)
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// writeTail writes the buffers to the tail segment file one after another
+// with as few vectored writes as the platform allows, resuming after the
+// bytes that made it to the file when a transient error is retried.
+func (l *Log) writeTail(bufs ...[]byte) error {
+	if l.alignsWrites(l.segments[len(l.segments)-1]) {
+		if l.config.DirectIO && directIOSupported {
+			return l.writeTailDirect(bufs)
+		}
+		return l.writeTailAligned(bufs)
+	}
+
+	return l.retry("write", func() error {
+		for bufs = consumeBuffers(bufs, 0); len(bufs) > 0; {
+			n, err := l.writevTail(bufs)
+			bufs = consumeBuffers(bufs, n)
+			if err != nil {
+				return err
+			} else if n == 0 {
+				return io.ErrShortWrite
+			}
+		}
+		return nil
+	})
+}
+
+// consumeBuffers drops the first n bytes from the buffers, along with the
+// empty buffers in front.
+func consumeBuffers(bufs [][]byte, n int) [][]byte {
+	for len(bufs) > 0 && n >= len(bufs[0]) {
+		n -= len(bufs[0])
+		bufs = bufs[1:]
+	}
+	if len(bufs) > 0 {
+		bufs[0] = bufs[0][n:]
+	}
+	return bufs
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+
+package jellywal
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRetry checks that transient errors are retried with the OnRetry hook
+// called for each, up to the attempts of the policy, and that other errors
+// are returned right away.
+func TestRetry(t *testing.T) {
+	var retries []int
+	l := openTest(t, t.TempDir(), Config{
+		Retry: RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		OnRetry: func(op string, attempt int, err error) {
+			if op != "write" || !errors.Is(err, syscall.EAGAIN) {
+				t.Errorf("retry of %s after %v", op, err)
+			}
+			retries = append(retries, attempt)
+		},
+	})
+
+	for _, test := range []struct {
+		name     string
+		failures int
+		err      error
+		want     error
+		retries  int
+	}{
+		{"transient", 2, syscall.EAGAIN, nil, 2},
+		{"exhausted", 3, syscall.EAGAIN, syscall.EAGAIN, 2},
+		{"permanent", 1, syscall.EIO, syscall.EIO, 0},
+	} {
+		retries = nil
+		calls := 0
+		l.mu.Lock()
+		err := l.retry("write", func() error {
+			calls++
+			if calls <= test.failures {
+				return test.err
+			}
+			return nil
+		})
+		l.mu.Unlock()
+
+		if !errors.Is(err, test.want) {
+			t.Fatalf("%s: got %v, want %v", test.name, err, test.want)
+		}
+		if len(retries) != test.retries {
+			t.Fatalf("%s: retried %v, want %d retries", test.name, retries, test.retries)
+		}
+		for i, attempt := range retries {
+			if attempt != i+1 {
+				t.Fatalf("%s: retried attempts %v, want 1 onwards", test.name, retries)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package jellywal
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Rewrap re-encrypts the entries of every sealed segment that are not
+// encrypted with the key with the given ID yet, so older keys can be
+// retired. Set the KeyProvider to return keyID as its current key first,
+// so new entries use it as well. Entries of the tail keep their key until
+// it is sealed, Rewrap can be called again afterwards. Segments without
+// encrypted entries are left as they are.
+//
+// Rewrap works one segment at a time without holding the log lock while
+// it re-encrypts, so reads and writes carry on in the meantime, and it can
+// be run in its own goroutine. Every segment is written to a temp file and
+// renamed over the original, so a crash leaves each segment either fully
+// rewrapped or untouched. Segments that are truncated or rewritten while
+// being rewrapped are skipped.
+func (l *Log) Rewrap(keyID uint32) error {
+	var next uint64
+	for {
+		l.mu.RLock()
+		if l.corrupt.Load() {
+			l.mu.RUnlock()
+			return ErrCorrupt
+		} else if l.closed {
+			l.mu.RUnlock()
+			return ErrClosed
+		} else if l.config.ReadOnly {
+			l.mu.RUnlock()
+			return ErrReadOnly
+		} else if l.keys == nil {
+			l.mu.RUnlock()
+			return ErrNoKeyProvider
+		}
+
+		// Find the next sealed segment, the tail is never rewrapped
+		sealed := l.segments[:len(l.segments)-1]
+		i, _ := slices.BinarySearchFunc(sealed, next, func(seg *segment, index uint64) int {
+			return cmp.Compare(seg.index, index)
+		})
+		if i == len(sealed) {
+			l.mu.RUnlock()
+			return nil
+		}
+		seg := sealed[i]
+		path, size, last, first := seg.path, seg.size, seg.last, l.firstIndex
+		l.mu.RUnlock()
+		next = seg.index + 1
+
+		rewrapped, err := l.rewrapSegment(path, seg.index, first, keyID)
+		if err != nil {
+			return err
+		} else if rewrapped == nil {
+			continue
+		}
+
+		l.lockSegments()
+		i = slices.Index(l.segments, seg)
+		if i >= 0 && i < len(l.segments)-1 && seg.path == path && seg.size == size && seg.last == last {
+			if err = l.replaceSegmentFile(seg, rewrapped); err == nil {
+				l.recordSegmentSizes()
+			}
+			l.clearCache()
+		}
+		l.unlockSegments()
+
+		if err != nil {
+			return fmt.Errorf("failed to replace rewrapped log segment: %w", err)
+		}
+	}
+}
+
+// rewrapSegment reads the segment file at path, which starts at the given
+// index, and returns its data with every entry re-encrypted with the key
+// keyID. Entries in front of the first index of the log are kept as they
+// are, they may have been punched. It returns nil when there is nothing to
+// rewrap.
+func (l *Log) rewrapSegment(path string, index, first uint64, keyID uint32) ([]byte, error) {
+	data, err := readSegmentFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log segment file: %w", err)
+	}
+
+	header, err := l.readSegmentHeader(data)
+	if err != nil {
+		return nil, locateCorruption(err, path, index, 0)
+	} else if header.flags&segmentFlagEncrypted == 0 {
+		return nil, nil
+	}
+
+	positions, ok := readSegmentFooter(data, header.size, index, header.sum)
+	if !ok {
+		if positions, _, err = parseSegmentEntries(data, header.size, header.sum); err != nil {
+			return nil, locateCorruption(err, path, index+uint64(len(positions)), 0)
+		}
+	}
+
+	rewrapped := append([]byte(nil), data[:header.size]...)
+	rewrappedPositions := make([]bytepos, 0, len(positions))
+	var changed bool
+	for i, pos := range positions {
+		if index+uint64(i) < first {
+			rewrapped = append(rewrapped, data[pos.start:pos.end]...)
+			rewrappedPositions = append(rewrappedPositions, bytepos{pos.start, pos.end})
+			continue
+		}
+
+		entry, flags, _, err := header.format().decodeEntry(data[pos.start:pos.end], header.sum)
+		if err == nil && (len(entry) < encryptionIDSize || binary.LittleEndian.Uint32(entry) != keyID) {
+			aad := segmentAAD(header.flags, index, index+uint64(i))
+			var plaintext []byte
+			if plaintext, err = l.keys.decrypt(entry, aad); err == nil {
+				entry, err = l.keys.encryptWith(keyID, plaintext, aad)
+				changed = true
+			}
+		}
+		if err != nil {
+			return nil, locateCorruption(err, path, index+uint64(i), 0)
+		}
+
+		start := len(rewrapped)
+		rewrapped = header.format().appendEntry(rewrapped, entry, flags&(entryMore|entryCompressed), header.sum)
+		rewrappedPositions = append(rewrappedPositions, bytepos{start, len(rewrapped)})
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	return appendSegmentFooter(rewrapped, rewrapped, index, rewrappedPositions, header.sum), nil
+}
@@ -0,0 +1,61 @@
+package jellywal
+
+import "time"
+
+// startRotator starts the background goroutine that seals the tail once it
+// reaches SegmentMaxAge, so a log that stops receiving writes still gets
+// its last entries sealed.
+func (l *Log) startRotator() {
+	if l.config.ReadOnly || l.config.SegmentMaxAge <= 0 {
+		return
+	}
+
+	l.rotateStop = make(chan struct{})
+	l.rotateDone = make(chan struct{})
+	go l.runRotator(l.rotateStop, l.rotateDone, l.config.SegmentMaxAge)
+}
+
+// stopRotator signals the rotator to stop and returns a channel that is
+// closed once it has exited, or nil when no rotator is running. The caller
+// must hold the write lock.
+func (l *Log) stopRotator() chan struct{} {
+	if l.rotateStop == nil {
+		return nil
+	}
+
+	close(l.rotateStop)
+	done := l.rotateDone
+	l.rotateStop = nil
+	l.rotateDone = nil
+
+	return done
+}
+
+func (l *Log) runRotator(stop, done chan struct{}, maxAge time.Duration) {
+	defer close(done)
+
+	// Check often enough to seal the tail within a tenth of its max age
+	ticker := time.NewTicker(max(maxAge/10, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.lockSegments()
+		if !l.closed && !l.corrupt.Load() && l.tailExpired(l.segments[len(l.segments)-1]) {
+			// Failures leave the tail in place, the next write tries again
+			l.cycle(l.lastIndex + 1)
+		}
+		l.unlockSegments()
+	}
+}
+
+// tailExpired reports whether the tail holds entries and has reached
+// SegmentMaxAge. The caller must hold the write lock.
+func (l *Log) tailExpired(tail *segment) bool {
+	return l.config.SegmentMaxAge > 0 && len(tail.cpos) > 0 && time.Since(l.tailStarted) >= l.config.SegmentMaxAge
+}
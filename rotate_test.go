@@ -0,0 +1,73 @@
+package jellywal
+
+import (
+	"testing"
+	"time"
+)
+
+// waitSegments waits for the log to hold the given number of segments.
+func waitSegments(tb testing.TB, l *Log, want int) {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.RLock()
+		got := len(l.segments)
+		l.mu.RUnlock()
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("got %d segments, want %d", got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestSegmentMaxAge checks that the tail is sealed in the background once
+// it reaches its age, and that an empty tail is left alone.
+func TestSegmentMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentMaxAge: 20 * time.Millisecond}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 3)
+	waitSegments(t, l, 2)
+
+	time.Sleep(5 * cfg.SegmentMaxAge)
+	waitSegments(t, l, 2)
+	checkEntries(t, l, 1, 3)
+
+	writeEntries(t, l, 4, 5)
+	waitSegments(t, l, 3)
+	l.mu.RLock()
+	if index := l.segments[1].index; index != 4 {
+		t.Fatalf("second segment starts at %d, want 4", index)
+	}
+	l.mu.RUnlock()
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 5)
+}
+
+// TestSegmentMaxAgeWrite checks that a write to an expired tail starts a
+// new segment at its entry.
+func TestSegmentMaxAgeWrite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentMaxAge: time.Hour}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 3)
+
+	l.mu.Lock()
+	l.tailStarted = time.Now().Add(-2 * cfg.SegmentMaxAge)
+	l.mu.Unlock()
+	writeBatchEntries(t, l, 4, 6)
+	if len(l.segments) != 2 || l.segments[1].index != 4 {
+		t.Fatalf("got %d segments, want a new one at 4", len(l.segments))
+	}
+	writeEntries(t, l, 7, 8)
+	if len(l.segments) != 2 {
+		t.Fatalf("got %d segments, want the new tail kept", len(l.segments))
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 8)
+}
@@ -0,0 +1,207 @@
+package s3archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidandw190/jellywal"
+)
+
+// errNotFound is returned for objects that do not exist.
+var errNotFound = errors.New("object not found")
+
+// client makes signed requests to one bucket.
+type client struct {
+	http      *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	creds     credentials
+	retry     jellywal.RetryPolicy
+}
+
+// statusError is a request that failed with an unexpected status.
+type statusError struct {
+	method string
+	key    string
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.method, e.key, http.StatusText(e.status), e.body)
+}
+
+// objectURL returns the URL of the object key with the given query.
+func (c *client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	return &u
+}
+
+// do sends a signed request for the object key, retrying transient
+// failures, and returns the body of the successful response. body is
+// resent for every attempt.
+func (c *client) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, []byte, error) {
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+
+	backoff := c.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, data, err := c.send(ctx, method, key, query, body, payloadHash)
+		if err == nil || attempt >= c.retry.Attempts || !retryable(err) || ctx.Err() != nil {
+			return resp, data, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.retry.MaxBackoff)
+	}
+}
+
+func (c *client) send(ctx context.Context, method, key string, query url.Values, body []byte, payloadHash string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = int64(len(body))
+	c.creds.sign(req, payloadHash, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("%s %s: %w", method, key, errNotFound)
+	} else if resp.StatusCode/100 != 2 {
+		return nil, nil, &statusError{method: method, key: key, status: resp.StatusCode, body: string(data)}
+	}
+
+	return resp, data, nil
+}
+
+// retryable reports whether a failed request is worth sending again:
+// network failures, throttling and server errors.
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.status == http.StatusTooManyRequests || status.status >= 500
+	}
+	return !errors.Is(err, errNotFound) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// get returns the contents of the object key.
+func (c *client) get(ctx context.Context, key string) ([]byte, error) {
+	_, data, err := c.do(ctx, http.MethodGet, key, nil, nil)
+	return data, err
+}
+
+// put replaces the contents of the object key.
+func (c *client) put(ctx context.Context, key string, data []byte) error {
+	_, _, err := c.do(ctx, http.MethodPut, key, nil, data)
+	return err
+}
+
+// upload writes the object key from r, which holds size bytes, in a
+// single request when it fits in one part and as a multipart upload
+// otherwise. A failed multipart upload is aborted.
+func (c *client) upload(ctx context.Context, key string, r io.ReaderAt, size, partSize int64) error {
+	if size <= partSize {
+		data := make([]byte, size)
+		if _, err := r.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return c.put(ctx, key, data)
+	}
+
+	_, data, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &created); err != nil || created.UploadID == "" {
+		return fmt.Errorf("failed to create multipart upload: unexpected response %q", data)
+	}
+
+	if err := c.uploadParts(ctx, key, created.UploadID, r, size, partSize); err != nil {
+		// Leave no orphaned parts behind, S3 bills for them
+		c.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {created.UploadID}}, nil)
+		return err
+	}
+
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (c *client) uploadParts(ctx context.Context, key, uploadID string, r io.ReaderAt, size, partSize int64) error {
+	var parts []completedPart
+	buf := make([]byte, partSize)
+	for off := int64(0); off < size; off += partSize {
+		part := buf[:min(partSize, size-off)]
+		if _, err := r.ReadAt(part, off); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		number := len(parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, _, err := c.do(ctx, http.MethodPut, key, query, part)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", number, err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	_, data, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, complete)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	// Completion can fail after the 200 status was sent
+	if bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete multipart upload: %s", data)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package s3archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/davidandw190/jellywal"
+)
+
+// Reader reads entries from archived segments. Segments are fetched into a
+// local directory the first time one of their entries is read, verified
+// against their recorded SHA-256, and opened as read-only logs. Fetched
+// segments are kept, so a directory can be reused by later readers.
+type Reader struct {
+	archiver *Archiver
+	dir      string
+	config   jellywal.Config
+
+	mu   sync.Mutex
+	logs map[uint64]*jellywal.Log // Opened segments by first index
+}
+
+// NewReader returns a reader fetching archived segments into dir. config
+// is used to open the segments, it needs the Encryption and Codec of the
+// log they were archived from. It may be nil.
+func (a *Archiver) NewReader(dir string, config *jellywal.Config) *Reader {
+	r := &Reader{archiver: a, dir: dir, logs: make(map[uint64]*jellywal.Log)}
+	if config != nil {
+		r.config = *config
+	}
+	r.config.ReadOnly = true
+	r.config.Archiver = nil
+
+	return r
+}
+
+// Read returns the archived entry at the given index. Returns
+// jellywal.ErrNotFound when no archived segment holds it.
+func (r *Reader) Read(ctx context.Context, index uint64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.logs == nil {
+		return nil, jellywal.ErrClosed
+	}
+
+	segments, err := r.archiver.Segments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].LastIndex >= index })
+	if i == len(segments) || index < segments[i].FirstIndex {
+		return nil, jellywal.ErrNotFound
+	}
+
+	log, err := r.open(ctx, segments[i])
+	if err != nil {
+		return nil, err
+	}
+
+	return log.Read(index)
+}
+
+// open returns the opened log holding the archived segment, fetching it
+// first when needed. The caller must hold mu.
+func (r *Reader) open(ctx context.Context, seg Segment) (*jellywal.Log, error) {
+	if log, ok := r.logs[seg.FirstIndex]; ok {
+		return log, nil
+	}
+
+	dir := filepath.Join(r.dir, fmt.Sprintf("%020d", seg.FirstIndex))
+	target := filepath.Join(dir, path.Base(seg.Key))
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		if err := r.fetch(ctx, seg, dir, target); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat fetched segment: %w", err)
+	}
+
+	config := r.config
+	log, err := jellywal.Open(dir, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fetched segment: %w", err)
+	}
+	r.logs[seg.FirstIndex] = log
+
+	return log, nil
+}
+
+// fetch downloads the archived segment to target in dir, only creating it
+// once its contents are verified.
+func (r *Reader) fetch(ctx context.Context, seg Segment, dir, target string) error {
+	data, err := r.archiver.client.get(ctx, seg.Key)
+	if err != nil {
+		return fmt.Errorf("failed to fetch archived segment: %w", err)
+	}
+
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != seg.SHA256 {
+		return fmt.Errorf("archived segment %s does not match its SHA-256: %w", seg.Key, jellywal.ErrCorrupt)
+	}
+
+	if err := os.MkdirAll(dir, jellywal.DefaultDirPerms); err != nil {
+		return fmt.Errorf("failed to create segment directory: %w", err)
+	}
+
+	temp := target + ".tmp"
+	if err := os.WriteFile(temp, data, jellywal.DefaultFilePerms); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to write fetched segment: %w", err)
+	}
+
+	if err := os.Rename(temp, target); err != nil {
+		return fmt.Errorf("failed to rename fetched segment: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the segments opened by the reader, leaving the fetched
+// files in place.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for _, log := range r.logs {
+		errs = append(errs, log.Close())
+	}
+	r.logs = nil
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+package s3archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidandw190/jellywal"
+)
+
+// TestReader checks that archived entries are read back, whatever the
+// segments are compressed with, from a reused directory too.
+func TestReader(t *testing.T) {
+	for _, compression := range []jellywal.Compression{jellywal.CompressionNone, jellywal.CompressionZstd} {
+		t.Run(compression.String(), func(t *testing.T) {
+			fake := newFakeS3()
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			a := newTestArchiver(t, srv, 1000)
+			l := openTestLog(t, a, jellywal.Config{SegmentSize: 4096, SegmentCompression: compression}, 500)
+			if err := l.TruncateFront(300); err != nil {
+				t.Fatal(err)
+			}
+			last := checkArchived(t, fake, a)
+
+			dir := t.TempDir()
+			for _, reader := range []*Archiver{a, newTestArchiver(t, srv, 1000)} {
+				r := reader.NewReader(dir, nil)
+				for i := uint64(1); i <= last; i++ {
+					data, err := r.Read(context.Background(), i)
+					if err != nil {
+						t.Fatalf("read %d: %v", i, err)
+					}
+					if !bytes.Equal(data, testEntry(i)) {
+						t.Fatalf("read %d: got %q, want %q", i, data, testEntry(i))
+					}
+				}
+				for _, index := range []uint64{0, last + 1} {
+					if _, err := r.Read(context.Background(), index); !errors.Is(err, jellywal.ErrNotFound) {
+						t.Fatalf("read %d: got %v, want ErrNotFound", index, err)
+					}
+				}
+				if err := r.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Read(context.Background(), 1); !errors.Is(err, jellywal.ErrClosed) {
+					t.Fatalf("read after close: got %v, want ErrClosed", err)
+				}
+			}
+		})
+	}
+}
+
+// TestReaderDamaged checks that an archived segment not matching its
+// checksum is not read from.
+func TestReaderDamaged(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	a := newTestArchiver(t, srv, DefaultPartSize)
+	l := openTestLog(t, a, jellywal.Config{SegmentSize: 4096}, 500)
+	if err := l.TruncateFront(300); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := a.Segments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	data := fake.objects[segments[0].Key]
+	data[len(data)-1] ^= 0xff
+	fake.mu.Unlock()
+
+	r := a.NewReader(t.TempDir(), nil)
+	defer r.Close()
+	if _, err := r.Read(context.Background(), 1); !errors.Is(err, jellywal.ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package s3archive archives the segments of a jellywal log to S3
+// compatible object storage, and reads archived entries back.
+//
+// An Archiver is set as Config.Archiver of a log. Every segment the log is
+// about to delete, through TruncateFront or retention, is uploaded as an
+// object named after the segment file under a prefix, in parts when it is
+// large, and recorded in a manifest object next to it. The log only deletes
+// the segment once both are stored. A Reader fetches archived segments back
+// on demand to read entries older than the log's FirstIndex.
+//
+// Uploads do not hold up writes to the log: TruncateFront uploads before it
+// takes the log lock, and retention leaves them to the log's background
+// janitor. TruncateFront and Close do wait for an upload in progress
+// though, up to Config.Timeout.
+//
+// Requests are signed with AWS Signature Version 4 using static
+// credentials, and retried with backoff when they fail with a network
+// error, throttling or a server error.
+package s3archive
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/davidandw190/jellywal"
+)
+
+// Defaults for Config.
+const (
+	DefaultPartSize   = 16 << 20
+	DefaultAttempts   = 4
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+	DefaultTimeout    = 30 * time.Second
+)
+
+// minPartSize is the smallest part S3 accepts in a multipart upload, but
+// for the last one.
+const minPartSize = 5 << 20
+
+// manifestName is the name of the manifest object under the prefix.
+const manifestName = "MANIFEST.json"
+
+// Config for connecting to the bucket archived segments are stored in.
+type Config struct {
+	// Endpoint is the URL of the S3 service, for example
+	// "https://s3.eu-west-1.amazonaws.com" or the URL of a MinIO server.
+	Endpoint string
+
+	Region string // Region signed into requests, "us-east-1" for most other services.
+	Bucket string // Bucket holding the archived segments.
+	Prefix string // Prefix of the object names, for example "wal/node-1/".
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Session token of temporary credentials, if any.
+
+	// PathStyle puts the bucket into the path of requests rather than the
+	// host name, which most services other than AWS need.
+	PathStyle bool
+
+	// PartSize is the size of the parts larger segments are uploaded in.
+	// Default is 16 MB, at least 5 MB.
+	PartSize int64
+
+	// Retry controls retries of failed requests. Default is 4 attempts,
+	// backing off from 100 ms up to 5 seconds.
+	Retry jellywal.RetryPolicy
+
+	// Timeout bounds the time an Archive call takes, all retries included,
+	// and so the time closing the log waits for an upload. Default is 30
+	// seconds.
+	Timeout time.Duration
+
+	// Client sends the requests. Default is http.DefaultClient.
+	Client *http.Client
+}
+
+// Segment is an archived segment recorded in the manifest.
+type Segment struct {
+	Key         string `json:"key"`         // Object name, including the prefix
+	FirstIndex  uint64 `json:"first"`       // First index held by the segment
+	LastIndex   uint64 `json:"last"`        // Last index held by the segment
+	Size        int64  `json:"size"`        // Size of the segment file
+	Compression string `json:"compression"` // Compression of the whole file
+	SHA256      string `json:"sha256"`      // Hex SHA-256 of the segment file
+}
+
+// manifest is the manifest object listing the archived segments, ordered
+// by their first index.
+type manifest struct {
+	Segments []Segment `json:"segments"`
+}
+
+// Archiver uploads the segments handed to it to a bucket. It implements
+// jellywal.Archiver. An archive prefix must have a single writer.
+type Archiver struct {
+	client  *client
+	prefix  string
+	part    int64
+	timeout time.Duration
+
+	mu       sync.Mutex
+	manifest *manifest // Loaded on first use
+}
+
+// New returns an archiver for the bucket described by config.
+func New(config Config) (*Archiver, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("missing bucket")
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", config.Endpoint)
+	}
+
+	if config.PartSize <= 0 {
+		config.PartSize = DefaultPartSize
+	}
+	config.PartSize = max(config.PartSize, minPartSize)
+
+	if config.Retry.Attempts <= 0 {
+		config.Retry.Attempts = DefaultAttempts
+	}
+	if config.Retry.Backoff <= 0 {
+		config.Retry.Backoff = DefaultBackoff
+	}
+	if config.Retry.MaxBackoff < config.Retry.Backoff {
+		config.Retry.MaxBackoff = max(DefaultMaxBackoff, config.Retry.Backoff)
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	return &Archiver{
+		client: &client{
+			http:      config.Client,
+			endpoint:  endpoint,
+			bucket:    config.Bucket,
+			pathStyle: config.PathStyle,
+			creds: credentials{
+				accessKeyID:     config.AccessKeyID,
+				secretAccessKey: config.SecretAccessKey,
+				sessionToken:    config.SessionToken,
+				region:          config.Region,
+			},
+			retry: config.Retry,
+		},
+		prefix:  config.Prefix,
+		part:    config.PartSize,
+		timeout: config.Timeout,
+	}, nil
+}
+
+// Archive uploads the segment and records it in the manifest. A segment
+// archived again replaces the earlier upload.
+func (a *Archiver) Archive(segment jellywal.ArchivedSegment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, err := a.loadManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(segment.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat segment: %w", err)
+	}
+
+	key := a.prefix + filepath.Base(segment.Path)
+	if err := a.client.upload(ctx, key, file, info.Size(), a.part); err != nil {
+		return fmt.Errorf("failed to upload segment: %w", err)
+	}
+
+	archived := Segment{
+		Key:         key,
+		FirstIndex:  segment.FirstIndex,
+		LastIndex:   segment.LastIndex,
+		Size:        info.Size(),
+		Compression: segment.Compression.String(),
+		SHA256:      hex.EncodeToString(segment.SHA256[:]),
+	}
+
+	// Replace earlier uploads of the same segment, possibly under another
+	// compression
+	segments := make([]Segment, 0, len(m.Segments)+1)
+	for _, seg := range m.Segments {
+		if seg.FirstIndex != archived.FirstIndex {
+			segments = append(segments, seg)
+		}
+	}
+	segments = append(segments, archived)
+	sort.Slice(segments, func(i, j int) bool { return segments[i].FirstIndex < segments[j].FirstIndex })
+
+	if err := a.storeManifest(ctx, &manifest{Segments: segments}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Segments returns the archived segments ordered by their first index.
+func (a *Archiver) Segments(ctx context.Context) ([]Segment, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, err := a.loadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]Segment(nil), m.Segments...), nil
+}
+
+// loadManifest returns the manifest, fetching it on first use. A missing
+// manifest is an empty archive. The caller must hold mu.
+func (a *Archiver) loadManifest(ctx context.Context) (*manifest, error) {
+	if a.manifest != nil {
+		return a.manifest, nil
+	}
+
+	data, err := a.client.get(ctx, a.prefix+manifestName)
+	if errors.Is(err, errNotFound) {
+		a.manifest = &manifest{}
+		return a.manifest, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch archive manifest: %w", err)
+	}
+
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to decode archive manifest: %w", err)
+	}
+	a.manifest = m
+
+	return m, nil
+}
+
+// storeManifest uploads the manifest, and keeps it once it is stored. The
+// caller must hold mu.
+func (a *Archiver) storeManifest(ctx context.Context, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := a.client.put(ctx, a.prefix+manifestName, data); err != nil {
+		return fmt.Errorf("failed to store archive manifest: %w", err)
+	}
+	a.manifest = m
+
+	return nil
+}
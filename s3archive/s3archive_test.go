@@ -0,0 +1,367 @@
+package s3archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidandw190/jellywal"
+)
+
+// fakeS3 is an in-memory bucket served over HTTP, understanding the
+// requests the archiver makes. It fails the next fails requests with a 503,
+// or with status when it is set, and the next partFails part uploads with
+// a 400.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte // Parts of the open multipart uploads
+	aborted   int                       // Multipart uploads aborted
+	parts     int                       // Parts uploaded
+	fails     int
+	status    int
+	partFails int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signAlgorithm+" ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if want := hashHex(body); r.Header.Get("X-Amz-Content-Sha256") != want {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if f.fails > 0 {
+		f.fails--
+		if f.status != 0 {
+			w.WriteHeader(f.status)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + f.aborted + 1)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if err != nil || !ok || f.partFails > 0 {
+			f.partFails--
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		parts[number] = body
+		f.parts++
+		w.Header().Set("ETag", fmt.Sprintf("%q", hashHex(body)))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for i := 1; i <= len(parts); i++ {
+			data = append(data, parts[i]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		io.WriteString(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fail makes the next n requests fail with status, or a 503 when it is 0.
+func (f *fakeS3) fail(n, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails, f.status = n, status
+}
+
+// object returns the contents of the object key.
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	return data, ok
+}
+
+// newTestArchiver returns an archiver storing into the bucket served by
+// srv, uploading segments larger than part in parts.
+func newTestArchiver(tb testing.TB, srv *httptest.Server, part int64) *Archiver {
+	tb.Helper()
+	a, err := New(Config{
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		Prefix:          "wal/",
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		Retry:           jellywal.RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+		Client:          srv.Client(),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// Below the S3 minimum, so small test segments go in parts
+	a.part = part
+	return a
+}
+
+// testEntry returns the data the tests write at index.
+func testEntry(index uint64) []byte {
+	return []byte(fmt.Sprintf("entry %d %s", index, strings.Repeat("x", int(index%30))))
+}
+
+// openTestLog opens a log archiving to a and writes the test entries 1
+// through last.
+func openTestLog(tb testing.TB, a *Archiver, cfg jellywal.Config, last uint64) *jellywal.Log {
+	tb.Helper()
+	cfg.Archiver = a
+	l, err := jellywal.Open(tb.TempDir(), &cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+
+	for i := uint64(1); i <= last; i++ {
+		if err := l.Write(i, testEntry(i)); err != nil {
+			tb.Fatalf("write %d: %v", i, err)
+		}
+	}
+	return l
+}
+
+// checkArchived checks that the archive manifest lists intact segments
+// covering the indexes from 1 on, and returns the last of them.
+func checkArchived(tb testing.TB, fake *fakeS3, a *Archiver) uint64 {
+	tb.Helper()
+	segments, err := a.Segments(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	next := uint64(1)
+	for _, seg := range segments {
+		if seg.FirstIndex != next || seg.LastIndex < seg.FirstIndex {
+			tb.Fatalf("archived %d-%d, want a segment from %d", seg.FirstIndex, seg.LastIndex, next)
+		}
+		data, ok := fake.object(seg.Key)
+		if !ok {
+			tb.Fatalf("archived segment %s not stored", seg.Key)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != seg.Size || hex.EncodeToString(sum[:]) != seg.SHA256 {
+			tb.Fatalf("archived segment %s stored damaged", seg.Key)
+		}
+		next = seg.LastIndex + 1
+	}
+	return next - 1
+}
+
+// TestArchive checks that truncated segments are uploaded, whole or in
+// parts, and listed in a manifest a new archiver loads.
+func TestArchive(t *testing.T) {
+	for _, part := range []int64{DefaultPartSize, 1000} {
+		t.Run(fmt.Sprint(part), func(t *testing.T) {
+			fake := newFakeS3()
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			a := newTestArchiver(t, srv, part)
+			l := openTestLog(t, a, jellywal.Config{SegmentSize: 4096}, 500)
+			if err := l.TruncateFront(300); err != nil {
+				t.Fatal(err)
+			}
+			// The segment holding the new first index is kept
+			if last := checkArchived(t, fake, a); last == 0 || last >= 300 {
+				t.Fatalf("archived up to %d, want a part of 1-299", last)
+			}
+			fake.mu.Lock()
+			multipart := fake.parts > 0
+			fake.mu.Unlock()
+			if multipart != (part < 4096) {
+				t.Fatalf("uploaded in parts: %v, with part size %d", multipart, part)
+			}
+
+			reloaded := newTestArchiver(t, srv, part)
+			got, err := reloaded.Segments(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, _ := a.Segments(context.Background()); len(got) != len(want) {
+				t.Fatalf("reloaded %d segments, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// TestArchiveRetry checks that failed requests are retried, and that a
+// segment is kept by the log until it is archived.
+func TestArchiveRetry(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	a := newTestArchiver(t, srv, 1000)
+	l := openTestLog(t, a, jellywal.Config{SegmentSize: 4096}, 500)
+	fake.fail(2, 0)
+	if err := l.TruncateFront(300); err != nil {
+		t.Fatal(err)
+	}
+	last := checkArchived(t, fake, a)
+
+	// Throttling runs out of attempts
+	fake.fail(100, http.StatusTooManyRequests)
+	if err := l.TruncateFront(450); err == nil {
+		t.Fatal("truncated with the archive failing")
+	}
+	if got := checkArchived(t, fake, a); got != last {
+		t.Fatalf("archived up to %d with the archive failing, want %d", got, last)
+	}
+	if first, err := l.FirstIndex(); err != nil || first != 300 {
+		t.Fatalf("first index %d, %v; want 300", first, err)
+	}
+
+	fake.fail(0, 0)
+	if err := l.TruncateFront(450); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkArchived(t, fake, a); got <= last {
+		t.Fatalf("archived up to %d, want past %d", got, last)
+	}
+}
+
+// TestArchiveNotRetried checks that requests the service rejects are not
+// retried.
+func TestArchiveNotRetried(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	a := newTestArchiver(t, srv, DefaultPartSize)
+	l := openTestLog(t, a, jellywal.Config{SegmentSize: 4096}, 500)
+	fake.fail(2, http.StatusForbidden)
+	err := l.TruncateFront(300)
+	var status *statusError
+	if !errors.As(err, &status) || status.status != http.StatusForbidden {
+		t.Fatalf("got %v, want a forbidden status error", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.fails != 1 {
+		t.Fatalf("sent %d requests, want 1", 2-fake.fails)
+	}
+}
+
+// TestArchiveAbort checks that a failed multipart upload is aborted.
+func TestArchiveAbort(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	a := newTestArchiver(t, srv, 1000)
+	l := openTestLog(t, a, jellywal.Config{SegmentSize: 4096}, 500)
+	fake.mu.Lock()
+	fake.partFails = 1
+	fake.mu.Unlock()
+	if err := l.TruncateFront(300); err == nil {
+		t.Fatal("truncated with the upload failing")
+	}
+	fake.mu.Lock()
+	aborted, open := fake.aborted, len(fake.uploads)
+	fake.mu.Unlock()
+	if aborted != 1 || open != 0 {
+		t.Fatalf("aborted %d uploads, %d left open; want the upload aborted", aborted, open)
+	}
+	if last := checkArchived(t, fake, a); last != 0 {
+		t.Fatalf("archived up to %d, want nothing", last)
+	}
+}
+
+// TestNew checks the validation and defaults of the configuration.
+func TestNew(t *testing.T) {
+	for _, config := range []Config{
+		{Endpoint: "http://localhost:9000"},
+		{Endpoint: "localhost:9000", Bucket: "bucket"},
+		{Endpoint: "", Bucket: "bucket"},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("New(%+v) succeeded, want an error", config)
+		}
+	}
+
+	a, err := New(Config{Endpoint: "http://localhost:9000", Bucket: "bucket", PartSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.part != minPartSize || a.timeout != DefaultTimeout || a.client.retry.Attempts != DefaultAttempts {
+		t.Fatalf("got part size %d, timeout %v, %d attempts; want the defaults", a.part, a.timeout, a.client.retry.Attempts)
+	}
+}
+
+// TestObjectURL checks that the bucket goes into the host name or the path.
+func TestObjectURL(t *testing.T) {
+	tests := []struct {
+		endpoint  string
+		pathStyle bool
+		want      string
+	}{
+		{"https://s3.eu-west-1.amazonaws.com", false, "https://bucket.s3.eu-west-1.amazonaws.com/wal/a%20b?partNumber=1"},
+		{"http://localhost:9000", true, "http://localhost:9000/bucket/wal/a%20b?partNumber=1"},
+		{"http://localhost:9000/s3/", true, "http://localhost:9000/s3/bucket/wal/a%20b?partNumber=1"},
+	}
+	for _, tt := range tests {
+		a, err := New(Config{Endpoint: tt.endpoint, Bucket: "bucket", PathStyle: tt.pathStyle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := a.client.objectURL("wal/a b", url.Values{"partNumber": {"1"}}).String()
+		if got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package jellywal
+
+import "time"
+
+// startScrubber starts the background goroutine that re-reads sealed
+// segments to find latent corruption, when Config.ScrubInterval is set.
+func (l *Log) startScrubber() {
+	if l.config.ScrubInterval <= 0 {
+		return
+	}
+
+	l.scrubStop = make(chan struct{})
+	l.scrubDone = make(chan struct{})
+	go l.runScrubber(l.scrubStop, l.scrubDone, l.config.ScrubInterval)
+}
+
+// stopScrubber signals the scrubber to stop and returns a channel that is
+// closed once it has exited, or nil when no scrubber is running. The caller
+// must hold the write lock.
+func (l *Log) stopScrubber() chan struct{} {
+	if l.scrubStop == nil {
+		return nil
+	}
+
+	close(l.scrubStop)
+	done := l.scrubDone
+	l.scrubStop = nil
+	l.scrubDone = nil
+
+	return done
+}
+
+func (l *Log) runScrubber(stop, done chan struct{}, interval time.Duration) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Index the next segment to scrub starts at or after, wrapping around
+	// once the tail is reached
+	var next uint64
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var report VerifyReport
+		l.mu.RLock()
+		if !l.closed && !l.corrupt.Load() {
+			next = l.scrubSegment(&report, next)
+		}
+		l.mu.RUnlock()
+
+		if l.config.OnScrubProblem != nil {
+			for _, problem := range report.Problems {
+				l.config.OnScrubProblem(problem)
+			}
+		}
+	}
+}
+
+// scrubSegment verifies the first sealed segment starting at or after the
+// given index and returns the index to continue from. The caller must hold
+// the read lock.
+func (l *Log) scrubSegment(report *VerifyReport, next uint64) uint64 {
+	sealed := l.segments[:len(l.segments)-1]
+	if len(sealed) == 0 {
+		return 0
+	}
+
+	i := l.findSegment(next)
+	if i < 0 || l.segments[i].index < next {
+		i++
+	}
+	if i >= len(sealed) {
+		i = 0
+	}
+
+	l.verifySegment(report, sealed[i], l.segments[i+1].index-1, false)
+
+	return l.segments[i+1].index
+}
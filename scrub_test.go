@@ -0,0 +1,49 @@
+package jellywal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestScrubber checks that the scrubber reports a damaged entry in a sealed
+// segment through OnScrubProblem, and that Close stops it.
+func TestScrubber(t *testing.T) {
+	problems := make(chan Problem, 100)
+	l := openTest(t, t.TempDir(), Config{
+		SegmentSize:   1024,
+		ScrubInterval: time.Millisecond,
+		OnScrubProblem: func(problem Problem) {
+			select {
+			case problems <- problem:
+			default:
+			}
+		},
+	})
+	writeEntries(t, l, 1, 500)
+	l.mu.RLock()
+	damaged := l.segments[2]
+	l.mu.RUnlock()
+	corruptEntry(t, damaged.path, damaged.index+1)
+
+	select {
+	case problem := <-problems:
+		if problem.Path != damaged.path || !errors.Is(problem.Err, errChecksum) {
+			t.Fatalf("got problem %v, want a checksum mismatch in %s", problem, damaged.path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scrubber reported no problem")
+	}
+
+	l.mu.RLock()
+	done := l.scrubDone
+	l.mu.RUnlock()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("scrubber running after close")
+	}
+}
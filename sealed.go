@@ -0,0 +1,313 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Sealed segments can be stored compressed as a whole, which compresses far
+// better than single entries. A compressed segment file holds the sealed
+// segment, footer included, in the standard framed format of its algorithm
+// and carries the matching suffix, so it can be inspected with the usual
+// command line tools. The tail is never compressed: a compressed segment
+// that becomes the tail again is decompressed first.
+var segmentSuffixes = map[Compression]string{
+	CompressionSnappy: ".sz",
+	CompressionZstd:   ".zst",
+	CompressionLZ4:    ".lz4",
+}
+
+// segmentFileCompression returns the algorithm a segment file name was
+// compressed with, judging by its suffix.
+func segmentFileCompression(name string) Compression {
+	for c, suffix := range segmentSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return c
+		}
+	}
+	return CompressionNone
+}
+
+// readSegmentFile reads a segment file, decompressing it when it is stored
+// compressed.
+func readSegmentFile(path string) ([]byte, error) {
+	c := segmentFileCompression(path)
+	if c == CompressionNone {
+		return os.ReadFile(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader
+	switch c {
+	case CompressionSnappy:
+		r = s2.NewReader(file)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		r = decoder
+	case CompressionLZ4:
+		r = lz4.NewReader(file)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+
+	return data, nil
+}
+
+// writeSegmentFile writes the concatenated parts to a new segment file at
+// path, compressed according to its suffix, and fsyncs it.
+func writeSegmentFile(path string, perm os.FileMode, parts ...[]byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := writeCompressed(file, segmentFileCompression(path), parts); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// writeCompressed writes the parts to w, compressed with c.
+func writeCompressed(w io.Writer, c Compression, parts [][]byte) error {
+	var cw io.WriteCloser
+	switch c {
+	case CompressionSnappy:
+		cw = s2.NewWriter(w, s2.WriterSnappyCompat())
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		cw = encoder
+	case CompressionLZ4:
+		cw = lz4.NewWriter(w)
+	default:
+		cw = nopWriteCloser{w}
+	}
+
+	for _, part := range parts {
+		if _, err := cw.Write(part); err != nil {
+			cw.Close()
+			return err
+		}
+	}
+
+	return cw.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressSealed replaces the file of a freshly sealed segment, whose data
+// is given in parts, with a compressed one. The caller must hold the write
+// lock.
+func (l *Log) compressSealed(seg *segment, parts ...[]byte) error {
+	tempPath := filepath.Join(l.path, "TEMP"+segmentSuffixes[l.config.SegmentCompression])
+	if err := l.writeCompressedTemp(tempPath, parts...); err != nil {
+		return err
+	}
+
+	return l.installCompressed(seg, tempPath)
+}
+
+// writeCompressedTemp writes the parts compressed with the configured
+// algorithm to the temp file at tempPath.
+func (l *Log) writeCompressedTemp(tempPath string, parts ...[]byte) error {
+	if err := writeSegmentFile(tempPath, l.config.FilePerms, parts...); err != nil {
+		os.Remove(tempPath)
+		return noSpaceError(fmt.Errorf("failed to write compressed log segment: %w", err))
+	}
+	return nil
+}
+
+// installCompressed renames the compressed temp file at tempPath into place
+// for a sealed segment and removes its raw file. The raw one is only
+// removed afterwards, so a crash leaves a complete file behind. Open prefers
+// the raw file when it finds both. The caller must hold the write lock.
+func (l *Log) installCompressed(seg *segment, tempPath string) error {
+	path := filepath.Join(l.path, l.segmentName(seg.index)+segmentSuffixes[segmentFileCompression(tempPath)])
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to rename compressed log segment: %w", err)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return err
+	}
+
+	if err := l.removeSparseIndex(seg.path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(seg.path); err != nil {
+		return fmt.Errorf("failed to remove raw log segment: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat compressed log segment: %w", err)
+	}
+
+	seg.path = path
+	seg.size = info.Size()
+	l.protectSegmentFile(path)
+	l.recordSegmentSizes()
+
+	return nil
+}
+
+// coldTempPrefix names the temp files the background compression writes
+// without holding the log lock, apart from those written under it.
+const coldTempPrefix = "TEMP-cold"
+
+// maybeCompressCold starts compressing the sealed segments that are
+// SegmentCompressionDelay rotations old in the background, unless that is
+// running already. The caller must hold the write lock.
+func (l *Log) maybeCompressCold() {
+	if l.config.SegmentCompression == CompressionNone || l.config.SegmentCompressionDelay <= 0 || l.config.ReadOnly || l.coldDone != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	l.coldDone = done
+	go l.compressCold(done)
+}
+
+// compressCold compresses the cold segments one at a time. Each one is
+// compressed without holding the log lock, and only installed when it was
+// neither truncated nor rewritten in the meantime.
+func (l *Log) compressCold(done chan struct{}) {
+	defer close(done)
+
+	tempPath := filepath.Join(l.path, coldTempPrefix+segmentSuffixes[l.config.SegmentCompression])
+	for {
+		l.mu.RLock()
+		var seg *segment
+		var path string
+		var size int64
+		var last uint64
+		if !l.closed && !l.corrupt.Load() {
+			for _, s := range l.segments[:max(len(l.segments)-1-l.config.SegmentCompressionDelay, 0)] {
+				if segmentFileCompression(s.path) == CompressionNone {
+					seg, path, size, last = s, s.path, s.size, s.last
+					break
+				}
+			}
+		}
+		l.mu.RUnlock()
+
+		if seg == nil {
+			break
+		}
+
+		data, err := readSegmentFile(path)
+		if err == nil {
+			err = l.writeCompressedTemp(tempPath, data)
+		}
+
+		l.lockSegments()
+		i := slices.Index(l.segments, seg)
+		if err == nil && !l.closed && !l.corrupt.Load() && i >= 0 && i < len(l.segments)-1 && seg.path == path && seg.size == size && seg.last == last {
+			err = l.installCompressed(seg, tempPath)
+		} else {
+			os.Remove(tempPath)
+		}
+		l.unlockSegments()
+
+		if err != nil {
+			// Not fatal, the segment stays raw until the next attempt
+			break
+		}
+	}
+
+	l.mu.Lock()
+	if l.coldDone == done {
+		l.coldDone = nil
+	}
+	l.mu.Unlock()
+}
+
+// decompressSegment replaces a compressed segment file with a raw one
+// holding data, its decompressed content or a prefix of it, so it can
+// become the tail. The raw file is renamed into place before the
+// compressed one is removed.
+func (l *Log) decompressSegment(seg *segment, data []byte) error {
+	path := filepath.Join(l.path, l.segmentName(seg.index))
+
+	tempPath := filepath.Join(l.path, "TEMP")
+	if err := writeFileSync(tempPath, data, l.config.FilePerms); err != nil {
+		return noSpaceError(fmt.Errorf("failed to write decompressed log segment: %w", err))
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to rename decompressed log segment: %w", err)
+	}
+
+	if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove compressed log segment: %w", err)
+	}
+
+	seg.path = path
+	seg.size = int64(len(data))
+
+	return l.syncDir()
+}
+
+// replaceSegmentFile atomically replaces the file of a sealed segment with
+// the given data, keeping its compression.
+func (l *Log) replaceSegmentFile(seg *segment, data []byte) error {
+	if err := l.removeSparseIndex(seg.path); err != nil {
+		return err
+	}
+
+	tempPath := filepath.Join(l.path, "TEMP"+segmentSuffixes[segmentFileCompression(seg.path)])
+	if err := writeSegmentFile(tempPath, l.config.FilePerms, data); err != nil {
+		os.Remove(tempPath)
+		return noSpaceError(fmt.Errorf("failed to write log segment: %w", err))
+	}
+
+	if err := os.Rename(tempPath, seg.path); err != nil {
+		return fmt.Errorf("failed to rename log segment: %w", err)
+	}
+
+	info, err := os.Stat(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat log segment: %w", err)
+	}
+	seg.size = info.Size()
+	l.protectSegmentFile(seg.path)
+
+	return l.syncDir()
+}
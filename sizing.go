@@ -0,0 +1,48 @@
+package jellywal
+
+import "time"
+
+// SizingPolicy adapts the size segments are sealed at to the rate the log
+// is written at, so a segment takes about TargetAge to fill up whether the
+// log gets a trickle or a firehose of writes. The size starts out at
+// SegmentSize when the log is opened, and moves halfway towards the size
+// the segment just sealed would have reached in TargetAge at every
+// rotation, within MinSize and MaxSize.
+type SizingPolicy struct {
+	TargetAge time.Duration // Time a segment should take to fill up. Zero disables adaptive sizing.
+	MinSize   int           // Smallest size segments are sealed at. Default is a sixteenth of SegmentSize.
+	MaxSize   int           // Largest size segments are sealed at. Default is 16 times SegmentSize.
+}
+
+// segmentSizingRange is the factor the default MinSize and MaxSize of the
+// SizingPolicy are away from SegmentSize.
+const segmentSizingRange = 16
+
+// adaptSegmentSize adjusts the size the tail is sealed at after a segment
+// was sealed, which took elapsed to be written the given number of bytes.
+// The caller must hold the write lock.
+func (l *Log) adaptSegmentSize(written int, elapsed time.Duration) {
+	p := l.config.SegmentSizing
+	if p.TargetAge <= 0 || written <= 0 || elapsed <= 0 {
+		return
+	}
+
+	target := float64(written) * (float64(p.TargetAge) / float64(elapsed))
+	size := (float64(l.segmentSize.Load()) + min(target, float64(p.MaxSize))) / 2
+	l.segmentSize.Store(int64(min(max(size, float64(p.MinSize)), float64(p.MaxSize))))
+}
+
+// growTail makes room for n more bytes in the cached entries of the tail.
+// The buffer is doubled when it runs full, rather than grown by the quarter
+// append settles on for large slices, so filling a segment copies the
+// entries cached so far a handful of times instead of dozens. It is not
+// grown past the size the tail is sealed at unless n calls for it, so no
+// memory is reserved that the tail would not use.
+func (l *Log) growTail(tail *segment, n int) {
+	if cap(tail.cbuf)-len(tail.cbuf) >= n {
+		return
+	}
+
+	size := max(len(tail.cbuf)+n, min(2*cap(tail.cbuf), int(l.segmentSize.Load())))
+	tail.cbuf = append(make([]byte, 0, size), tail.cbuf...)
+}
@@ -0,0 +1,114 @@
+package jellywal
+
+import (
+	"math/bits"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// TestSizingDefaults checks the MinSize and MaxSize Validate fills in.
+func TestSizingDefaults(t *testing.T) {
+	cfg := Config{SegmentSize: 1 << 20, SegmentSizing: SizingPolicy{TargetAge: time.Hour}}
+	cfg.Validate()
+	if got := cfg.SegmentSizing; got.MinSize != 1<<16 || got.MaxSize != 1<<24 {
+		t.Fatalf("got sizes %d to %d, want %d to %d", got.MinSize, got.MaxSize, 1<<16, 1<<24)
+	}
+
+	cfg = Config{SegmentSize: 1 << 20, SegmentSizing: SizingPolicy{TargetAge: time.Hour, MinSize: 1 << 25}}
+	cfg.Validate()
+	if got := cfg.SegmentSizing; got.MaxSize != got.MinSize {
+		t.Fatalf("got sizes %d to %d, want no larger maximum than the minimum", got.MinSize, got.MaxSize)
+	}
+}
+
+// TestAdaptSegmentSize checks that the segment size moves halfway towards
+// the size a segment would reach in the target age, within the limits.
+func TestAdaptSegmentSize(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		written int
+		elapsed time.Duration
+		want    int64
+	}{
+		{"on target", 1000, time.Minute, 1000},
+		{"faster", 2000, time.Minute, 1500},
+		{"slower", 500, 2 * time.Minute, 625},
+		{"maximum", 1000, time.Second, 2500},
+		{"minimum", 1, time.Hour, 500},
+		{"nothing written", 0, time.Minute, 1000},
+		{"no time elapsed", 1000, 0, 1000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := &Log{config: Config{SegmentSizing: SizingPolicy{
+				TargetAge: time.Minute,
+				MinSize:   500,
+				MaxSize:   4000,
+			}}}
+			l.segmentSize.Store(1000)
+			l.adaptSegmentSize(test.written, test.elapsed)
+			if got := l.segmentSize.Load(); got != test.want {
+				t.Fatalf("got size %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// TestSegmentSizing checks that segments grow when they fill up faster
+// than the target age, and shrink when they fill up slower.
+func TestSegmentSizing(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SegmentSizing: SizingPolicy{TargetAge: time.Hour}}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 2000)
+	if got := l.segmentSize.Load(); got <= 8*1024 {
+		t.Fatalf("got size %d after fast writes, want it near the maximum", got)
+	}
+	if last := l.segments[len(l.segments)-2]; last.size <= 8*1024 {
+		t.Fatalf("last sealed segment is %d bytes, want it grown", last.size)
+	}
+
+	cfg.SegmentSizing.TargetAge = time.Nanosecond
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 2000)
+	segments := len(l.segments)
+	writeEntries(t, l, 2001, 3000)
+	if got := l.segmentSize.Load(); got != 1024/16 {
+		t.Fatalf("got size %d after slow writes, want the minimum", got)
+	}
+	if len(l.segments) < segments+100 {
+		t.Fatalf("got %d segments for 1000 entries, want one every few entries", len(l.segments)-segments)
+	}
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 3000)
+}
+
+// TestGrowTail checks that filling the tail reallocates its cached entries
+// about as many times as doubling takes to reach the segment size, rather
+// than the dozens of times append grows a large slice, and that no room is
+// reserved past the segment size.
+func TestGrowTail(t *testing.T) {
+	const segmentSize = 4 << 20
+	l := openTest(t, t.TempDir(), Config{SegmentSize: segmentSize, SyncPolicy: SyncPolicy{Mode: SyncNever}})
+	tail := l.segments[0]
+	data := make([]byte, 100)
+
+	grown := 0
+	buf := unsafe.SliceData(tail.cbuf)
+	for i := uint64(1); len(tail.cbuf) < segmentSize; i++ {
+		if err := l.Write(i, data); err != nil {
+			t.Fatal(err)
+		}
+		if unsafe.SliceData(tail.cbuf) != buf {
+			buf = unsafe.SliceData(tail.cbuf)
+			grown++
+		}
+	}
+
+	if want := bits.Len(segmentSize); grown > want {
+		t.Fatalf("tail reallocated %d times, want at most %d", grown, want)
+	}
+	if limit := segmentSize + len(data) + maxHeaderSize + tail.sum.Size; cap(tail.cbuf) > limit {
+		t.Fatalf("tail capacity %d past the segment size", cap(tail.cbuf))
+	}
+}
@@ -0,0 +1,338 @@
+package jellywal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Sealed raw segments can get a sparse index sidecar, a file next to the
+// segment recording the offset of every Nth entry, so a single entry can be
+// read from a segment that is not cached without loading all of it:
+//
+//	magic[4] | interval[4] | first index[8] | count[4] | entries end[8] | file size[8] | offsets[8*n] | crc32c[4]
+//
+// where n is count divided by the interval, rounded up. The file size ties
+// the sidecar to the segment file it was written for, so one left behind by
+// a rewrite of the segment is ignored rather than trusted.
+const (
+	sparseIndexMagic      = "JIDX"
+	sparseIndexSuffix     = ".idx"
+	sparseIndexHeaderSize = 36
+)
+
+// sparseIndex is a decoded sparse index sidecar.
+type sparseIndex struct {
+	interval uint32
+	first    uint64
+	count    uint32
+	end      int64   // Offset past the last entry
+	size     int64   // Size of the segment file
+	offsets  []int64 // Offsets of every interval-th entry
+}
+
+// sparseIndexPath returns the path of the sidecar of the segment file at
+// path, which is named after the raw file of the segment.
+func sparseIndexPath(path string) string {
+	return strings.TrimSuffix(path, segmentSuffixes[segmentFileCompression(path)]) + sparseIndexSuffix
+}
+
+// appendSparseIndex appends the sidecar for a sealed segment of the given
+// size holding the entries at positions, the first of which has the given
+// index, to dst.
+func appendSparseIndex(dst []byte, interval int, index uint64, positions []bytepos, size int64) []byte {
+	start := len(dst)
+	dst = append(dst, sparseIndexMagic...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(interval))
+	dst = binary.LittleEndian.AppendUint64(dst, index)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(positions)))
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(positions[len(positions)-1].end))
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(size))
+	for i := 0; i < len(positions); i += interval {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(positions[i].start))
+	}
+	return binary.LittleEndian.AppendUint32(dst, uint32(crc32c(dst[start:])))
+}
+
+// decodeSparseIndex decodes a sidecar, reporting false when it is damaged.
+func decodeSparseIndex(data []byte) (*sparseIndex, bool) {
+	if len(data) < sparseIndexHeaderSize+4 || string(data[:4]) != sparseIndexMagic {
+		return nil, false
+	}
+
+	body := data[:len(data)-4]
+	if binary.LittleEndian.Uint32(data[len(body):]) != uint32(crc32c(body)) {
+		return nil, false
+	}
+
+	idx := &sparseIndex{
+		interval: binary.LittleEndian.Uint32(body[4:]),
+		first:    binary.LittleEndian.Uint64(body[8:]),
+		count:    binary.LittleEndian.Uint32(body[16:]),
+		end:      int64(binary.LittleEndian.Uint64(body[20:])),
+		size:     int64(binary.LittleEndian.Uint64(body[28:])),
+	}
+
+	offsets := body[sparseIndexHeaderSize:]
+	if idx.interval == 0 || idx.count == 0 || idx.end > idx.size || uint64(len(offsets)) != 8*((uint64(idx.count)+uint64(idx.interval)-1)/uint64(idx.interval)) {
+		return nil, false
+	}
+
+	idx.offsets = make([]int64, len(offsets)/8)
+	for i := range idx.offsets {
+		idx.offsets[i] = int64(binary.LittleEndian.Uint64(offsets[8*i:]))
+		if idx.offsets[i] >= idx.end || (i > 0 && idx.offsets[i] <= idx.offsets[i-1]) {
+			return nil, false
+		}
+	}
+
+	return idx, true
+}
+
+// writeSparseIndex writes the sidecar of a freshly sealed raw segment whose
+// entries are at positions. It is not synced: a sidecar lost or torn by a
+// crash fails its checksum and reads fall back to loading the segment.
+func (l *Log) writeSparseIndex(seg *segment, positions []bytepos) {
+	if l.config.SparseIndexInterval <= 0 || len(positions) == 0 {
+		return
+	}
+
+	data := appendSparseIndex(nil, l.config.SparseIndexInterval, seg.index, positions, seg.size)
+	if err := os.WriteFile(sparseIndexPath(seg.path), data, l.config.FilePerms); err != nil {
+		// Not fatal, reads load the segment instead
+		os.Remove(sparseIndexPath(seg.path))
+	}
+}
+
+// removeSparseIndex removes the sidecar of a segment file whose content
+// changes or goes away.
+func (l *Log) removeSparseIndex(path string) error {
+	l.icache.drop(path)
+	if err := os.Remove(sparseIndexPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove log segment index: %w", err)
+	}
+
+	return nil
+}
+
+// readSparse reads the entry at the given index through the sidecar of its
+// segment, when the segment is sealed, raw and not cached. A sidecar that
+// is not usable is rebuilt on the way. Only the header of the segment and
+// the entries from the nearest indexed one up to the wanted one are read.
+// It reports false whenever the entry has to be read by loading the segment
+// instead, which also reports any error. The caller must hold the read
+// lock.
+func (l *Log) readSparse(index uint64) ([]byte, bool) {
+	if l.config.SparseIndexInterval <= 0 || index >= l.segments[len(l.segments)-1].index || l.scache.holds(index) {
+		return nil, false
+	}
+
+	found := l.segments[l.findSegment(index)]
+	if segmentFileCompression(found.path) != CompressionNone {
+		return nil, false
+	}
+
+	idx := l.icache.get(found.path)
+	if idx == nil || idx.first != found.index || idx.size != found.size {
+		if idx = l.loadSparseIndex(found); idx == nil {
+			return nil, false
+		}
+	}
+
+	// Entries in front of the first index may have been punched, they
+	// cannot be skipped over
+	k := index - found.index
+	group := k / uint64(idx.interval)
+	if k >= uint64(idx.count) || found.index+group*uint64(idx.interval) < l.firstIndex {
+		return nil, false
+	}
+
+	file, err := os.Open(found.path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	header, head, err := l.readStreamedHeader(file, found.size)
+	if err != nil {
+		return nil, false
+	}
+
+	start, end := idx.offsets[group], idx.end
+	if group+1 < uint64(len(idx.offsets)) {
+		end = idx.offsets[group+1]
+	}
+
+	buf := make([]byte, end-start)
+	if _, err := file.ReadAt(buf, start); err != nil {
+		return nil, false
+	}
+
+	off := 0
+	for skip := k % uint64(idx.interval); skip > 0; skip-- {
+		n, _, err := header.format().loadNextBinaryEntry(buf[off:], header.sum)
+		if err != nil {
+			return nil, false
+		}
+		off += n
+	}
+
+	n, _, err := header.format().loadNextBinaryEntry(buf[off:], header.sum)
+	if err != nil {
+		return nil, false
+	}
+
+	seg := &segment{index: found.index, path: found.path, cbuf: head, sum: header.sum, hdr: header.size}
+	data, err := seg.decodeEntryData(buf[off:off+n], int(start)+off, index, l.entryDecoder())
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// loadSparseIndex reads the sidecar of a sealed raw segment into the cache.
+// A sidecar that is missing, damaged or left behind by an earlier file of
+// the segment is rebuilt from the loaded segment instead and written again,
+// unless the log is read-only, so later reads can use it. It returns nil
+// when the entry is to be read from the loaded segment.
+func (l *Log) loadSparseIndex(found *segment) *sparseIndex {
+	data, err := os.ReadFile(sparseIndexPath(found.path))
+	if err == nil {
+		if idx, ok := decodeSparseIndex(data); ok && idx.first == found.index && idx.size == found.size {
+			l.icache.put(found.path, idx)
+			return idx
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	seg, err := l.loadSegment(found.index)
+	if err != nil || len(seg.cpos) == 0 {
+		return nil
+	}
+
+	data = appendSparseIndex(nil, l.config.SparseIndexInterval, found.index, seg.cpos, found.size)
+	idx, _ := decodeSparseIndex(data)
+	l.icache.put(found.path, idx)
+
+	if !l.config.ReadOnly {
+		// Concurrent readers may rebuild the same sidecar, each writes its
+		// own temp file and the last rename wins
+		l.replaceSparseIndex(found.path, data)
+	}
+
+	// The segment is cached now, reading the entry from it is cheaper
+	return nil
+}
+
+// replaceSparseIndex atomically replaces the sidecar of the segment file at
+// path with data. Failures are ignored, the sidecar is rebuilt again next
+// time.
+func (l *Log) replaceSparseIndex(path string, data []byte) {
+	file, err := os.CreateTemp(l.path, "TEMP*"+sparseIndexSuffix)
+	if err != nil {
+		return
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), l.config.FilePerms)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), sparseIndexPath(path))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+}
+
+// sparseCache keeps the decoded sidecars of segment files by path. They are
+// small next to the segments, so every one read is kept until the cache is
+// cleared or its segment file changes, unless MaxCacheBytes gives the cache
+// a budget, beyond which the least recently read ones are evicted.
+type sparseCache struct {
+	mu      sync.Mutex
+	budget  int64    // Max bytes of cached sidecars, 0 for no limit
+	size    int64    // Bytes of cached sidecars
+	order   []string // Paths of the cached sidecars, least recently used first
+	entries map[string]*sparseIndex
+}
+
+// get returns the cached sidecar of the segment file at path, or nil.
+func (c *sparseCache) get(path string) *sparseIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := c.entries[path]
+	if idx != nil {
+		i := slices.Index(c.order, path)
+		copy(c.order[i:], c.order[i+1:])
+		c.order[len(c.order)-1] = path
+	}
+	return idx
+}
+
+// put caches the sidecar of the segment file at path, evicting the least
+// recently used ones to stay within the budget.
+func (c *sparseCache) put(path string, idx *sparseIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*sparseIndex)
+	}
+	c.remove(path)
+
+	size := idx.memorySize()
+	for c.budget > 0 && len(c.order) > 0 && c.size+size > c.budget {
+		c.remove(c.order[0])
+	}
+
+	c.entries[path] = idx
+	c.order = append(c.order, path)
+	c.size += size
+}
+
+// drop forgets the sidecar of the segment file at path.
+func (c *sparseCache) drop(path string) {
+	c.mu.Lock()
+	c.remove(path)
+	c.mu.Unlock()
+}
+
+// remove forgets the sidecar of the segment file at path. The caller must
+// hold the cache lock.
+func (c *sparseCache) remove(path string) {
+	idx := c.entries[path]
+	if idx == nil {
+		return
+	}
+
+	i := slices.Index(c.order, path)
+	c.order = slices.Delete(c.order, i, i+1)
+	delete(c.entries, path)
+	c.size -= idx.memorySize()
+}
+
+// clear drops every cached sidecar.
+func (c *sparseCache) clear() {
+	c.mu.Lock()
+	c.entries = nil
+	c.order = nil
+	c.size = 0
+	c.mu.Unlock()
+}
+
+// memorySize returns the memory held by the decoded sidecar.
+func (idx *sparseIndex) memorySize() int64 {
+	return int64(unsafe.Sizeof(*idx)) + 8*int64(len(idx.offsets))
+}
@@ -0,0 +1,210 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// TestSparseIndexEncoding checks that sidecars decode to what was encoded,
+// and that damaged ones are rejected.
+func TestSparseIndexEncoding(t *testing.T) {
+	var positions []bytepos
+	for i := 0; i < 20; i++ {
+		positions = append(positions, bytepos{16 + 10*i, 26 + 10*i})
+	}
+	data := appendSparseIndex(nil, 8, 100, positions, 300)
+
+	idx, ok := decodeSparseIndex(data)
+	if !ok {
+		t.Fatal("sidecar rejected")
+	}
+	if idx.interval != 8 || idx.first != 100 || idx.count != 20 || idx.end != 216 || idx.size != 300 {
+		t.Fatalf("got %+v", idx)
+	}
+	if want := []int64{16, 96, 176}; !slices.Equal(idx.offsets, want) {
+		t.Fatalf("got offsets %v, want %v", idx.offsets, want)
+	}
+
+	for i := range data {
+		damaged := bytes.Clone(data)
+		damaged[i] ^= 1
+		if _, ok := decodeSparseIndex(damaged); ok {
+			t.Fatalf("sidecar damaged at %d accepted", i)
+		}
+	}
+	for _, n := range []int{0, 4, sparseIndexHeaderSize, len(data) - 1} {
+		if _, ok := decodeSparseIndex(data[:n]); ok {
+			t.Fatalf("sidecar cut to %d bytes accepted", n)
+		}
+	}
+}
+
+// TestSparseIndex checks that sealed segments get a sidecar, and that reads
+// of segments that are not cached go through it without loading them.
+func TestSparseIndex(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SparseIndexInterval: 8}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	for _, seg := range l.segments[:len(l.segments)-1] {
+		if _, err := os.Stat(sparseIndexPath(seg.path)); err != nil {
+			t.Fatalf("segment %d: %v", seg.index, err)
+		}
+	}
+	if _, err := os.Stat(sparseIndexPath(l.segments[len(l.segments)-1].path)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("tail has a sidecar: %v", err)
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		index := uint64(r.Intn(500) + 1)
+		data, err := l.Read(index)
+		if err != nil {
+			t.Fatalf("read %d: %v", index, err)
+		}
+		if !bytes.Equal(data, testEntry(index)) {
+			t.Fatalf("read %d: got %q, want %q", index, data, testEntry(index))
+		}
+	}
+	if n := len(l.scache.entries); n != 0 {
+		t.Fatalf("%d segments loaded, want the entries read through their sidecars", n)
+	}
+}
+
+// TestSparseIndexRebuilt checks that a missing or damaged sidecar is
+// rebuilt on the first read of its segment.
+func TestSparseIndexRebuilt(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SparseIndexInterval: 8}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	first, second := l.segments[0], l.segments[1]
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(sparseIndexPath(first.path)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(sparseIndexPath(second.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(sparseIndexPath(second.path), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	for _, seg := range []*segment{first, second} {
+		data, err := os.ReadFile(sparseIndexPath(seg.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := decodeSparseIndex(data); !ok {
+			t.Fatalf("sidecar of segment %d not rebuilt", seg.index)
+		}
+	}
+}
+
+// TestSparseIndexTruncate checks that the sidecars of deleted segments are
+// removed along with them.
+func TestSparseIndexTruncate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024, SparseIndexInterval: 8}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	sealed := append([]*segment(nil), l.segments[:len(l.segments)-1]...)
+
+	if err := l.TruncateFront(250); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TruncateBack(400); err != nil {
+		t.Fatal(err)
+	}
+	for i, seg := range sealed {
+		deleted := seg.index > 400 || (i+1 < len(sealed) && sealed[i+1].index <= 250)
+		if _, err := os.Stat(sparseIndexPath(seg.path)); deleted && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("sidecar of deleted segment %d kept: %v", seg.index, err)
+		}
+	}
+	checkEntries(t, l, 250, 400)
+}
+
+// TestSparseIndexStale checks that a sidecar written for another file of
+// the segment is rebuilt rather than trusted, and that sidecars are built
+// for a log written without them, once and by concurrent readers alike.
+func TestSparseIndexStale(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	sealed := append([]*segment(nil), l.segments[:len(l.segments)-1]...)
+
+	cfg.SparseIndexInterval = 8
+	l = reopenTest(t, l, dir, cfg)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := uint64(1); i <= 500; i += 7 {
+				if data, err := l.Read(i); err != nil || !bytes.Equal(data, testEntry(i)) {
+					t.Errorf("read %d: got %q, %v", i, data, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, seg := range sealed {
+		if _, err := os.Stat(sparseIndexPath(seg.path)); err != nil {
+			t.Fatalf("sidecar of segment %d not built: %v", seg.index, err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first segment gets the sidecar of the second
+	stale, err := os.ReadFile(sparseIndexPath(sealed[1].path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sparseIndexPath(sealed[0].path), stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	data, err := os.ReadFile(sparseIndexPath(sealed[0].path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, ok := decodeSparseIndex(data); !ok || idx.first != sealed[0].index {
+		t.Fatal("stale sidecar not rebuilt")
+	}
+}
+
+// TestSparseIndexReadOnly checks that a read-only log reads through a
+// missing sidecar without writing one.
+func TestSparseIndexReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	first := l.segments[0]
+
+	cfg.SparseIndexInterval, cfg.ReadOnly = 8, true
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	if _, err := os.Stat(sparseIndexPath(first.path)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("read-only log wrote a sidecar: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package jellywal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Split splits every sealed segment holding more than SegmentSize bytes into
+// segments of up to SegmentSize bytes each, such as after importing foreign
+// data or lowering SegmentSize on an existing log. A single entry larger
+// than SegmentSize gets a segment of its own. The tail is left alone, it is
+// sealed once it fills up as usual. It returns the number of segments that
+// were split.
+//
+// The pieces of a segment are written as START files and only put in place
+// once the manifest lists them, so a crash leaves the segment either split
+// or as it was. Entries in front of the first index are left out.
+func (l *Log) Split() (int, error) {
+	l.lockSegments()
+	defer l.unlockSegments()
+
+	if l.corrupt.Load() {
+		return 0, ErrCorrupt
+	} else if l.closed {
+		return 0, ErrClosed
+	} else if l.config.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	var split int
+	for i := 0; i < len(l.segments)-1; i++ {
+		pieces, err := l.splitSegment(i)
+		if err != nil {
+			return split, err
+		}
+		if pieces > 1 {
+			split++
+			i += pieces - 1
+		}
+	}
+
+	return split, nil
+}
+
+// splitSegment splits the sealed segment at segIdx when it is larger than
+// SegmentSize, and returns the number of segments it became.
+func (l *Log) splitSegment(segIdx int) (int, error) {
+	found := l.segments[segIdx]
+	seg, err := l.loadSegment(max(found.index, l.firstIndex))
+	if err != nil {
+		return 0, err
+	}
+
+	end := seg.index + uint64(len(seg.cpos))
+	if len(seg.cpos) == 0 || seg.cpos[len(seg.cpos)-1].end <= l.config.SegmentSize {
+		return 1, nil
+	} else if !l.compactable(seg) {
+		return 0, ErrNoKeyProvider
+	}
+
+	// Cut the entries into pieces of up to SegmentSize bytes, counting the
+	// header but not the footer like rotation does
+	var firsts []uint64
+	size := l.config.SegmentSize
+	for i := max(seg.index, l.firstIndex); i < end; i++ {
+		pos := seg.cpos[i-seg.index]
+		if size+pos.end-pos.start > l.config.SegmentSize {
+			firsts = append(firsts, i)
+			size = seg.hdr
+		}
+		size += pos.end - pos.start
+	}
+	if len(firsts) < 2 {
+		return 1, nil
+	}
+
+	suffix := segmentSuffixes[segmentFileCompression(found.path)]
+	pieces := make([]*segment, len(firsts))
+	positions := make([][]bytepos, len(firsts))
+	abandon := func(err error) (int, error) {
+		for _, piece := range pieces {
+			if piece != nil {
+				os.Remove(piece.path + startSuffix)
+			}
+		}
+		return 0, err
+	}
+
+	for i, first := range firsts {
+		last := end
+		if i < len(firsts)-1 {
+			last = firsts[i+1]
+		}
+
+		data, pos, err := l.segmentRange(seg, first, last, true)
+		if err != nil {
+			return abandon(err)
+		}
+		positions[i] = pos
+
+		path := filepath.Join(l.path, l.segmentName(first)+suffix)
+		size, err := l.writeStartFile(path, data)
+		if err != nil {
+			return abandon(err)
+		}
+
+		pieces[i] = &segment{
+			index: first,
+			path:  path,
+			size:  size,
+			last:  last - 1,
+			seal:  found.seal,
+		}
+	}
+
+	// The START files have to be durable before the manifest refers to them
+	if err := l.syncDir(); err != nil {
+		return abandon(err)
+	}
+
+	segments := make([]*segment, 0, len(l.segments)+len(pieces)-1)
+	segments = append(segments, l.segments[:segIdx]...)
+	segments = append(segments, pieces...)
+	segments = append(segments, l.segments[segIdx+1:]...)
+	if err := l.writeManifest(segments, l.firstIndex, 0); err != nil {
+		return abandon(noSpaceError(err))
+	}
+
+	if err := l.syncDir(); err != nil {
+		return 0, err
+	}
+
+	// Any errors from here on will not corrupt the data on disk, but leave
+	// the in-memory state inconsistent. Flag the log as corrupt so the user
+	// can recover by calling RecoverCorrupt, or Close followed by Open.
+	if err := l.removeSparseIndex(found.path); err != nil {
+		return 0, l.markCorrupt(err)
+	}
+
+	// The first piece replaces the segment file, it has to be put in place
+	// before the others for Open to tell the split was done
+	if found.path != pieces[0].path {
+		if err := l.discardSegmentFile(found); err != nil {
+			return 0, l.markCorrupt(fmt.Errorf("failed to remove split log segment: %w", err))
+		}
+	}
+	for _, piece := range pieces {
+		if err := os.Rename(piece.path+startSuffix, piece.path); err != nil {
+			return 0, l.markCorrupt(fmt.Errorf("failed to rename START log segment: %w", err))
+		}
+		l.protectSegmentFile(piece.path)
+	}
+
+	if err := l.syncDir(); err != nil {
+		return 0, l.markCorrupt(err)
+	}
+
+	l.segments = segments
+	l.clearCache()
+
+	if suffix == "" {
+		for i, piece := range pieces {
+			l.writeSparseIndex(piece, positions[i])
+		}
+	}
+
+	return len(pieces), nil
+}
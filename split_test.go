@@ -0,0 +1,162 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkSegmentSizes checks that the sealed segments of the log hold no more
+// than SegmentSize bytes of entries, but for single entries.
+func checkSegmentSizes(tb testing.TB, l *Log) {
+	tb.Helper()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, found := range l.segments[:len(l.segments)-1] {
+		seg, err := l.loadSegment(max(found.index, l.firstIndex))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if end := seg.cpos[len(seg.cpos)-1].end; end > l.config.SegmentSize && len(seg.cpos) > 1 {
+			tb.Fatalf("segment %d holds %d bytes, over %d", seg.index, end, l.config.SegmentSize)
+		}
+	}
+}
+
+// TestSplit checks that sealed segments larger than SegmentSize are split
+// into segments that fit it, whatever their encoding, and that splitting
+// again does nothing.
+func TestSplit(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg.SegmentSize = 4096
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			segments := len(l.segments)
+
+			cfg.SegmentSize = 1024
+			l = reopenTest(t, l, dir, cfg)
+			split, err := l.Split()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if split != segments-1 {
+				t.Fatalf("split %d segments, want %d", split, segments-1)
+			}
+			checkSegmentSizes(t, l)
+			checkEntries(t, l, 1, 500)
+			if split, err := l.Split(); err != nil || split != 0 {
+				t.Fatalf("split again: got %d, %v; want 0", split, err)
+			}
+
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 500)
+			checkVerify(t, l)
+		})
+	}
+}
+
+// TestSplitLarge checks that an entry larger than SegmentSize gets a
+// segment of its own, and that entries truncated from the front are left
+// out.
+func TestSplitLarge(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 8192}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 100)
+	large := bytes.Repeat([]byte("x"), 2048)
+	if err := l.Write(101, large); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, 102, 600)
+	if err := l.TruncateFront(50); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.SegmentSize = 1024
+	l = reopenTest(t, l, dir, cfg)
+	if _, err := l.Split(); err != nil {
+		t.Fatal(err)
+	}
+	checkSegmentSizes(t, l)
+	if l.segments[0].index != 50 {
+		t.Fatalf("first segment starts at %d, want 50", l.segments[0].index)
+	}
+	found := false
+	for i, seg := range l.segments[:len(l.segments)-1] {
+		if seg.index == 101 && l.segments[i+1].index == 102 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("large entry not in a segment of its own")
+	}
+
+	l = reopenTest(t, l, dir, cfg)
+	if data, err := l.Read(101); err != nil || !bytes.Equal(data, large) {
+		t.Fatalf("read 101: got %d bytes, %v", len(data), err)
+	}
+	if first, err := l.FirstIndex(); err != nil || first != 50 {
+		t.Fatalf("first index %d, %v; want 50", first, err)
+	}
+	checkVerify(t, l)
+}
+
+// TestSplitInterrupted checks that Open completes a split that was recorded
+// in the manifest before a crash, and removes the pieces of one that was
+// not.
+func TestSplitInterrupted(t *testing.T) {
+	for _, recorded := range []bool{false, true} {
+		dir := t.TempDir()
+		cfg := Config{SegmentSize: 4096}
+		l := openTest(t, dir, cfg)
+		writeEntries(t, l, 1, 200)
+		cfg.SegmentSize = 1024
+		l = reopenTest(t, l, dir, cfg)
+		crashed := crashCopy(t, dir)
+
+		if _, err := l.Split(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Crash once the pieces were written, and maybe recorded
+		var starts []string
+		for _, seg := range l.segments[:len(l.segments)-1] {
+			data, err := os.ReadFile(seg.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := filepath.Join(crashed, filepath.Base(seg.path)+startSuffix)
+			if err := os.WriteFile(start, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			starts = append(starts, start)
+		}
+		if recorded {
+			manifest, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(crashed, manifestFileName), manifest, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		l = openTest(t, crashed, cfg)
+		for _, start := range starts {
+			if _, err := os.Stat(start); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("recorded %v: START file left in place: %v", recorded, err)
+			}
+		}
+		if recorded {
+			checkSegmentSizes(t, l)
+		}
+		checkEntries(t, l, 1, 200)
+		l = reopenTest(t, l, crashed, cfg)
+		checkEntries(t, l, 1, 200)
+		checkVerify(t, l)
+	}
+}
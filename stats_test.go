@@ -0,0 +1,59 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestStats checks the counts and sizes reported by Stats against the
+// segment files on disk.
+func TestStats(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	if err := l.TruncateFront(101); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, l, 101, 500)
+
+	stats, err := l.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 400 {
+		t.Fatalf("%d entries, want 400", stats.Entries)
+	}
+	if stats.Segments != len(l.segments) {
+		t.Fatalf("%d segments, want %d", stats.Segments, len(l.segments))
+	}
+
+	var disk, tail int64
+	for _, seg := range l.segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		disk += info.Size()
+		tail = info.Size()
+	}
+	if stats.DiskBytes != disk {
+		t.Fatalf("%d bytes on disk, want %d", stats.DiskBytes, disk)
+	}
+	if stats.TailBytes != tail {
+		t.Fatalf("%d bytes in the tail, want %d", stats.TailBytes, tail)
+	}
+	if stats.CacheBytes < tail {
+		t.Fatalf("%d bytes cached, want at least the tail's %d", stats.CacheBytes, tail)
+	}
+}
+
+// TestStatsClosed checks that Stats fails on a closed log.
+func TestStatsClosed(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Stats(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,269 @@
+package jellywal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// streamBufferSize is the size of the buffer segments are streamed through.
+const streamBufferSize = 64 << 10
+
+// openStreamed opens the segment file at path for streaming when it is a
+// raw file larger than StreamSegmentBytes, and returns nil otherwise.
+// Segments that cannot be stated are left to the whole file read to
+// report.
+func (l *Log) openStreamed(path string) (*os.File, int64, error) {
+	if l.config.StreamSegmentBytes <= 0 || segmentFileCompression(path) != CompressionNone {
+		return nil, 0, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Size() <= l.config.StreamSegmentBytes {
+		return nil, 0, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open log segment file: %w", err)
+	}
+
+	return file, info.Size(), nil
+}
+
+// readStreamedHeader reads the header of a streamed segment file of the
+// given size, returning it along with its bytes.
+func (l *Log) readStreamedHeader(file *os.File, size int64) (segmentHeader, []byte, error) {
+	head := make([]byte, min(size, segmentHeaderSize))
+	if _, err := file.ReadAt(head, 0); err != nil {
+		return segmentHeader{}, nil, fmt.Errorf("failed to read log segment header: %w", err)
+	}
+
+	header, err := l.readSegmentHeader(head)
+	return header, head[:header.size], err
+}
+
+// parseSegmentStream is parseSegmentEntries for a segment file of the given
+// size with entries in the given format, read through a small buffer so
+// only one entry at a time is held in memory. Errors reading the file are
+// returned as they are, damage as CorruptErrors.
+func parseSegmentStream(file *os.File, hdr int, size int64, format EntryFormat, sum *Checksum) ([]bytepos, int, error) {
+	padding, err := paddingStart(file, hdr, size)
+	if err != nil {
+		return nil, hdr, fmt.Errorf("failed to read log segment file: %w", err)
+	}
+
+	r := bufio.NewReaderSize(io.NewSectionReader(file, int64(hdr), size-int64(hdr)), streamBufferSize)
+
+	var entryPositions []bytepos
+	var buf []byte
+	currentPosition := hdr
+	committed, committedPosition := 0, hdr
+
+	for int64(currentPosition) < padding {
+		var entry []byte
+		var err error
+		entry, buf, err = readStreamEntry(r, size-int64(currentPosition), format, sum, buf)
+		if err != nil {
+			return entryPositions[:committed], committedPosition, fmt.Errorf("failed to read log segment file: %w", err)
+		}
+
+		bytesRead, flags, err := format.loadNextBinaryEntry(entry, sum)
+		if errors.Is(err, errChecksum) && flags&entryFooter != 0 {
+			// A damaged footer only loses the index, the entries are intact
+			break
+		}
+		if err != nil {
+			corrupt := err.(*CorruptError)
+			corrupt.Offset += int64(currentPosition)
+			if errors.Is(err, errChecksum) && int64(currentPosition+bytesRead) == size {
+				corrupt.Err = errTornEntry
+			}
+			return entryPositions[:committed], committedPosition, err
+		}
+
+		if flags&entryFooter != 0 {
+			// The segment was sealed, nothing but its footer follows
+			break
+		}
+
+		entryPositions = append(entryPositions, bytepos{currentPosition, currentPosition + bytesRead})
+		currentPosition += bytesRead
+
+		if flags&entryMore == 0 {
+			committed = len(entryPositions)
+			committedPosition = currentPosition
+		}
+	}
+
+	if committed < len(entryPositions) {
+		// The final batch lacks its last entry
+		return entryPositions[:committed], committedPosition, &CorruptError{Offset: int64(committedPosition), Err: errTornEntry}
+	}
+
+	return entryPositions, currentPosition, nil
+}
+
+// paddingStart returns the offset of the zeros running up to the end of the
+// segment file of the given size, which are padding when it has a header,
+// see parseSegmentEntries. The file is read backwards until the last byte
+// that is not zero.
+func paddingStart(file *os.File, hdr int, size int64) (int64, error) {
+	if hdr == 0 || size <= int64(hdr) {
+		return size, nil
+	}
+
+	buf := make([]byte, min(size-int64(hdr), streamBufferSize))
+	for end := size; end > int64(hdr); {
+		chunk := buf[:min(end-int64(hdr), int64(len(buf)))]
+		if _, err := file.ReadAt(chunk, end-int64(len(chunk))); err != nil {
+			return 0, err
+		}
+
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != 0 {
+				return end - int64(len(chunk)) + int64(i) + 1, nil
+			}
+		}
+		end -= int64(len(chunk))
+	}
+
+	return int64(hdr), nil
+}
+
+// readStreamEntry returns the encoded entry at the start of r, which holds
+// remaining bytes of the segment in the given format, reading it into buf,
+// which is returned grown when needed. An entry that does not fit is
+// returned as the bytes available, which decodeEntry rejects.
+func readStreamEntry(r *bufio.Reader, remaining int64, format EntryFormat, sum *Checksum, buf []byte) ([]byte, []byte, error) {
+	head, err := r.Peek(int(min(remaining, int64(format.maxHeaderSize()))))
+	if err != nil {
+		return nil, buf, err
+	}
+
+	header, n := format.decodeHeader(head)
+	if n <= 0 {
+		return head, buf, nil
+	}
+
+	size := uint64(n) + header>>entryFlagBits
+	if header&entryChecksum != 0 {
+		size += uint64(sum.Size)
+	}
+	if size > uint64(remaining) {
+		return head, buf, nil
+	}
+
+	if uint64(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, buf, err
+	}
+
+	return buf, buf, nil
+}
+
+// streamSegmentEntries indexes the entries of a streamed segment file of
+// the given size. The segment keeps the file to read its entries from,
+// with only the header cached. The caller owns the file on error.
+func (l *Log) streamSegmentEntries(segment *segment, file *os.File, size int64) error {
+	header, head, err := l.readStreamedHeader(file, size)
+	if err != nil {
+		return fmt.Errorf("failed to load log segment %s: %w", segment.path, err)
+	}
+
+	positions, _, err := parseSegmentStream(file, header.size, size, header.format(), header.sum)
+	if err != nil {
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			err = locateCorruption(err, segment.path, segment.index+uint64(len(positions)), 0)
+		}
+		return fmt.Errorf("failed to load log segment entries: %w", err)
+	}
+
+	segment.cbuf = head
+	segment.cpos = positions
+	segment.sum = header.sum
+	segment.hdr = header.size
+	segment.rfile = l.files.wrap(file)
+	return nil
+}
+
+// entryBytes returns the encoded entry at pos, from the cached buffer or
+// from the file of a streamed segment.
+func (s *segment) entryBytes(pos bytepos) ([]byte, error) {
+	if s.rfile == nil {
+		return s.cbuf[pos.start:pos.end], nil
+	}
+
+	buf := make([]byte, pos.end-pos.start)
+	if _, err := s.rfile.ReadAt(buf, int64(pos.start)); err != nil {
+		return nil, fmt.Errorf("failed to read log segment entry: %w", err)
+	}
+
+	return buf, nil
+}
+
+// sealStreamedTail seals a tail that was streamed because it is too large
+// to hold in memory, and starts a new tail after it. Without its entries in
+// memory it cannot be indexed, so it is sealed without a footer like
+// segments too large for one.
+func (l *Log) sealStreamedTail() error {
+	current := l.segments[len(l.segments)-1]
+	last := current.index + uint64(len(current.cpos)) - 1
+	tail := &segment{
+		index: last + 1,
+		path:  filepath.Join(l.path, l.segmentName(last+1)),
+		sum:   l.config.Checksum,
+	}
+
+	file, header, err := l.createSegmentFile(tail.path)
+	if err != nil {
+		return noSpaceError(fmt.Errorf("failed to create log segment file: %w", err))
+	}
+	tail.cbuf = header
+	tail.hdr = len(header)
+
+	current.last = last
+	current.seal = time.Now()
+	current.size = int64(current.cpos[len(current.cpos)-1].end)
+	segments := append(l.segments[:len(l.segments):len(l.segments)], tail)
+	if err := l.writeManifest(segments, l.firstIndex, 0); err != nil {
+		current.last = 0
+		current.seal = time.Time{}
+		current.size = 0
+		file.Close()
+		os.Remove(tail.path)
+		return err
+	}
+
+	if l.config.Preallocate {
+		l.sfile.Truncate(int64(current.cpos[len(current.cpos)-1].end))
+	}
+	closeErr := l.sfile.Close()
+	l.protectSegmentFile(current.path)
+
+	l.writeSparseIndex(current, current.cpos)
+	current.cbuf = nil
+	current.cpos = nil
+	current.rfile.Close()
+	current.rfile = nil
+
+	l.sfile = file
+	l.tailStarted = time.Now()
+	l.tailBase = 0
+	l.written = len(header)
+	l.segments = segments
+
+	if closeErr != nil {
+		return fmt.Errorf("failed to close sealed segment: %w", closeErr)
+	}
+
+	return l.syncDir()
+}
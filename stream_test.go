@@ -0,0 +1,84 @@
+package jellywal
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestStreamSegments checks that segments larger than StreamSegmentBytes
+// are loaded without their entries, which are read from the file, and that
+// a streamed tail is sealed so appends go to a new segment.
+func TestStreamSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 4096}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	segments := len(l.segments)
+
+	cfg.StreamSegmentBytes = 100
+	l = reopenTest(t, l, dir, cfg)
+	if len(l.segments) != segments+1 {
+		t.Fatalf("got %d segments, want the streamed tail sealed into %d", len(l.segments), segments+1)
+	}
+	checkEntries(t, l, 1, 500)
+
+	l.mu.RLock()
+	seg, err := l.loadSegment(1)
+	l.mu.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.rfile == nil || len(seg.cbuf) != seg.hdr {
+		t.Fatalf("segment loaded with %d bytes of entries, want them streamed", len(seg.cbuf)-seg.hdr)
+	}
+
+	writeEntries(t, l, 501, 600)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 600)
+	checkVerify(t, l)
+}
+
+// TestStreamSegmentsCompressed checks that compressed segments are read
+// whole whatever their size.
+func TestStreamSegmentsCompressed(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 4096, SegmentCompression: CompressionSnappy}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+
+	cfg.StreamSegmentBytes = 100
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+	l.mu.RLock()
+	seg, err := l.loadSegment(1)
+	l.mu.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.rfile != nil {
+		t.Fatal("compressed segment streamed")
+	}
+}
+
+// TestStreamSegmentsDamaged checks that damage found while streaming a
+// segment is reported as corruption.
+func TestStreamSegmentsDamaged(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 4096}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	sealed := l.segments[0]
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, sealed.path, 10)
+
+	cfg.StreamSegmentBytes = 100
+	l = openTest(t, dir, cfg)
+	if _, err := l.Read(10); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+	if _, err := l.Read(500); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package jellywal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSync checks that Sync writes out buffered entries and makes them
+// durable under a relaxed sync policy.
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{
+		SyncPolicy:      SyncPolicy{Mode: SyncNever},
+		WriteBufferSize: 1 << 20,
+	})
+	writeEntries(t, l, 1, 10)
+	if durable := l.DurableIndex(); durable != 0 {
+		t.Fatalf("durable index %d before Sync, want 0", durable)
+	}
+
+	crashed := openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 0, 0)
+
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if durable := l.DurableIndex(); durable != 10 {
+		t.Fatalf("durable index %d after Sync, want 10", durable)
+	}
+	crashed = openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 1, 10)
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Sync(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("sync after close: got %v, want ErrClosed", err)
+	}
+}
+
+// TestSyncPolicy checks how far each sync mode makes writes durable.
+func TestSyncPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		policy  SyncPolicy
+		durable uint64
+	}{
+		{"always", SyncPolicy{Mode: SyncAlways}, 100},
+		{"dsync", SyncPolicy{Mode: SyncDSync}, 100},
+		{"never", SyncPolicy{Mode: SyncNever}, 0},
+		{"interval", SyncPolicy{Mode: SyncInterval, Interval: time.Hour}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, Config{SyncPolicy: test.policy})
+			writeEntries(t, l, 1, 100)
+			if durable := l.DurableIndex(); durable != test.durable {
+				t.Fatalf("durable index %d, want %d", durable, test.durable)
+			}
+
+			l = reopenTest(t, l, dir, Config{SyncPolicy: test.policy})
+			checkEntries(t, l, 1, 100)
+		})
+	}
+}
+
+// TestSyncPolicyBytes checks that SyncInterval syncs once Bytes have been
+// written since the last sync.
+func TestSyncPolicyBytes(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{
+		SyncPolicy: SyncPolicy{Mode: SyncInterval, Interval: time.Hour, Bytes: 100},
+	})
+	writeEntries(t, l, 1, 5)
+	if durable := l.DurableIndex(); durable != 0 {
+		t.Fatalf("durable index %d below Bytes, want 0", durable)
+	}
+
+	// Each entry takes at least 10 bytes, so the sync is due by 20
+	writeEntries(t, l, 6, 20)
+	if durable := l.DurableIndex(); durable == 0 {
+		t.Fatal("no sync after Bytes were written")
+	}
+}
+
+// TestFlusher checks that the background flusher of SyncInterval makes
+// writes durable without further writes, and that Close stops it.
+func TestFlusher(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{
+		SyncPolicy: SyncPolicy{Mode: SyncInterval, Interval: 10 * time.Millisecond},
+	})
+	writeEntries(t, l, 1, 10)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.DurableIndex() != 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("durable index %d, want 10", l.DurableIndex())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := l.flushDone
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("flusher running after close")
+	}
+}
+
+// TestWriteOptionsSync checks that WriteOptions.Sync makes a write durable
+// under a relaxed sync policy, along with the writes before it.
+func TestWriteOptionsSync(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{
+		SyncPolicy:      SyncPolicy{Mode: SyncNever},
+		WriteBufferSize: 1 << 20,
+	})
+	writeEntries(t, l, 1, 5)
+	if err := l.WriteWithOptions(6, testEntry(6), WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if durable := l.DurableIndex(); durable != 6 {
+		t.Fatalf("durable index %d after a synced write, want 6", durable)
+	}
+
+	var b Batch
+	for i := uint64(7); i <= 10; i++ {
+		b.Write(i, testEntry(i))
+	}
+	if err := l.WriteBatchWithOptions(&b, WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if durable := l.DurableIndex(); durable != 10 {
+		t.Fatalf("durable index %d after a synced batch, want 10", durable)
+	}
+
+	writeEntries(t, l, 11, 15)
+	if durable := l.DurableIndex(); durable != 10 {
+		t.Fatalf("durable index %d after unsynced writes, want 10", durable)
+	}
+	crashed := openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 1, 10)
+}
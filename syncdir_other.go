@@ -0,0 +1,21 @@
+//go:build !windows
+
+package jellywal
+
+import "os"
+
+// syncDir fsyncs the directory so that file creations, renames and removals
+// inside it survive a power loss.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+
+	return dir.Close()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package jellywal
+
+// syncDir is a no-op on Windows, where directories cannot be opened for
+// syncing and NTFS journals directory updates itself.
+func syncDir(path string) error {
+	return nil
+}
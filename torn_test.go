@@ -0,0 +1,158 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// tearTail cuts n bytes off the end of the tail segment of the closed log,
+// as a crash in the middle of a write leaves it, and returns its path.
+func tearTail(tb testing.TB, l *Log, n int64) string {
+	tb.Helper()
+	path := l.segments[len(l.segments)-1].path
+	info, err := os.Stat(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-n); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// TestTornWrite checks that Open truncates a torn final entry away, records
+// it as the damage, and that the log continues after the last whole entry.
+func TestTornWrite(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		if cfg.SegmentCompression != CompressionNone {
+			// The tail is never compressed
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 10)
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			path := tearTail(t, l, 3)
+
+			l = openTest(t, dir, cfg)
+			checkEntries(t, l, 1, 9)
+			if err := l.Damage(); !errors.Is(err, errTornEntry) {
+				t.Fatalf("damage %v, want errTornEntry", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(l.segments[len(l.segments)-1].cbuf)) {
+				t.Fatalf("tail is %d bytes, want the %d bytes of whole entries", info.Size(), len(l.segments[len(l.segments)-1].cbuf))
+			}
+
+			writeEntries(t, l, 10, 20)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 20)
+			if err := l.Damage(); err != nil {
+				t.Fatalf("damage %v after a clean close, want none", err)
+			}
+		})
+	}
+}
+
+// TestTornHeader checks that a tail cut inside its segment header opens as
+// an empty tail.
+func TestTornHeader(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	last := l.lastIndex
+	tail := l.segments[len(l.segments)-1]
+	if err := os.Truncate(tail.path, segmentHeaderSize-2); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 1, tail.index-1)
+	if err := l.Damage(); !errors.Is(err, errTornHeader) {
+		t.Fatalf("damage %v, want errTornHeader", err)
+	}
+	writeEntries(t, l, tail.index, last)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, last)
+}
+
+// TestRecoveryModes checks how each recovery mode deals with a torn final
+// entry and with a damaged entry in the middle of the tail.
+func TestRecoveryModes(t *testing.T) {
+	for _, test := range []struct {
+		mode     RecoveryMode
+		torn     uint64 // Last index after a torn write, 0 when Open fails
+		damaged  uint64 // Last index after damage, 0 when Open fails
+		readOnly bool   // Whether the damaged log opens read-only
+	}{
+		{RecoverTorn, 9, 0, false},
+		{RecoverStrict, 0, 0, false},
+		{RecoverTruncate, 9, 4, false},
+		{RecoverReadOnly, 9, 4, true},
+	} {
+		for _, torn := range []bool{true, false} {
+			dir := t.TempDir()
+			l := openTest(t, dir, Config{})
+			writeEntries(t, l, 1, 10)
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			path := l.segments[0].path
+			want := test.damaged
+			if torn {
+				tearTail(t, l, 3)
+				want = test.torn
+			} else {
+				corruptEntry(t, path, 5)
+			}
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			l, err = Open(dir, &Config{Recovery: test.mode})
+			if want == 0 {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Fatalf("mode %d, torn %v: got %v, want ErrCorrupt", test.mode, torn, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("mode %d, torn %v: %v", test.mode, torn, err)
+			}
+			checkEntries(t, l, 1, want)
+			if l.Damage() == nil {
+				t.Fatalf("mode %d, torn %v: no damage reported", test.mode, torn)
+			}
+
+			readOnly := test.readOnly && !torn
+			if err := l.Write(want+1, testEntry(want+1)); errors.Is(err, ErrReadOnly) != readOnly {
+				t.Fatalf("mode %d, torn %v: write got %v, want read-only %v", test.mode, torn, err, readOnly)
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if readOnly {
+				after, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(before, after) {
+					t.Fatalf("mode %d: damaged file was modified", test.mode)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package jellywal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// truncateConfigs are the configurations the truncation tests run with,
+// covering the different ways a segment is truncated.
+var truncateConfigs = map[string]Config{
+	"raw":        {},
+	"fixed":      {EntryFormat: EntryFormatFixed},
+	"mmap":       {MmapSegments: true},
+	"compressed": {SegmentCompression: CompressionSnappy},
+}
+
+// TestTruncateBackInsideBatch checks that truncating to an entry in the
+// middle of a batch keeps it, both right away and after reopening, in the
+// tail and in a sealed segment.
+func TestTruncateBackInsideBatch(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 256
+			for _, sealed := range []bool{false, true} {
+				dir := t.TempDir()
+				l := openTest(t, dir, cfg)
+				writeEntries(t, l, 1, 3)
+				writeBatchEntries(t, l, 4, 8)
+				if sealed {
+					writeEntries(t, l, 9, 40)
+				}
+
+				if err := l.TruncateBack(6); err != nil {
+					t.Fatal(err)
+				}
+				checkEntries(t, l, 1, 6)
+
+				writeEntries(t, l, 7, 10)
+				checkEntries(t, l, 1, 10)
+
+				l = reopenTest(t, l, dir, cfg)
+				checkEntries(t, l, 1, 10)
+			}
+		})
+	}
+}
+
+// TestTruncateBackInsideBatchReopen checks that an entry a truncation cut
+// a batch at survives reopening with nothing written after it.
+func TestTruncateBackInsideBatchReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeBatchEntries(t, l, 1, 5)
+	if err := l.TruncateBack(3); err != nil {
+		t.Fatal(err)
+	}
+
+	l = reopenTest(t, l, dir, Config{})
+	checkEntries(t, l, 1, 3)
+}
+
+// TestSplitInsideBatch checks that splitting a segment between the entries
+// of a batch leaves every piece readable and intact after reopening.
+func TestSplitInsideBatch(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 4096}
+	l := openTest(t, dir, cfg)
+	writeBatchEntries(t, l, 1, 400)
+	writeBatchEntries(t, l, 401, 410)
+
+	cfg.SegmentSize = 256
+	l = reopenTest(t, l, dir, cfg)
+	split, err := l.Split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if split != 1 {
+		t.Fatalf("split %d segments, want 1", split)
+	}
+	checkEntries(t, l, 1, 410)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 410)
+	report, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("split segments do not verify: %v", report.Problems)
+	}
+}
+
+// TestTruncateFront checks that truncating the front removes the entries
+// before the index, within a segment and across segments, and that the
+// log stays writable.
+func TestTruncateFront(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+			segments := len(l.segments)
+
+			if err := l.TruncateFront(250); err != nil {
+				t.Fatal(err)
+			}
+			checkEntries(t, l, 250, 500)
+			if len(l.segments) >= segments {
+				t.Fatalf("%d segments left of %d, want fewer", len(l.segments), segments)
+			}
+			if err := l.TruncateFront(251); err != nil {
+				t.Fatal(err)
+			}
+			checkEntries(t, l, 251, 500)
+
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 251, 500)
+			writeEntries(t, l, 501, 550)
+			if err := l.TruncateFront(550); err != nil {
+				t.Fatal(err)
+			}
+			checkEntries(t, l, 550, 550)
+		})
+	}
+}
+
+// TestTruncateFrontOutOfRange checks that indexes outside of the log are
+// rejected.
+func TestTruncateFrontOutOfRange(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	if err := l.TruncateFront(1); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("empty log: got %v, want ErrOutOfRange", err)
+	}
+
+	writeEntries(t, l, 1, 10)
+	if err := l.TruncateFront(5); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []uint64{0, 4, 11} {
+		if err := l.TruncateFront(index); !errors.Is(err, ErrOutOfRange) {
+			t.Fatalf("truncate to %d: got %v, want ErrOutOfRange", index, err)
+		}
+	}
+	checkEntries(t, l, 5, 10)
+}
+
+// TestTruncateFrontStray checks that a segment file a crash left behind
+// after the truncation was recorded is not taken back by Open.
+func TestTruncateFrontStray(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SegmentSize: 1024}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+
+	first := l.segments[0].path
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TruncateFront(250); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(first, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l = openTest(t, dir, cfg)
+	checkEntries(t, l, 250, 500)
+	if _, err := os.Stat(first); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stray segment left in place: %v", err)
+	}
+}
+
+// TestTruncateBack checks that truncating the back removes the entries
+// after the index, within the tail and across segments, and that the log
+// takes the following index next.
+func TestTruncateBack(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 500)
+
+			if err := l.TruncateBack(495); err != nil {
+				t.Fatal(err)
+			}
+			checkEntries(t, l, 1, 495)
+			segments := len(l.segments)
+			if err := l.TruncateBack(100); err != nil {
+				t.Fatal(err)
+			}
+			checkEntries(t, l, 1, 100)
+			if len(l.segments) >= segments {
+				t.Fatalf("%d segments left of %d, want fewer", len(l.segments), segments)
+			}
+
+			writeEntries(t, l, 101, 200)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 200)
+			if err := l.TruncateBack(1); err != nil {
+				t.Fatal(err)
+			}
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 1)
+		})
+	}
+}
+
+// TestTruncateBackOutOfRange checks that indexes outside of the log are
+// rejected.
+func TestTruncateBackOutOfRange(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	if err := l.TruncateBack(1); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("empty log: got %v, want ErrOutOfRange", err)
+	}
+
+	writeEntries(t, l, 1, 10)
+	if err := l.TruncateFront(5); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []uint64{0, 4, 11} {
+		if err := l.TruncateBack(index); !errors.Is(err, ErrOutOfRange) {
+			t.Fatalf("truncate to %d: got %v, want ErrOutOfRange", index, err)
+		}
+	}
+	checkEntries(t, l, 5, 10)
+}
+
+// TestTruncateBackInterrupted checks that Open completes a truncation that
+// was recorded in the manifest before a crash, including one cutting a
+// batch.
+func TestTruncateBackInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	l := openTest(t, dir, Config{})
+	writeEntries(t, l, 1, 10)
+	writeBatchEntries(t, l, 11, 20)
+
+	// Crash right after the truncation was recorded
+	if err := l.writeManifest(l.segments, l.firstIndex, 15); err != nil {
+		t.Fatal(err)
+	}
+	crashed := crashCopy(t, dir)
+
+	l = openTest(t, crashed, Config{})
+	checkEntries(t, l, 1, 15)
+	l = reopenTest(t, l, crashed, Config{})
+	checkEntries(t, l, 1, 15)
+	writeEntries(t, l, 16, 20)
+	l = reopenTest(t, l, crashed, Config{})
+	checkEntries(t, l, 1, 20)
+}
@@ -0,0 +1,104 @@
+package jellywal
+
+import (
+	"fmt"
+	"time"
+)
+
+// uringEntries is the number of tail writes and fsyncs the ring of a log
+// with IOUring holds in flight.
+const uringEntries = 64
+
+// startRing sets up the ring the tail writes and fsyncs are submitted to
+// with IOUring. Without io_uring they are made with plain system calls.
+func (l *Log) startRing() {
+	if !l.config.IOUring || l.config.ReadOnly {
+		return
+	}
+
+	if ring, err := newURing(uringEntries); err == nil {
+		l.ring = ring
+	}
+}
+
+// stopRing waits for the operations in flight on the ring and releases it.
+// The caller must hold the write lock.
+func (l *Log) stopRing() error {
+	if l.ring == nil {
+		return nil
+	}
+
+	err := l.ring.close()
+	l.ring = nil
+	return err
+}
+
+// writevTail writes the buffers to the tail segment file like writev,
+// through the ring when there is one.
+func (l *Log) writevTail(bufs [][]byte) (int, error) {
+	if l.ring != nil {
+		return l.ring.writev(l.sfile, bufs, -1)
+	}
+	return writev(l.sfile, bufs)
+}
+
+// datasyncTail flushes the tail segment file to stable storage like
+// datasync, through the ring when there is one.
+func (l *Log) datasyncTail() error {
+	if l.ring != nil {
+		return l.ring.fsync(l.sfile)
+	}
+	return datasync(l.sfile)
+}
+
+// syncTailAsync is syncTail for the fsyncs of the sync policy, which
+// nothing waits for. With a ring the fsync is submitted without waiting for
+// it, the durable index advances once the reaper completes it.
+func (l *Log) syncTailAsync() error {
+	if l.ring == nil {
+		return l.syncTail()
+	}
+
+	if err := l.flushTail(); err != nil {
+		return err
+	}
+
+	index, epoch := l.lastIndex, l.durableEpoch
+	err := l.ring.fsyncAsync(l.sfile, func(err error) {
+		// The reaper must not wait for the log lock, which a writer
+		// waiting on the ring may hold
+		go l.completeSync(index, epoch, err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sync tail segment: %w", err)
+	}
+
+	l.lastSync = time.Now()
+	l.unsynced = 0
+
+	return nil
+}
+
+// completeSync records the outcome of an fsync submitted by syncTailAsync
+// for the entries up to index. A failed fsync is reported to OnSyncError
+// and leaves the entries to be synced again.
+func (l *Log) completeSync(index, epoch uint64, err error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+
+	if err != nil {
+		l.unsynced = max(l.unsynced, 1)
+		l.lastSync = time.Time{}
+	} else if epoch == l.durableEpoch {
+		// Entries truncated since may have been written again unsynced
+		l.setDurable(index)
+	}
+	l.mu.Unlock()
+
+	if err != nil && l.config.OnSyncError != nil {
+		l.config.OnSyncError(fmt.Errorf("failed to sync tail segment: %w", err))
+	}
+}
@@ -0,0 +1,407 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring constants from linux/io_uring.h, which x/sys/unix lacks
+const (
+	uringOpNop    = 0
+	uringOpWritev = 2
+	uringOpFsync  = 3
+
+	uringFsyncDatasync  = 1
+	uringEnterGetEvents = 1
+
+	uringFeatSingleMmap = 1 << 0
+	uringFeatRWCurPos   = 1 << 3
+
+	uringOffSQRing = 0
+	uringOffSQEs   = 0x10000000
+)
+
+// uringParams is struct io_uring_params.
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+// uringSQOffsets is struct io_sqring_offsets.
+type uringSQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// uringCQOffsets is struct io_cqring_offsets.
+type uringCQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// uringSQE is struct io_uring_sqe.
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+// uringCQE is struct io_uring_cqe.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringOp is an operation submitted to the ring. It keeps the memory the
+// kernel reads from reachable until the operation completes, the iovecs
+// point to the buffers written.
+type uringOp struct {
+	iovecs []unix.Iovec
+	done   func(res int32)
+}
+
+// uring is an io_uring the tail writes and fsyncs are submitted to. A reaper
+// goroutine waits for their completions and hands them to the operations.
+type uring struct {
+	fd   int
+	ring []byte // Submission and completion rings, mapped together
+	sqes []uringSQE
+
+	sqTail *uint32
+	sqMask uint32
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []uringCQE
+
+	mu       sync.Mutex
+	idle     sync.Cond // Signalled when an operation completes
+	ops      map[uint64]*uringOp
+	next     uint64 // User data of the next operation, zero wakes the reaper to stop
+	closing  bool
+	broken   error // Set when a submission may have been left in the ring
+	reaped   chan struct{}
+	capacity int
+}
+
+// newURing sets up a ring with room for the given number of operations in
+// flight. Kernels without a ring, or without the features the log relies
+// on, return an error.
+func newURing(entries uint32) (*uring, error) {
+	var params uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("failed to set up io_uring: %w", errno)
+	}
+
+	r := &uring{fd: int(fd), ops: make(map[uint64]*uringOp), next: 1, reaped: make(chan struct{})}
+	r.idle.L = &r.mu
+
+	// Writes at the file offset keep the tail file position in step with
+	// the writes made without the ring
+	if want := uint32(uringFeatSingleMmap | uringFeatRWCurPos); params.features&want != want {
+		unix.Close(r.fd)
+		return nil, fmt.Errorf("failed to set up io_uring: %w", errors.ErrUnsupported)
+	}
+
+	size := max(params.sqOff.array+params.sqEntries*4, params.cqOff.cqes+params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	ring, err := unix.Mmap(r.fd, uringOffSQRing, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		unix.Close(r.fd)
+		return nil, fmt.Errorf("failed to map io_uring: %w", err)
+	}
+	r.ring = ring
+
+	sqes, err := unix.Mmap(r.fd, uringOffSQEs, int(params.sqEntries)*int(unsafe.Sizeof(uringSQE{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		unix.Munmap(ring)
+		unix.Close(r.fd)
+		return nil, fmt.Errorf("failed to map io_uring: %w", err)
+	}
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&sqes[0])), params.sqEntries)
+
+	r.sqTail = (*uint32)(unsafe.Pointer(&ring[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&ring[params.sqOff.ringMask]))
+	r.cqHead = (*uint32)(unsafe.Pointer(&ring[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&ring[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&ring[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&ring[params.cqOff.cqes])), params.cqEntries)
+
+	// Every submission queue slot refers to the entry of the same number
+	array := unsafe.Slice((*uint32)(unsafe.Pointer(&ring[params.sqOff.array])), params.sqEntries)
+	for i := range array {
+		array[i] = uint32(i)
+	}
+	r.capacity = int(params.sqEntries)
+
+	go r.reap()
+	return r, nil
+}
+
+// submit queues the operation prepared by prep and submits it to the
+// kernel, waiting for room when the ring is full. op.done is called by the
+// reaper with the result once it completes.
+func (r *uring) submit(op *uringOp, prep func(sqe *uringSQE)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.ops) >= r.capacity && !r.closing && r.broken == nil {
+		r.idle.Wait()
+	}
+	if r.broken != nil {
+		return r.broken
+	} else if r.closing {
+		return os.ErrClosed
+	}
+
+	userData := r.next
+	r.next++
+	r.ops[userData] = op
+	r.push(userData, prep)
+
+	if err := r.enter(1); err != nil {
+		// The entry may still be in the ring, and would be submitted along
+		// with the next one. The operation is kept to hold on to the memory
+		// it refers to, and the ring is never entered again.
+		r.broken = fmt.Errorf("failed to submit to io_uring: %w", err)
+		return r.broken
+	}
+
+	return nil
+}
+
+// push fills the next submission queue entry. The caller must hold the lock.
+func (r *uring) push(userData uint64, prep func(sqe *uringSQE)) {
+	tail := *r.sqTail
+	sqe := &r.sqes[tail&r.sqMask]
+	*sqe = uringSQE{userData: userData}
+	prep(sqe)
+	atomic.StoreUint32(r.sqTail, tail+1)
+}
+
+// enter submits the given number of queued entries.
+func (r *uring) enter(n uint) error {
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(n), 0, 0, 0, 0)
+		if errno != unix.EINTR {
+			if errno != 0 {
+				return errno
+			}
+			return nil
+		}
+	}
+}
+
+// reap waits for completions and hands them to their operations until the
+// ring is closed.
+func (r *uring) reap() {
+	defer close(r.reaped)
+
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), 0, 1, uringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != unix.EINTR {
+			// The ring is unusable, fail the operations waiting on it
+			r.mu.Lock()
+			r.broken = fmt.Errorf("failed to wait for io_uring: %w", errno)
+			ops := r.ops
+			r.ops = make(map[uint64]*uringOp)
+			r.idle.Broadcast()
+			r.mu.Unlock()
+			for _, op := range ops {
+				op.done(-int32(errno))
+			}
+			return
+		}
+
+		head, tail := *r.cqHead, atomic.LoadUint32(r.cqTail)
+		var stop bool
+		for ; head != tail; head++ {
+			cqe := r.cqes[head&r.cqMask]
+			if cqe.userData == 0 {
+				stop = true
+				continue
+			}
+
+			r.mu.Lock()
+			op := r.ops[cqe.userData]
+			delete(r.ops, cqe.userData)
+			r.idle.Broadcast()
+			r.mu.Unlock()
+
+			if op != nil {
+				op.done(cqe.res)
+			}
+		}
+		atomic.StoreUint32(r.cqHead, head)
+
+		if stop {
+			return
+		}
+	}
+}
+
+// wait submits the operation and waits for its result.
+func (r *uring) wait(op *uringOp, prep func(sqe *uringSQE)) (int32, error) {
+	results := make(chan int32, 1)
+	op.done = func(res int32) { results <- res }
+	if err := r.submit(op, prep); err != nil {
+		return 0, err
+	}
+	return <-results, nil
+}
+
+// writev writes the buffers to the file at the given offset, or at its
+// offset when negative like the writev function, through the ring.
+func (r *uring) writev(file *os.File, bufs [][]byte, off int64) (int, error) {
+	if len(bufs) > maxIovecs {
+		bufs = bufs[:maxIovecs]
+	}
+	if off < 0 {
+		off = -1
+	}
+
+	op := &uringOp{iovecs: make([]unix.Iovec, 0, len(bufs))}
+	for _, buf := range bufs {
+		if len(buf) > 0 {
+			iovec := unix.Iovec{Base: &buf[0]}
+			iovec.SetLen(len(buf))
+			op.iovecs = append(op.iovecs, iovec)
+		}
+	}
+	if len(op.iovecs) == 0 {
+		return 0, nil
+	}
+
+	res, err := r.wait(op, func(sqe *uringSQE) {
+		sqe.opcode = uringOpWritev
+		sqe.fd = int32(file.Fd())
+		sqe.off = uint64(off) // -1 writes at the file offset and advances it
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&op.iovecs[0])))
+		sqe.len = uint32(len(op.iovecs))
+	})
+	runtime.KeepAlive(op)
+	if err != nil {
+		return 0, &os.PathError{Op: "writev", Path: file.Name(), Err: err}
+	} else if res < 0 {
+		return 0, &os.PathError{Op: "writev", Path: file.Name(), Err: unix.Errno(-res)}
+	}
+
+	return int(res), nil
+}
+
+// fsync flushes the file data to stable storage like datasync, through the
+// ring.
+func (r *uring) fsync(file *os.File) error {
+	res, err := r.wait(&uringOp{}, fsyncPrep(file))
+	if err == nil && res < 0 {
+		err = unix.Errno(-res)
+	}
+	if err != nil {
+		return &os.PathError{Op: "fdatasync", Path: file.Name(), Err: err}
+	}
+	return nil
+}
+
+// fsyncAsync submits an fsync of the file data without waiting for it. The
+// reaper calls done with its outcome, which must not block.
+func (r *uring) fsyncAsync(file *os.File, done func(err error)) error {
+	op := &uringOp{done: func(res int32) {
+		var err error
+		if res < 0 {
+			err = &os.PathError{Op: "fdatasync", Path: file.Name(), Err: unix.Errno(-res)}
+		}
+		done(err)
+	}}
+
+	if err := r.submit(op, fsyncPrep(file)); err != nil {
+		return &os.PathError{Op: "fdatasync", Path: file.Name(), Err: err}
+	}
+	return nil
+}
+
+// fsyncPrep prepares an fdatasync of the file.
+func fsyncPrep(file *os.File) func(sqe *uringSQE) {
+	fd := int32(file.Fd())
+	return func(sqe *uringSQE) {
+		sqe.opcode = uringOpFsync
+		sqe.fd = fd
+		sqe.opFlags = uringFsyncDatasync
+	}
+}
+
+// close waits for the operations in flight, stops the reaper and releases
+// the ring. A ring broken by a failed submission cannot wake its reaper
+// without submitting what was left in it, it is left to the reaper.
+func (r *uring) close() error {
+	r.mu.Lock()
+	r.closing = true
+	for len(r.ops) > 0 && r.broken == nil {
+		r.idle.Wait()
+	}
+
+	if r.broken == nil {
+		// A nop without user data tells the reaper to stop
+		r.push(0, func(sqe *uringSQE) { sqe.opcode = uringOpNop })
+		if err := r.enter(1); err != nil {
+			r.broken = fmt.Errorf("failed to stop io_uring: %w", err)
+		}
+	}
+	broken := r.broken
+	r.mu.Unlock()
+
+	select {
+	case <-r.reaped:
+	default:
+		if broken != nil {
+			return broken
+		}
+		<-r.reaped
+	}
+
+	unix.Munmap(unsafe.Slice((*byte)(unsafe.Pointer(&r.sqes[0])), len(r.sqes)*int(unsafe.Sizeof(uringSQE{}))))
+	unix.Munmap(r.ring)
+	return unix.Close(r.fd)
+}
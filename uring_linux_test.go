@@ -0,0 +1,106 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openRing sets up a ring, skipping the test where io_uring is unavailable.
+func openRing(tb testing.TB) *uring {
+	tb.Helper()
+	ring, err := newURing(uringEntries)
+	if err != nil {
+		tb.Skip(err)
+	}
+	tb.Cleanup(func() { ring.close() })
+	return ring
+}
+
+// TestURing checks that writes through the ring land at the file offset or
+// the one given, and that fsyncs complete whether waited for or not.
+func TestURing(t *testing.T) {
+	ring := openRing(t)
+	file, err := os.Create(filepath.Join(t.TempDir(), "uring"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	bufs := [][]byte{[]byte("hello"), nil, []byte(", "), []byte("world")}
+	if n, err := ring.writev(file, bufs, -1); err != nil || n != 12 {
+		t.Fatalf("wrote %d bytes, %v; want 12", n, err)
+	}
+	if n, err := ring.writev(file, [][]byte{[]byte("!")}, -1); err != nil || n != 1 {
+		t.Fatalf("wrote %d bytes, %v; want 1", n, err)
+	}
+	if n, err := ring.writev(file, [][]byte{[]byte("W")}, 7); err != nil || n != 1 {
+		t.Fatalf("wrote %d bytes at an offset, %v; want 1", n, err)
+	}
+	if n, err := ring.writev(file, [][]byte{nil}, -1); err != nil || n != 0 {
+		t.Fatalf("wrote %d bytes of nothing, %v", n, err)
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello, World!" {
+		t.Fatalf("got %q, want %q", data, "hello, World!")
+	}
+
+	if err := ring.fsync(file); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	if err := ring.fsyncAsync(file, func(err error) { done <- err }); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestURingClose checks that closing the ring waits for the fsyncs in
+// flight.
+func TestURingClose(t *testing.T) {
+	ring, err := newURing(uringEntries)
+	if err != nil {
+		t.Skip(err)
+	}
+	file, err := os.Create(filepath.Join(t.TempDir(), "uring"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	done := make(chan error, 2*uringEntries)
+	for i := 0; i < 2*uringEntries; i++ {
+		if err := ring.fsyncAsync(file, func(err error) { done <- err }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ring.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2*uringEntries {
+		t.Fatalf("%d fsyncs completed on close, want %d", len(done), 2*uringEntries)
+	}
+}
+
+// TestIOUringRing checks that a log with IOUring sets up a ring where
+// io_uring is available, and releases it on Close.
+func TestIOUringRing(t *testing.T) {
+	openRing(t)
+	l := openTest(t, t.TempDir(), Config{IOUring: true})
+	if l.ring == nil {
+		t.Fatal("no ring set up")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l.ring != nil {
+		t.Fatal("ring left set up after close")
+	}
+}
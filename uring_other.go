@@ -0,0 +1,38 @@
+//go:build !linux
+
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// uring stands in for the io_uring of Linux, which other platforms lack.
+type uring struct{}
+
+// newURing reports that there is no io_uring.
+func newURing(entries uint32) (*uring, error) {
+	return nil, fmt.Errorf("failed to set up io_uring: %w", errors.ErrUnsupported)
+}
+
+func (r *uring) writev(file *os.File, bufs [][]byte, off int64) (int, error) {
+	if off >= 0 {
+		return file.WriteAt(bytes.Join(bufs, nil), off)
+	}
+	return writev(file, bufs)
+}
+
+func (r *uring) fsync(file *os.File) error {
+	return datasync(file)
+}
+
+func (r *uring) fsyncAsync(file *os.File, done func(err error)) error {
+	done(datasync(file))
+	return nil
+}
+
+func (r *uring) close() error {
+	return nil
+}
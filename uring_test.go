@@ -0,0 +1,51 @@
+package jellywal
+
+import (
+	"testing"
+	"time"
+)
+
+// waitDurable waits for the durable index of the log to reach want.
+func waitDurable(tb testing.TB, l *Log, want uint64) {
+	tb.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for l.DurableIndex() < want {
+		if time.Now().After(deadline) {
+			tb.Fatalf("durable index %d, want %d", l.DurableIndex(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestIOUring checks that a log writing its tail through io_uring, or with
+// plain system calls where it is unavailable, keeps its entries and
+// advances the durable index with the fsyncs it does not wait for.
+func TestIOUring(t *testing.T) {
+	for name, policy := range map[string]SyncPolicy{
+		"always":   {Mode: SyncAlways},
+		"interval": {Mode: SyncInterval, Bytes: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{SegmentSize: 1024, SyncPolicy: policy, IOUring: true}
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 300)
+			writeBatchEntries(t, l, 301, 500)
+			checkEntries(t, l, 1, 500)
+			waitDurable(t, l, 500)
+
+			if err := l.TruncateBack(450); err != nil {
+				t.Fatal(err)
+			}
+			if durable := l.DurableIndex(); durable > 450 {
+				t.Fatalf("durable index %d after truncating to 450", durable)
+			}
+			writeEntries(t, l, 451, 600)
+			waitDurable(t, l, 600)
+
+			l = openTest(t, crashCopy(t, dir), Config{})
+			checkEntries(t, l, 1, 600)
+			checkVerify(t, l)
+		})
+	}
+}
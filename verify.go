@@ -0,0 +1,198 @@
+package jellywal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Problem is an integrity problem found by Verify.
+type Problem struct {
+	Path   string // Path of the segment file
+	Offset int64  // Byte offset of the problem in the file
+	Index  uint64 // Index of the first affected entry, 0 when unknown
+	Err    error  // What is wrong, a CorruptError unless the file could not be read
+}
+
+func (p Problem) String() string {
+	var corrupt *CorruptError
+	if errors.As(p.Err, &corrupt) {
+		// The error carries the location itself
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("%s at offset %d: %v", p.Path, p.Offset, p.Err)
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Segments int       // Number of segment files checked
+	Entries  uint64    // Number of entries that passed their checks
+	Bytes    int64     // Number of bytes read
+	Problems []Problem // Problems found, in log order
+}
+
+// OK reports whether no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify reads every segment file of the log back from disk and checks its
+// header, entry structure, checksums and footer, along with the index
+// continuity between segments. Nothing is modified: problems are collected
+// in the report instead of failing the call, which only returns an error
+// when the log is closed or corrupt. Writers are blocked while it runs, use
+// VerifyRange to check a large log piece by piece.
+func (l *Log) Verify() (*VerifyReport, error) {
+	return l.VerifyRange(0, ^uint64(0))
+}
+
+// VerifyRange is like Verify but only checks the segments holding entries
+// between the first and last index, inclusive. Segments are always checked
+// as a whole.
+func (l *Log) VerifyRange(first, last uint64) (*VerifyReport, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.corrupt.Load() {
+		return nil, ErrCorrupt
+	} else if l.closed {
+		return nil, ErrClosed
+	}
+
+	report := &VerifyReport{}
+	for i, seg := range l.segments {
+		// Segments reach up to the start of the next one
+		end := ^uint64(0)
+		if i < len(l.segments)-1 {
+			end = l.segments[i+1].index - 1
+		}
+
+		if end < first || seg.index > last {
+			continue
+		}
+
+		l.verifySegment(report, seg, end, i == len(l.segments)-1)
+	}
+
+	return report, nil
+}
+
+// verifySegment checks a single segment file, adding its problems to the
+// report. The segment may hold entries up to the end index. The caller must
+// hold the read lock.
+func (l *Log) verifySegment(report *VerifyReport, seg *segment, end uint64, isTail bool) {
+	last := end
+	if isTail {
+		last = 0
+	}
+
+	problem := func(offset int, index uint64, err error) {
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			offset = int(corrupt.Offset)
+		}
+
+		report.Problems = append(report.Problems, Problem{
+			Path:   seg.path,
+			Offset: int64(offset),
+			Index:  index,
+			Err:    locateCorruption(err, seg.path, index, max(index, last)),
+		})
+	}
+
+	data, err := readSegmentFile(seg.path)
+	if errors.Is(err, os.ErrNotExist) && isTail && len(seg.cpos) == 0 {
+		// The empty tail of a read-only log is never created
+		return
+	} else if err != nil {
+		problem(0, seg.index, fmt.Errorf("failed to read log segment file: %w", err))
+		return
+	}
+
+	report.Segments++
+	report.Bytes += int64(len(data))
+
+	header, err := l.readSegmentHeader(data)
+	if err != nil {
+		problem(0, seg.index, err)
+		return
+	}
+
+	// A sealed segment whose footer records a matching segment checksum is
+	// verified by that single pass, its entries need no further checks
+	var footer segmentFooter
+	var indexed bool
+	if !isTail {
+		footer, indexed = decodeSegmentFooter(data, header.size, seg.index, header.sum)
+	}
+
+	var positions []bytepos
+	var valid int
+	if indexed && footer.matches(data, header.sum) {
+		positions, valid = footer.positions, footer.off
+		report.Entries += uint64(len(positions))
+	} else if indexed && seg == l.segments[0] && seg.index < l.firstIndex {
+		// The entries in front of the first index may have been punched,
+		// which the segment checksum does not account for, so the entries
+		// left are checked one by one
+		positions, valid = footer.positions, footer.off
+		for i := min(l.firstIndex-seg.index, uint64(len(positions))); i < uint64(len(positions)); i++ {
+			pos := positions[i]
+			if _, _, _, err := header.format().decodeEntry(data[pos.start:pos.end], header.sum); err != nil {
+				var corrupt *CorruptError
+				if errors.As(err, &corrupt) {
+					corrupt.Offset += int64(pos.start)
+				}
+				problem(pos.start, seg.index+i, err)
+				return
+			}
+			report.Entries++
+		}
+	} else {
+		positions, valid, err = parseSegmentEntries(data, header.size, header.sum)
+		report.Entries += uint64(len(positions))
+		if err != nil {
+			problem(0, seg.index+uint64(len(positions)), err)
+			return
+		}
+
+		if indexed && footer.sum != nil && valid == footer.off {
+			// The entries are fine, so the recorded checksum is wrong
+			sum := header.sum
+			problem(0, 0, &CorruptError{
+				Offset:   int64(valid),
+				Err:      errFooter,
+				Expected: fmt.Sprintf("segment %s %0*x", sum.Name, 2*sum.Size, sum.mask(sum.Sum(data[:valid]))),
+				Found:    fmt.Sprintf("%0*x", 2*sum.Size, sum.readSum(footer.sum)),
+			})
+		}
+	}
+
+	if count := uint64(len(positions)); count > 0 && seg.index+count-1 > end {
+		offset := positions[end-seg.index+1].start
+		problem(0, end+1, &CorruptError{
+			Offset:   int64(offset),
+			Err:      errOverlap,
+			Expected: fmt.Sprintf("last index %d", end),
+			Found:    fmt.Sprintf("last index %d", seg.index+count-1),
+		})
+	} else if !isTail && !l.config.AllowGaps && seg.index+count-1 < end {
+		problem(0, seg.index+count, &CorruptError{
+			Offset:   int64(valid),
+			Err:      errMissing,
+			Expected: fmt.Sprintf("last index %d", end),
+			Found:    fmt.Sprintf("last index %d", seg.index+count-1),
+		})
+	}
+
+	// Sealed segments must be indexed by a matching footer, unless they
+	// were sealed before footers existed or were too large for one
+	if isTail || valid == len(data) {
+		return
+	}
+
+	if !indexed || !slices.Equal(footer.positions, positions) {
+		problem(0, 0, &CorruptError{Offset: int64(valid), Err: errFooter})
+	}
+}
@@ -0,0 +1,80 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestVerify checks that Verify counts every segment and entry of an
+// intact log.
+func TestVerify(t *testing.T) {
+	for name, cfg := range truncateConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg.SegmentSize = 1024
+			l := openTest(t, t.TempDir(), cfg)
+			writeEntries(t, l, 1, 500)
+
+			report, err := l.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK() {
+				t.Fatalf("intact log has problems: %v", report.Problems)
+			}
+			if report.Segments != len(l.segments) || report.Entries != 500 {
+				t.Fatalf("checked %d segments and %d entries, want %d and 500", report.Segments, report.Entries, len(l.segments))
+			}
+		})
+	}
+}
+
+// TestVerifyDamaged checks that Verify reports a damaged entry without
+// touching the file, and that VerifyRange only checks the segments of its
+// range.
+func TestVerifyDamaged(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{SegmentSize: 1024})
+	writeEntries(t, l, 1, 500)
+	damaged := l.segments[1]
+	index := damaged.index + 2
+	corruptEntry(t, damaged.path, index)
+	before, err := os.ReadFile(damaged.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 {
+		t.Fatalf("got problems %v, want one", report.Problems)
+	}
+	problem := report.Problems[0]
+	if problem.Path != damaged.path || problem.Index != index || !errors.Is(problem.Err, errChecksum) {
+		t.Fatalf("got problem %v, want a checksum mismatch of entry %d in %s", problem, index, damaged.path)
+	}
+	after, err := os.ReadFile(damaged.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("verify modified the damaged segment")
+	}
+
+	report, err = l.VerifyRange(1, damaged.index-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Segments != 1 {
+		t.Fatalf("range before the damage: %d segments with problems %v, want 1 and none", report.Segments, report.Problems)
+	}
+	report, err = l.VerifyRange(index, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Segments != 1 {
+		t.Fatalf("range of the damage: %d segments with problems %v, want 1 and some", report.Segments, report.Problems)
+	}
+}
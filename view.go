@@ -0,0 +1,82 @@
+package jellywal
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// segmentView is an immutable snapshot of the segment list, published for
+// readers of sealed segments so they need no lock at all. While a view is
+// published the segment list, the first index and the files of sealed
+// segments stay as it records them, changes to them withdraw it first and
+// wait for the readers still using it, see lockSegments. Writes to the tail
+// leave it published.
+type segmentView struct {
+	segments []*segment // Segment list, never modified in place
+	first    uint64     // First index of the log
+
+	refs    atomic.Int64  // Readers using the view
+	retired atomic.Bool   // Set once the view was withdrawn
+	drained chan struct{} // Closed once a withdrawn view has no readers left
+	once    sync.Once
+}
+
+// acquireView returns the published view, which stays valid until it is
+// released, or nil when there is none.
+func (l *Log) acquireView() *segmentView {
+	v := l.view.Load()
+	if v == nil {
+		return nil
+	}
+
+	v.refs.Add(1)
+	if l.view.Load() != v {
+		// Withdrawn in the meantime, whoever did may be waiting for us
+		v.release()
+		return nil
+	}
+
+	return v
+}
+
+// release drops a reference acquired by acquireView.
+func (v *segmentView) release() {
+	if v.refs.Add(-1) == 0 && v.retired.Load() {
+		v.once.Do(func() { close(v.drained) })
+	}
+}
+
+// withdrawView withdraws the published view and waits until no reader uses
+// it anymore, so the segments can be changed. Readers fall back to the
+// read lock in the meantime. The caller must hold the write lock.
+func (l *Log) withdrawView() {
+	v := l.view.Swap(nil)
+	if v == nil {
+		return
+	}
+
+	v.retired.Store(true)
+	if v.refs.Load() > 0 {
+		<-v.drained
+	}
+}
+
+// publishView publishes a view of the current segments, unless the log is
+// closed. The caller must hold the write lock.
+func (l *Log) publishView() {
+	if l.closed || len(l.segments) == 0 {
+		return
+	}
+
+	l.view.Store(&segmentView{
+		segments: l.segments,
+		first:    l.firstIndex,
+		drained:  make(chan struct{}),
+	})
+}
+
+// sealed reports whether the entry at the given index lives in one of the
+// sealed segments of the view.
+func (v *segmentView) sealed(index uint64) bool {
+	return index != 0 && index >= v.first && index < v.segments[len(v.segments)-1].index
+}
@@ -0,0 +1,107 @@
+package jellywal
+
+import (
+	"os"
+	"testing"
+)
+
+// tailFileSize returns the size of the tail segment file of the log.
+func tailFileSize(tb testing.TB, l *Log) int {
+	tb.Helper()
+	info, err := os.Stat(l.segments[len(l.segments)-1].path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return int(info.Size())
+}
+
+// TestWriteBuffer checks that entries are kept in memory until the buffer
+// fills up or the tail is synced, and are readable meanwhile.
+func TestWriteBuffer(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SyncPolicy: SyncPolicy{Mode: SyncNever}, WriteBufferSize: 1024}
+	l := openTest(t, dir, cfg)
+	tail := l.segments[0]
+	writeEntries(t, l, 1, 10)
+	checkEntries(t, l, 1, 10)
+	if got := tailFileSize(t, l); got != tail.hdr {
+		t.Fatalf("tail file is %d bytes, want only its %d byte header", got, tail.hdr)
+	}
+	crashed := openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 0, 0)
+
+	// Filling up the buffer writes all of it
+	for i := uint64(11); tailFileSize(t, l) == tail.hdr; i++ {
+		if i > 1000 {
+			t.Fatal("buffer never written")
+		}
+		writeEntries(t, l, i, i)
+	}
+	if got := tailFileSize(t, l); got != len(tail.cbuf) {
+		t.Fatalf("tail file is %d bytes, want %d", got, len(tail.cbuf))
+	}
+
+	last, err := l.LastIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, last+1, last+5)
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tailFileSize(t, l); got != len(tail.cbuf) {
+		t.Fatalf("tail file is %d bytes after sync, want %d", got, len(tail.cbuf))
+	}
+	crashed = openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 1, last+5)
+}
+
+// TestWriteBufferCycle checks that a segment is sealed with the entries
+// buffered for it, when the buffer is larger than the segments.
+func TestWriteBufferCycle(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		SegmentSize:     1024,
+		SyncPolicy:      SyncPolicy{Mode: SyncNever},
+		WriteBufferSize: 1 << 20,
+	}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 500)
+	checkEntries(t, l, 1, 500)
+
+	crashed := openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 1, l.segments[len(l.segments)-1].index-1)
+	checkVerify(t, crashed)
+
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 500)
+}
+
+// TestWriteBufferTruncateBack checks that truncating the back cuts off
+// buffered entries as well as written ones.
+func TestWriteBufferTruncateBack(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{SyncPolicy: SyncPolicy{Mode: SyncNever}, WriteBufferSize: 1 << 20}
+	l := openTest(t, dir, cfg)
+	writeEntries(t, l, 1, 100)
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, 101, 150)
+
+	if err := l.TruncateBack(120); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, l, 1, 120)
+	crashed := openTest(t, crashCopy(t, dir), Config{})
+	checkEntries(t, crashed, 1, 120)
+
+	writeEntries(t, l, 121, 130)
+	if err := l.TruncateBack(50); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, l, 51, 60)
+	checkEntries(t, l, 1, 60)
+	l = reopenTest(t, l, dir, cfg)
+	checkEntries(t, l, 1, 60)
+}
@@ -0,0 +1,150 @@
+package jellywal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestWriteFrom checks that entries read from a reader are stored as Write
+// would store them, whether they are read straight into the tail or into a
+// buffer first.
+func TestWriteFrom(t *testing.T) {
+	configs := map[string]Config{
+		"raw":        {},
+		"fixed":      {EntryFormat: EntryFormatFixed, SegmentSize: 1 << 16},
+		"compressed": {Compression: CompressionSnappy},
+		"encrypted":  {Encryption: benchKeys{}},
+		"buffered":   {WriteBufferSize: 1 << 20, SyncPolicy: SyncPolicy{Mode: SyncNever}},
+		"aligned":    {AlignWrites: true},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			large := bytes.Repeat([]byte("jellywal"), 100000)
+			for i := uint64(1); i <= 10; i++ {
+				if err := l.WriteFrom(i, bytes.NewReader(large), int64(len(large))); err != nil {
+					t.Fatalf("write %d: %v", i, err)
+				}
+			}
+			if err := l.Write(11, testEntry(11)); err != nil {
+				t.Fatal(err)
+			}
+
+			check := func() {
+				for i := uint64(1); i <= 10; i++ {
+					data, err := l.Read(i)
+					if err != nil {
+						t.Fatalf("read %d: %v", i, err)
+					}
+					if !bytes.Equal(data, large) {
+						t.Fatalf("read %d: got %d bytes, want the %d written", i, len(data), len(large))
+					}
+				}
+				if data, err := l.Read(11); err != nil || !bytes.Equal(data, testEntry(11)) {
+					t.Fatalf("read 11: got %q, %v", data, err)
+				}
+			}
+			check()
+			l = reopenTest(t, l, dir, cfg)
+			check()
+		})
+	}
+}
+
+// TestWriteFromShortReader checks that a reader holding fewer bytes than
+// the given size fails the write and leaves the log as it was.
+func TestWriteFromShortReader(t *testing.T) {
+	for name, cfg := range map[string]Config{"raw": {}, "compressed": {Compression: CompressionSnappy}} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 3)
+
+			err := l.WriteFrom(4, bytes.NewReader(testEntry(4)), 100)
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+			}
+			checkEntries(t, l, 1, 3)
+
+			writeEntries(t, l, 4, 5)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 5)
+		})
+	}
+}
+
+// TestWriteFromOutOfOrder checks that WriteFrom enforces the index order
+// like Write does, without reading the reader.
+func TestWriteFromOutOfOrder(t *testing.T) {
+	l := openTest(t, t.TempDir(), Config{})
+	writeEntries(t, l, 1, 3)
+
+	r := bytes.NewReader(testEntry(3))
+	if err := l.WriteFrom(3, r, r.Size()); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("got %v, want ErrOutOfOrder", err)
+	}
+	if r.Len() != int(r.Size()) {
+		t.Fatalf("read %d bytes of the reader", int(r.Size())-r.Len())
+	}
+	checkEntries(t, l, 1, 3)
+}
+
+// TestWriteFromGap checks that an entry skipping ahead with AllowGaps starts
+// a single new segment, whether it is transformed or not.
+func TestWriteFromGap(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"raw":        {AllowGaps: true},
+		"compressed": {AllowGaps: true, Compression: CompressionSnappy},
+		"encrypted":  {AllowGaps: true, Encryption: benchKeys{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 3)
+
+			r := bytes.NewReader(testEntry(10))
+			if err := l.WriteFrom(10, r, r.Size()); err != nil {
+				t.Fatal(err)
+			}
+			if len(l.segments) != 2 {
+				t.Fatalf("%d segments after a gap, want 2", len(l.segments))
+			}
+
+			l = reopenTest(t, l, dir, cfg)
+			for _, index := range []uint64{1, 3, 10} {
+				if data, err := l.Read(index); err != nil || !bytes.Equal(data, testEntry(index)) {
+					t.Fatalf("read %d: got %q, %v", index, data, err)
+				}
+			}
+		})
+	}
+}
+
+// TestWriteFromTooLarge checks that an entry too large for a segment is
+// refused before anything is read or allocated.
+func TestWriteFromTooLarge(t *testing.T) {
+	for name, cfg := range map[string]Config{"raw": {}, "compressed": {Compression: CompressionSnappy}} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTest(t, dir, cfg)
+			writeEntries(t, l, 1, 3)
+
+			r := bytes.NewReader(testEntry(4))
+			for _, size := range []int64{maxWriteFromSize + 1, 1 << 62} {
+				if err := l.WriteFrom(4, r, size); !errors.Is(err, ErrEntryTooLarge) {
+					t.Fatalf("size %d: got %v, want ErrEntryTooLarge", size, err)
+				}
+			}
+			if r.Len() != int(r.Size()) {
+				t.Fatalf("read %d bytes of the reader", int(r.Size())-r.Len())
+			}
+
+			writeEntries(t, l, 4, 5)
+			l = reopenTest(t, l, dir, cfg)
+			checkEntries(t, l, 1, 5)
+		})
+	}
+}
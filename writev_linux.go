@@ -0,0 +1,31 @@
+//go:build linux
+
+package jellywal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxIovecs is the number of buffers a single writev accepts (IOV_MAX).
+const maxIovecs = 1024
+
+// writev writes the buffers to the file at its offset with a single writev
+// call and returns the number of bytes written, which may fall short of
+// their total.
+func writev(file *os.File, bufs [][]byte) (int, error) {
+	if len(bufs) > maxIovecs {
+		bufs = bufs[:maxIovecs]
+	}
+
+	for {
+		n, err := unix.Writev(int(file.Fd()), bufs)
+		if err != unix.EINTR {
+			if err != nil {
+				return max(n, 0), &os.PathError{Op: "writev", Path: file.Name(), Err: err}
+			}
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package jellywal
+
+import "os"
+
+// writev writes the buffers to the file at its offset and returns the
+// number of bytes written. Platforms without writev in x/sys/unix write them
+// one at a time.
+func writev(file *os.File, bufs [][]byte) (int, error) {
+	var written int
+	for _, buf := range bufs {
+		n, err := file.Write(buf)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,71 @@
+package jellywal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestConsumeBuffers checks that written bytes are dropped from the front
+// of the buffers, along with the empty buffers in front.
+func TestConsumeBuffers(t *testing.T) {
+	for _, test := range []struct {
+		n    int
+		want []string
+	}{
+		{0, []string{"abc", "", "de", "f"}},
+		{2, []string{"c", "", "de", "f"}},
+		{3, []string{"de", "f"}},
+		{4, []string{"e", "f"}},
+		{6, nil},
+	} {
+		bufs := [][]byte{nil, []byte("abc"), {}, []byte("de"), []byte("f")}
+		bufs = consumeBuffers(consumeBuffers(bufs, 0), test.n)
+		var got []string
+		for _, buf := range bufs {
+			got = append(got, string(buf))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Fatalf("consume %d: got %q, want %q", test.n, got, test.want)
+		}
+	}
+}
+
+// TestWritev checks that writev writes the buffers in order at the offset
+// of the file, all of them over as many calls as it takes.
+func TestWritev(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "writev")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte("head|")); err != nil {
+		t.Fatal(err)
+	}
+
+	var bufs [][]byte
+	want := []byte("head|")
+	for i := 0; i < 3000; i++ {
+		buf := testEntry(uint64(i))
+		bufs = append(bufs, buf)
+		want = append(want, buf...)
+	}
+
+	for len(bufs) > 0 {
+		n, err := writev(file, bufs)
+		if err != nil || n == 0 {
+			t.Fatalf("wrote %d bytes: %v", n, err)
+		}
+		bufs = consumeBuffers(bufs, n)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}